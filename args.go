@@ -1,6 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/units"
 	"github.com/kffl/speedbump/lib"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -12,16 +19,46 @@ func parseArgs(args []string) (*lib.SpeedbumpCfg, error) {
 		host = app.Flag("host", "IP or hostname to listen on. Speedbump will bind to all network interfaces if unspecified.").
 			Default("").
 			String()
-		port       = app.Flag("port", "Port number to listen on.").Default("8000").Int()
+		port      = app.Flag("port", "Port number to listen on.").Default("8000").Int()
+		portRange = app.Flag("port-range", "Range of ports tried in order in place of --port, listening on the first available one, i.e. 9000-9099.").
+				PlaceHolder("START-END").
+				String()
 		bufferSize = app.Flag("buffer", "Size of the buffer used for TCP reads.").
 				Default("64KB").
 				Bytes()
 		queueSize = app.Flag("queue-size", "Size of the delay queue storing read buffers.").
 				Default("1024").
 				Int()
+		queueDrainWindow = app.Flag("queue-drain-window", "Window within which buffers due in the delay queue are released in one batch.").
+					PlaceHolder("0").
+					Duration()
+		maxQueueAge = app.Flag("max-queue-age", "Maximum time past its release time a buffer may wait in the delay queue before being evicted.").
+				PlaceHolder("0").
+				Duration()
+		drainBatchSize = app.Flag("drain-batch-size", "Maximum number of due buffers a connection releases from its delay queue in a row before yielding to other connections.").
+				PlaceHolder("0").
+				Int()
+		globalQueueMemLimit = app.Flag("global-queue-mem-limit", "Maximum total size of buffers held in the delay queues of all connections.").
+					PlaceHolder("0").
+					Bytes()
+		queueMemPolicy = app.Flag("queue-mem-policy", "Action taken once --global-queue-mem-limit is reached. Possible values: block, drop-oldest, close-heaviest.").
+				Default("block").
+				Enum("block", "drop-oldest", "close-heaviest")
 		latency = app.Flag("latency", "Base latency added to proxied traffic.").
 			Default("5ms").
 			Duration()
+		latencyStdDev = app.Flag("latency-stddev", "Standard deviation of normally distributed jitter added to the base latency.").
+				PlaceHolder("0").
+				Duration()
+		jitterCorrelation = app.Flag("jitter-correlation", "Correlation (between 0 and 1) of the jitter added by --latency-stddev and --server-to-client-latency-stddev to consecutive buffers.").
+					PlaceHolder("0").
+					Float64()
+		serverToClientLatency = app.Flag("server-to-client-latency", "Latency added to data sent back by the destination (only data sent by the client is delayed by --latency).").
+					PlaceHolder("0").
+					Duration()
+		serverToClientStdDev = app.Flag("server-to-client-latency-stddev", "Standard deviation of normally distributed jitter added to --server-to-client-latency.").
+					PlaceHolder("0").
+					Duration()
 		logLevel = app.Flag("log-level", "Log level. Possible values: DEBUG, TRACE, INFO, WARN, ERROR.").
 				Default("INFO").
 				Enum("DEBUG", "TRACE", "INFO", "WARN", "ERROR")
@@ -49,24 +86,351 @@ func parseArgs(args []string) (*lib.SpeedbumpCfg, error) {
 		trianglePeriod = app.Flag("triangle-period", "Period of the latency triangle wave.").
 				PlaceHolder("0").
 				Duration()
+		latencyProfile = app.Flag("latency-profile", "Latency profile selectable by clients sending its name in the first line, i.e. slow:500ms (repeatable).").
+				PlaceHolder("NAME:LATENCY").
+				Strings()
+		destinationLatency = app.Flag("destination-latency", "Latency used in place of --latency for connections proxied to a given destination, i.e. localhost:443:200ms (repeatable).").
+					PlaceHolder("HOST:PORT:LATENCY").
+					Strings()
+		fingerprintRoute = app.Flag("fingerprint-route", "Destination of connections whose greeting fingerprints as a given protocol (tls, http or ssh), i.e. http:localhost:8080 (repeatable).").
+					PlaceHolder("PROTOCOL:HOST:PORT").
+					Strings()
+		preambleTimeout = app.Flag("preamble-timeout", "Time within which clients have to send the preamble read by peek-based modes such as --latency-profile.").
+				Default("5s").
+				Duration()
+		maxPreambleBytes = app.Flag("max-preamble-bytes", "Maximum size of the preamble read by peek-based modes in bytes.").
+					Default("4096").
+					Int()
+		markovGoodLatency = app.Flag("markov-good-latency", "Latency added while the Markov on/off model is in the good state.").
+					PlaceHolder("0").
+					Duration()
+		markovBadLatency = app.Flag("markov-bad-latency", "Latency added while the Markov on/off model is in the bad state.").
+					PlaceHolder("0").
+					Duration()
+		markovGoodToBad = app.Flag("markov-good-to-bad", "Probability of the Markov on/off model transitioning from the good to the bad state with each buffer.").
+				PlaceHolder("0").
+				Float64()
+		markovBadToGood = app.Flag("markov-bad-to-good", "Probability of the Markov on/off model transitioning from the bad to the good state with each buffer.").
+				PlaceHolder("0").
+				Float64()
+		latencySeed = app.Flag("latency-seed", "Seed of the random number generator used by randomized latency models (time-based if unspecified).").
+				PlaceHolder("0").
+				Int64()
+		stallDirection = app.Flag("stall-direction", "Direction of traffic affected by periodic stalls. Possible values: client-to-server, server-to-client.").
+				Default("server-to-client").
+				Enum("client-to-server", "server-to-client")
+		stallPeriod = app.Flag("stall-period", "Period of the stalls of one direction of traffic.").
+				PlaceHolder("0").
+				Duration()
+		stallDuration = app.Flag("stall-duration", "Duration of each stall of one direction of traffic.").
+				PlaceHolder("0").
+				Duration()
+		rampStep = app.Flag("ramp-step", "Delay added to each subsequent buffer read from the client within a connection.").
+				PlaceHolder("0").
+				Duration()
+		rampMax = app.Flag("ramp-max", "Maximum delay added by the per-connection delay ramp.").
+			PlaceHolder("0").
+			Duration()
+		slowStartPenalty = app.Flag("slow-start-penalty", "Delay added to buffers sent by the client right after a connection is opened, decaying over --slow-start-duration.").
+					PlaceHolder("0").
+					Duration()
+		slowStartDuration = app.Flag("slow-start-duration", "Time since a connection is opened after which the slow start penalty is gone.").
+					PlaceHolder("0").
+					Duration()
+		slowStartCurve = app.Flag("slow-start-curve", "Shape of the decay of the slow start penalty. Possible values: linear, exponential, logarithmic.").
+				Default("linear").
+				Enum("linear", "exponential", "logarithmic")
+		idleRatio = app.Flag("idle-latency-ratio", "Delay added to a buffer sent by the client per unit of time the connection was idle before it, i.e. 0.1 adds 100ms after 1s of idle time.").
+				PlaceHolder("0").
+				Float64()
+		idleThreshold = app.Flag("idle-latency-threshold", "Idle time below which no idle latency is added.").
+				PlaceHolder("0").
+				Duration()
+		idleMax = app.Flag("idle-latency-max", "Maximum delay added after the connection was idle.").
+			PlaceHolder("0").
+			Duration()
+		rttMultiplier = app.Flag("rtt-latency-multiplier", "Delay added to a buffer sent by the client per unit of the destination's smoothed round-trip time estimated within each connection, i.e. 2 adds 100ms at an estimate of 50ms.").
+				PlaceHolder("0").
+				Float64()
+		rttSmoothing = app.Flag("rtt-latency-smoothing", "Weight of each new round-trip sample in the estimate used by --rtt-latency-multiplier.").
+				Default("0.125").
+				Float64()
+		rttMax = app.Flag("rtt-latency-max", "Maximum delay added by --rtt-latency-multiplier.").
+			PlaceHolder("0").
+			Duration()
+		latencyBudget = app.Flag("latency-budget", "Total latency injected into a single connection after which its data passes through without delay.").
+				PlaceHolder("0").
+				Duration()
+		compressionDelay = app.Flag("compression-delay-per-kb", "Delay added to each buffer per KiB of its data on top of latency, modeling a compressing middlebox.").
+					PlaceHolder("0").
+					Duration()
+		minLatency = app.Flag("min-latency", "Minimum latency added to each buffer, raising lower values produced by the configured latency summands.").
+				PlaceHolder("0").
+				Duration()
+		responseLatency = app.Flag("response-latency", "Latency added to HTTP responses with a status code in a given range, i.e. 500-599:200ms (repeatable).").
+				PlaceHolder("MIN-MAX:LATENCY").
+				Strings()
+		maxChunkSize = app.Flag("max-chunk-size", "Maximum size of individual writes made by the proxy in bytes.").
+				PlaceHolder("0").
+				Int()
+		pmtuDropAfter = app.Flag("pmtu-drop-after", "Time since each connection was opened after which the maximum write size drops to --pmtu-drop-chunk-size.").
+				PlaceHolder("0").
+				Duration()
+		pmtuDropChunkSize = app.Flag("pmtu-drop-chunk-size", "Maximum size of individual writes in bytes after the simulated path MTU drop.").
+					PlaceHolder("0").
+					Int()
+		coalesceWindow = app.Flag("coalesce-window", "Period of time within which consecutive small reads from the client are coalesced into a single buffer.").
+				PlaceHolder("0").
+				Duration()
+		padBytes = app.Flag("pad-bytes", "Number of zero bytes appended to each buffer sent by the client. Alters the stream, intended for framed protocols.").
+				PlaceHolder("0").
+				Int()
+		reorderRate = app.Flag("reorder-rate", "Probability of a buffer swapping places with the next queued one. Corrupts TCP streams, intended for testing datagram-like framing.").
+				PlaceHolder("0").
+				Float64()
+		bandwidth = app.Flag("bandwidth", "Maximum throughput of each direction of a proxy connection per second, i.e. 1MB (unlimited if unspecified).").
+				PlaceHolder("0").
+				Bytes()
+		linkWindow = app.Flag("link-window", "Bytes kept in flight by the emulated link, i.e. 64KB. Derives --bandwidth from the base latency, adjusting it as latency is changed (i.e. via --stdin-control).").
+				PlaceHolder("0").
+				Bytes()
+		bandwidthSchedule = app.Flag("bandwidth-schedule", "Step of a schedule varying the bandwidth of each connection over time, i.e. 10s:1MB (repeatable, overrides --bandwidth).").
+					PlaceHolder("DURATION:BANDWIDTH").
+					Strings()
+		bandwidthScheduleGlobal = app.Flag("bandwidth-schedule-global", "Advance all connections through --bandwidth-schedule from startup rather than from the time each one was opened.").
+					Bool()
+		bandwidthAlgorithm = app.Flag("bandwidth-algorithm", "Algorithm enforcing --bandwidth. Possible values: tokenbucket, leakybucket, fixedwindow.").
+					Default("tokenbucket").
+					Enum("tokenbucket", "leakybucket", "fixedwindow")
+		bandwidthWindow = app.Flag("bandwidth-window", "Period of time worth of traffic let through in a burst by tokenbucket and the window length of fixedwindow.").
+				Default("100ms").
+				Duration()
+		maxLifetimeConns = app.Flag("max-lifetime-connections", "Maximum number of client connections accepted over the lifetime of the proxy (or within each --conn-quota-interval). Excess connections are refused.").
+					PlaceHolder("0").
+					Int()
+		connQuotaInterval = app.Flag("conn-quota-interval", "Reset the --max-lifetime-connections count this often, turning it into a rolling quota.").
+					PlaceHolder("0").
+					Duration()
+		backendMaxConns = app.Flag("backend-max-conns", "Maximum number of concurrent connections to the proxy destination. Excess client connections are queued.").
+				PlaceHolder("0").
+				Int()
+		backendQueueTimeout = app.Flag("backend-queue-timeout", "Maximum time a client connection waits in the queue before being rejected.").
+					PlaceHolder("0").
+					Duration()
+		happyEyeballs = app.Flag("happy-eyeballs", "Dial all addresses of the proxy destination's host in parallel with a small stagger, using the first connection established.").
+				Bool()
+		destHealthInterval = app.Flag("dest-health-interval", "Re-resolve the destination's host and connect to each of its addresses this often, proxying new connections to the healthy ones.").
+					PlaceHolder("0").
+					Duration()
+		destHealthTimeout = app.Flag("dest-health-timeout", "Time within which connecting to an address of the destination has to succeed for it to be healthy (defaults to --dest-health-interval).").
+					PlaceHolder("0").
+					Duration()
+		probeBackend = app.Flag("probe-backend", "Dial the destination once on startup and log the time it took to connect as the baseline RTT.").
+				Bool()
+		dialTimeout = app.Flag("dial-timeout", "Timeout for dialing the proxy destination (including the TLS handshake with --backend-tls).").
+				PlaceHolder("0").
+				Duration()
+		refusedMessage = app.Flag("refused-message", "Message written to clients whose connection the destination refused, i.e. 'retry in {{.RetryAfterSeconds}}s' (a Go template).").
+				PlaceHolder("TEMPLATE").
+				String()
+		refusedRetryAfter = app.Flag("refused-retry-after", "Retry-after suggested by --refused-message after the first refused dial, doubling with each consecutive one.").
+					Default("1s").
+					Duration()
+		cancelDialOnClose = app.Flag("cancel-dial-on-client-close", "Abort dialing the proxy destination if the client disconnects in the meantime.").
+					Bool()
+		acceptIdleTimeout = app.Flag("accept-idle-timeout", "Period of time without incoming connections after which a warning is logged.").
+					PlaceHolder("0").
+					Duration()
+		acceptWorkers = app.Flag("accept-workers", "Number of goroutines concurrently accepting incoming connections.").
+				Default("1").
+				Int()
+		acceptDelayJitter = app.Flag("accept-delay-jitter", "Maximum random delay applied before setting up each accepted connection.").
+					PlaceHolder("0").
+					Duration()
+		acceptTimeline = app.Flag("accept-timeline", "File with one RFC 3339 timestamp per line (i.e. extracted from logs) to which accepting connections is paced.").
+				PlaceHolder("FILE").
+				ExistingFile()
+		closeLinger = app.Flag("close-linger", "Delay before closing one side of a connection after its other side got closed.").
+				PlaceHolder("0").
+				Duration()
+		warnOnClientGone = app.Flag("warn-on-client-gone", "Log connections closed because their client went away (i.e. reset the connection) as warnings.").
+					Bool()
+		reconnectBackend = app.Flag("reconnect-backend", "Re-dial the proxy destination if it fails mid-stream instead of closing the client connection.").
+					Bool()
+		reconnectAttempts = app.Flag("reconnect-attempts", "Number of attempts made when re-dialing the proxy destination.").
+					Default("3").
+					Int()
+		reconnectBackoff = app.Flag("reconnect-backoff", "Delay before each attempt of re-dialing the proxy destination.").
+					Default("100ms").
+					Duration()
+		readRetries = app.Flag("read-retries", "Number of times a read failing with a temporary network error is retried before closing the connection.").
+				PlaceHolder("0").
+				Int()
+		readRetryBackoff = app.Flag("read-retry-backoff", "Delay before each retry of a read that failed with a temporary network error.").
+					Default("10ms").
+					Duration()
+		backendTLS = app.Flag("backend-tls", "Originate TLS connections to the proxy destination while accepting plaintext from clients.").
+				Bool()
+		backendTLSServerName = app.Flag("backend-tls-server-name", "Server name verified against the proxy destination's certificate (defaults to the destination's host).").
+					Default("").
+					String()
+		backendInsecureSkipVerify = app.Flag("backend-insecure-skip-verify", "Skip verifying the proxy destination's TLS certificate.").
+						Bool()
+		tlsDestAddr = app.Flag("tls-destination", "Separate proxy destination for TLS connections in host:port format. Enables TLS handshake detection.").
+				Default("").
+				String()
+		labelVirtualHosts = app.Flag("label-virtual-hosts", "Label connections with the server name sent via SNI or the HTTP Host header in stats and logs.").
+					Bool()
+		allowedSNI = app.Flag("allowed-sni", "Server name TLS clients may request via SNI, rejecting all other clients at accept (repeatable).").
+				PlaceHolder("NAME").
+				Strings()
+		tlsDetectTimeout = app.Flag("tls-detect-timeout", "Time to wait for the first byte sent by the client before proxying it to the regular destination.").
+					Default("1s").
+					Duration()
+		captureDir = app.Flag("capture-dir", "Directory to which the byte stream of each connection is recorded with timestamps (as conn-<id>.jsonl).").
+				PlaceHolder("DIR").
+				ExistingDir()
+		statsWarmup = app.Flag("stats-warmup", "Initial period of time excluded from the stats exposed by the admin API.").
+				PlaceHolder("0").
+				Duration()
+		logRateLimit = app.Flag("log-rate-limit", "Interval within which identical warnings are coalesced into a periodic summary.").
+				PlaceHolder("0").
+				Duration()
+		logSampleRate = app.Flag("log-sample-rate", "Fraction of connections (0-1) whose opening and closing gets logged.").
+				PlaceHolder("1").
+				Float64()
+		adminAddr = app.Flag("admin-addr", "Address of the HTTP admin API exposing stats and config in host:port format or as a Unix socket (unix:/path).").
+				Default("").
+				String()
+		poolBuffers = app.Flag("pool-buffers", "Reuse read buffers across proxy connections in order to reduce allocations.").
+				Bool()
+		migrateOnReload = app.Flag("migrate-on-reload", "Reset existing connections when the destination is changed (i.e. via --stdin-control), so that clients reconnect to the new one.").
+				Bool()
+		stdinControl = app.Flag("stdin-control", "Read commands (enable, disable, latency <duration>, destination <host:port>, stats, conns, close <id>) from stdin.").
+				Bool()
+		sink = app.Flag("sink", "Discard data sent by clients after applying latency and bandwidth limits instead of proxying it (the destination is not required).").
+			Bool()
+		source = app.Flag("source", "Stream generated data to clients at the configured latency and bandwidth instead of proxying the destination's (the destination is not required).").
+			Bool()
+		sourcePattern = app.Flag("source-pattern", "Pattern repeated in the data streamed by --source (random bytes if unspecified).").
+				String()
+		sourceBytes = app.Flag("source-bytes", "Number of bytes streamed by --source to each client before closing the connection, i.e. 10MB (unlimited if unspecified).").
+				PlaceHolder("0").
+				Bytes()
 		destAddr = app.Arg("destination", "TCP proxy destination in host:post format.").
-				Required().
 				String()
 	)
 
-	app.Version("1.1.0")
+	app.Version(lib.Version)
 	_, err := app.Parse(args)
 
 	if err != nil {
 		return nil, err
 	}
 
+	mode := lib.ModeProxy
+	switch {
+	case *sink && *source:
+		return nil, fmt.Errorf("--sink and --source can't be combined")
+	case *sink:
+		mode = lib.ModeSink
+	case *source:
+		mode = lib.ModeSource
+	case *destAddr == "":
+		return nil, fmt.Errorf("required argument 'destination' not provided")
+	}
+
+	responseRules, err := parseResponseLatencyRules(*responseLatency)
+	if err != nil {
+		return nil, err
+	}
+
+	latencyProfiles, err := parseLatencyProfiles(*latencyProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	destinationLatencies, err := parseDestinationLatencies(*destinationLatency)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprintRoutes, err := parseFingerprintRoutes(*fingerprintRoute)
+	if err != nil {
+		return nil, err
+	}
+	var fingerprintFunc lib.FingerprintFunc
+	if fingerprintRoutes != nil {
+		fingerprintFunc = lib.FingerprintProtocol
+	}
+
+	schedule, err := parseBandwidthSchedule(*bandwidthSchedule)
+	if err != nil {
+		return nil, err
+	}
+
+	timeline, err := readAcceptTimeline(*acceptTimeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var markov *lib.MarkovLatencyCfg
+	if *markovGoodToBad > 0 || *markovBadToGood > 0 {
+		markov = &lib.MarkovLatencyCfg{
+			GoodLatency: *markovGoodLatency,
+			BadLatency:  *markovBadLatency,
+			GoodToBad:   *markovGoodToBad,
+			BadToGood:   *markovBadToGood,
+		}
+	}
+
+	var serverToClient *lib.LatencyCfg
+	if *serverToClientLatency > 0 || *serverToClientStdDev > 0 {
+		serverToClient = &lib.LatencyCfg{
+			Base:              *serverToClientLatency,
+			GaussianStdDev:    *serverToClientStdDev,
+			JitterCorrelation: *jitterCorrelation,
+			Seed:              *latencySeed,
+		}
+	}
+
+	var destHealthCheck *lib.DestHealthCheckCfg
+	if *destHealthInterval > 0 {
+		destHealthCheck = &lib.DestHealthCheckCfg{
+			Interval: *destHealthInterval,
+			Timeout:  *destHealthTimeout,
+		}
+	}
+
+	var rampCurve lib.RampCurve
+	rampCurve.UnmarshalText([]byte(*slowStartCurve))
+
+	var algorithm lib.BandwidthAlgorithm
+	algorithm.UnmarshalText([]byte(*bandwidthAlgorithm))
+
+	var memPolicy lib.QueueMemPolicy
+	memPolicy.UnmarshalText([]byte(*queueMemPolicy))
+
 	var cfg = lib.SpeedbumpCfg{
-		Host:       *host,
-		Port:       *port,
-		DestAddr:   *destAddr,
-		BufferSize: int(*bufferSize),
-		QueueSize:  *queueSize,
+		Host:                      *host,
+		Port:                      *port,
+		PortRangePreferred:        *portRange,
+		DestAddr:                  *destAddr,
+		Mode:                      mode,
+		SourcePattern:             []byte(*sourcePattern),
+		SourceBytes:               int64(*sourceBytes),
+		TLSDestAddr:               *tlsDestAddr,
+		BackendTLS:                *backendTLS,
+		BackendTLSServerName:      *backendTLSServerName,
+		BackendInsecureSkipVerify: *backendInsecureSkipVerify,
+		TLSDetectTimeout:          *tlsDetectTimeout,
+		BufferSize:                int(*bufferSize),
+		QueueSize:                 *queueSize,
+		QueueDrainWindow:          *queueDrainWindow,
+		MaxQueueAge:               *maxQueueAge,
+		DrainBatchSize:            *drainBatchSize,
+		GlobalQueueMemLimit:       int(*globalQueueMemLimit),
+		QueueMemPolicy:            memPolicy,
 		Latency: &lib.LatencyCfg{
 			Base:              *latency,
 			SineAmplitude:     *sineAmplitude,
@@ -77,9 +441,217 @@ func parseArgs(args []string) (*lib.SpeedbumpCfg, error) {
 			SquarePeriod:      *squarePeriod,
 			TriangleAmplitude: *triangleAmplitude,
 			TrianglePeriod:    *trianglePeriod,
+			GaussianStdDev:    *latencyStdDev,
+			JitterCorrelation: *jitterCorrelation,
+			Markov:            markov,
+			Seed:              *latencySeed,
+		},
+		ServerToClientLatency: serverToClient,
+		LatencyProfiles:       latencyProfiles,
+		DestinationLatency:    destinationLatencies,
+		FingerprintFunc:       fingerprintFunc,
+		FingerprintRoutes:     fingerprintRoutes,
+		LabelVirtualHosts:     *labelVirtualHosts,
+		AllowedSNIs:           *allowedSNI,
+		PreambleTimeout:       *preambleTimeout,
+		MaxPreambleBytes:      *maxPreambleBytes,
+		LogLevel:              *logLevel,
+		LogRateLimit:          *logRateLimit,
+		LogSampleRate:         *logSampleRate,
+		AdminAddr:             *adminAddr,
+		StatsWarmup:           *statsWarmup,
+		CaptureDir:            *captureDir,
+		EnableStdinControl:    *stdinControl,
+		PoolBuffers:           *poolBuffers,
+		Stall: &lib.StallCfg{
+			Direction: parseDirection(*stallDirection),
+			Period:    *stallPeriod,
+			Duration:  *stallDuration,
 		},
-		LogLevel: *logLevel,
+		DelayRamp: &lib.DelayRampCfg{
+			Step: *rampStep,
+			Max:  *rampMax,
+		},
+		SlowStart: &lib.SlowStartCfg{
+			Penalty:      *slowStartPenalty,
+			RampDuration: *slowStartDuration,
+			RampCurve:    rampCurve,
+		},
+		IdleLatency: &lib.IdleLatencyCfg{
+			Ratio:     *idleRatio,
+			Threshold: *idleThreshold,
+			Max:       *idleMax,
+		},
+		RTTLatency: &lib.RTTLatencyCfg{
+			Multiplier: *rttMultiplier,
+			Smoothing:  *rttSmoothing,
+			Max:        *rttMax,
+		},
+		LatencyBudget:           *latencyBudget,
+		CompressionDelayPerKB:   *compressionDelay,
+		ResponseLatency:         responseRules,
+		MaxChunkSize:            *maxChunkSize,
+		PMTUDropAfter:           *pmtuDropAfter,
+		PMTUDropChunkSize:       *pmtuDropChunkSize,
+		ReorderRate:             *reorderRate,
+		PadBytes:                *padBytes,
+		CoalesceWindow:          *coalesceWindow,
+		Bandwidth:               int(*bandwidth),
+		LinkWindow:              int(*linkWindow),
+		BandwidthSchedule:       schedule,
+		BandwidthScheduleGlobal: *bandwidthScheduleGlobal,
+		BandwidthAlgorithm:      algorithm,
+		BandwidthWindow:         *bandwidthWindow,
+		MaxLifetimeConnections:  *maxLifetimeConns,
+		ConnQuotaInterval:       *connQuotaInterval,
+		BackendMaxConns:         *backendMaxConns,
+		BackendQueueTimeout:     *backendQueueTimeout,
+		DialTimeout:             *dialTimeout,
+		RefusedMessage:          *refusedMessage,
+		RefusedRetryAfter:       *refusedRetryAfter,
+		CancelDialOnClientClose: *cancelDialOnClose,
+		HappyEyeballs:           *happyEyeballs,
+		DestHealthCheck:         destHealthCheck,
+		ProbeBackendOnStart:     *probeBackend,
+		AcceptIdleTimeout:       *acceptIdleTimeout,
+		AcceptTimeline:          timeline,
+		AcceptWorkers:           *acceptWorkers,
+		AcceptDelayJitter:       *acceptDelayJitter,
+		CloseLinger:             *closeLinger,
+		WarnOnClientGone:        *warnOnClientGone,
+		ReconnectBackend:        *reconnectBackend,
+		ReconnectAttempts:       *reconnectAttempts,
+		ReconnectBackoff:        *reconnectBackoff,
+		ReadRetries:             *readRetries,
+		ReadRetryBackoff:        *readRetryBackoff,
+		MinLatency:              *minLatency,
+		MigrateOnReload:         *migrateOnReload,
 	}
 
 	return &cfg, err
 }
+
+// readAcceptTimeline reads the accept timeline from a given file (if specified)
+func readAcceptTimeline(path string) ([]time.Duration, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening accept timeline: %s", err)
+	}
+	defer f.Close()
+	return lib.ParseAcceptTimeline(f)
+}
+
+func parseDirection(direction string) lib.Direction {
+	if direction == "client-to-server" {
+		return lib.ClientToServer
+	}
+	return lib.ServerToClient
+}
+
+// parseResponseLatencyRules parses rules in either MIN-MAX:LATENCY or STATUS:LATENCY format
+func parseResponseLatencyRules(rules []string) ([]lib.ResponseLatencyRule, error) {
+	var parsed []lib.ResponseLatencyRule
+	for _, rule := range rules {
+		i := strings.LastIndex(rule, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("Error parsing response latency rule %s: missing latency", rule)
+		}
+		latency, err := time.ParseDuration(rule[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing response latency rule %s: %s", rule, err)
+		}
+		statusRange := strings.SplitN(rule[:i], "-", 2)
+		if len(statusRange) == 1 {
+			statusRange = append(statusRange, statusRange[0])
+		}
+		min, err := strconv.Atoi(statusRange[0])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing response latency rule %s: %s", rule, err)
+		}
+		max, err := strconv.Atoi(statusRange[1])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing response latency rule %s: %s", rule, err)
+		}
+		parsed = append(parsed, lib.ResponseLatencyRule{StatusMin: min, StatusMax: max, Latency: latency})
+	}
+	return parsed, nil
+}
+
+// parseLatencyProfiles parses profiles in NAME:LATENCY format
+func parseLatencyProfiles(profiles []string) (map[string]lib.LatencyCfg, error) {
+	if len(profiles) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]lib.LatencyCfg, len(profiles))
+	for _, profile := range profiles {
+		i := strings.LastIndex(profile, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("Error parsing latency profile %s: expected NAME:LATENCY", profile)
+		}
+		latency, err := time.ParseDuration(profile[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing latency profile %s: %s", profile, err)
+		}
+		parsed[profile[:i]] = lib.LatencyCfg{Base: latency}
+	}
+	return parsed, nil
+}
+
+// parseBandwidthSchedule parses bandwidth schedule steps in DURATION:BANDWIDTH format
+func parseBandwidthSchedule(steps []string) ([]lib.BandwidthStep, error) {
+	var parsed []lib.BandwidthStep
+	for _, step := range steps {
+		i := strings.Index(step, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("Error parsing bandwidth schedule step %s: expected DURATION:BANDWIDTH", step)
+		}
+		duration, err := time.ParseDuration(step[:i])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing bandwidth schedule step %s: %s", step, err)
+		}
+		bandwidth, err := units.ParseBase2Bytes(step[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing bandwidth schedule step %s: %s", step, err)
+		}
+		parsed = append(parsed, lib.BandwidthStep{Duration: duration, Bandwidth: int(bandwidth)})
+	}
+	return parsed, nil
+}
+
+// parseDestinationLatencies parses destination latencies in HOST:PORT:LATENCY format
+func parseFingerprintRoutes(routes []string) (map[string]string, error) {
+	if len(routes) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]string, len(routes))
+	for _, r := range routes {
+		i := strings.Index(r, ":")
+		if i <= 0 || !strings.Contains(r[i+1:], ":") {
+			return nil, fmt.Errorf("Error parsing fingerprint route %s: expected PROTOCOL:HOST:PORT", r)
+		}
+		parsed[r[:i]] = r[i+1:]
+	}
+	return parsed, nil
+}
+
+func parseDestinationLatencies(latencies []string) (map[string]*lib.LatencyCfg, error) {
+	if len(latencies) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]*lib.LatencyCfg, len(latencies))
+	for _, l := range latencies {
+		i := strings.LastIndex(l, ":")
+		if i <= 0 || !strings.Contains(l[:i], ":") {
+			return nil, fmt.Errorf("Error parsing destination latency %s: expected HOST:PORT:LATENCY", l)
+		}
+		latency, err := time.ParseDuration(l[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing destination latency %s: %s", l, err)
+		}
+		parsed[l[:i]] = &lib.LatencyCfg{Base: latency}
+	}
+	return parsed, nil
+}
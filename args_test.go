@@ -1,9 +1,13 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/kffl/speedbump/lib"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,6 +32,7 @@ func TestParseArgsAll(t *testing.T) {
 			"--host=somehost",
 			"--port=1234",
 			"--buffer=200B",
+			"--queue-drain-window=2ms",
 			"--latency=100ms",
 			"--sine-amplitude=50ms",
 			"--sine-period=1m",
@@ -35,14 +40,28 @@ func TestParseArgsAll(t *testing.T) {
 			"--square-period=3m",
 			"--triangle-amplitude=150ms",
 			"--triangle-period=2m",
+			"--dial-timeout=3s",
+			"--happy-eyeballs",
+			"--accept-idle-timeout=1m",
+			"--tls-destination=host:443",
+			"--reconnect-backend",
+			"--reconnect-attempts=5",
+			"--log-rate-limit=10s",
+			"--admin-addr=unix:/tmp/speedbump.sock",
+			"--stats-warmup=30s",
+			"--stdin-control",
+			"--pool-buffers",
 			"host:777",
 		},
 	)
 	assert.Nil(t, err)
 	assert.Equal(t, cfg.DestAddr, "host:777")
+	assert.Equal(t, cfg.TLSDestAddr, "host:443")
+	assert.Equal(t, time.Second, cfg.TLSDetectTimeout)
 	assert.Equal(t, cfg.Host, "somehost")
 	assert.Equal(t, cfg.Port, 1234)
 	assert.Equal(t, 200, cfg.BufferSize)
+	assert.Equal(t, time.Millisecond*2, cfg.QueueDrainWindow)
 	assert.Equal(t, time.Millisecond*100, cfg.Latency.Base)
 	assert.Equal(t, time.Millisecond*50, cfg.Latency.SineAmplitude)
 	assert.Equal(t, time.Minute, cfg.Latency.SinePeriod)
@@ -52,4 +71,503 @@ func TestParseArgsAll(t *testing.T) {
 	assert.Equal(t, time.Minute*3, cfg.Latency.SquarePeriod)
 	assert.Equal(t, time.Millisecond*150, cfg.Latency.TriangleAmplitude)
 	assert.Equal(t, time.Minute*2, cfg.Latency.TrianglePeriod)
+	assert.Equal(t, time.Second*3, cfg.DialTimeout)
+	assert.True(t, cfg.HappyEyeballs)
+	assert.Equal(t, time.Minute, cfg.AcceptIdleTimeout)
+	assert.True(t, cfg.ReconnectBackend)
+	assert.Equal(t, 5, cfg.ReconnectAttempts)
+	assert.Equal(t, time.Millisecond*100, cfg.ReconnectBackoff)
+	assert.Equal(t, time.Second*10, cfg.LogRateLimit)
+	assert.Equal(t, "unix:/tmp/speedbump.sock", cfg.AdminAddr)
+	assert.Equal(t, time.Second*30, cfg.StatsWarmup)
+	assert.True(t, cfg.EnableStdinControl)
+	assert.True(t, cfg.PoolBuffers)
+}
+
+func TestParseArgsMarkov(t *testing.T) {
+	cfg, err := parseArgs(
+		[]string{
+			"--markov-good-latency=10ms",
+			"--markov-bad-latency=500ms",
+			"--markov-good-to-bad=0.05",
+			"--markov-bad-to-good=0.5",
+			"--latency-seed=42",
+			"host:777",
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, &lib.MarkovLatencyCfg{
+		GoodLatency: time.Millisecond * 10,
+		BadLatency:  time.Millisecond * 500,
+		GoodToBad:   0.05,
+		BadToGood:   0.5,
+	}, cfg.Latency.Markov)
+	assert.Equal(t, int64(42), cfg.Latency.Seed)
+
+	cfg, err = parseArgs([]string{"host:777"})
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.Latency.Markov)
+}
+
+func TestParseArgsStall(t *testing.T) {
+	cfg, err := parseArgs(
+		[]string{
+			"--stall-direction=client-to-server",
+			"--stall-period=10s",
+			"--stall-duration=2s",
+			"host:777",
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, lib.ClientToServer, cfg.Stall.Direction)
+	assert.Equal(t, time.Second*10, cfg.Stall.Period)
+	assert.Equal(t, time.Second*2, cfg.Stall.Duration)
+}
+
+func TestParseArgsDelayRamp(t *testing.T) {
+	cfg, err := parseArgs(
+		[]string{
+			"--ramp-step=5ms",
+			"--ramp-max=500ms",
+			"host:777",
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Millisecond*5, cfg.DelayRamp.Step)
+	assert.Equal(t, time.Millisecond*500, cfg.DelayRamp.Max)
+}
+
+func TestParseArgsResponseLatency(t *testing.T) {
+	cfg, err := parseArgs(
+		[]string{
+			"--response-latency=500-599:200ms",
+			"--response-latency=429:1s",
+			"host:777",
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []lib.ResponseLatencyRule{
+		{StatusMin: 500, StatusMax: 599, Latency: time.Millisecond * 200},
+		{StatusMin: 429, StatusMax: 429, Latency: time.Second},
+	}, cfg.ResponseLatency)
+}
+
+func TestParseArgsResponseLatencyError(t *testing.T) {
+	for _, rule := range []string{"500-599", "5xx:1s", "500-abc:1s", "500:soon"} {
+		_, err := parseArgs([]string{"--response-latency=" + rule, "host:777"})
+		assert.NotNil(t, err, rule)
+		assert.True(t, strings.HasPrefix(err.Error(), "Error parsing response latency rule "+rule), rule)
+	}
+}
+
+func TestParseArgsChunking(t *testing.T) {
+	cfg, err := parseArgs(
+		[]string{
+			"--max-chunk-size=1400",
+			"--pmtu-drop-after=30s",
+			"--pmtu-drop-chunk-size=500",
+			"host:777",
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, 1400, cfg.MaxChunkSize)
+	assert.Equal(t, time.Second*30, cfg.PMTUDropAfter)
+	assert.Equal(t, 500, cfg.PMTUDropChunkSize)
+}
+
+func TestParseArgsConnQuota(t *testing.T) {
+	cfg, err := parseArgs([]string{"--max-lifetime-connections=100", "--conn-quota-interval=1m", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, cfg.MaxLifetimeConnections)
+	assert.Equal(t, time.Minute, cfg.ConnQuotaInterval)
+}
+
+func TestParseArgsBackendMaxConns(t *testing.T) {
+	cfg, err := parseArgs(
+		[]string{
+			"--backend-max-conns=10",
+			"--backend-queue-timeout=5s",
+			"host:777",
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, cfg.BackendMaxConns)
+	assert.Equal(t, time.Second*5, cfg.BackendQueueTimeout)
+}
+
+func TestParseArgsReorderRate(t *testing.T) {
+	cfg, err := parseArgs([]string{"--reorder-rate=0.1", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0.1, cfg.ReorderRate)
+}
+
+func TestParseArgsLogSampleRate(t *testing.T) {
+	cfg, err := parseArgs([]string{"--log-sample-rate=0.05", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0.05, cfg.LogSampleRate)
+}
+
+func TestParseArgsCloseLinger(t *testing.T) {
+	cfg, err := parseArgs([]string{"--close-linger=2s", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second*2, cfg.CloseLinger)
+}
+
+func TestParseArgsWarnOnClientGone(t *testing.T) {
+	cfg, err := parseArgs([]string{"--warn-on-client-gone", "host:777"})
+	assert.Nil(t, err)
+	assert.True(t, cfg.WarnOnClientGone)
+}
+
+func TestParseArgsDestinationLatency(t *testing.T) {
+	cfg, err := parseArgs(
+		[]string{
+			"--destination-latency=localhost:443:200ms",
+			"--destination-latency=[::1]:80:0s",
+			"host:777",
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*lib.LatencyCfg{
+		"localhost:443": {Base: time.Millisecond * 200},
+		"[::1]:80":      {},
+	}, cfg.DestinationLatency)
+
+	_, err = parseArgs([]string{"--destination-latency=localhost:200ms", "host:777"})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error parsing destination latency"))
+	_, err = parseArgs([]string{"--destination-latency=localhost:443:soon", "host:777"})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error parsing destination latency"))
+}
+
+func TestParseArgsLatencyProfiles(t *testing.T) {
+	cfg, err := parseArgs(
+		[]string{
+			"--latency-profile=slow:500ms",
+			"--latency-profile=fast:0s",
+			"--preamble-timeout=2s",
+			"--max-preamble-bytes=128",
+			"host:777",
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second*2, cfg.PreambleTimeout)
+	assert.Equal(t, 128, cfg.MaxPreambleBytes)
+	assert.Equal(t, map[string]lib.LatencyCfg{
+		"slow": {Base: time.Millisecond * 500},
+		"fast": {},
+	}, cfg.LatencyProfiles)
+
+	_, err = parseArgs([]string{"--latency-profile=:500ms", "host:777"})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error parsing latency profile"))
+	_, err = parseArgs([]string{"--latency-profile=slow:soon", "host:777"})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error parsing latency profile"))
+}
+
+func TestParseArgsBackendTLS(t *testing.T) {
+	cfg, err := parseArgs(
+		[]string{
+			"--backend-tls",
+			"--backend-tls-server-name=example.com",
+			"--backend-insecure-skip-verify",
+			"host:777",
+		},
+	)
+	assert.Nil(t, err)
+	assert.True(t, cfg.BackendTLS)
+	assert.Equal(t, "example.com", cfg.BackendTLSServerName)
+	assert.True(t, cfg.BackendInsecureSkipVerify)
+}
+
+func TestParseArgsPadBytes(t *testing.T) {
+	cfg, err := parseArgs([]string{"--pad-bytes=16", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 16, cfg.PadBytes)
+}
+
+func TestParseArgsCoalesceWindow(t *testing.T) {
+	cfg, err := parseArgs([]string{"--coalesce-window=2ms", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Millisecond*2, cfg.CoalesceWindow)
+}
+
+func TestParseArgsQueueMemLimit(t *testing.T) {
+	cfg, err := parseArgs([]string{"--global-queue-mem-limit=1MB", "--queue-mem-policy=close-heaviest", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1<<20, cfg.GlobalQueueMemLimit)
+	assert.Equal(t, lib.QueueMemCloseHeaviest, cfg.QueueMemPolicy)
+
+	cfg, err = parseArgs([]string{"host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, cfg.GlobalQueueMemLimit)
+	assert.Equal(t, lib.QueueMemBlock, cfg.QueueMemPolicy)
+}
+
+func TestParseArgsRefusedMessage(t *testing.T) {
+	cfg, err := parseArgs([]string{"--refused-message=retry in {{.RetryAfterSeconds}}s", "--refused-retry-after=5s", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, "retry in {{.RetryAfterSeconds}}s", cfg.RefusedMessage)
+	assert.Equal(t, time.Second*5, cfg.RefusedRetryAfter)
+}
+
+func TestParseArgsDestHealthCheck(t *testing.T) {
+	cfg, err := parseArgs([]string{"--dest-health-interval=5s", "--dest-health-timeout=1s", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, &lib.DestHealthCheckCfg{Interval: time.Second * 5, Timeout: time.Second}, cfg.DestHealthCheck)
+
+	cfg, err = parseArgs([]string{"host:777"})
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.DestHealthCheck)
+}
+
+func TestParseArgsJitterCorrelation(t *testing.T) {
+	cfg, err := parseArgs([]string{
+		"--latency-stddev=2ms",
+		"--server-to-client-latency-stddev=5ms",
+		"--jitter-correlation=0.8",
+		"host:777",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0.8, cfg.Latency.JitterCorrelation)
+	assert.Equal(t, 0.8, cfg.ServerToClientLatency.JitterCorrelation)
+}
+
+func TestParseArgsServerToClientLatency(t *testing.T) {
+	cfg, err := parseArgs([]string{
+		"--latency=10ms",
+		"--latency-stddev=2ms",
+		"--server-to-client-latency=50ms",
+		"--server-to-client-latency-stddev=5ms",
+		"--latency-seed=7",
+		"host:777",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Millisecond*2, cfg.Latency.GaussianStdDev)
+	assert.Equal(t, &lib.LatencyCfg{
+		Base:           time.Millisecond * 50,
+		GaussianStdDev: time.Millisecond * 5,
+		Seed:           7,
+	}, cfg.ServerToClientLatency)
+
+	cfg, err = parseArgs([]string{"host:777"})
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.ServerToClientLatency)
+}
+
+func TestParseArgsProbeBackend(t *testing.T) {
+	cfg, err := parseArgs([]string{"--probe-backend", "host:777"})
+	assert.Nil(t, err)
+	assert.True(t, cfg.ProbeBackendOnStart)
+}
+
+func TestParseArgsBandwidth(t *testing.T) {
+	cfg, err := parseArgs([]string{"--bandwidth=1MB", "--bandwidth-algorithm=fixedwindow", "--bandwidth-window=1s", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1<<20, cfg.Bandwidth)
+	assert.Equal(t, lib.BandwidthFixedWindow, cfg.BandwidthAlgorithm)
+	assert.Equal(t, time.Second, cfg.BandwidthWindow)
+
+	cfg, err = parseArgs([]string{"host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, cfg.Bandwidth)
+	assert.Equal(t, lib.BandwidthTokenBucket, cfg.BandwidthAlgorithm)
+
+	_, err = parseArgs([]string{"--bandwidth-algorithm=nope", "host:777"})
+	assert.NotNil(t, err)
+}
+
+func TestParseArgsBandwidthSchedule(t *testing.T) {
+	cfg, err := parseArgs([]string{"--bandwidth-schedule=10s:1MB", "--bandwidth-schedule=5s:0", "--bandwidth-schedule-global", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, []lib.BandwidthStep{
+		{Duration: time.Second * 10, Bandwidth: 1 << 20},
+		{Duration: time.Second * 5},
+	}, cfg.BandwidthSchedule)
+	assert.True(t, cfg.BandwidthScheduleGlobal)
+
+	_, err = parseArgs([]string{"--bandwidth-schedule=1MB", "host:777"})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error parsing bandwidth schedule step"))
+	_, err = parseArgs([]string{"--bandwidth-schedule=10s:lots", "host:777"})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error parsing bandwidth schedule step"))
+}
+
+func TestParseArgsSlowStart(t *testing.T) {
+	cfg, err := parseArgs([]string{"--slow-start-penalty=200ms", "--slow-start-duration=30s", "--slow-start-curve=exponential", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, &lib.SlowStartCfg{
+		Penalty:      time.Millisecond * 200,
+		RampDuration: time.Second * 30,
+		RampCurve:    lib.RampExponential,
+	}, cfg.SlowStart)
+
+	cfg, err = parseArgs([]string{"host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, lib.RampLinear, cfg.SlowStart.RampCurve)
+}
+
+func TestParseArgsIdleLatency(t *testing.T) {
+	cfg, err := parseArgs([]string{"--idle-latency-ratio=0.1", "--idle-latency-threshold=500ms", "--idle-latency-max=2s", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, &lib.IdleLatencyCfg{
+		Ratio:     0.1,
+		Threshold: time.Millisecond * 500,
+		Max:       time.Second * 2,
+	}, cfg.IdleLatency)
+}
+
+func TestParseArgsAcceptTimeline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timeline.log")
+	os.WriteFile(path, []byte("2022-08-01T10:00:00Z\n2022-08-01T10:00:00.5Z\n"), 0600)
+
+	cfg, err := parseArgs([]string{"--accept-timeline=" + path, "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{time.Millisecond * 500}, cfg.AcceptTimeline)
+
+	os.WriteFile(path, []byte("yesterday\n"), 0600)
+	_, err = parseArgs([]string{"--accept-timeline=" + path, "host:777"})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error parsing accept timeline line 1"))
+}
+
+func TestParseArgsLabelVirtualHosts(t *testing.T) {
+	cfg, err := parseArgs([]string{"--label-virtual-hosts", "host:777"})
+	assert.Nil(t, err)
+	assert.True(t, cfg.LabelVirtualHosts)
+}
+
+func TestParseArgsAllowedSNI(t *testing.T) {
+	cfg, err := parseArgs([]string{"--allowed-sni=a.example.com", "--allowed-sni=b.example.com", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, cfg.AllowedSNIs)
+}
+
+func TestParseArgsAcceptWorkers(t *testing.T) {
+	cfg, err := parseArgs([]string{"--accept-workers=4", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, cfg.AcceptWorkers)
+}
+
+func TestParseArgsRTTLatency(t *testing.T) {
+	cfg, err := parseArgs([]string{"--rtt-latency-multiplier=1.5", "--rtt-latency-max=1s", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, &lib.RTTLatencyCfg{Multiplier: 1.5, Smoothing: 0.125, Max: time.Second}, cfg.RTTLatency)
+}
+
+func TestParseArgsLatencyBudget(t *testing.T) {
+	cfg, err := parseArgs([]string{"--latency-budget=2s", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second*2, cfg.LatencyBudget)
+}
+
+func TestParseArgsCancelDialOnClientClose(t *testing.T) {
+	cfg, err := parseArgs([]string{"--cancel-dial-on-client-close", "host:777"})
+	assert.Nil(t, err)
+	assert.True(t, cfg.CancelDialOnClientClose)
+}
+
+func TestParseArgsCompressionDelay(t *testing.T) {
+	cfg, err := parseArgs([]string{"--compression-delay-per-kb=2ms", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Millisecond*2, cfg.CompressionDelayPerKB)
+}
+
+func TestParseArgsReadRetries(t *testing.T) {
+	cfg, err := parseArgs([]string{"--read-retries=3", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, cfg.ReadRetries)
+	assert.Equal(t, time.Millisecond*10, cfg.ReadRetryBackoff)
+}
+
+func TestParseArgsMaxQueueAge(t *testing.T) {
+	cfg, err := parseArgs([]string{"--max-queue-age=500ms", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Millisecond*500, cfg.MaxQueueAge)
+}
+
+func TestParseArgsPortRange(t *testing.T) {
+	cfg, err := parseArgs([]string{"--port-range=9000-9099", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, "9000-9099", cfg.PortRangePreferred)
+}
+
+func TestParseArgsCaptureDir(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := parseArgs([]string{"--capture-dir=" + dir, "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, dir, cfg.CaptureDir)
+
+	_, err = parseArgs([]string{"--capture-dir=/nonexistent/dir", "host:777"})
+	assert.NotNil(t, err)
+}
+
+func TestParseArgsDrainBatchSize(t *testing.T) {
+	cfg, err := parseArgs([]string{"--drain-batch-size=16", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 16, cfg.DrainBatchSize)
+}
+
+func TestParseArgsAcceptDelayJitter(t *testing.T) {
+	cfg, err := parseArgs([]string{"--accept-delay-jitter=20ms", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Millisecond*20, cfg.AcceptDelayJitter)
+}
+
+func TestParseArgsMigrateOnReload(t *testing.T) {
+	cfg, err := parseArgs([]string{"--migrate-on-reload", "host:777"})
+	assert.Nil(t, err)
+	assert.True(t, cfg.MigrateOnReload)
+}
+
+func TestParseArgsSink(t *testing.T) {
+	cfg, err := parseArgs([]string{"--sink", "--bandwidth=1MB"})
+	assert.Nil(t, err)
+	assert.Equal(t, lib.ModeSink, cfg.Mode)
+	assert.Equal(t, "", cfg.DestAddr)
+
+	cfg, err = parseArgs([]string{"host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, lib.ModeProxy, cfg.Mode)
+
+	// the destination is only optional in sink mode
+	_, err = parseArgs([]string{"--bandwidth=1MB"})
+	assert.NotNil(t, err)
+}
+
+func TestParseArgsSource(t *testing.T) {
+	cfg, err := parseArgs([]string{"--source", "--source-pattern=abc", "--source-bytes=10KB"})
+	assert.Nil(t, err)
+	assert.Equal(t, lib.ModeSource, cfg.Mode)
+	assert.Equal(t, []byte("abc"), cfg.SourcePattern)
+	assert.Equal(t, int64(10*1024), cfg.SourceBytes)
+
+	_, err = parseArgs([]string{"--source", "--sink"})
+	assert.NotNil(t, err)
+}
+
+func TestParseArgsMinLatency(t *testing.T) {
+	cfg, err := parseArgs([]string{"--latency=5ms", "--latency-stddev=10ms", "--min-latency=2ms", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Millisecond*2, cfg.MinLatency)
+}
+
+func TestParseArgsLinkWindow(t *testing.T) {
+	cfg, err := parseArgs([]string{"--link-window=64KB", "--latency=100ms", "host:777"})
+	assert.Nil(t, err)
+	assert.Equal(t, 64*1024, cfg.LinkWindow)
+}
+
+func TestParseArgsFingerprintRoutes(t *testing.T) {
+	cfg, err := parseArgs([]string{
+		"--fingerprint-route=http:localhost:8080",
+		"--fingerprint-route=ssh:[::1]:22",
+		"host:777",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"http": "localhost:8080", "ssh": "[::1]:22"}, cfg.FingerprintRoutes)
+	assert.Equal(t, "tls", cfg.FingerprintFunc([]byte{0x16}))
+
+	cfg, err = parseArgs([]string{"host:777"})
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.FingerprintFunc)
+
+	_, err = parseArgs([]string{"--fingerprint-route=localhost:8080", "host:777"})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error parsing fingerprint route"))
 }
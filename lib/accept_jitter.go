@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// acceptJitter picks random delays applied before handing off accepted connections,
+// so that clients connecting at once don't get their connections set up in lockstep.
+// It's shared by all accept workers.
+type acceptJitter struct {
+	max time.Duration
+	// mu guards rng, which isn't safe for concurrent use
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newAcceptJitter(max time.Duration, seed int64) *acceptJitter {
+	if max <= 0 {
+		return nil
+	}
+	return &acceptJitter{
+		max: max,
+		rng: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// next returns a random delay within [0, max] (always 0 if j is nil)
+func (j *acceptJitter) next() time.Duration {
+	if j == nil {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return time.Duration(j.rng.Int63n(int64(j.max) + 1))
+}
@@ -0,0 +1,214 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpeedbumpAcceptWorkersUniqueIDs(t *testing.T) {
+	conns := 200
+	ids := make(chan int, conns)
+	cfg := SpeedbumpCfg{
+		Port:          8040,
+		DestAddr:      "localhost:9043",
+		BufferSize:    0xffff,
+		Latency:       defaultLatencyCfg,
+		LogLevel:      "ERROR",
+		AcceptWorkers: 4,
+		ConnTraceFunc: func(ctx context.Context, info ConnInfo) func(ConnSummary) {
+			ids <- info.ID
+			return nil
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", "localhost:8040")
+			if assert.Nil(t, err) {
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	for i := 0; i < conns; i++ {
+		id := <-ids
+		assert.False(t, seen[id], "duplicate connection ID %d", id)
+		seen[id] = true
+	}
+	for id := 0; id < conns; id++ {
+		assert.True(t, seen[id], "missing connection ID %d", id)
+	}
+}
+
+func benchmarkAcceptWorkers(b *testing.B, port, workers int) {
+	cfg := SpeedbumpCfg{
+		Port:          port,
+		DestAddr:      "localhost:9043",
+		BufferSize:    0xffff,
+		Latency:       defaultLatencyCfg,
+		LogLevel:      "ERROR",
+		AcceptWorkers: workers,
+	}
+	s, err := NewSpeedbump(&cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer s.Stop()
+	addr := fmt.Sprintf("localhost:%d", port)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			conn.Close()
+		}
+	})
+}
+
+func BenchmarkAcceptWorkers1(b *testing.B) {
+	benchmarkAcceptWorkers(b, 8041, 1)
+}
+
+func BenchmarkAcceptWorkers4(b *testing.B) {
+	benchmarkAcceptWorkers(b, 8042, 4)
+}
+
+func TestSpeedbumpAcceptWorkersIdleTimeout(t *testing.T) {
+	timeouts := make(chan error, 10)
+	clock := NewVirtualClock(time.Unix(0, 0))
+	cfg := SpeedbumpCfg{
+		Host:              "127.0.0.1",
+		Port:              0,
+		DestAddr:          "localhost:9043",
+		BufferSize:        0xffff,
+		Latency:           defaultLatencyCfg,
+		LogLevel:          "ERROR",
+		AcceptWorkers:     4,
+		AcceptIdleTimeout: time.Millisecond * 50,
+		Clock:             clock,
+		OnTimeout: func(err error) {
+			timeouts <- err
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Millisecond * 50)
+	<-timeouts
+	// the idle period is watched once for all workers, so the next one is awaited right away
+	clock.BlockUntil(1)
+	assert.Empty(t, timeouts)
+	assert.Equal(t, 1, s.Stats().AcceptIdleTimeouts)
+}
+
+func TestAcceptJitterNext(t *testing.T) {
+	var disabled *acceptJitter
+	assert.Equal(t, time.Duration(0), disabled.next())
+	assert.Nil(t, newAcceptJitter(0, 1))
+
+	j := newAcceptJitter(time.Millisecond*10, 1)
+	for i := 0; i < 100; i++ {
+		d := j.next()
+		assert.GreaterOrEqual(t, int64(d), int64(0))
+		assert.LessOrEqual(t, int64(d), int64(time.Millisecond*10))
+	}
+}
+
+// acceptSpread opens a number of connections at once and returns the time between
+// the first and the last of them being handed off by the accept loop
+func acceptSpread(t *testing.T, port int, jitter time.Duration) time.Duration {
+	conns := 10
+	handedOff := make(chan time.Time, conns)
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:              port,
+		DestAddr:          "localhost:1234",
+		BufferSize:        0xffff,
+		Latency:           defaultLatencyCfg,
+		LogLevel:          "ERROR",
+		AcceptDelayJitter: jitter,
+		ConnTraceFunc: func(ctx context.Context, info ConnInfo) func(ConnSummary) {
+			handedOff <- time.Now()
+			return nil
+		},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	for i := 0; i < conns; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		if assert.Nil(t, err) {
+			defer conn.Close()
+		}
+	}
+	first := <-handedOff
+	last := first
+	for i := 1; i < conns; i++ {
+		last = <-handedOff
+	}
+	return last.Sub(first)
+}
+
+func TestSpeedbumpAcceptDelayJitter(t *testing.T) {
+	// without jitter, the connections are set up right away
+	assert.Less(t, int64(acceptSpread(t, 8047, 0)), int64(time.Millisecond*50))
+	// with jitter, they are spread out by an average of 50ms each
+	assert.Greater(t, int64(acceptSpread(t, 8048, time.Millisecond*100)), int64(time.Millisecond*200))
+}
+
+func TestAcceptConnectionsStopsOnClosedListener(t *testing.T) {
+	logger, buf := newBufferLogger()
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:          8063,
+		DestAddr:      "localhost:1234",
+		BufferSize:    0xffff,
+		Latency:       defaultLatencyCfg,
+		LogLevel:      "WARN",
+		AcceptWorkers: 4,
+	})
+	assert.Nil(t, err)
+	s.log = logger
+	s.listener, err = net.ListenTCP("tcp", &s.srcAddr)
+	assert.Nil(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		s.startAcceptLoop()
+		close(done)
+	}()
+	time.Sleep(time.Millisecond * 50)
+	s.listener.Close()
+
+	// closing the listener is detected via net.ErrClosed rather than the error's message
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("accept loop didn't return after the listener was closed")
+	}
+	assert.Equal(t, []string{""}, buf.lines())
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// unixAddrPrefix marks admin API addresses that refer to a Unix socket path
+const unixAddrPrefix = "unix:"
+
+// defaultStreamInterval is the interval between stats snapshots pushed by /stats/stream
+const defaultStreamInterval = time.Second
+
+// listenAdmin creates a listener for the admin API on either a TCP address
+// in host:port format or a Unix socket (specified as unix:/path)
+func listenAdmin(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, unixAddrPrefix) {
+		return net.Listen("unix", strings.TrimPrefix(addr, unixAddrPrefix))
+	}
+	return net.Listen("tcp", addr)
+}
+
+func (s *Speedbump) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Stats())
+	})
+	mux.HandleFunc("/stats/stream", s.streamStats)
+	mux.HandleFunc("/connections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.ConnStats())
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		s.SaveConfig(w, "json")
+	})
+	mux.HandleFunc("/capabilities", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Capabilities())
+	})
+	return mux
+}
+
+// streamStats pushes periodic stats snapshots as Server-Sent Events until
+// the client disconnects or the instance is stopped. The interval between
+// snapshots can be customized via the interval query parameter (e.g. ?interval=500ms).
+func (s *Speedbump) streamStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	interval := defaultStreamInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid interval: %s", v), http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	for {
+		data, err := json.Marshal(s.Stats())
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: stats\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-s.clock.After(interval):
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// startAdmin starts serving the admin API if AdminAddr was configured
+func (s *Speedbump) startAdmin() error {
+	if s.adminAddr == "" {
+		return nil
+	}
+	listener, err := listenAdmin(s.adminAddr)
+	if err != nil {
+		return fmt.Errorf("Error starting admin API listener: %s", err)
+	}
+	s.adminServer = &http.Server{Handler: s.adminHandler()}
+	s.adminListener = listener
+	go s.adminServer.Serve(listener)
+	s.log.Info("Started admin API", "addr", listener.Addr().String())
+	return nil
+}
+
+// AdminAddr returns the address the admin API is listening on
+// (nil if the admin API is disabled or the instance wasn't started)
+func (s *Speedbump) AdminAddr() net.Addr {
+	if s.adminListener == nil {
+		return nil
+	}
+	return s.adminListener.Addr()
+}
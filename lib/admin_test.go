@@ -0,0 +1,181 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func unixHTTPClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func TestAdminAPIUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8011,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+		AdminAddr:  "unix:" + path,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	assert.Equal(t, path, s.AdminAddr().String())
+
+	client := unixHTTPClient(path)
+	res, err := client.Get("http://speedbump/stats")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var stats Stats
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&stats))
+	res.Body.Close()
+	assert.Equal(t, Stats{}, stats)
+
+	res, err = client.Get("http://speedbump/connections")
+	assert.Nil(t, err)
+	var conns []ConnStats
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&conns))
+	res.Body.Close()
+	assert.Empty(t, conns)
+
+	res, err = client.Get("http://speedbump/config")
+	assert.Nil(t, err)
+	cfg, err := LoadConfig(res.Body, "json")
+	res.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:1234", cfg.DestAddr)
+
+	res, err = client.Post("http://speedbump/stats", "application/json", strings.NewReader(""))
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+
+	s.Stop()
+
+	// the socket is removed once the instance is stopped
+	_, err = client.Get("http://speedbump/stats")
+	assert.NotNil(t, err)
+}
+
+func TestAdminAPITCP(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8012,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+		AdminAddr:  "localhost:0",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	res, err := http.Get("http://" + s.AdminAddr().String() + "/stats")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestAdminAPIListenError(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8013,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+		AdminAddr:  "unix:" + filepath.Join(t.TempDir(), "missing", "admin.sock"),
+	})
+	assert.Nil(t, err)
+	err = s.Start()
+	assert.True(t, strings.HasPrefix(err.Error(), "Error starting admin API listener"))
+	assert.Nil(t, s.AdminAddr())
+
+	// the proxy listener was closed, so the port can be reused
+	l, err := net.Listen("tcp", ":8013")
+	assert.Nil(t, err)
+	l.Close()
+}
+
+func TestAdminAPIStatsStream(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8044,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+		AdminAddr:  "localhost:0",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	res, err := http.Get("http://" + s.AdminAddr().String() + "/stats/stream?interval=50ms")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	scanner := bufio.NewScanner(res.Body)
+	start := time.Now()
+	snapshots := 0
+	for snapshots < 3 && scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var stats Stats
+		assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &stats))
+		snapshots++
+	}
+	assert.Equal(t, 3, snapshots)
+	// the first snapshot is sent right away, followed by one per interval
+	assert.True(t, isDurationCloseTo(time.Millisecond*100, time.Since(start), 50))
+
+	done := make(chan struct{})
+	go func() {
+		for scanner.Scan() {
+		}
+		close(done)
+	}()
+	s.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stats stream wasn't closed on Stop()")
+	}
+}
+
+func TestAdminAPIStatsStreamInvalidInterval(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8045,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+		AdminAddr:  "localhost:0",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	res, err := http.Get("http://" + s.AdminAddr().String() + "/stats/stream?interval=soon")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
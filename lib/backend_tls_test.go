@@ -0,0 +1,196 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// selfSignedCert returns a certificate valid for localhost and 127.0.0.1
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func startTLSEchoSrv(t *testing.T, port int) {
+	l, err := tls.Listen("tcp", fmt.Sprintf("localhost:%d", port), &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+}
+
+func TestSpeedbumpBackendTLS(t *testing.T) {
+	startTLSEchoSrv(t, 9026)
+
+	cfg := SpeedbumpCfg{
+		Port:                      8024,
+		DestAddr:                  "localhost:9026",
+		BufferSize:                0xffff,
+		Latency:                   &LatencyCfg{Base: time.Millisecond * 100},
+		LogLevel:                  "ERROR",
+		BackendTLS:                true,
+		BackendInsecureSkipVerify: true,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost", s.backendTLS.ServerName)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8024")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	start := time.Now()
+	conn.Write([]byte("test-string"))
+	res := make([]byte, 1024)
+	n, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:n])
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Millisecond*100))
+}
+
+func TestSpeedbumpBackendTLSVerifyFails(t *testing.T) {
+	startTLSEchoSrv(t, 9027)
+
+	cfg := SpeedbumpCfg{
+		Port:       8025,
+		DestAddr:   "localhost:9027",
+		BufferSize: 0xffff,
+		LogLevel:   "ERROR",
+		BackendTLS: true,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	// the self-signed certificate is rejected, so the client gets disconnected
+	conn, err := net.Dial("tcp", "localhost:8025")
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1024))
+	assert.Equal(t, io.EOF, err)
+}
+
+// startStuckTLSSrv accepts TCP connections without ever completing a TLS handshake
+func startStuckTLSSrv(t *testing.T, port int) {
+	l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(io.Discard, c)
+			}(conn)
+		}
+	}()
+}
+
+func TestSpeedbumpBackendTLSHandshakeTimeout(t *testing.T) {
+	startStuckTLSSrv(t, 9051)
+
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:        8058,
+		DestAddr:    "localhost:9051",
+		BufferSize:  0xffff,
+		LogLevel:    "ERROR",
+		BackendTLS:  true,
+		DialTimeout: time.Millisecond * 200,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8058")
+	assert.Nil(t, err)
+	defer conn.Close()
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	_, err = conn.Read(make([]byte, 1024))
+	// the client gets disconnected once the handshake exceeds the dial timeout
+	assert.Equal(t, io.EOF, err)
+	assert.True(t, isDurationCloseTo(time.Millisecond*200, time.Since(start), 50))
+	assert.Equal(t, 1, s.Stats().DialTimeouts)
+}
+
+func TestSpeedbumpBackendTLSHandshakeAbortedOnStop(t *testing.T) {
+	startStuckTLSSrv(t, 9052)
+
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8059,
+		DestAddr:   "localhost:9052",
+		BufferSize: 0xffff,
+		LogLevel:   "ERROR",
+		BackendTLS: true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	conn, err := net.Dial("tcp", "localhost:8059")
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Eventually(t, func() bool {
+		s.activeMu.Lock()
+		defer s.activeMu.Unlock()
+		return s.activeCount == 1
+	}, time.Second, time.Millisecond*10)
+	// give the connection time to start the handshake
+	time.Sleep(time.Millisecond * 50)
+
+	// Stop() waits for the connection, whose handshake gets aborted
+	start := time.Now()
+	s.Stop()
+	assert.Less(t, time.Since(start), time.Millisecond*500)
+	assert.Equal(t, 0, s.Stats().DialTimeouts)
+}
@@ -0,0 +1,260 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultBandwidthWindow is used if BandwidthWindow is unspecified
+const defaultBandwidthWindow = time.Millisecond * 100
+
+// BandwidthAlgorithm selects how Bandwidth is enforced, which determines
+// how bursts of traffic are shaped
+type BandwidthAlgorithm int
+
+const (
+	// BandwidthTokenBucket lets bursts of up to BandwidthWindow worth of traffic through
+	// without delay once the connection was idle, delaying the rest to match the rate
+	BandwidthTokenBucket BandwidthAlgorithm = iota
+	// BandwidthLeakyBucket releases data at a constant rate, smoothing out all bursts
+	BandwidthLeakyBucket
+	// BandwidthFixedWindow lets through up to BandwidthWindow worth of traffic within each
+	// window aligned to multiples of BandwidthWindow, delaying the excess to the next one,
+	// which allows for bursts of up to twice the window's budget around window boundaries
+	BandwidthFixedWindow
+)
+
+func (a BandwidthAlgorithm) String() string {
+	switch a {
+	case BandwidthLeakyBucket:
+		return "leakybucket"
+	case BandwidthFixedWindow:
+		return "fixedwindow"
+	}
+	return "tokenbucket"
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (a BandwidthAlgorithm) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (a *BandwidthAlgorithm) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "tokenbucket":
+		*a = BandwidthTokenBucket
+	case "leakybucket":
+		*a = BandwidthLeakyBucket
+	case "fixedwindow":
+		*a = BandwidthFixedWindow
+	default:
+		return fmt.Errorf("Unknown bandwidth algorithm: %s", text)
+	}
+	return nil
+}
+
+// rateLimiter limits the throughput of a single direction of a proxy connection
+type rateLimiter interface {
+	// reserve returns how long to wait at a given point in time before n bytes can be sent
+	reserve(now time.Time, n int) time.Duration
+}
+
+// BandwidthStep is a single step of a bandwidth schedule
+type BandwidthStep struct {
+	// Duration is how long the step lasts
+	Duration time.Duration `json:"duration" yaml:"duration"`
+	// Bandwidth is the throughput limit in effect during the step in bytes per second
+	// (unlimited if 0)
+	Bandwidth int `json:"bandwidth" yaml:"bandwidth"`
+}
+
+// bandwidthLimit creates the rate limiters of proxy connections
+type bandwidthLimit struct {
+	// rate is in bytes per second
+	rate      int
+	window    time.Duration
+	algorithm BandwidthAlgorithm
+	// schedule optionally overrides rate with steps starting at scheduleStart,
+	// which is the time each connection was opened unless the schedule is global
+	schedule      []BandwidthStep
+	scheduleStart time.Time
+	global        bool
+}
+
+func newBandwidthLimit(cfg *SpeedbumpCfg) bandwidthLimit {
+	window := cfg.BandwidthWindow
+	if window <= 0 {
+		window = defaultBandwidthWindow
+	}
+	rate := cfg.Bandwidth
+	if cfg.LinkWindow > 0 {
+		rate = linkBandwidth(cfg.LinkWindow, cfg.Latency)
+	}
+	return bandwidthLimit{
+		rate:      rate,
+		window:    window,
+		algorithm: cfg.BandwidthAlgorithm,
+		schedule:  cfg.BandwidthSchedule,
+		global:    cfg.BandwidthScheduleGlobal,
+	}
+}
+
+// validateBandwidthSchedule checks that each step of a bandwidth schedule lasts for some time
+func validateBandwidthSchedule(schedule []BandwidthStep) error {
+	for i, step := range schedule {
+		if step.Duration <= 0 {
+			return fmt.Errorf("Error configuring bandwidth schedule: step %d must have a positive duration", i+1)
+		}
+		if step.Bandwidth < 0 {
+			return fmt.Errorf("Error configuring bandwidth schedule: step %d has a negative bandwidth", i+1)
+		}
+	}
+	return nil
+}
+
+// forConnection returns the bandwidth limit of a connection opened at a given point in time,
+// which starts its bandwidth schedule unless the schedule is global
+func (b bandwidthLimit) forConnection(opened time.Time) bandwidthLimit {
+	if len(b.schedule) > 0 && !b.global {
+		b.scheduleStart = opened
+	}
+	return b
+}
+
+// linkBandwidth returns the throughput in bytes per second of a link keeping window bytes
+// in flight with a given latency (0, which means unlimited, if there's no base latency)
+func linkBandwidth(window int, latency *LatencyCfg) int {
+	if latency == nil || latency.Base <= 0 {
+		return 0
+	}
+	return int(float64(window) / latency.Base.Seconds())
+}
+
+// newLimiter returns a rate limiter for a single direction of a proxy connection
+// (nil if bandwidth isn't limited)
+func (b bandwidthLimit) newLimiter() rateLimiter {
+	if len(b.schedule) > 0 {
+		return &scheduledLimiter{limit: b, step: -1}
+	}
+	if b.rate <= 0 {
+		return nil
+	}
+	switch b.algorithm {
+	case BandwidthLeakyBucket:
+		return &leakyBucket{rate: float64(b.rate)}
+	case BandwidthFixedWindow:
+		budget := int(float64(b.rate) * b.window.Seconds())
+		if budget < 1 {
+			budget = 1
+		}
+		return &fixedWindow{window: b.window, budget: budget}
+	}
+	capacity := float64(b.rate) * b.window.Seconds()
+	return &tokenBucket{rate: float64(b.rate), capacity: capacity, tokens: capacity}
+}
+
+// bytesDuration returns the time it takes to send n bytes at a given rate
+func bytesDuration(n float64, rate float64) time.Duration {
+	return time.Duration(n / rate * float64(time.Second))
+}
+
+type tokenBucket struct {
+	rate, capacity float64
+	// tokens may turn negative, in which case the bucket is in debt
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) reserve(now time.Time, n int) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return bytesDuration(-b.tokens, b.rate)
+}
+
+type leakyBucket struct {
+	rate float64
+	// next is the time at which the bucket is free to send more data
+	next time.Time
+}
+
+func (b *leakyBucket) reserve(now time.Time, n int) time.Duration {
+	if b.next.Before(now) {
+		b.next = now
+	}
+	wait := b.next.Sub(now)
+	b.next = b.next.Add(bytesDuration(float64(n), b.rate))
+	return wait
+}
+
+type fixedWindow struct {
+	window time.Duration
+	budget int
+	// start is the start of the window in which the next data is sent,
+	// while used counts the bytes sent within it
+	start time.Time
+	used  int
+}
+
+func (w *fixedWindow) reserve(now time.Time, n int) time.Duration {
+	if !now.Before(w.start.Add(w.window)) {
+		w.start = now.Truncate(w.window)
+		w.used = 0
+	}
+	// data exceeding the budget is carried over to the following windows
+	for w.used >= w.budget {
+		w.start = w.start.Add(w.window)
+		w.used -= w.budget
+	}
+	w.used += n
+	if w.start.After(now) {
+		return w.start.Sub(now)
+	}
+	return 0
+}
+
+// scheduledLimiter advances through the steps of a bandwidth schedule, enforcing each
+// step's rate with a fresh limiter of the configured algorithm. Once the schedule ends,
+// the rate of its last step stays in effect.
+type scheduledLimiter struct {
+	limit bandwidthLimit
+	// step is the index of the current step, whose limiter is nil if it's unlimited
+	step    int
+	limiter rateLimiter
+}
+
+func (s *scheduledLimiter) reserve(now time.Time, n int) time.Duration {
+	if step := s.stepAt(now); step != s.step {
+		s.step = step
+		s.limiter = bandwidthLimit{
+			rate:      s.limit.schedule[step].Bandwidth,
+			window:    s.limit.window,
+			algorithm: s.limit.algorithm,
+		}.newLimiter()
+	}
+	if s.limiter == nil {
+		return 0
+	}
+	return s.limiter.reserve(now, n)
+}
+
+// stepAt returns the index of the schedule's step in effect at a given point in time
+func (s *scheduledLimiter) stepAt(when time.Time) int {
+	elapsed := when.Sub(s.limit.scheduleStart)
+	for i, step := range s.limit.schedule {
+		if elapsed < step.Duration {
+			return i
+		}
+		elapsed -= step.Duration
+	}
+	return len(s.limit.schedule) - 1
+}
@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// burstWaits reserves n buffers of a given size at the same point in time
+func burstWaits(l rateLimiter, at time.Time, n, size int) []time.Duration {
+	waits := make([]time.Duration, n)
+	for i := range waits {
+		waits[i] = l.reserve(at, size)
+	}
+	return waits
+}
+
+func TestTokenBucketAllowsBursts(t *testing.T) {
+	start := time.Unix(1000, 0)
+	l := bandwidthLimit{rate: 1000, window: time.Second, algorithm: BandwidthTokenBucket}.newLimiter()
+
+	// a full bucket lets a second worth of traffic through right away
+	waits := burstWaits(l, start, 12, 100)
+	for _, w := range waits[:10] {
+		assert.Equal(t, time.Duration(0), w)
+	}
+	// the rest is delayed to match the rate
+	assert.Equal(t, time.Millisecond*100, waits[10])
+	assert.Equal(t, time.Millisecond*200, waits[11])
+
+	// the bucket refills while idle, but never beyond its capacity
+	waits = burstWaits(l, start.Add(time.Second*10), 11, 100)
+	assert.Equal(t, time.Duration(0), waits[9])
+	assert.Equal(t, time.Millisecond*100, waits[10])
+}
+
+func TestLeakyBucketSmoothsBursts(t *testing.T) {
+	start := time.Unix(1000, 0)
+	l := bandwidthLimit{rate: 1000, window: time.Second, algorithm: BandwidthLeakyBucket}.newLimiter()
+
+	// each buffer of a burst is released 100ms after the previous one
+	for i, w := range burstWaits(l, start, 10, 100) {
+		assert.Equal(t, time.Millisecond*100*time.Duration(i), w)
+	}
+
+	// idle time doesn't accumulate credit for a later burst
+	waits := burstWaits(l, start.Add(time.Second*10), 2, 100)
+	assert.Equal(t, []time.Duration{0, time.Millisecond * 100}, waits)
+}
+
+func TestFixedWindowBurstsAtBoundaries(t *testing.T) {
+	start := time.Unix(1000, 0)
+	l := bandwidthLimit{rate: 1000, window: time.Second, algorithm: BandwidthFixedWindow}.newLimiter()
+
+	// a window's budget is sent right away, regardless of how late in the window it's sent
+	lateInWindow := start.Add(time.Millisecond * 900)
+	waits := burstWaits(l, lateInWindow, 20, 100)
+	for _, w := range waits[:10] {
+		assert.Equal(t, time.Duration(0), w)
+	}
+	// with the excess all released at once as the next window begins,
+	// twice the budget gets through within 100ms
+	for _, w := range waits[10:] {
+		assert.Equal(t, time.Millisecond*100, w)
+	}
+	// the following window's budget is used up, so more data waits for the one after
+	assert.Equal(t, time.Millisecond*1100, l.reserve(lateInWindow, 100))
+
+	// buffers larger than the budget are carried over to the following windows
+	l = bandwidthLimit{rate: 1000, window: time.Second, algorithm: BandwidthFixedWindow}.newLimiter()
+	assert.Equal(t, time.Duration(0), l.reserve(start, 2500))
+	assert.Equal(t, time.Second*2, l.reserve(start, 100))
+}
+
+func TestBandwidthLimitDisabled(t *testing.T) {
+	assert.Nil(t, bandwidthLimit{}.newLimiter())
+	assert.Equal(t, defaultBandwidthWindow, newBandwidthLimit(&SpeedbumpCfg{Bandwidth: 1}).window)
+}
+
+func TestBandwidthSchedule(t *testing.T) {
+	start := time.Unix(1000, 0)
+	schedule := []BandwidthStep{
+		{Duration: time.Second, Bandwidth: 1000},
+		{Duration: time.Second * 2, Bandwidth: 0},
+		{Duration: time.Second, Bandwidth: 500},
+	}
+	b := bandwidthLimit{rate: 1, window: time.Second, algorithm: BandwidthLeakyBucket, schedule: schedule}
+	l := b.forConnection(start).newLimiter()
+
+	// the first step releases 100 bytes every 100ms, overriding rate
+	assert.Equal(t, []time.Duration{0, time.Millisecond * 100}, burstWaits(l, start, 2, 100))
+	assert.Equal(t, time.Millisecond*200, l.reserve(start, 100))
+
+	// the second step doesn't limit throughput
+	for _, w := range burstWaits(l, start.Add(time.Second), 100, 1000) {
+		assert.Equal(t, time.Duration(0), w)
+	}
+
+	// the third step halves the first one's rate and stays in effect once the schedule ends
+	thirdStep := start.Add(time.Second * 3)
+	assert.Equal(t, []time.Duration{0, time.Millisecond * 200}, burstWaits(l, thirdStep, 2, 100))
+	assert.Equal(t, []time.Duration{0, time.Millisecond * 200}, burstWaits(l, start.Add(time.Second*10), 2, 100))
+
+	// connections follow a global schedule from the time the instance was created
+	b.global = true
+	b.scheduleStart = start
+	l = b.forConnection(start.Add(time.Millisecond * 1500)).newLimiter()
+	for _, w := range burstWaits(l, start.Add(time.Millisecond*1500), 10, 1000) {
+		assert.Equal(t, time.Duration(0), w)
+	}
+}
+
+func TestBandwidthScheduleValidation(t *testing.T) {
+	_, err := NewSpeedbump(&SpeedbumpCfg{
+		DestAddr:          "localhost:80",
+		BandwidthSchedule: []BandwidthStep{{Duration: time.Second, Bandwidth: 1000}, {Bandwidth: 1000}},
+	})
+	assert.EqualError(t, err, "Error configuring bandwidth schedule: step 2 must have a positive duration")
+	_, err = NewSpeedbump(&SpeedbumpCfg{
+		DestAddr:          "localhost:80",
+		BandwidthSchedule: []BandwidthStep{{Duration: time.Second, Bandwidth: -1}},
+	})
+	assert.EqualError(t, err, "Error configuring bandwidth schedule: step 1 has a negative bandwidth")
+}
+
+func TestBandwidthAlgorithmText(t *testing.T) {
+	for _, a := range []BandwidthAlgorithm{BandwidthTokenBucket, BandwidthLeakyBucket, BandwidthFixedWindow} {
+		text, err := a.MarshalText()
+		assert.Nil(t, err)
+		var parsed BandwidthAlgorithm
+		assert.Nil(t, parsed.UnmarshalText(text))
+		assert.Equal(t, a, parsed)
+	}
+	var parsed BandwidthAlgorithm
+	assert.EqualError(t, parsed.UnmarshalText([]byte("nope")), "Unknown bandwidth algorithm: nope")
+}
+
+func TestSpeedbumpBandwidth(t *testing.T) {
+	go startEchoSrv(9038)
+	waitForListener("localhost:9038")
+
+	cfg := SpeedbumpCfg{
+		Port:               8035,
+		DestAddr:           "localhost:9038",
+		BufferSize:         0xffff,
+		Latency:            defaultLatencyCfg,
+		LogLevel:           "ERROR",
+		Bandwidth:          10000,
+		BandwidthAlgorithm: BandwidthLeakyBucket,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8035")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	start := time.Now()
+	msg := make([]byte, 1000)
+	res := make([]byte, 1000)
+	for i := 0; i < 3; i++ {
+		conn.Write(msg)
+		_, err := io.ReadFull(conn, res)
+		assert.Nil(t, err)
+	}
+	// releasing 1000 bytes at 10KB/s takes 100ms
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*200)
+	assert.Less(t, time.Since(start), time.Millisecond*600)
+}
+
+func TestLinkBandwidth(t *testing.T) {
+	assert.Equal(t, 10000, linkBandwidth(1000, &LatencyCfg{Base: time.Millisecond * 100}))
+	assert.Equal(t, 64*1024*4, linkBandwidth(64*1024, &LatencyCfg{Base: time.Millisecond * 250}))
+	// no limit is applied without a base latency
+	assert.Equal(t, 0, linkBandwidth(1000, &LatencyCfg{}))
+	assert.Equal(t, 0, linkBandwidth(1000, nil))
+}
+
+// sinkBandwidthDelay pushes 5 buffers of 1000 bytes into a sink and returns
+// the time they spent waiting for the bandwidth limit
+func sinkBandwidthDelay(t *testing.T, s *Speedbump, addr string) time.Duration {
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	for i := 0; i < 5; i++ {
+		conn.Write(make([]byte, 1000))
+		time.Sleep(time.Millisecond * 5)
+	}
+	time.Sleep(time.Millisecond * 700)
+	conns := s.ConnStats()
+	assert.Len(t, conns, 1)
+	return conns[0].BandwidthDelay
+}
+
+func TestSpeedbumpLinkWindow(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:               8064,
+		BufferSize:         1000,
+		Latency:            &LatencyCfg{Base: time.Millisecond * 100},
+		LogLevel:           "ERROR",
+		Mode:               ModeSink,
+		LinkWindow:         1000,
+		BandwidthAlgorithm: BandwidthLeakyBucket,
+	})
+	assert.Nil(t, err)
+	// 1000 bytes in flight with 100ms of latency make for 10KB/s
+	assert.Equal(t, 10000, s.cfg.Bandwidth)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	// each buffer after the first one waits 100ms for the previous one to be released
+	d := sinkBandwidthDelay(t, s, "localhost:8064")
+	assert.True(t, isDurationCloseTo(time.Millisecond*400, d, 25), d)
+
+	// halving the latency doubles the throughput of new connections
+	s.ArmLatency(&LatencyCfg{Base: time.Millisecond * 50})
+	assert.Equal(t, 20000, s.cfg.Bandwidth)
+	assert.Eventually(t, func() bool { return len(s.ConnStats()) == 0 }, time.Second, time.Millisecond*10)
+	d = sinkBandwidthDelay(t, s, "localhost:8064")
+	assert.True(t, isDurationCloseTo(time.Millisecond*200, d, 25), d)
+}
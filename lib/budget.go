@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyBudget caps the total latency injected into a single proxy connection,
+// simulating a system that eventually adapts to a degraded network. It is shared
+// by both directions of the connection.
+type latencyBudget struct {
+	mu        sync.Mutex
+	remaining time.Duration
+}
+
+func newLatencyBudget(budget time.Duration) *latencyBudget {
+	if budget <= 0 {
+		return nil
+	}
+	return &latencyBudget{remaining: budget}
+}
+
+// spend returns the part of a desired delay that fits within the remaining budget
+// and deducts it (the delay is returned as is if b is nil)
+func (b *latencyBudget) spend(d time.Duration) time.Duration {
+	if b == nil || d <= 0 {
+		return d
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d > b.remaining {
+		d = b.remaining
+	}
+	b.remaining -= d
+	return d
+}
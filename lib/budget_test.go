@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyBudgetSpend(t *testing.T) {
+	assert.Nil(t, newLatencyBudget(0))
+	var disabled *latencyBudget
+	assert.Equal(t, time.Second, disabled.spend(time.Second))
+
+	b := newLatencyBudget(time.Millisecond * 250)
+	assert.Equal(t, time.Millisecond*100, b.spend(time.Millisecond*100))
+	assert.Equal(t, time.Millisecond*100, b.spend(time.Millisecond*100))
+	// the delay exhausting the budget is trimmed to what's left of it
+	assert.Equal(t, time.Millisecond*50, b.spend(time.Millisecond*100))
+	assert.Equal(t, time.Duration(0), b.spend(time.Millisecond*100))
+}
+
+func TestReadFromSrcLatencyBudget(t *testing.T) {
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+	c := &connection{
+		srcConn:    &pausingConn{limit: 6},
+		bufferSize: 20,
+		latencyGen: &mockLatencyGenerator{time.Millisecond * 100},
+		budget:     newLatencyBudget(time.Millisecond * 300),
+		delayQueue: delayQueue,
+		counters:   &connCounters{},
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+
+	start := time.Now()
+	c.readFromSrc()
+	<-done
+
+	for i := 0; i < 6; i++ {
+		delay := (<-delayQueue).delayUntil.Sub(start)
+		if i < 3 {
+			// buffers are delayed until the budget is exhausted
+			assert.True(t, isDurationCloseTo(time.Millisecond*100, delay, 10), delay)
+		} else {
+			// and pass through without delay afterwards
+			assert.Less(t, int64(delay), int64(time.Millisecond*5))
+		}
+	}
+	assert.Equal(t, time.Millisecond*300, c.counters.snapshot(0).TotalDelayTime.ClientToServer)
+}
@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	// calibrationRounds is the number of round trips timed by Calibrate, of which
+	// the median is reported
+	calibrationRounds = 3
+	// calibrationTimeout bounds each round trip of Calibrate on top of the configured latency
+	calibrationTimeout = time.Second * 5
+)
+
+// calibrationPayload is echoed back by the loopback backend of Calibrate
+var calibrationPayload = []byte("speedbump-calibration")
+
+// Calibrate measures the latency actually added to a round trip by the latency config
+// of new connections, returning it along with the configured base latency of both
+// directions (Latency and ServerToClientLatency combined). Round trips are timed
+// through a temporary instance with the same latency config proxying to a loopback
+// echo backend, with the time of direct round trips to the backend subtracted.
+// Other delays (i.e. bandwidth limiting or stalls) are not applied. It uses real time
+// regardless of Clock and doesn't affect the instance's connections or stats.
+func (s *Speedbump) Calibrate() (configured, measured time.Duration, err error) {
+	s.latencyMu.Lock()
+	latency := s.cfg.Latency
+	s.latencyMu.Unlock()
+	returnLatency := s.cfg.ServerToClientLatency
+	for _, l := range []*LatencyCfg{latency, returnLatency} {
+		if l != nil {
+			configured += l.Base
+		}
+	}
+
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return configured, 0, fmt.Errorf("Error starting calibration backend: %s", err)
+	}
+	defer backend.Close()
+	go serveEcho(backend)
+
+	proxy, err := NewSpeedbump(&SpeedbumpCfg{
+		Host:                  "127.0.0.1",
+		DestAddr:              backend.Addr().String(),
+		BufferSize:            s.bufferSize,
+		QueueSize:             s.queueSize,
+		Latency:               latency,
+		ServerToClientLatency: returnLatency,
+		MinLatency:            s.minLatency,
+		LogLevel:              "ERROR",
+	})
+	if err != nil {
+		return configured, 0, err
+	}
+	if err := proxy.Start(); err != nil {
+		return configured, 0, err
+	}
+	defer proxy.Stop()
+
+	timeout := configured*2 + calibrationTimeout
+	direct, err := timeRoundTrips(backend.Addr().String(), timeout)
+	if err != nil {
+		return configured, 0, err
+	}
+	proxied, err := timeRoundTrips(proxy.dialAddr(), timeout)
+	if err != nil {
+		return configured, 0, err
+	}
+	measured = proxied - direct
+	if measured < 0 {
+		measured = 0
+	}
+	return configured, measured, nil
+}
+
+// serveEcho echoes data sent over connections accepted by l until it's closed
+func serveEcho(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+// timeRoundTrips returns the median time it takes calibrationPayload to be echoed back
+// over a connection to a given address (excluding the time of establishing it)
+func timeRoundTrips(addr string, timeout time.Duration) (time.Duration, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return 0, fmt.Errorf("Error connecting for calibration: %s", err)
+	}
+	defer conn.Close()
+	res := make([]byte, len(calibrationPayload))
+	rounds := make([]time.Duration, 0, calibrationRounds)
+	for i := 0; i < calibrationRounds; i++ {
+		conn.SetDeadline(time.Now().Add(timeout))
+		start := time.Now()
+		if _, err := conn.Write(calibrationPayload); err != nil {
+			return 0, fmt.Errorf("Error writing calibration payload: %s", err)
+		}
+		if _, err := io.ReadFull(conn, res); err != nil {
+			return 0, fmt.Errorf("Error reading calibration payload: %s", err)
+		}
+		rounds = append(rounds, time.Since(start))
+	}
+	sort.Slice(rounds, func(i, j int) bool { return rounds[i] < rounds[j] })
+	return rounds[len(rounds)/2], nil
+}
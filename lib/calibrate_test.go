@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// calibrationSlack bounds the overhead measured by Calibrate on top of the configured latency,
+// which is generous so that a loaded machine doesn't fail the tests
+const calibrationSlack = time.Millisecond * 200
+
+func TestSpeedbumpCalibrate(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{},
+		LogLevel:   "ERROR",
+	})
+	assert.Nil(t, err)
+
+	for _, base := range []time.Duration{0, time.Millisecond * 50, time.Millisecond * 150} {
+		s.SetLatency(base)
+		configured, measured, err := s.Calibrate()
+		assert.Nil(t, err)
+		assert.Equal(t, base, configured)
+		assert.GreaterOrEqual(t, int64(measured), int64(base), "%s", base)
+		assert.Less(t, int64(measured), int64(base+calibrationSlack), "%s", base)
+	}
+}
+
+func TestSpeedbumpCalibrateBothDirections(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		BufferSize:            0xffff,
+		Latency:               &LatencyCfg{Base: time.Millisecond * 40},
+		ServerToClientLatency: &LatencyCfg{Base: time.Millisecond * 60},
+		LogLevel:              "ERROR",
+	})
+	assert.Nil(t, err)
+
+	configured, measured, err := s.Calibrate()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Millisecond*100, configured)
+	assert.GreaterOrEqual(t, int64(measured), int64(configured))
+	assert.Less(t, int64(measured), int64(configured+calibrationSlack))
+}
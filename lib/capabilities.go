@@ -0,0 +1,40 @@
+package lib
+
+// Version is the version of the speedbump package
+const Version = "1.1.0"
+
+// latencyGenerators lists the kinds of latency combined by LatencyCfg
+var latencyGenerators = []string{"base", "sine", "sawtooth", "square", "triangle", "gaussian", "uniform", "markov"}
+
+// Capabilities describes the version and features of a Speedbump instance,
+// which lets a control plane talking to instances of various versions
+// find out what each of them supports
+type Capabilities struct {
+	// Version is the version of the package the instance was built with
+	Version string `json:"version"`
+	// Modes lists the supported values of Mode
+	Modes []string `json:"modes"`
+	// Protocols lists the protocols that can be proxied, which are TCP and TLS (detected
+	// on the listening port with TLSDestAddr and originated with BackendTLS)
+	Protocols []string `json:"protocols"`
+	// LatencyGenerators lists the kinds of latency that LatencyCfg can combine
+	LatencyGenerators []string `json:"latencyGenerators"`
+	// Features lists optional features compiled into the package (i.e. otel
+	// if it was built with the otel tag)
+	Features []string `json:"features"`
+}
+
+// Capabilities returns the version and features of the instance
+func (s *Speedbump) Capabilities() Capabilities {
+	features := []string{}
+	if otelSupported {
+		features = append(features, "otel")
+	}
+	return Capabilities{
+		Version:           Version,
+		Modes:             []string{ModeProxy, ModeSink, ModeSource},
+		Protocols:         []string{"tcp", "tls"},
+		LatencyGenerators: append([]string(nil), latencyGenerators...),
+		Features:          features,
+	}
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilitiesLatencyGenerators(t *testing.T) {
+	// each reported generator is added to the base latency by a config enabling it
+	generators := map[string]struct {
+		cfg     LatencyCfg
+		summand latencySummand
+	}{
+		"base":     {LatencyCfg{Base: time.Millisecond}, baseLatencySummand{}},
+		"sine":     {LatencyCfg{SineAmplitude: time.Millisecond, SinePeriod: time.Second}, sineLatencySummand{}},
+		"sawtooth": {LatencyCfg{SawAmplitude: time.Millisecond, SawPeriod: time.Second}, sawtoothLatencySummand{}},
+		"square":   {LatencyCfg{SquareAmplitude: time.Millisecond, SquarePeriod: time.Second}, squareLatencySummand{}},
+		"triangle": {LatencyCfg{TriangleAmplitude: time.Millisecond, TrianglePeriod: time.Second}, triangleLatencySummand{}},
+		"gaussian": {LatencyCfg{GaussianStdDev: time.Millisecond}, &gaussianLatencySummand{}},
+		"uniform":  {LatencyCfg{UniformJitter: time.Millisecond}, &uniformLatencySummand{}},
+		"markov":   {LatencyCfg{Markov: &MarkovLatencyCfg{GoodLatency: time.Millisecond}}, &markovLatencySummand{}},
+	}
+	reported := (&Speedbump{}).Capabilities().LatencyGenerators
+	assert.Len(t, reported, len(generators))
+	for _, name := range reported {
+		generator, ok := generators[name]
+		if !assert.True(t, ok, name) {
+			continue
+		}
+		cfg := generator.cfg
+		summands := newSimpleLatencyGenerator(time.Now(), &cfg).summands
+		assert.IsType(t, generator.summand, summands[len(summands)-1], name)
+	}
+}
+
+func TestCapabilitiesModes(t *testing.T) {
+	caps := (&Speedbump{}).Capabilities()
+	for _, mode := range caps.Modes {
+		_, err := newLocalBackend(&SpeedbumpCfg{Mode: mode})
+		assert.Nil(t, err, mode)
+	}
+	_, err := newLocalBackend(&SpeedbumpCfg{Mode: "udp"})
+	assert.NotNil(t, err)
+
+	assert.Equal(t, Version, caps.Version)
+	assert.Equal(t, []string{"tcp", "tls"}, caps.Protocols)
+	assert.Equal(t, otelSupported, len(caps.Features) == 1 && caps.Features[0] == "otel")
+}
+
+func TestAdminAPICapabilities(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8084,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+		AdminAddr:  "localhost:0",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	res, err := http.Get("http://" + s.AdminAddr().String() + "/capabilities")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var caps Capabilities
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&caps))
+	assert.Equal(t, s.Capabilities(), caps)
+}
@@ -0,0 +1,208 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// CaptureRecord is a chunk of data read from one side of a captured connection.
+// Captures are written as one JSON-encoded record per line.
+type CaptureRecord struct {
+	// Offset is the time since the connection was accepted at which the data was read
+	Offset time.Duration `json:"offset"`
+	// Direction is the direction in which the data was flowing
+	Direction Direction `json:"direction"`
+	// Data is the data as read, before any padding or chunking was applied
+	Data []byte `json:"data"`
+}
+
+// connCapture records the byte stream of a single proxy connection
+type connCapture struct {
+	// mu guards the encoder and err, as both directions are recorded concurrently
+	mu    sync.Mutex
+	start time.Time
+	f     *os.File
+	w     *bufio.Writer
+	enc   *json.Encoder
+	// err is the first error encountered while writing, after which nothing is recorded
+	err error
+}
+
+func newConnCapture(path string, start time.Time) (*connCapture, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating connection capture: %s", err)
+	}
+	w := bufio.NewWriter(f)
+	return &connCapture{start: start, f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// record appends data read in a given direction at a given point in time to the capture
+func (cc *connCapture) record(direction Direction, data []byte, when time.Time) {
+	if cc == nil || len(data) == 0 {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.err != nil {
+		return
+	}
+	cc.err = cc.enc.Encode(CaptureRecord{Offset: when.Sub(cc.start), Direction: direction, Data: data})
+}
+
+// close flushes the capture to its file, returning the first error encountered while recording
+func (cc *connCapture) close() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.err == nil {
+		cc.err = cc.w.Flush()
+	}
+	if err := cc.f.Close(); cc.err == nil {
+		cc.err = err
+	}
+	if cc.err != nil {
+		return fmt.Errorf("Error writing connection capture: %s", cc.err)
+	}
+	return nil
+}
+
+// startCapture creates the capture of a newly accepted connection if CaptureDir is set.
+// Failing to create it is only logged, so that the connection is proxied regardless.
+func (s *Speedbump) startCapture(id int, acceptedAt time.Time, l hclog.Logger) *connCapture {
+	if s.cfg.CaptureDir == "" {
+		return nil
+	}
+	cc, err := newConnCapture(filepath.Join(s.cfg.CaptureDir, "conn-"+strconv.Itoa(id)+".jsonl"), acceptedAt)
+	if err != nil {
+		s.warnLimiter.warn(l, "Capturing proxy connection failed", "err", err)
+		return nil
+	}
+	return cc
+}
+
+// endCapture closes the capture of a connection (if it's captured)
+func (s *Speedbump) endCapture(cc *connCapture, l hclog.Logger) {
+	if cc == nil {
+		return
+	}
+	if err := cc.close(); err != nil {
+		s.warnLimiter.warn(l, "Capturing proxy connection failed", "err", err)
+	}
+}
+
+// ReadCapture reads the records of a connection capture written with CaptureDir
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+	var records []CaptureRecord
+	dec := json.NewDecoder(r)
+	for {
+		var record CaptureRecord
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading connection capture: %s", err)
+		}
+		records = append(records, record)
+	}
+}
+
+// ReplayCapture replays the client side of a connection captured with CaptureDir against
+// dest through a Speedbump instance configured with cfg (its DestAddr is replaced with dest,
+// while Port 0 picks a free port). The data sent by the client is written at the recorded
+// offsets, while data sent back by dest is discarded. The connection is closed once as much
+// time has passed as the capture spans and all of the data was written to dest, after which
+// the instance is stopped.
+func ReplayCapture(path, dest string, cfg SpeedbumpCfg) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error opening connection capture: %s", err)
+	}
+	records, err := ReadCapture(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	cfg.DestAddr = dest
+	s, err := NewSpeedbump(&cfg)
+	if err != nil {
+		return err
+	}
+	if err := s.Start(); err != nil {
+		return err
+	}
+	defer s.Stop()
+
+	// the replay connection is the first one accepted by the instance
+	s.acceptMu.Lock()
+	id := s.nextConnId
+	s.acceptMu.Unlock()
+	conn, err := net.Dial("tcp", s.dialAddr())
+	if err != nil {
+		return fmt.Errorf("Error connecting to replay proxy: %s", err)
+	}
+	defer conn.Close()
+	// closed is closed once the proxy closes the connection
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	start := s.clock.Now()
+	var sent int64
+	for _, record := range records {
+		if d := start.Add(record.Offset).Sub(s.clock.Now()); d > 0 {
+			s.clock.Sleep(d)
+		}
+		if record.Direction != ClientToServer {
+			continue
+		}
+		if _, err := conn.Write(record.Data); err != nil {
+			return fmt.Errorf("Error replaying connection capture: %s", err)
+		}
+		sent += int64(len(record.Data))
+	}
+	s.waitForDelivery(id, sent, closed)
+	return nil
+}
+
+// waitForDelivery waits until n bytes sent by the client of a given connection were written
+// to the proxy destination (or the connection is closed), as closing it discards the data
+// held back by latency
+func (s *Speedbump) waitForDelivery(id int, n int64, closed <-chan struct{}) {
+	for {
+		s.connsMu.Lock()
+		c, ok := s.conns[id]
+		s.connsMu.Unlock()
+		var written <-chan struct{}
+		var registered <-chan time.Time
+		if ok {
+			var totals ByteTotals
+			totals, written = c.counters.writtenBytesNotify()
+			if totals.ClientToServer >= n {
+				return
+			}
+		} else {
+			// the connection may not be started yet
+			registered = s.clock.After(time.Millisecond)
+		}
+		select {
+		case <-closed:
+			return
+		case <-written:
+		case <-registered:
+		}
+	}
+}
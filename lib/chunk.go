@@ -0,0 +1,50 @@
+package lib
+
+import "time"
+
+// chunkSchedule limits the size of individual writes made within a proxy connection,
+// optionally reducing the limit once a given time since the connection was opened elapses
+// (simulating a path MTU drop)
+type chunkSchedule struct {
+	start     time.Time
+	size      int
+	dropAfter time.Duration
+	dropSize  int
+}
+
+func newChunkSchedule(start time.Time, size int, dropAfter time.Duration, dropSize int) *chunkSchedule {
+	if size <= 0 && dropSize <= 0 {
+		return nil
+	}
+	return &chunkSchedule{
+		start:     start,
+		size:      size,
+		dropAfter: dropAfter,
+		dropSize:  dropSize,
+	}
+}
+
+// maxSize returns the maximum size of a single write at a given point in time (0 if unlimited)
+func (cs *chunkSchedule) maxSize(when time.Time) int {
+	if cs == nil {
+		return 0
+	}
+	if cs.dropSize > 0 && when.Sub(cs.start) >= cs.dropAfter {
+		return cs.dropSize
+	}
+	return cs.size
+}
+
+// split divides data into chunks no larger than the maximum size at a given point in time
+func (cs *chunkSchedule) split(data []byte, when time.Time) [][]byte {
+	size := cs.maxSize(when)
+	if size <= 0 || len(data) <= size {
+		return [][]byte{data}
+	}
+	chunks := make([][]byte, 0, (len(data)+size-1)/size)
+	for len(data) > size {
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+	return append(chunks, data)
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewChunkScheduleDisabled(t *testing.T) {
+	assert.Nil(t, newChunkSchedule(time.Now(), 0, time.Second, 0))
+
+	var cs *chunkSchedule
+	assert.Equal(t, 0, cs.maxSize(time.Now()))
+	assert.Equal(t, [][]byte{[]byte("data")}, cs.split([]byte("data"), time.Now()))
+}
+
+func TestChunkScheduleMaxSize(t *testing.T) {
+	start := time.Now()
+	cs := newChunkSchedule(start, 1400, time.Second*10, 500)
+
+	assert.Equal(t, 1400, cs.maxSize(start))
+	assert.Equal(t, 1400, cs.maxSize(start.Add(time.Second*9)))
+	assert.Equal(t, 500, cs.maxSize(start.Add(time.Second*10)))
+
+	// the limit can be introduced by the drop alone
+	cs = newChunkSchedule(start, 0, time.Second, 500)
+	assert.Equal(t, 0, cs.maxSize(start))
+	assert.Equal(t, 500, cs.maxSize(start.Add(time.Second)))
+}
+
+func TestChunkScheduleSplit(t *testing.T) {
+	start := time.Now()
+	cs := newChunkSchedule(start, 4, time.Second, 2)
+
+	assert.Equal(t, [][]byte{[]byte("0123"), []byte("4567"), []byte("89")}, cs.split([]byte("0123456789"), start))
+	assert.Equal(t, [][]byte{[]byte("0123")}, cs.split([]byte("0123"), start))
+	assert.Equal(t, [][]byte{[]byte("01"), []byte("23")}, cs.split([]byte("0123"), start.Add(time.Second)))
+}
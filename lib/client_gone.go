@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+// ClientGoneError is the close reason of a proxy connection whose client went away
+// without closing the connection cleanly (i.e. it was reset), which is detected when
+// reading from or writing data back to the client fails
+type ClientGoneError struct {
+	// Err is the error returned by the failed read or write
+	Err error
+}
+
+func (e *ClientGoneError) Error() string {
+	return fmt.Sprintf("Proxy client gone: %s", e.Err)
+}
+
+func (e *ClientGoneError) Unwrap() error {
+	return e.Err
+}
+
+// isClientGone reports whether an error returned by a client connection
+// means that the client went away
+func isClientGone(err error) bool {
+	return errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.ErrClosedPipe)
+}
+
+// clientError returns the error closing the connection after reading from or writing to
+// the client failed, which is a *ClientGoneError if the client went away
+func clientError(prefix string, err error) error {
+	if isClientGone(err) {
+		return &ClientGoneError{Err: err}
+	}
+	return fmt.Errorf("%s %s", prefix, err)
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientError(t *testing.T) {
+	err := clientError(clientReadError, &net.OpError{Op: "read", Err: syscall.ECONNRESET})
+	var goneErr *ClientGoneError
+	assert.True(t, errors.As(err, &goneErr))
+	assert.True(t, errors.Is(err, syscall.ECONNRESET))
+
+	err = clientError("Error writing data back to proxy client:", errors.New("other-error"))
+	assert.EqualError(t, err, "Error writing data back to proxy client: other-error")
+}
+
+func TestReadFromDestClientGone(t *testing.T) {
+	mockDest := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		readRes: []readReturn{
+			{10, []byte("testdata12jibberish"), nil},
+		},
+	}
+	mockSrc := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		writeRes: []writeReturn{
+			{0, syscall.EPIPE},
+		},
+	}
+	done := make(chan error, 1)
+	c := &connection{
+		srcConn:    mockSrc,
+		destConn:   mockDest,
+		bufferSize: 20,
+		done:       done,
+	}
+
+	c.readFromDest()
+
+	err := <-done
+	var goneErr *ClientGoneError
+	assert.True(t, errors.As(err, &goneErr))
+	assert.EqualError(t, err, "Proxy client gone: broken pipe")
+}
+
+func TestSpeedbumpClientGone(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:9069")
+	assert.Nil(t, err)
+	defer l.Close()
+	// backendClosed receives once the backend's connection was closed by the proxy
+	backendClosed := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data := make([]byte, 1024)
+		// the backend keeps sending until the proxy closes the connection
+		for {
+			if _, err := conn.Write(data); err != nil {
+				close(backendClosed)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	summaries := make(chan ConnSummary, 1)
+	cfg := SpeedbumpCfg{
+		Port:       8071,
+		DestAddr:   "localhost:9069",
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{},
+		LogLevel:   "ERROR",
+		ConnTraceFunc: func(ctx context.Context, info ConnInfo) func(ConnSummary) {
+			return func(summary ConnSummary) { summaries <- summary }
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8071")
+	assert.Nil(t, err)
+	res := make([]byte, 1024)
+	_, err = conn.Read(res)
+	assert.Nil(t, err)
+	// the client goes away without reading the rest, resetting the connection
+	conn.(*net.TCPConn).SetLinger(0)
+	conn.Close()
+
+	select {
+	case summary := <-summaries:
+		var goneErr *ClientGoneError
+		assert.True(t, errors.As(summary.CloseReason, &goneErr), "unexpected close reason: %v", summary.CloseReason)
+	case <-time.After(time.Second):
+		t.Fatal("proxy connection wasn't closed after the client went away")
+	}
+	select {
+	case <-backendClosed:
+	case <-time.After(time.Second):
+		t.Fatal("backend connection wasn't closed after the client went away")
+	}
+}
@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock abstracts the passage of time, so that it can be faked in tests (see SpeedbumpCfg.Clock).
+// It drives latency, stall schedules, bandwidth limiting, queueing timeouts, backoffs and
+// scripted scenarios, while network deadlines always use real time, as they're enforced by
+// the sockets (i.e. DialTimeout, coalescing reads and write timeouts).
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// After returns a channel receiving the current time once d elapses
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// clockOrReal returns c, falling back to real time if it's nil
+func clockOrReal(c Clock) Clock {
+	if c == nil {
+		return realClock{}
+	}
+	return c
+}
+
+// VirtualClock is a Clock that only advances when Advance is called,
+// which makes timing of a Speedbump instance fully deterministic in tests
+type VirtualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []virtualWaiter
+	// changed is closed and replaced whenever a waiter is added
+	changed chan struct{}
+}
+
+type virtualWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewVirtualClock creates a VirtualClock starting at a given point in time
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start, changed: make(chan struct{})}
+}
+
+// Now returns the virtual time
+func (v *VirtualClock) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+// Sleep blocks until the virtual time is advanced by d
+func (v *VirtualClock) Sleep(d time.Duration) {
+	<-v.After(d)
+}
+
+// After returns a channel receiving the virtual time once it's advanced by d
+func (v *VirtualClock) After(d time.Duration) <-chan time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- v.now
+		return c
+	}
+	v.waiters = append(v.waiters, virtualWaiter{at: v.now.Add(d), c: c})
+	close(v.changed)
+	v.changed = make(chan struct{})
+	return c
+}
+
+// Advance moves the virtual time forward by d, waking up the waiters due by then
+// in the order of their wakeup times
+func (v *VirtualClock) Advance(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.now = v.now.Add(d)
+	sort.SliceStable(v.waiters, func(i, j int) bool { return v.waiters[i].at.Before(v.waiters[j].at) })
+	n := 0
+	for n < len(v.waiters) && !v.waiters[n].at.After(v.now) {
+		v.waiters[n].c <- v.now
+		n++
+	}
+	v.waiters = v.waiters[n:]
+}
+
+// Waiters returns the number of pending Sleep and After calls (After calls abandoned
+// by a select taking another branch remain pending until they're due)
+func (v *VirtualClock) Waiters() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.waiters)
+}
+
+// BlockUntil blocks until at least n Sleep or After calls are pending, so that
+// a test can advance the clock once the instance is waiting for it
+func (v *VirtualClock) BlockUntil(n int) {
+	for {
+		v.mu.Lock()
+		pending, changed := len(v.waiters), v.changed
+		v.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		<-changed
+	}
+}
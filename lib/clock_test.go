@@ -0,0 +1,210 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock advances only when Sleep is called
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Sleep(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.Sleep(d)
+	c := make(chan time.Time, 1)
+	c <- f.now
+	return c
+}
+
+type fakeClockWrite struct {
+	at   time.Time
+	data string
+}
+
+// fakeClockConn returns numbered payloads on each read until the limit is reached
+// and records the fake time of each write
+type fakeClockConn struct {
+	clock  *fakeClock
+	reads  int
+	limit  int
+	writes []fakeClockWrite
+}
+
+func (f *fakeClockConn) Read(p []byte) (int, error) {
+	if f.reads == f.limit {
+		return 0, io.EOF
+	}
+	f.reads++
+	return copy(p, fmt.Sprintf("payload-%d", f.reads)), nil
+}
+
+func (f *fakeClockConn) Write(p []byte) (int, error) {
+	f.writes = append(f.writes, fakeClockWrite{f.clock.now, string(p)})
+	return len(p), nil
+}
+
+func (f *fakeClockConn) Close() error {
+	return nil
+}
+
+func runSerial(start time.Time) []fakeClockWrite {
+	clock := &fakeClock{now: start}
+	conn := &fakeClockConn{clock: clock, limit: 50}
+	c := &connection{
+		srcConn:    conn,
+		destConn:   conn,
+		bufferSize: 64,
+		serial:     true,
+		clock:      clock,
+		latencyGen: newLatencyGenerator(start, &LatencyCfg{
+			Base: time.Millisecond * 10,
+			Markov: &MarkovLatencyCfg{
+				BadLatency: time.Millisecond * 200,
+				GoodToBad:  0.2,
+				BadToGood:  0.5,
+			},
+			Seed: 7,
+		}),
+		ramp:     newDelayRamp(&DelayRampCfg{Step: time.Millisecond, Max: time.Millisecond * 20}),
+		counters: &connCounters{},
+		done:     make(chan error, 3),
+		log:      hclog.NewNullLogger(),
+	}
+	c.proxySerial()
+	<-c.done
+	return conn.writes
+}
+
+func TestProxySerialDeterministic(t *testing.T) {
+	start := time.Unix(1000, 0)
+	first := runSerial(start)
+	second := runSerial(start)
+
+	assert.Len(t, first, 50)
+	assert.Equal(t, first, second)
+	for i, w := range first {
+		assert.Equal(t, fmt.Sprintf("payload-%d", i+1), w.data)
+		if i > 0 {
+			// each buffer is delayed by at least the base latency after the previous one
+			assert.GreaterOrEqual(t, int64(w.at.Sub(first[i-1].at)), int64(time.Millisecond*10))
+		}
+	}
+}
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	vc := NewVirtualClock(start)
+
+	first := vc.After(time.Second)
+	second := vc.After(time.Millisecond * 500)
+	immediate := vc.After(0)
+	assert.Equal(t, start, <-immediate)
+	assert.Equal(t, 2, vc.Waiters())
+
+	vc.Advance(time.Millisecond * 500)
+	assert.Equal(t, start.Add(time.Millisecond*500), <-second)
+	assert.Equal(t, 1, vc.Waiters())
+
+	slept := make(chan struct{})
+	go func() {
+		vc.Sleep(time.Millisecond * 200)
+		close(slept)
+	}()
+	vc.BlockUntil(2)
+	vc.Advance(time.Second)
+	<-slept
+	assert.Equal(t, start.Add(time.Millisecond*1500), <-first)
+	assert.Equal(t, start.Add(time.Millisecond*1500), vc.Now())
+	assert.Equal(t, 0, vc.Waiters())
+}
+
+func TestSpeedbumpVirtualClock(t *testing.T) {
+	go startEchoSrv(9058)
+	waitForListener("localhost:9058")
+
+	vc := NewVirtualClock(time.Unix(0, 0))
+	timeouts := make(chan error, 1)
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8066,
+		DestAddr:   "localhost:9058",
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{Base: time.Millisecond * 100},
+		// server-to-client traffic is stalled for the last 2s of every 10s
+		Stall: &StallCfg{
+			Direction: ServerToClient,
+			Period:    time.Second * 10,
+			Duration:  time.Second * 2,
+		},
+		BackendMaxConns:     1,
+		BackendQueueTimeout: time.Second,
+		OnTimeout: func(err error) {
+			timeouts <- err
+		},
+		LogLevel: "WARN",
+		Clock:    vc,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	client, err := net.Dial("tcp", "localhost:8066")
+	assert.Nil(t, err)
+	defer client.Close()
+	// the deadline only guards against the test hanging
+	client.SetReadDeadline(time.Now().Add(time.Second * 5))
+	res := make([]byte, 1024)
+
+	// the buffer is held in the delay queue until the virtual latency passes
+	client.Write([]byte("ping"))
+	vc.BlockUntil(1)
+	vc.Advance(time.Millisecond * 99)
+	assert.Equal(t, 1, vc.Waiters())
+	vc.Advance(time.Millisecond)
+	n, err := client.Read(res)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(res[:n]))
+
+	// the second client times out in the backend queue after a virtual second
+	queued, err := net.Dial("tcp", "localhost:8066")
+	assert.Nil(t, err)
+	defer queued.Close()
+	queued.SetReadDeadline(time.Now().Add(time.Second * 5))
+	vc.BlockUntil(1)
+	vc.Advance(time.Second)
+	_, err = queued.Read(res)
+	assert.Equal(t, io.EOF, err)
+	var queueErr *BackendQueueTimeoutError
+	assert.ErrorAs(t, <-timeouts, &queueErr)
+
+	// the echoed data is stalled until the end of the period
+	vc.Advance(time.Millisecond * 7400)
+	client.Write([]byte("pong"))
+	vc.BlockUntil(1)
+	vc.Advance(time.Millisecond * 100)
+	vc.BlockUntil(1)
+	vc.Advance(time.Millisecond * 1400)
+	n, err = client.Read(res)
+	assert.Nil(t, err)
+	assert.Equal(t, "pong", string(res[:n]))
+
+	stats := s.ConnStats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, time.Millisecond*200, stats[0].LatencyDelay)
+	assert.Equal(t, time.Millisecond*1400, stats[0].StallDelay)
+	assert.Equal(t, time.Unix(10, 0), vc.Now())
+}
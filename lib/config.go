@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// textDuration is used in place of time.Duration in config files,
+// so that durations are written in a human-readable form such as "100ms"
+type textDuration time.Duration
+
+func (d textDuration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *textDuration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = textDuration(v)
+	return nil
+}
+
+// textLatencyRange is used in place of LatencyCfg.Base in config files, so that
+// the base latency can also be specified as a range (e.g. "100ms-300ms"), which
+// gets converted into the range's minimum and a uniformly distributed jitter
+type textLatencyRange struct {
+	min    time.Duration
+	spread time.Duration
+}
+
+func (r textLatencyRange) MarshalText() ([]byte, error) {
+	if r.spread == 0 {
+		return []byte(r.min.String()), nil
+	}
+	return []byte(fmt.Sprintf("%s-%s", r.min, r.min+r.spread)), nil
+}
+
+func (r *textLatencyRange) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	// the separator can't be the first character, which may be the sign of a single duration
+	i := strings.LastIndex(s, "-")
+	if i <= 0 {
+		min, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*r = textLatencyRange{min: min}
+		return nil
+	}
+	min, err := time.ParseDuration(strings.TrimSpace(s[:i]))
+	if err != nil {
+		return fmt.Errorf("Invalid latency range %q: %s", s, err)
+	}
+	max, err := time.ParseDuration(strings.TrimSpace(s[i+1:]))
+	if err != nil {
+		return fmt.Errorf("Invalid latency range %q: %s", s, err)
+	}
+	if max < min {
+		return fmt.Errorf("Invalid latency range %q: the maximum is lower than the minimum", s)
+	}
+	*r = textLatencyRange{min: min, spread: max - min}
+	return nil
+}
+
+var (
+	durationType     = reflect.TypeOf(time.Duration(0))
+	textDurationType = reflect.TypeOf(textDuration(0))
+	latencyRangeType = reflect.TypeOf(textLatencyRange{})
+	latencyCfgType   = reflect.TypeOf(LatencyCfg{})
+	bytesType        = reflect.TypeOf([]byte(nil))
+	stringType       = reflect.TypeOf("")
+	cfgPkgPath       = reflect.TypeOf(SpeedbumpCfg{}).PkgPath()
+)
+
+// fileType returns the type used for encoding values of type t in config files,
+// in which time.Duration fields are replaced with textDuration (or textLatencyRange
+// in case of LatencyCfg.Base), byte slices with strings and fields excluded
+// from encoding (such as callbacks) are omitted
+func fileType(t reflect.Type) reflect.Type {
+	switch t {
+	case durationType:
+		return textDurationType
+	case bytesType:
+		return stringType
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return reflect.PtrTo(fileType(t.Elem()))
+	case reflect.Slice:
+		return reflect.SliceOf(fileType(t.Elem()))
+	case reflect.Map:
+		return reflect.MapOf(fileType(t.Key()), fileType(t.Elem()))
+	case reflect.Struct:
+		if t.PkgPath() != cfgPkgPath {
+			return t
+		}
+		var fields []reflect.StructField
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Tag.Get("json") == "-" {
+				continue
+			}
+			ft := fileType(f.Type)
+			if t == latencyCfgType && f.Name == "Base" {
+				ft = latencyRangeType
+			}
+			fields = append(fields, reflect.StructField{Name: f.Name, Type: ft, Tag: f.Tag})
+		}
+		return reflect.StructOf(fields)
+	}
+	return t
+}
+
+// convertCfg copies src into dst, where one of them is of the config file type
+// corresponding to the other one's type (see fileType)
+func convertCfg(dst, src reflect.Value) {
+	switch {
+	case (src.Kind() == reflect.Map || src.Kind() == reflect.Slice) && src.Len() == 0:
+		// empty maps and lists (i.e. in YAML files) are loaded as nil
+		return
+	case dst.Type() == src.Type():
+		dst.Set(src)
+	case dst.Type() == latencyRangeType:
+		dst.Set(reflect.ValueOf(textLatencyRange{min: time.Duration(src.Int())}))
+	case src.Type() == latencyRangeType:
+		dst.SetInt(int64(src.Interface().(textLatencyRange).min))
+	case dst.Type() == bytesType || src.Type() == bytesType:
+		// empty strings are loaded as nil byte slices
+		if src.Len() > 0 {
+			dst.Set(src.Convert(dst.Type()))
+		}
+	case src.Kind() == reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.New(dst.Type().Elem()))
+		convertCfg(dst.Elem(), src.Elem())
+	case src.Kind() == reflect.Slice:
+		dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+		for i := 0; i < src.Len(); i++ {
+			convertCfg(dst.Index(i), src.Index(i))
+		}
+	case src.Kind() == reflect.Map:
+		dst.Set(reflect.MakeMapWithSize(dst.Type(), src.Len()))
+		iter := src.MapRange()
+		for iter.Next() {
+			key := reflect.New(dst.Type().Key()).Elem()
+			convertCfg(key, iter.Key())
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			convertCfg(elem, iter.Value())
+			dst.SetMapIndex(key, elem)
+		}
+	case src.Kind() == reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			if f := src.FieldByName(dst.Type().Field(i).Name); f.IsValid() {
+				convertCfg(dst.Field(i), f)
+			}
+		}
+		// a base latency range overrides UniformJitter
+		if dst.Type() == latencyCfgType {
+			if r := src.FieldByName("Base").Interface().(textLatencyRange); r.spread > 0 {
+				dst.FieldByName("UniformJitter").SetInt(int64(r.spread))
+			}
+		}
+	default:
+		dst.Set(src.Convert(dst.Type()))
+	}
+}
+
+// LoadConfig reads a Speedbump instance configuration in a given format
+// (either "json" or "yaml") from r
+func LoadConfig(r io.Reader, format string) (*SpeedbumpCfg, error) {
+	file := reflect.New(fileType(reflect.TypeOf(SpeedbumpCfg{})))
+	var err error
+	switch format {
+	case "json":
+		err = json.NewDecoder(r).Decode(file.Interface())
+	case "yaml", "yml":
+		err = yaml.NewDecoder(r).Decode(file.Interface())
+	default:
+		return nil, fmt.Errorf("Unsupported config format: %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error loading config: %s", err)
+	}
+	cfg := &SpeedbumpCfg{}
+	convertCfg(reflect.ValueOf(cfg).Elem(), file.Elem())
+	return cfg, nil
+}
+
+// Save writes the configuration in a given format (either "json" or "yaml") to w,
+// so that it can be loaded back using LoadConfig. Durations are written as strings
+// (e.g. "100ms") and callback fields are omitted.
+func (cfg *SpeedbumpCfg) Save(w io.Writer, format string) error {
+	file := reflect.New(fileType(reflect.TypeOf(*cfg))).Elem()
+	convertCfg(file, reflect.ValueOf(*cfg))
+	var err error
+	switch format {
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		err = e.Encode(file.Interface())
+	case "yaml", "yml":
+		e := yaml.NewEncoder(w)
+		err = e.Encode(file.Interface())
+		if err == nil {
+			err = e.Close()
+		}
+	default:
+		return fmt.Errorf("Unsupported config format: %s", format)
+	}
+	if err != nil {
+		return fmt.Errorf("Error saving config: %s", err)
+	}
+	return nil
+}
+
+// SaveConfig writes the effective configuration of the Speedbump instance
+// (including the applied defaults) in a given format to w
+func (s *Speedbump) SaveConfig(w io.Writer, format string) error {
+	s.latencyMu.Lock()
+	cfg := s.cfg
+	s.latencyMu.Unlock()
+	return cfg.Save(w, format)
+}
@@ -0,0 +1,229 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var complexCfg = &SpeedbumpCfg{
+	Host:        "localhost",
+	Port:        8000,
+	DestAddr:    "localhost:80",
+	TLSDestAddr: "localhost:443",
+	BufferSize:  0xffff,
+	QueueSize:   2048,
+	Latency: &LatencyCfg{
+		Base:          time.Millisecond * 100,
+		SineAmplitude: time.Millisecond * 50,
+		SinePeriod:    time.Minute,
+		SawAmplitude:  time.Millisecond * 20,
+		SawPeriod:     time.Second * 30,
+		UniformJitter: time.Millisecond * 30,
+	},
+	LatencyProfiles: map[string]LatencyCfg{
+		"slow": {Base: time.Second},
+		"wavy": {SineAmplitude: time.Millisecond * 50, SinePeriod: time.Second * 10},
+	},
+	LogLevel: "DEBUG",
+	Stall: &StallCfg{
+		Direction: ServerToClient,
+		Period:    time.Second * 10,
+		Duration:  time.Second * 2,
+	},
+	ResponseLatency: []ResponseLatencyRule{
+		{StatusMin: 500, StatusMax: 599, Latency: time.Second},
+		{StatusMin: 429, StatusMax: 429, Latency: time.Millisecond * 300},
+	},
+	DialTimeout:       time.Second,
+	AcceptIdleTimeout: time.Minute,
+	ReconnectBackend:  true,
+	ReconnectAttempts: 5,
+	ReconnectBackoff:  time.Millisecond * 250,
+	ShutdownMessage:   []byte("bye\n"),
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		var buf bytes.Buffer
+		err := complexCfg.Save(&buf, format)
+		assert.Nil(t, err)
+
+		loaded, err := LoadConfig(&buf, format)
+		assert.Nil(t, err)
+		assert.Equal(t, complexCfg, loaded, format)
+	}
+}
+
+func TestConfigUnsupportedFormat(t *testing.T) {
+	err := complexCfg.Save(&bytes.Buffer{}, "toml")
+	assert.EqualError(t, err, "Unsupported config format: toml")
+
+	_, err = LoadConfig(strings.NewReader(""), "toml")
+	assert.EqualError(t, err, "Unsupported config format: toml")
+}
+
+func TestLoadConfigError(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader("{nope"), "json")
+	assert.True(t, strings.HasPrefix(err.Error(), "Error loading config"))
+}
+
+func TestSaveConfigEffective(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:             8000,
+		DestAddr:         "localhost:1234",
+		BufferSize:       0xffff,
+		Latency:          defaultLatencyCfg,
+		LogLevel:         "WARN",
+		ReconnectBackend: true,
+		OnTimeout:        func(err error) {},
+	})
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, s.SaveConfig(&buf, "yaml"))
+
+	loaded, err := LoadConfig(&buf, "yaml")
+	assert.Nil(t, err)
+	// defaults are included in the effective config
+	assert.Equal(t, 1024, loaded.QueueSize)
+	assert.Equal(t, defaultReconnectAttempts, loaded.ReconnectAttempts)
+	assert.Equal(t, defaultReconnectBackoff, loaded.ReconnectBackoff)
+	assert.Equal(t, defaultLatencyCfg, loaded.Latency)
+	assert.Nil(t, loaded.OnTimeout)
+}
+
+func TestSaveConfigKeysAndDurations(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		var buf bytes.Buffer
+		assert.Nil(t, complexCfg.Save(&buf, format))
+		saved := buf.String()
+		assert.Contains(t, saved, "reconnectBackoff", format)
+		assert.Contains(t, saved, "250ms", format)
+		assert.Contains(t, saved, "server-to-client", format)
+		assert.Contains(t, saved, "bye", format)
+		assert.Contains(t, saved, "300ms", format)
+		assert.NotContains(t, saved, "onTimeout", format)
+	}
+}
+
+func TestLoadConfigHandWritten(t *testing.T) {
+	expected := &SpeedbumpCfg{
+		Port:     8000,
+		DestAddr: "localhost:80",
+		Latency: &LatencyCfg{
+			Base:       time.Millisecond * 100,
+			SinePeriod: time.Minute,
+		},
+		Stall: &StallCfg{
+			Direction: ClientToServer,
+			Period:    time.Second * 10,
+			Duration:  time.Millisecond * 1500,
+		},
+		DialTimeout:     time.Second,
+		ShutdownMessage: []byte("bye"),
+	}
+
+	json := `{
+		"port": 8000,
+		"destAddr": "localhost:80",
+		"latency": {"base": "100ms", "sinePeriod": "1m"},
+		"stall": {"direction": "client-to-server", "period": "10s", "duration": "1.5s"},
+		"dialTimeout": "1s",
+		"shutdownMessage": "bye"
+	}`
+	loaded, err := LoadConfig(strings.NewReader(json), "json")
+	assert.Nil(t, err)
+	assert.Equal(t, expected, loaded)
+
+	yaml := `
+port: 8000
+destAddr: localhost:80
+latency:
+  base: 100ms
+  sinePeriod: 1m
+stall:
+  direction: client-to-server
+  period: 10s
+  duration: 1.5s
+dialTimeout: 1s
+shutdownMessage: bye
+`
+	loaded, err = LoadConfig(strings.NewReader(yaml), "yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, expected, loaded)
+}
+
+func TestLoadConfigInvalidDuration(t *testing.T) {
+	_, err := LoadConfig(strings.NewReader(`{"dialTimeout": "soon"}`), "json")
+	assert.True(t, strings.HasPrefix(err.Error(), "Error loading config"))
+}
+
+func TestLoadConfigLatencyDurations(t *testing.T) {
+	cases := map[string]time.Duration{
+		`"150ms"`:   time.Millisecond * 150,
+		`"1.5s"`:    time.Millisecond * 1500,
+		`"1m30s"`:   time.Second * 90,
+		`" 250us "`: time.Microsecond * 250,
+	}
+	for base, expected := range cases {
+		cfg, err := LoadConfig(strings.NewReader(`{"latency": {"base": `+base+`}}`), "json")
+		assert.Nil(t, err, base)
+		assert.Equal(t, &LatencyCfg{Base: expected}, cfg.Latency, base)
+	}
+}
+
+func TestLoadConfigLatencyRange(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		var src string
+		if format == "json" {
+			src = `{"latency": {"base": "100ms-300ms", "seed": 1}}`
+		} else {
+			src = "latency:\n  base: 100ms - 300ms\n  seed: 1\n"
+		}
+		cfg, err := LoadConfig(strings.NewReader(src), format)
+		assert.Nil(t, err, format)
+		assert.Equal(t, &LatencyCfg{
+			Base:          time.Millisecond * 100,
+			UniformJitter: time.Millisecond * 200,
+			Seed:          1,
+		}, cfg.Latency, format)
+	}
+
+	cfg, err := LoadConfig(strings.NewReader(`{"latency": {"base": "100ms-300ms"}}`), "json")
+	assert.Nil(t, err)
+	start := time.Now()
+	g := newLatencyGenerator(start, cfg.Latency)
+	var lowest, highest time.Duration = time.Hour, 0
+	for i := 0; i < 1000; i++ {
+		l := g.generateLatency(start)
+		assert.GreaterOrEqual(t, l, time.Millisecond*100)
+		assert.LessOrEqual(t, l, time.Millisecond*300)
+		if l < lowest {
+			lowest = l
+		}
+		if l > highest {
+			highest = l
+		}
+	}
+	// the generated latency spans the whole range
+	assert.Less(t, lowest, time.Millisecond*120)
+	assert.Greater(t, highest, time.Millisecond*280)
+
+	// ranges are saved as a base latency and a uniform jitter
+	var buf bytes.Buffer
+	assert.Nil(t, cfg.Save(&buf, "json"))
+	assert.Contains(t, buf.String(), `"base": "100ms"`)
+	assert.Contains(t, buf.String(), `"uniformJitter": "200ms"`)
+}
+
+func TestLoadConfigInvalidLatencyRange(t *testing.T) {
+	for _, base := range []string{"300ms-100ms", "100ms-soon", "soon", "100ms-"} {
+		_, err := LoadConfig(strings.NewReader(`{"latency": {"base": "`+base+`"}}`), "json")
+		assert.NotNil(t, err, base)
+		assert.True(t, strings.HasPrefix(err.Error(), "Error loading config"), base)
+	}
+}
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultConnBatchSize     = 100
+	defaultConnBatchInterval = time.Second
+)
+
+// connBatcher buffers the stats of closed connections, delivering them to a callback
+// once a batch is full or the interval since its first connection was closed passes
+type connBatcher struct {
+	size     int
+	interval time.Duration
+	clock    Clock
+	deliver  func([]ConnStats)
+	mu       sync.Mutex
+	batch    []ConnStats
+	// cancel stops the delivery of a partial batch once its interval passes
+	// (it's closed if the batch is taken before that)
+	cancel chan struct{}
+	// deliverMu serializes deliveries, which may be triggered by the timer
+	// and by closing connections at the same time
+	deliverMu sync.Mutex
+}
+
+func newConnBatcher(cfg *SpeedbumpCfg) *connBatcher {
+	if cfg.OnConnectionsClosed == nil {
+		return nil
+	}
+	size := cfg.ConnBatchSize
+	if size <= 0 {
+		size = defaultConnBatchSize
+	}
+	interval := cfg.ConnBatchInterval
+	if interval <= 0 {
+		interval = defaultConnBatchInterval
+	}
+	return &connBatcher{size: size, interval: interval, clock: clockOrReal(cfg.Clock), deliver: cfg.OnConnectionsClosed}
+}
+
+// add buffers the stats of a closed connection (no-op if b is nil)
+func (b *connBatcher) add(stats ConnStats) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.batch = append(b.batch, stats)
+	if len(b.batch) < b.size {
+		if b.cancel == nil {
+			b.cancel = make(chan struct{})
+			go b.flushAfterInterval(b.cancel)
+		}
+		b.mu.Unlock()
+		return
+	}
+	batch := b.take()
+	b.mu.Unlock()
+	b.send(batch)
+}
+
+// flush delivers the buffered stats right away, regardless of the batch size
+func (b *connBatcher) flush() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+	b.send(batch)
+}
+
+// flushAfterInterval delivers the partial batch once its interval passes,
+// unless it's taken (and cancel closed) before that
+func (b *connBatcher) flushAfterInterval(cancel chan struct{}) {
+	select {
+	case <-b.clock.After(b.interval):
+	case <-cancel:
+		return
+	}
+	b.mu.Lock()
+	if b.cancel != cancel {
+		b.mu.Unlock()
+		return
+	}
+	batch := b.take()
+	b.mu.Unlock()
+	b.send(batch)
+}
+
+// take removes the current batch and cancels its delivery by interval (mu must be held)
+func (b *connBatcher) take() []ConnStats {
+	batch := b.batch
+	b.batch = nil
+	if b.cancel != nil {
+		close(b.cancel)
+		b.cancel = nil
+	}
+	return batch
+}
+
+func (b *connBatcher) send(batch []ConnStats) {
+	if len(batch) == 0 {
+		return
+	}
+	b.deliverMu.Lock()
+	defer b.deliverMu.Unlock()
+	b.deliver(batch)
+}
@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// batchRecorder collects batches delivered to OnConnectionsClosed
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]ConnStats
+}
+
+func (r *batchRecorder) record(stats []ConnStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, stats)
+}
+
+func (r *batchRecorder) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sizes []int
+	for _, b := range r.batches {
+		sizes = append(sizes, len(b))
+	}
+	return sizes
+}
+
+func TestConnBatcherBySize(t *testing.T) {
+	r := &batchRecorder{}
+	b := newConnBatcher(&SpeedbumpCfg{OnConnectionsClosed: r.record, ConnBatchSize: 3, ConnBatchInterval: time.Hour})
+	for i := 0; i < 7; i++ {
+		b.add(ConnStats{ID: i})
+	}
+	assert.Equal(t, []int{3, 3}, r.sizes())
+	assert.Equal(t, 3, r.batches[1][0].ID)
+
+	b.flush()
+	assert.Equal(t, []int{3, 3, 1}, r.sizes())
+	// flushing an empty batch doesn't invoke the callback
+	b.flush()
+	assert.Len(t, r.sizes(), 3)
+}
+
+func TestConnBatcherByInterval(t *testing.T) {
+	r := &batchRecorder{}
+	b := newConnBatcher(&SpeedbumpCfg{OnConnectionsClosed: r.record, ConnBatchInterval: time.Millisecond * 50})
+	b.add(ConnStats{ID: 0})
+	b.add(ConnStats{ID: 1})
+	assert.Empty(t, r.sizes())
+	assert.Eventually(t, func() bool { return len(r.sizes()) == 1 }, time.Second, time.Millisecond*5)
+	assert.Equal(t, []int{2}, r.sizes())
+}
+
+func TestConnBatcherDisabled(t *testing.T) {
+	b := newConnBatcher(&SpeedbumpCfg{})
+	assert.Nil(t, b)
+	b.add(ConnStats{})
+	b.flush()
+}
+
+func TestSpeedbumpOnConnectionsClosed(t *testing.T) {
+	go startEchoSrv(9053)
+	waitForListener("localhost:9053")
+
+	r := &batchRecorder{}
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:                8061,
+		DestAddr:            "localhost:9053",
+		BufferSize:          0xffff,
+		Latency:             defaultLatencyCfg,
+		LogLevel:            "ERROR",
+		OnConnectionsClosed: r.record,
+		ConnBatchSize:       4,
+		ConnBatchInterval:   time.Hour,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	for i := 0; i < 10; i++ {
+		conn, err := net.Dial("tcp", "localhost:8061")
+		assert.Nil(t, err)
+		conn.Write([]byte("test"))
+		conn.Read(make([]byte, 4))
+		conn.Close()
+	}
+	assert.Eventually(t, func() bool { return len(r.sizes()) == 2 }, time.Second, time.Millisecond*10)
+
+	// the remaining connections are delivered on Stop()
+	s.Stop()
+	assert.Equal(t, []int{4, 4, 2}, r.sizes())
+	ids := map[int]bool{}
+	for _, batch := range r.batches {
+		for _, stats := range batch {
+			ids[stats.ID] = true
+			assert.Equal(t, int64(4), stats.Bytes.ClientToServer)
+		}
+	}
+	assert.Len(t, ids, 10)
+}
@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// connQuota caps the number of connections accepted over the lifetime of the instance,
+// or within each interval (aligned to the first accepted connection) if it's set
+type connQuota struct {
+	max      int
+	interval time.Duration
+	// mu guards windowStart and used
+	mu          sync.Mutex
+	windowStart time.Time
+	used        int
+}
+
+func newConnQuota(max int, interval time.Duration) *connQuota {
+	if max <= 0 {
+		return nil
+	}
+	return &connQuota{max: max, interval: interval}
+}
+
+// take uses up the quota of a connection accepted at a given point in time,
+// returning false if the quota is exhausted
+func (q *connQuota) take(now time.Time) bool {
+	if q == nil {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.windowStart.IsZero() {
+		q.windowStart = now
+	} else if q.interval > 0 && now.Sub(q.windowStart) >= q.interval {
+		// the window is advanced by whole intervals, so that it stays aligned
+		q.windowStart = q.windowStart.Add(now.Sub(q.windowStart) / q.interval * q.interval)
+		q.used = 0
+	}
+	if q.used >= q.max {
+		return false
+	}
+	q.used++
+	return true
+}
+
+// recordQuotaRejection records a connection refused due to MaxLifetimeConnections in stats
+func (s *Speedbump) recordQuotaRejection(acceptedAt time.Time) {
+	if s.warmingUp(acceptedAt) {
+		return
+	}
+	s.statsMu.Lock()
+	s.stats.QuotaRejections++
+	s.statsMu.Unlock()
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnQuotaLifetime(t *testing.T) {
+	assert.Nil(t, newConnQuota(0, time.Minute))
+	assert.True(t, (*connQuota)(nil).take(time.Now()))
+
+	q := newConnQuota(2, 0)
+	now := time.Now()
+	assert.True(t, q.take(now))
+	assert.True(t, q.take(now))
+	assert.False(t, q.take(now.Add(time.Hour)))
+}
+
+func TestConnQuotaInterval(t *testing.T) {
+	q := newConnQuota(2, time.Minute)
+	start := time.Now()
+	assert.True(t, q.take(start))
+	assert.True(t, q.take(start.Add(time.Second)))
+	assert.False(t, q.take(start.Add(time.Second*59)))
+	// the quota is reset once the interval passes
+	assert.True(t, q.take(start.Add(time.Minute)))
+	assert.True(t, q.take(start.Add(time.Minute+time.Second)))
+	assert.False(t, q.take(start.Add(time.Minute*2-time.Second)))
+	// windows stay aligned to the first connection after idle intervals
+	assert.True(t, q.take(start.Add(time.Minute*5+time.Second*30)))
+	assert.True(t, q.take(start.Add(time.Minute*5+time.Second*59)))
+	assert.False(t, q.take(start.Add(time.Minute*5+time.Second*59)))
+	assert.True(t, q.take(start.Add(time.Minute*6)))
+}
+
+func TestSpeedbumpConnQuotaInterval(t *testing.T) {
+	go startEchoSrv(9077)
+	waitForListener("localhost:9077")
+
+	cfg := SpeedbumpCfg{
+		Port:                   8079,
+		DestAddr:               "localhost:9077",
+		BufferSize:             0xffff,
+		Latency:                &LatencyCfg{},
+		LogLevel:               "ERROR",
+		MaxLifetimeConnections: 3,
+		ConnQuotaInterval:      time.Second,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	// echoed reports whether a connection is proxied
+	echoed := func() bool {
+		conn, err := net.Dial("tcp", "localhost:8079")
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.Write([]byte("test-string"))
+		res := make([]byte, 1024)
+		n, err := io.ReadAtLeast(conn, res, len("test-string"))
+		return err == nil && string(res[:n]) == "test-string"
+	}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.True(t, echoed())
+	}
+	// the connections past the quota are refused within the window
+	assert.False(t, echoed())
+	assert.False(t, echoed())
+	assert.Equal(t, 2, s.Stats().QuotaRejections)
+
+	time.Sleep(time.Until(start.Add(time.Millisecond * 1100)))
+	assert.True(t, echoed())
+}
@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ConnStats contains counters describing a single active proxy connection
+type ConnStats struct {
+	// ID identifies the connection (matching the connection field in logs)
+	ID int `json:"id"`
+	// TotalDelayTime sums the delays applied to buffers flowing in each direction
+	TotalDelayTime DelayTotals `json:"totalDelayTime"`
+	// Bytes counts the bytes read from each side of the connection
+	Bytes ByteTotals `json:"bytes"`
+	// VirtualHost is the server name requested by the client (see LabelVirtualHosts)
+	VirtualHost string `json:"virtualHost"`
+	// Fingerprint is the label returned by FingerprintFunc for the connection
+	Fingerprint string `json:"fingerprint"`
+	// EvictedBuffers is the number of buffers dropped from the delay queue after exceeding MaxQueueAge
+	EvictedBuffers int `json:"evictedBuffers"`
+	// LatencyDelay, BandwidthDelay, StallDelay and CompressionDelay break TotalDelayTime of both
+	// directions down into delays added by latency (including DelayRamp, IdleLatency and
+	// ResponseLatency), bandwidth limiting, stalls and CompressionDelayPerKB
+	LatencyDelay     time.Duration `json:"latencyDelay"`
+	BandwidthDelay   time.Duration `json:"bandwidthDelay"`
+	StallDelay       time.Duration `json:"stallDelay"`
+	CompressionDelay time.Duration `json:"compressionDelay"`
+	// QueueDelay sums the time buffers spent in the delay queue past their release time
+	// (i.e. while writing to the proxy destination was blocked), which isn't part of TotalDelayTime
+	QueueDelay time.Duration `json:"queueDelay"`
+	// QueueWait summarizes the time buffers actually waited in the delay queue (and in the queue
+	// of ServerToClientLatency) since being read, which exceeds the intended latency
+	// if the proxy itself is a bottleneck
+	QueueWait DurationStats `json:"queueWait"`
+	// QueueDepth is the number of buffers currently waiting in the delay queue (and in the queue
+	// of ServerToClientLatency), which stays close to QueueSize if the queue is a bottleneck
+	QueueDepth QueueDepths `json:"queueDepth"`
+}
+
+// delayComponent identifies the feature that delayed a buffer
+type delayComponent int
+
+const (
+	latencyDelay delayComponent = iota
+	bandwidthDelay
+	stallDelay
+	queueDelay
+	compressionDelay
+	numDelayComponents
+)
+
+// DelayTotals contains a total delay for each direction of a proxy connection
+type DelayTotals struct {
+	ClientToServer time.Duration `json:"clientToServer"`
+	ServerToClient time.Duration `json:"serverToClient"`
+}
+
+// ByteTotals contains a number of bytes for each direction of a proxy connection
+type ByteTotals struct {
+	ClientToServer int64 `json:"clientToServer"`
+	ServerToClient int64 `json:"serverToClient"`
+}
+
+// QueueDepths contains a number of queued buffers for each direction of a proxy connection
+type QueueDepths struct {
+	ClientToServer int `json:"clientToServer"`
+	ServerToClient int `json:"serverToClient"`
+}
+
+// connCounters accumulates the counters of a single proxy connection,
+// which are updated by the connection's goroutines
+type connCounters struct {
+	mu    sync.Mutex
+	delay DelayTotals
+	bytes ByteTotals
+	// written counts the bytes written to the side of the connection a given direction ends at
+	written ByteTotals
+	// writeNotify is closed by the next write, once requested by writtenBytesNotify
+	writeNotify chan struct{}
+	evicted     int
+	// components breaks the delays down by delayComponent
+	components [numDelayComponents]time.Duration
+	// injected averages the latency generated for recent buffers (see AverageInjectedLatency)
+	injected latencyWindow
+	// queueWait is created once the first buffer leaves a queue
+	queueWait *durationHistogram
+	// virtualHost and fingerprint are set before the connection is started
+	virtualHost string
+	fingerprint string
+}
+
+// addDelay records a delay added by a given component to a buffer flowing in a given direction
+func (cc *connCounters) addDelay(direction Direction, component delayComponent, d time.Duration) {
+	if cc == nil || d <= 0 {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.components[component] += d
+	if component == queueDelay {
+		return
+	}
+	if direction == ClientToServer {
+		cc.delay.ClientToServer += d
+	} else {
+		cc.delay.ServerToClient += d
+	}
+}
+
+// addBytes records data read from the side of the connection a given direction starts at
+func (cc *connCounters) addBytes(direction Direction, n int) {
+	if cc == nil || n <= 0 {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if direction == ClientToServer {
+		cc.bytes.ClientToServer += int64(n)
+	} else {
+		cc.bytes.ServerToClient += int64(n)
+	}
+}
+
+// addWritten records data written to the side of the connection a given direction ends at
+func (cc *connCounters) addWritten(direction Direction, n int) {
+	if cc == nil || n <= 0 {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if direction == ClientToServer {
+		cc.written.ClientToServer += int64(n)
+	} else {
+		cc.written.ServerToClient += int64(n)
+	}
+	if cc.writeNotify != nil {
+		close(cc.writeNotify)
+		cc.writeNotify = nil
+	}
+}
+
+// writtenBytesNotify returns the bytes written to each side of the connection so far
+// along with a channel closed once more data is written
+func (cc *connCounters) writtenBytesNotify() (ByteTotals, <-chan struct{}) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.writeNotify == nil {
+		cc.writeNotify = make(chan struct{})
+	}
+	return cc.written, cc.writeNotify
+}
+
+// addQueueWait records the wait of a buffer leaving a queue at a given point in time
+func (cc *connCounters) addQueueWait(t transitBuffer, now time.Time) {
+	if cc == nil || t.queuedAt.IsZero() {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.queueWait == nil {
+		cc.queueWait = newDurationHistogram()
+	}
+	cc.queueWait.record(now.Sub(t.queuedAt))
+}
+
+// mergeQueueWait adds the queue waits recorded so far to h
+func (cc *connCounters) mergeQueueWait(h *durationHistogram) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	h.merge(cc.queueWait)
+}
+
+// addEvicted records a buffer evicted from the delay queue
+func (cc *connCounters) addEvicted() {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.evicted++
+}
+
+func (cc *connCounters) snapshot(id int) ConnStats {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return ConnStats{
+		ID:               id,
+		TotalDelayTime:   cc.delay,
+		Bytes:            cc.bytes,
+		VirtualHost:      cc.virtualHost,
+		Fingerprint:      cc.fingerprint,
+		EvictedBuffers:   cc.evicted,
+		LatencyDelay:     cc.components[latencyDelay],
+		BandwidthDelay:   cc.components[bandwidthDelay],
+		StallDelay:       cc.components[stallDelay],
+		QueueDelay:       cc.components[queueDelay],
+		CompressionDelay: cc.components[compressionDelay],
+		QueueWait:        cc.queueWait.stats(),
+	}
+}
+
+// ConnStats returns a snapshot of the counters of all active proxy connections ordered by ID
+func (s *Speedbump) ConnStats() []ConnStats {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	stats := make([]ConnStats, 0, len(s.conns))
+	for id, c := range s.conns {
+		stat := c.counters.snapshot(id)
+		stat.QueueDepth = c.queueDepth()
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
@@ -0,0 +1,277 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFromSrcDelayTotals(t *testing.T) {
+	mockSrc := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		readRes: []readReturn{
+			{4, []byte("data"), nil},
+			{4, []byte("data"), nil},
+			{4, []byte("data"), nil},
+			{0, []byte(""), errors.New("some-error")},
+		},
+	}
+
+	done := make(chan error, 3)
+	c := &connection{
+		srcConn:    mockSrc,
+		bufferSize: 20,
+		latencyGen: &mockLatencyGenerator{time.Millisecond * 10},
+		ramp:       newDelayRamp(&DelayRampCfg{Step: time.Millisecond}),
+		delayQueue: make(chan transitBuffer, 10),
+		done:       done,
+		log:        hclog.NewNullLogger(),
+		counters:   &connCounters{},
+	}
+
+	c.readFromSrc()
+	<-done
+
+	stats := c.counters.snapshot(7)
+	assert.Equal(t, 7, stats.ID)
+	// 3 buffers delayed by 10ms of latency plus 1ms, 2ms and 3ms of ramp
+	assert.Equal(t, time.Millisecond*36, stats.TotalDelayTime.ClientToServer)
+	assert.Equal(t, time.Duration(0), stats.TotalDelayTime.ServerToClient)
+}
+
+func TestWaitForResponseRuleDelayTotals(t *testing.T) {
+	c := &connection{
+		responseRules: []ResponseLatencyRule{{StatusMin: 500, StatusMax: 599, Latency: time.Millisecond * 20}},
+		log:           hclog.NewNullLogger(),
+		counters:      &connCounters{},
+	}
+
+	c.waitForResponseRule([]byte("HTTP/1.1 503 Service Unavailable\r\n"))
+	c.waitForResponseRule([]byte("HTTP/1.1 200 OK\r\n"))
+	c.waitForResponseRule([]byte("HTTP/1.1 500 Internal Server Error\r\n"))
+
+	assert.Equal(t, time.Millisecond*40, c.counters.snapshot(0).TotalDelayTime.ServerToClient)
+}
+
+func TestSpeedbumpConnStats(t *testing.T) {
+	go startEchoSrv(9020)
+	waitForListener("localhost:9020")
+
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8018,
+		DestAddr:   "localhost:9020",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	assert.Empty(t, s.ConnStats())
+
+	conn, err := net.Dial("tcp", "localhost:8018")
+	assert.Nil(t, err)
+	res := make([]byte, 1024)
+	for i := 0; i < 2; i++ {
+		conn.Write([]byte("test-string"))
+		conn.Read(res)
+	}
+
+	stats := s.ConnStats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, defaultLatencyCfg.Base*2, stats[0].TotalDelayTime.ClientToServer)
+
+	// closed connections are no longer reported
+	conn.Close()
+	deadline := time.Now().Add(time.Second * 5)
+	for len(s.ConnStats()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Empty(t, s.ConnStats())
+}
+
+func TestSpeedbumpDelayBreakdown(t *testing.T) {
+	go startEchoSrv(9048)
+	waitForListener("localhost:9048")
+
+	cfg := SpeedbumpCfg{
+		Port:               8051,
+		DestAddr:           "localhost:9048",
+		BufferSize:         0xffff,
+		Latency:            &LatencyCfg{Base: time.Millisecond * 20},
+		LogLevel:           "ERROR",
+		Bandwidth:          5000,
+		BandwidthAlgorithm: BandwidthLeakyBucket,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8051")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	msg := make([]byte, 1000)
+	res := make([]byte, 1000)
+	for i := 0; i < 3; i++ {
+		conn.Write(msg)
+		_, err := io.ReadFull(conn, res)
+		assert.Nil(t, err)
+	}
+
+	stats := s.ConnStats()
+	assert.Len(t, stats, 1)
+	// each request gets delayed by 20ms of latency
+	assert.Equal(t, time.Millisecond*60, stats[0].LatencyDelay)
+	// while sending 1000 bytes at 5KB/s takes 200ms, of which the latency covers the first 20ms
+	// and the first request is sent right away, so that the two following ones wait for ~180ms
+	assert.InDelta(t, float64(time.Millisecond*360), float64(stats[0].BandwidthDelay), float64(time.Millisecond*100))
+	assert.Equal(t, time.Duration(0), stats[0].StallDelay)
+	assert.Equal(t, stats[0].TotalDelayTime.ClientToServer+stats[0].TotalDelayTime.ServerToClient, stats[0].LatencyDelay+stats[0].BandwidthDelay)
+}
+
+func TestReadFromDelayQueueQueueDelay(t *testing.T) {
+	dest := &timedConn{limit: 2}
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+	c := &connection{
+		destConn:   &stallingConn{dest, time.Millisecond * 100},
+		delayQueue: delayQueue,
+		counters:   &connCounters{},
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+
+	start := time.Now()
+	delayQueue <- transitBuffer{data: []byte("stalled"), delayUntil: start}
+	// the buffer due alongside the stalled one waits for its write to complete
+	delayQueue <- transitBuffer{data: []byte("waiting"), delayUntil: start}
+	// the last write fails in order for readFromDelayQueue to return
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start.Add(time.Millisecond * 200)}
+
+	c.readFromDelayQueue()
+	<-done
+
+	stats := c.counters.snapshot(0)
+	assert.InDelta(t, float64(time.Millisecond*100), float64(stats.QueueDelay), float64(time.Millisecond*20))
+	// time spent in the queue isn't part of the delay added by speedbump
+	assert.Equal(t, DelayTotals{}, stats.TotalDelayTime)
+}
+
+func TestReadFromDelayQueueQueueWait(t *testing.T) {
+	dest := &timedConn{limit: 2}
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+	c := &connection{
+		destConn:   &stallingConn{dest, time.Millisecond * 100},
+		delayQueue: delayQueue,
+		counters:   &connCounters{},
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+
+	// both buffers are intended to wait for 10ms
+	start := time.Now()
+	delayQueue <- transitBuffer{data: []byte("stalled"), delayUntil: start.Add(time.Millisecond * 10), queuedAt: start}
+	delayQueue <- transitBuffer{data: []byte("waiting"), delayUntil: start.Add(time.Millisecond * 10), queuedAt: start}
+	// the last write fails in order for readFromDelayQueue to return
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start.Add(time.Millisecond * 300)}
+
+	c.readFromDelayQueue()
+	<-done
+
+	wait := c.counters.snapshot(0).QueueWait
+	// buffers without the queueing time aren't recorded
+	assert.Equal(t, 2, wait.Count)
+	// the second buffer waits for the write of the first one
+	assert.InDelta(t, float64(time.Millisecond*110), float64(wait.Max), float64(time.Millisecond*20))
+}
+
+func TestSpeedbumpQueueWait(t *testing.T) {
+	backendConns := make(chan net.Conn, 1)
+	assert.Nil(t, startAcceptingSrv(9060, backendConns))
+
+	cfg := SpeedbumpCfg{
+		Port:       8068,
+		DestAddr:   "localhost:9060",
+		BufferSize: 1000,
+		Latency:    &LatencyCfg{Base: time.Millisecond * 10},
+		LogLevel:   "ERROR",
+		// sending each buffer takes 100ms, so that the following ones wait in the queue
+		Bandwidth:          10000,
+		BandwidthAlgorithm: BandwidthLeakyBucket,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8068")
+	assert.Nil(t, err)
+	backendConn := <-backendConns
+	defer backendConn.Close()
+	conn.Write(make([]byte, 4000))
+	_, err = io.ReadFull(backendConn, make([]byte, 4000))
+	assert.Nil(t, err)
+
+	stats := s.ConnStats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, 4, stats[0].QueueWait.Count)
+	// the wait of the last buffer far exceeds the intended latency
+	assert.Greater(t, int64(stats[0].QueueWait.Max), int64(time.Millisecond*150))
+	assert.Less(t, int64(stats[0].LatencyDelay), int64(time.Millisecond*50))
+
+	// the aggregate stats include the connection once it's closed
+	assert.Equal(t, 0, s.Stats().QueueWait.Count)
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		return s.Stats().QueueWait.Count == 4
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, stats[0].QueueWait.Max, s.Stats().QueueWait.Max)
+}
+
+func TestSpeedbumpQueueDepth(t *testing.T) {
+	go startEchoSrv(9078)
+	waitForListener("localhost:9078")
+
+	cfg := SpeedbumpCfg{
+		Port:       8080,
+		DestAddr:   "localhost:9078",
+		BufferSize: 16,
+		QueueSize:  8,
+		Latency:    &LatencyCfg{Base: time.Millisecond * 500},
+		LogLevel:   "ERROR",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8080")
+	assert.Nil(t, err)
+	defer conn.Close()
+	for i := 0; i < 8; i++ {
+		conn.Write([]byte("queued-buffer"))
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// the first buffer is taken from the queue while waiting for its release,
+	// while the following ones fill up the queue
+	stats := s.ConnStats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, QueueDepths{ClientToServer: 7}, stats[0].QueueDepth)
+
+	// the queue empties as the buffers get released
+	_, err = io.ReadFull(conn, make([]byte, 8*len("queued-buffer")))
+	assert.Nil(t, err)
+	assert.Equal(t, QueueDepths{}, s.ConnStats()[0].QueueDepth)
+}
@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimelineMaxEvents     = 1000
+	defaultTimelineByteMilestone = 1 << 20
+	// maxClosedTimelines is the number of closed connections whose timelines are retained
+	maxClosedTimelines = 100
+)
+
+// TimelineEventKind identifies the kind of an event in a connection's timeline
+type TimelineEventKind string
+
+const (
+	// TimelineOpen is recorded once a client connection is accepted
+	TimelineOpen TimelineEventKind = "open"
+	// TimelineLatency is recorded whenever the latency added in a direction changes
+	TimelineLatency TimelineEventKind = "latency"
+	// TimelinePause is recorded once a direction gets held back by a freeze or a stall
+	TimelinePause TimelineEventKind = "pause"
+	// TimelineResume is recorded once a paused direction resumes
+	TimelineResume TimelineEventKind = "resume"
+	// TimelineBytes is recorded whenever the bytes read in a direction reach a milestone
+	TimelineBytes TimelineEventKind = "bytes"
+	// TimelineClose is recorded once the connection is closed
+	TimelineClose TimelineEventKind = "close"
+)
+
+// TimelineEvent is a single event in the lifecycle of a proxy connection
+type TimelineEvent struct {
+	Time time.Time         `json:"time"`
+	Kind TimelineEventKind `json:"kind"`
+	// Direction is the direction of latency, pause, resume and bytes events
+	Direction Direction `json:"direction"`
+	// Latency is the latency in effect since a latency event
+	Latency time.Duration `json:"latency,omitempty"`
+	// Bytes is the milestone reached by a bytes event
+	Bytes int64 `json:"bytes,omitempty"`
+	// Detail is the destination of open events, the cause of pause events
+	// (freeze or stall) and the reason of close events
+	Detail string `json:"detail,omitempty"`
+}
+
+// connTimeline records the events of a single proxy connection, keeping
+// the most recent maxEvents of them
+type connTimeline struct {
+	maxEvents int
+	milestone int64
+	clock     Clock
+	mu        sync.Mutex
+	events    []TimelineEvent
+	// latency and bytes are the last latency and the bytes read so far in each direction
+	latency [2]time.Duration
+	bytes   [2]int64
+}
+
+func newConnTimeline(cfg *SpeedbumpCfg) *connTimeline {
+	maxEvents := cfg.TimelineMaxEvents
+	if maxEvents <= 0 {
+		maxEvents = defaultTimelineMaxEvents
+	}
+	milestone := cfg.TimelineByteMilestone
+	if milestone <= 0 {
+		milestone = defaultTimelineByteMilestone
+	}
+	return &connTimeline{maxEvents: maxEvents, milestone: milestone, clock: clockOrReal(cfg.Clock)}
+}
+
+// record appends an event to the timeline (no-op if ct is nil)
+func (ct *connTimeline) record(e TimelineEvent) {
+	if ct == nil {
+		return
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.append(e)
+}
+
+// append adds an event, dropping the oldest one if the timeline is full (mu must be held)
+func (ct *connTimeline) append(e TimelineEvent) {
+	if len(ct.events) >= ct.maxEvents {
+		ct.events = ct.events[1:]
+	}
+	ct.events = append(ct.events, e)
+}
+
+// setLatency records a latency event if the latency added in a direction changed
+func (ct *connTimeline) setLatency(direction Direction, latency time.Duration) {
+	if ct == nil {
+		return
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if ct.latency[direction] == latency {
+		return
+	}
+	ct.latency[direction] = latency
+	ct.append(TimelineEvent{Time: ct.clock.Now(), Kind: TimelineLatency, Direction: direction, Latency: latency})
+}
+
+// addBytes records a bytes event for each milestone reached by reading n bytes in a direction
+func (ct *connTimeline) addBytes(direction Direction, n int) {
+	if ct == nil {
+		return
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	prev := ct.bytes[direction]
+	ct.bytes[direction] += int64(n)
+	for m := (prev/ct.milestone + 1) * ct.milestone; m <= ct.bytes[direction]; m += ct.milestone {
+		ct.append(TimelineEvent{Time: ct.clock.Now(), Kind: TimelineBytes, Direction: direction, Bytes: m})
+	}
+}
+
+func (ct *connTimeline) snapshot() []TimelineEvent {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	events := make([]TimelineEvent, len(ct.events))
+	copy(events, ct.events)
+	return events
+}
+
+// startTimeline creates the timeline of a newly accepted connection if RecordTimelines is set
+func (s *Speedbump) startTimeline(id int) *connTimeline {
+	if !s.cfg.RecordTimelines {
+		return nil
+	}
+	ct := newConnTimeline(&s.cfg)
+	s.timelinesMu.Lock()
+	s.timelines[id] = ct
+	s.timelinesMu.Unlock()
+	return ct
+}
+
+// endTimeline records the close event of a connection, retaining
+// the timelines of the most recently closed connections
+func (s *Speedbump) endTimeline(id int, ct *connTimeline, reason error) {
+	if ct == nil {
+		return
+	}
+	detail := ""
+	if reason != nil {
+		detail = reason.Error()
+	}
+	ct.record(TimelineEvent{Time: s.clock.Now(), Kind: TimelineClose, Detail: detail})
+	s.timelinesMu.Lock()
+	defer s.timelinesMu.Unlock()
+	s.closedTimelines = append(s.closedTimelines, id)
+	if len(s.closedTimelines) > maxClosedTimelines {
+		delete(s.timelines, s.closedTimelines[0])
+		s.closedTimelines = s.closedTimelines[1:]
+	}
+}
+
+// ConnectionTimeline returns the events recorded for a given connection (if RecordTimelines
+// is set), which is either active or among the 100 most recently closed connections
+func (s *Speedbump) ConnectionTimeline(id int) ([]TimelineEvent, error) {
+	if !s.cfg.RecordTimelines {
+		return nil, fmt.Errorf("Connection timelines are not recorded (see RecordTimelines)")
+	}
+	s.timelinesMu.Lock()
+	ct, ok := s.timelines[id]
+	s.timelinesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown connection: %d", id)
+	}
+	return ct.snapshot(), nil
+}
@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnTimelineByteMilestones(t *testing.T) {
+	ct := newConnTimeline(&SpeedbumpCfg{TimelineByteMilestone: 10})
+	ct.addBytes(ClientToServer, 5)
+	ct.addBytes(ClientToServer, 25)
+	ct.addBytes(ServerToClient, 10)
+	var milestones []int64
+	for _, e := range ct.snapshot() {
+		assert.Equal(t, TimelineBytes, e.Kind)
+		if e.Direction == ClientToServer {
+			milestones = append(milestones, e.Bytes)
+		}
+	}
+	assert.Equal(t, []int64{10, 20, 30}, milestones)
+	assert.Len(t, ct.snapshot(), 4)
+}
+
+func TestConnTimelineMaxEvents(t *testing.T) {
+	ct := newConnTimeline(&SpeedbumpCfg{TimelineMaxEvents: 3})
+	for i := 1; i <= 5; i++ {
+		ct.setLatency(ClientToServer, time.Duration(i))
+	}
+	// unchanged latency isn't recorded
+	ct.setLatency(ClientToServer, 5)
+	events := ct.snapshot()
+	assert.Len(t, events, 3)
+	assert.Equal(t, time.Duration(3), events[0].Latency)
+	assert.Equal(t, time.Duration(5), events[2].Latency)
+}
+
+func eventKinds(events []TimelineEvent) []TimelineEventKind {
+	var kinds []TimelineEventKind
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+	}
+	return kinds
+}
+
+func TestSpeedbumpConnectionTimeline(t *testing.T) {
+	go startEchoSrv(9054)
+	waitForListener("localhost:9054")
+
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:                  8062,
+		DestAddr:              "localhost:9054",
+		BufferSize:            0xffff,
+		Latency:               &LatencyCfg{Base: time.Millisecond * 10},
+		DelayRamp:             &DelayRampCfg{Step: time.Millisecond * 10, Max: time.Millisecond * 20},
+		LogLevel:              "ERROR",
+		RecordTimelines:       true,
+		TimelineByteMilestone: 8,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8062")
+	assert.Nil(t, err)
+	res := make([]byte, 5)
+	for _, msg := range []string{"hello", "world"} {
+		conn.Write([]byte(msg))
+		conn.Read(res)
+	}
+	id := s.ConnStats()[0].ID
+
+	s.FreezeDirection(ServerToClient)
+	conn.Write([]byte("again"))
+	time.Sleep(time.Millisecond * 100)
+	s.ThawDirection(ServerToClient)
+	conn.Read(res)
+	conn.Close()
+
+	var events []TimelineEvent
+	assert.Eventually(t, func() bool {
+		events, err = s.ConnectionTimeline(id)
+		return err == nil && events[len(events)-1].Kind == TimelineClose
+	}, time.Second, time.Millisecond*10)
+
+	kinds := eventKinds(events)
+	assert.Equal(t, TimelineOpen, kinds[0])
+	assert.Equal(t, "localhost:9054", events[0].Detail)
+	assert.True(t, strings.HasSuffix(events[len(events)-1].Detail, "EOF"))
+
+	var latencies []time.Duration
+	var pause, resume *TimelineEvent
+	bytes := map[Direction][]int64{}
+	for i, e := range events {
+		switch e.Kind {
+		case TimelineLatency:
+			assert.Equal(t, ClientToServer, e.Direction)
+			latencies = append(latencies, e.Latency)
+		case TimelineBytes:
+			bytes[e.Direction] = append(bytes[e.Direction], e.Bytes)
+		case TimelinePause:
+			pause = &events[i]
+		case TimelineResume:
+			resume = &events[i]
+		}
+		if i > 0 {
+			assert.False(t, e.Time.Before(events[i-1].Time), "events are ordered by time")
+		}
+	}
+	// the ramp raises the latency until it reaches its max
+	assert.GreaterOrEqual(t, len(latencies), 2)
+	assert.Equal(t, time.Millisecond*30, latencies[len(latencies)-1])
+	for i := 1; i < len(latencies); i++ {
+		assert.Greater(t, latencies[i], latencies[i-1])
+	}
+	assert.Equal(t, []int64{8}, bytes[ClientToServer])
+	assert.Equal(t, []int64{8}, bytes[ServerToClient])
+	// the echoed data was held back while the direction was frozen
+	assert.NotNil(t, pause)
+	assert.NotNil(t, resume)
+	assert.Equal(t, ServerToClient, pause.Direction)
+	assert.Equal(t, "freeze", pause.Detail)
+	assert.True(t, isDurationCloseTo(time.Millisecond*100, resume.Time.Sub(pause.Time), 50))
+
+	_, err = s.ConnectionTimeline(id + 1)
+	assert.EqualError(t, err, fmt.Sprintf("Unknown connection: %d", id+1))
+}
+
+func TestSpeedbumpConnectionTimelineDisabled(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8000,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "ERROR",
+	})
+	assert.Nil(t, err)
+	_, err = s.ConnectionTimeline(0)
+	assert.True(t, strings.HasPrefix(err.Error(), "Connection timelines are not recorded"))
+}
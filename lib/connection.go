@@ -2,152 +2,842 @@ package lib
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
 )
 
+// shutdownMessageTimeout limits the time spent writing the shutdown message
+// (or the refused message) to a client
+const shutdownMessageTimeout = time.Second
+
+// clientReadError prefixes errors reading from the proxy client
+const clientReadError = "Error reading data from client"
+
 type transitBuffer struct {
 	data       []byte
 	delayUntil time.Time
+	// queuedAt is when the buffer was queued (its wait isn't recorded if it's zero)
+	queuedAt time.Time
 }
 
 type connection struct {
 	srcConn, destConn io.ReadWriteCloser
+	dial              func() (io.ReadWriteCloser, error)
+	reconnect         *reconnectPolicy
+	readRetry         *readRetryPolicy
 	bufferSize        int
-	latencyGen        LatencyGenerator
-	delayQueue        chan transitBuffer
-	done              chan error
-	ctx               context.Context
-	log               hclog.Logger
+	// pool optionally provides read buffers, which are returned to it once fully written
+	pool *bufferPool
+	// padBytes is the number of filler bytes appended to each buffer read from the client
+	padBytes int
+	// coalesceWindow is how long consecutive reads from the client are coalesced into a buffer
+	coalesceWindow time.Duration
+	queueMem       *queueMemory
+	// queuedBytes and queueMemReleased are guarded by queueMem.mu
+	queuedBytes      int
+	queueMemReleased bool
+	// serial makes data sent by the client get proxied by a single goroutine (see DebugSerial)
+	serial     bool
+	clock      Clock
+	latencyGen LatencyGenerator
+	// returnLatencyGen optionally delays data sent back by the proxy destination
+	// via returnQueue (nil if only data sent by the client is delayed)
+	returnLatencyGen LatencyGenerator
+	// returnQueue delays data sent back by the proxy destination (nil if neither
+	// returnLatencyGen nor classifyDirection is set)
+	returnQueue chan transitBuffer
+	// classifyDirection optionally tells the logical direction of each buffer,
+	// which selects the generator of its latency (see DirectionClassifier)
+	classifyDirection func([]byte) Direction
+	// returnFlushed is closed once readFromReturnQueue stops
+	returnFlushed chan struct{}
+	stall         *stallSchedule
+	ramp          *delayRamp
+	slowStart     *slowStart
+	// destination is the proxy destination as configured (set before the connection is started)
+	destination string
+	// timeline optionally records the connection's events (set before the connection is started)
+	timeline *connTimeline
+	// capture optionally records the connection's byte stream (set before the connection is started)
+	capture *connCapture
+	// budget optionally caps the total latency injected into the connection
+	budget        *latencyBudget
+	idle          *idleLatency
+	rtt           *rttLatency
+	responseRules []ResponseLatencyRule
+	chunks        *chunkSchedule
+	// compressionPerKB is the delay added to each buffer per KiB of its data
+	compressionPerKB time.Duration
+	// limiters optionally limit the throughput of each direction (indexed by Direction)
+	limiters    [2]rateLimiter
+	reorder     *reorderer
+	freeze      *directionFreeze
+	delayQueue  chan transitBuffer
+	drainWindow time.Duration
+	// maxQueueAge optionally bounds how long past its release time a buffer
+	// may wait in the delay queue before being evicted
+	maxQueueAge time.Duration
+	// drainBatchSize optionally limits the number of buffers released from the delay queue
+	// in a row (without waiting for the next one to be due) before the goroutine yields,
+	// while batched counts the ones released so far and yields counts how often it yielded
+	drainBatchSize  int
+	batched         int
+	yields          int
+	shutdownMessage []byte
+	// closeLinger defers closing one side of the connection after the other one closed it
+	closeLinger time.Duration
+	// warnOnClientGone makes clients going away get logged as a warning (see WarnOnClientGone)
+	warnOnClientGone bool
+	// stopCtx is the Speedbump instance's context, which is cancelled by Stop()
+	stopCtx     context.Context
+	warnLimiter *logLimiter
+	done        chan error
+	ctx         context.Context
+	log         hclog.Logger
+	// lifecycleLog optionally replaces log for the opening and closing of the connection
+	// (see LogSampleRate)
+	lifecycleLog hclog.Logger
+	// destMu guards destConn, destGen, closed and the reconnect state,
+	// as destConn may get replaced when reconnecting to the proxy destination
+	destMu  sync.Mutex
+	destGen int
+	closed  bool
+	// reconnecting is closed once an ongoing reconnect finishes
+	reconnecting    chan struct{}
+	reconnectFailed bool
+	// wakeups counts the delay queue's timer wakeups
+	wakeups  int
+	counters *connCounters
 }
 
 func (c *connection) readFromSrc() {
 	for {
-		buffer := make([]byte, c.bufferSize)
-		bytes, err := c.srcConn.Read(buffer)
-		receivedAt := time.Now()
+		buffer := c.pool.get(c.bufferSize)
+		bytes, err := c.read(c.srcConn, buffer)
+		receivedAt := c.now()
 		if err != nil {
-			c.done <- fmt.Errorf("Error reading data from client %s", err)
+			c.pool.put(buffer)
+			c.done <- clientError(clientReadError, err)
 			return
 		}
+		bytes, err = c.coalesceReads(buffer, bytes)
+		c.counters.addBytes(ClientToServer, bytes)
+		c.timeline.addBytes(ClientToServer, bytes)
+		c.capture.record(ClientToServer, buffer[:bytes], receivedAt)
+		c.waitForFreeze(ClientToServer)
 		trimmedBuffer := buffer[:bytes]
-		desiredLatency := c.latencyGen.generateLatency(receivedAt)
-		delayUntil := receivedAt.Add(desiredLatency)
+		if c.padBytes > 0 {
+			trimmedBuffer = append(trimmedBuffer, make([]byte, c.padBytes)...)
+		}
+		desiredLatency := c.budget.spend(c.latencyGenFor(ClientToServer, buffer[:bytes]).generateLatency(receivedAt) + c.ramp.next() + c.slowStart.next(receivedAt) + c.idle.next(receivedAt) + c.rtt.next())
+		c.counters.addDelay(ClientToServer, latencyDelay, desiredLatency)
+		c.counters.addInjectedLatency(desiredLatency, receivedAt)
+		c.timeline.setLatency(ClientToServer, desiredLatency)
+		compression := c.compressionDelay(ClientToServer, bytes)
+		delayUntil := receivedAt.Add(desiredLatency + compression)
 
 		t := transitBuffer{
 			data:       trimmedBuffer,
 			delayUntil: delayUntil,
+			queuedAt:   receivedAt,
 		}
 
 		c.log.Trace("Writing to delay queue", "bytes", bytes, "delay", desiredLatency)
 
-		c.delayQueue <- t
+		if c.queueMem.acquire(c, len(trimmedBuffer)) {
+			c.delayQueue <- t
+		} else {
+			c.pool.put(buffer)
+		}
 
+		if err != nil {
+			c.done <- clientError(clientReadError, err)
+			return
+		}
 	}
 }
 
+// proxySerial forwards data sent by the client to the proxy destination on a single
+// goroutine, reading a buffer, waiting for its delay to pass according to the clock
+// and writing it before the next buffer is read. Without the delay queue, the order of
+// operations is deterministic, which makes it reproducible with a fake clock.
+func (c *connection) proxySerial() {
+	for {
+		// the buffer is returned to the pool by writeToDest
+		buffer := c.pool.get(c.bufferSize)
+		bytes, err := c.read(c.srcConn, buffer)
+		receivedAt := c.now()
+		if err != nil {
+			c.pool.put(buffer)
+			c.done <- clientError(clientReadError, err)
+			return
+		}
+		c.counters.addBytes(ClientToServer, bytes)
+		c.timeline.addBytes(ClientToServer, bytes)
+		c.capture.record(ClientToServer, buffer[:bytes], receivedAt)
+		desiredLatency := c.budget.spend(c.latencyGenFor(ClientToServer, buffer[:bytes]).generateLatency(receivedAt) + c.ramp.next() + c.slowStart.next(receivedAt) + c.idle.next(receivedAt) + c.rtt.next())
+		c.counters.addDelay(ClientToServer, latencyDelay, desiredLatency)
+		c.counters.addInjectedLatency(desiredLatency, receivedAt)
+		c.timeline.setLatency(ClientToServer, desiredLatency)
+		desiredLatency += c.compressionDelay(ClientToServer, bytes)
+		c.log.Trace("Delaying buffer", "bytes", bytes, "delay", desiredLatency)
+		c.sleep(desiredLatency)
+		if !c.writeToDest(transitBuffer{data: buffer[:bytes], delayUntil: receivedAt.Add(desiredLatency)}) {
+			return
+		}
+	}
+}
+
+// coalesceReads keeps reading from the client into the remainder of a buffer holding n bytes
+// for up to coalesceWindow, so that small writes of chatty clients occupy fewer delay queue
+// slots. It returns the number of bytes in the buffer and the error that ended reading
+// (other than the window's deadline being exceeded).
+func (c *connection) coalesceReads(buffer []byte, n int) (int, error) {
+	d, ok := c.srcConn.(interface{ SetReadDeadline(time.Time) error })
+	if c.coalesceWindow <= 0 || !ok {
+		return n, nil
+	}
+	// socket deadlines are in real time (see Clock)
+	d.SetReadDeadline(time.Now().Add(c.coalesceWindow))
+	defer d.SetReadDeadline(time.Time{})
+	for n < len(buffer) {
+		read, err := c.srcConn.Read(buffer[n:])
+		n += read
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
 func (c *connection) readFromDest() {
-	buffer := make([]byte, c.bufferSize)
+	buffer := c.pool.get(c.bufferSize)
+	defer func() { c.pool.put(buffer) }()
 	for {
-		bytes, err := c.destConn.Read(buffer)
+		destConn, gen := c.dest()
+		bytes, err := c.read(destConn, buffer)
+		receivedAt := c.now()
 		if err != nil {
+			if c.reconnectDest(gen, err) == nil {
+				continue
+			}
+			c.flushReturnQueue()
 			c.done <- fmt.Errorf("Error reading data from proxy destination: %s", err)
 			return
 		}
+		c.rtt.received(receivedAt)
+		c.counters.addBytes(ServerToClient, bytes)
+		c.timeline.addBytes(ServerToClient, bytes)
+		c.capture.record(ServerToClient, buffer[:bytes], receivedAt)
+		c.waitForFreeze(ServerToClient)
 		trimmedBuffer := buffer[:bytes]
 
-		bytes, err = c.srcConn.Write(trimmedBuffer)
-		if err != nil {
-			c.done <- fmt.Errorf("Error writing data back to proxy client: %s", err)
+		c.waitForStall(ServerToClient)
+		c.waitForResponseRule(trimmedBuffer)
+		compression := c.compressionDelay(ServerToClient, bytes)
+
+		if c.returnQueue != nil {
+			desiredLatency := c.budget.spend(c.latencyGenFor(ServerToClient, trimmedBuffer).generateLatency(receivedAt))
+			c.counters.addDelay(ServerToClient, latencyDelay, desiredLatency)
+			c.counters.addInjectedLatency(desiredLatency, receivedAt)
+			c.timeline.setLatency(ServerToClient, desiredLatency)
+			c.returnQueue <- transitBuffer{data: trimmedBuffer, delayUntil: receivedAt.Add(desiredLatency + compression), queuedAt: receivedAt}
+			// the queued buffer is returned to the pool once written to the client
+			buffer = c.pool.get(c.bufferSize)
+			continue
+		}
+
+		c.sleep(compression)
+		if !c.writeToSrc(trimmedBuffer) {
 			return
 		}
 	}
 }
 
+// flushReturnQueue stops readFromReturnQueue and waits until the buffers queued so far
+// are written, so that data sent by the proxy destination before closing the connection
+// is delivered to the client
+func (c *connection) flushReturnQueue() {
+	if c.returnQueue == nil {
+		return
+	}
+	close(c.returnQueue)
+	<-c.returnFlushed
+}
+
+// readFromReturnQueue writes buffers delayed in returnQueue back to the client.
+// Once writing fails, the remaining buffers are discarded until readFromDest stops,
+// so that it never blocks on a full queue.
+func (c *connection) readFromReturnQueue() {
+	defer close(c.returnFlushed)
+	failed := false
+	for t := range c.returnQueue {
+		if !failed {
+			if d := t.delayUntil.Sub(c.now()); d > 0 {
+				c.sleep(d)
+			}
+			c.counters.addDelay(ServerToClient, queueDelay, c.now().Sub(t.delayUntil))
+			c.counters.addQueueWait(t, c.now())
+			failed = !c.writeToSrc(t.data)
+		}
+		c.pool.put(t.data)
+	}
+}
+
+// writeToSrc writes data sent by the proxy destination back to the client.
+// It returns false if writing failed and the connection is done.
+func (c *connection) writeToSrc(data []byte) bool {
+	for _, chunk := range c.chunks.split(data, c.now()) {
+		c.waitForBandwidth(ServerToClient, len(chunk))
+		if _, err := writeFull(c.srcConn, chunk); err != nil {
+			c.done <- clientError("Error writing data back to proxy client:", err)
+			return false
+		}
+	}
+	return true
+}
+
+// now, sleep and after consult the connection's clock (real time if it's unset)
+func (c *connection) now() time.Time {
+	return clockOrReal(c.clock).Now()
+}
+
+func (c *connection) sleep(d time.Duration) {
+	clockOrReal(c.clock).Sleep(d)
+}
+
+func (c *connection) after(d time.Duration) <-chan time.Time {
+	return clockOrReal(c.clock).After(d)
+}
+
+// compressionDelay returns the delay modeling the CPU cost of (de)compressing
+// a buffer of n bytes flowing in a given direction and records it
+func (c *connection) compressionDelay(direction Direction, n int) time.Duration {
+	d := c.compressionPerKB * time.Duration(n) / 1024
+	c.counters.addDelay(direction, compressionDelay, d)
+	return d
+}
+
+// writeFull writes the whole buffer to w, continuing after short writes.
+// The number of bytes written is less than len(b) only if an error occurred.
+func writeFull(w io.Writer, b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := w.Write(b[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n <= 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return len(b), nil
+}
+
+// queueDepth returns the number of buffers currently waiting in the connection's queues
+func (c *connection) queueDepth() QueueDepths {
+	return QueueDepths{ClientToServer: len(c.delayQueue), ServerToClient: len(c.returnQueue)}
+}
+
 func (c *connection) readFromDelayQueue() {
+	// held is a buffer taken from the delay queue that wasn't due yet while batching
+	var held *transitBuffer
 	for {
-		t := <-c.delayQueue
+		var t transitBuffer
+		if held != nil {
+			t, held = *held, nil
+		} else {
+			t = <-c.delayQueue
+		}
 
 		c.log.Trace("Read from delay queue", "bytes", len(t.data))
 
-		time.Sleep(time.Until(t.delayUntil))
+		wakeAt := c.wakeupTime(t.delayUntil)
+		if d := wakeAt.Sub(c.now()); d > 0 {
+			c.wakeups++
+			c.batched = 0
+			c.sleep(d)
+		}
 
-		_, err := c.destConn.Write(t.data)
-		if err != nil {
-			c.done <- fmt.Errorf("Error writing from delay queue to proxy destination: %s", err)
+		if next, ok := c.swapWithNext(); ok {
+			c.log.Trace("Reordering buffers", "bytes", len(t.data), "next", len(next.data))
+			if d := c.wakeupTime(next.delayUntil).Sub(c.now()); d > 0 {
+				c.sleep(d)
+			}
+			if !c.release(next) {
+				return
+			}
+		}
+
+		if !c.release(t) {
 			return
 		}
+
+		if c.drainWindow > 0 {
+			var ok bool
+			held, ok = c.drainDueBuffers(wakeAt)
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// swapWithNext returns the buffer queued behind the one being released if the two
+// are to swap places according to the reorderer (buffers aren't reordered if the
+// delay queue is empty, so that a buffer is never held back indefinitely)
+func (c *connection) swapWithNext() (transitBuffer, bool) {
+	if !c.reorder.swap() {
+		return transitBuffer{}, false
+	}
+	select {
+	case next := <-c.delayQueue:
+		return next, true
+	default:
+		return transitBuffer{}, false
 	}
 }
 
+// wakeupTime returns the time at which a buffer due at a given point in time
+// should be released. With batching enabled, wakeups are aligned
+// to multiples of the drain window.
+func (c *connection) wakeupTime(due time.Time) time.Time {
+	if c.drainWindow <= 0 {
+		return due
+	}
+	wakeAt := due.Truncate(c.drainWindow)
+	if wakeAt.Before(due) {
+		wakeAt = wakeAt.Add(c.drainWindow)
+	}
+	return wakeAt
+}
+
+// drainDueBuffers releases all buffers waiting in the delay queue that are due
+// by a given wakeup time without sleeping again. It returns the first buffer
+// that isn't due yet (if any) and false if writing to the destination failed.
+func (c *connection) drainDueBuffers(wakeAt time.Time) (*transitBuffer, bool) {
+	for {
+		select {
+		case t := <-c.delayQueue:
+			if t.delayUntil.After(wakeAt) {
+				return &t, true
+			}
+			c.log.Trace("Read from delay queue", "bytes", len(t.data))
+			if !c.release(t) {
+				return nil, false
+			}
+		default:
+			return nil, true
+		}
+	}
+}
+
+// release writes a buffer released from the delay queue to the proxy destination,
+// recording the time it spent in the queue past its release time. The buffer is dropped
+// instead if that time exceeds maxQueueAge (i.e. because writing the previous buffers
+// to the proxy destination blocked). It returns false if writing failed.
+func (c *connection) release(t transitBuffer) bool {
+	now := c.now()
+	age := now.Sub(t.delayUntil)
+	c.counters.addDelay(ClientToServer, queueDelay, age)
+	c.counters.addQueueWait(t, now)
+	if c.maxQueueAge <= 0 || age <= c.maxQueueAge {
+		if !c.writeToDest(t) {
+			return false
+		}
+		c.yieldAfterBatch()
+		return true
+	}
+	c.log.Trace("Evicting stale buffer", "bytes", len(t.data), "age", age)
+	c.counters.addEvicted()
+	c.queueMem.release(c, len(t.data))
+	c.pool.put(t.data)
+	return true
+}
+
+// yieldAfterBatch counts a buffer released from the delay queue, yielding the goroutine
+// once drainBatchSize buffers were released in a row, so that a connection with a backlog
+// of due buffers doesn't hold up the others. Smaller batches favor fairness across
+// connections, while larger ones favor the throughput of a single connection.
+func (c *connection) yieldAfterBatch() {
+	if c.drainBatchSize <= 0 {
+		return
+	}
+	c.batched++
+	if c.batched >= c.drainBatchSize {
+		c.batched = 0
+		c.yields++
+		runtime.Gosched()
+	}
+}
+
+// writeToDest writes a buffer released from the delay queue to the proxy
+// destination. It returns false if writing failed and the connection is done.
+func (c *connection) writeToDest(t transitBuffer) bool {
+	// the buffer isn't referenced anywhere else once it's been written
+	defer c.pool.put(t.data)
+	defer c.queueMem.release(c, len(t.data))
+	c.waitForStall(ClientToServer)
+
+	for _, chunk := range c.chunks.split(t.data, c.now()) {
+		c.waitForBandwidth(ClientToServer, len(chunk))
+		if !c.writeChunkToDest(chunk) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *connection) writeChunkToDest(chunk []byte) bool {
+	for {
+		destConn, gen := c.dest()
+		_, err := writeFull(destConn, chunk)
+		if err == nil {
+			c.counters.addWritten(ClientToServer, len(chunk))
+			c.rtt.sent(c.now())
+			return true
+		}
+		if c.reconnectDest(gen, err) != nil {
+			c.done <- fmt.Errorf("Error writing from delay queue to proxy destination: %s", err)
+			return false
+		}
+	}
+}
+
+// waitForStall blocks for as long as the given direction of the connection
+// is stalled according to the stall schedule
+func (c *connection) waitForStall(direction Direction) {
+	if d := c.stall.remaining(direction, c.now()); d > 0 {
+		c.log.Trace("Stalling connection", "direction", direction, "duration", d)
+		c.counters.addDelay(direction, stallDelay, d)
+		c.timeline.record(TimelineEvent{Time: c.now(), Kind: TimelinePause, Direction: direction, Detail: "stall"})
+		c.sleep(d)
+		c.timeline.record(TimelineEvent{Time: c.now(), Kind: TimelineResume, Direction: direction})
+	}
+}
+
+// waitForFreeze blocks for as long as the given direction is frozen (see FreezeDirection)
+func (c *connection) waitForFreeze(direction Direction) {
+	if c.timeline == nil || !c.freeze.isFrozen(direction) {
+		c.freeze.wait(c.ctx, direction)
+		return
+	}
+	c.timeline.record(TimelineEvent{Time: c.now(), Kind: TimelinePause, Direction: direction, Detail: "freeze"})
+	c.freeze.wait(c.ctx, direction)
+	c.timeline.record(TimelineEvent{Time: c.now(), Kind: TimelineResume, Direction: direction})
+}
+
+// waitForBandwidth blocks until n bytes can be sent in the given direction
+// without exceeding its bandwidth limit
+func (c *connection) waitForBandwidth(direction Direction, n int) {
+	limiter := c.limiters[direction]
+	if limiter == nil {
+		return
+	}
+	if d := limiter.reserve(c.now(), n); d > 0 {
+		c.log.Trace("Limiting bandwidth", "direction", direction, "duration", d)
+		c.counters.addDelay(direction, bandwidthDelay, d)
+		c.sleep(d)
+	}
+}
+
+// waitForResponseRule delays a buffer read from the proxy destination if it starts
+// an HTTP response matching one of the response latency rules. Only responses
+// starting at the beginning of a read are detected.
+func (c *connection) waitForResponseRule(data []byte) {
+	if d := responseLatency(c.responseRules, data); d > 0 {
+		c.log.Trace("Delaying response", "duration", d)
+		c.counters.addDelay(ServerToClient, latencyDelay, d)
+		c.sleep(d)
+	}
+}
+
+// latencyGenFor returns the generator of the latency of a buffer read from the side
+// of the connection a given direction starts at, which is the generator of the direction
+// returned by classifyDirection if it's set
+func (c *connection) latencyGenFor(direction Direction, data []byte) LatencyGenerator {
+	if c.classifyDirection != nil {
+		direction = c.classifyDirection(data)
+	}
+	if direction == ClientToServer {
+		return c.latencyGen
+	}
+	if c.returnLatencyGen == nil {
+		return noLatencyGenerator{}
+	}
+	return c.returnLatencyGen
+}
+
+// lifecycle returns the logger used for the opening and closing of the connection
+func (c *connection) lifecycle() hclog.Logger {
+	if c.lifecycleLog != nil {
+		return c.lifecycleLog
+	}
+	return c.log
+}
+
 // start launches 3 goroutines responsible for handling a proxy connection
-// (dest->src, src->queue, queue->dest). This operation will block until
-// either an error is sent via the done channel or the context is cancelled.
-func (c *connection) start() {
-	c.log.Debug("Starting a new proxy connection")
+// (dest->src, src->queue, queue->dest), plus one writing delayed data back
+// to the client (return queue->src) if returnQueue is set. This operation
+// will block until either an error is sent via the done channel or the context
+// is cancelled. It returns the error that closed the connection.
+func (c *connection) start() error {
+	c.lifecycle().Debug("Starting a new proxy connection")
 	go c.readFromDest()
-	go c.readFromSrc()
-	go c.readFromDelayQueue()
+	if c.returnQueue != nil {
+		go c.readFromReturnQueue()
+	}
+	if c.serial {
+		go c.proxySerial()
+	} else {
+		go c.readFromSrc()
+		go c.readFromDelayQueue()
+	}
 	for {
 		select {
 		case err := <-c.done:
 			c.handleError(err)
-			return
+			return err
 		case <-c.ctx.Done():
 			c.handleStop()
-			return
+			return c.ctx.Err()
 		}
 	}
 }
 
 func (c *connection) handleError(err error) {
-	if !strings.HasSuffix(err.Error(), io.EOF.Error()) {
-		c.log.Warn("Closing proxy connection due to an unexpected error", "err", err)
+	var goneErr *ClientGoneError
+	if errors.As(err, &goneErr) {
+		// the proxy destination is closed right away, as nothing can be delivered anymore
+		if c.warnOnClientGone {
+			c.warnLimiter.warn(c.log, "Closing proxy connection, client gone", "err", goneErr.Err)
+		} else {
+			c.lifecycle().Debug("Closing proxy connection, client gone", "err", goneErr.Err)
+		}
+	} else if !strings.HasSuffix(err.Error(), io.EOF.Error()) {
+		c.warnLimiter.warn(c.log, "Closing proxy connection due to an unexpected error", "err", err)
+	} else if c.closeLinger > 0 {
+		c.lingerClose(strings.HasPrefix(err.Error(), clientReadError))
+		return
+	} else {
+		c.lifecycle().Debug("Closing proxy connection (EOF)")
+	}
+	c.closeProxyConnections()
+}
+
+// lingerClose closes the side of the connection which was closed by its peer right away,
+// while the other side is kept open for closeLinger (or until the context is done),
+// simulating a peer that is slow to complete the close handshake
+func (c *connection) lingerClose(clientClosed bool) {
+	c.destMu.Lock()
+	// the proxy destination is not re-dialed while lingering
+	c.closed = true
+	if clientClosed {
+		c.srcConn.Close()
 	} else {
-		c.log.Debug("Closing proxy connection (EOF)")
+		c.destConn.Close()
+	}
+	c.destMu.Unlock()
+	c.lifecycle().Debug("Lingering before closing proxy connection (EOF)", "clientClosed", clientClosed, "linger", c.closeLinger)
+	select {
+	case <-c.after(c.closeLinger):
+	case <-c.ctx.Done():
 	}
 	c.closeProxyConnections()
 }
 
 func (c *connection) handleStop() {
-	c.log.Info("Stopping proxy connection")
+	c.lifecycle().Info("Stopping proxy connection", "reason", c.ctx.Err())
+	// the shutdown message is not sent if the connection's own context is done
+	if len(c.shutdownMessage) > 0 && c.stopCtx != nil && c.stopCtx.Err() != nil {
+		c.writeShutdownMessage()
+	}
 	c.closeProxyConnections()
 }
 
+// writeShutdownMessage notifies the client about a planned shutdown
+// of the proxy connection before it gets closed
+func (c *connection) writeShutdownMessage() {
+	if d, ok := c.srcConn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		// socket deadlines are in real time (see Clock)
+		d.SetWriteDeadline(time.Now().Add(shutdownMessageTimeout))
+	}
+	if _, err := writeFull(c.srcConn, c.shutdownMessage); err != nil {
+		c.log.Debug("Writing shutdown message to proxy client failed", "err", err)
+	}
+}
+
 func (c *connection) closeProxyConnections() {
+	c.destMu.Lock()
+	c.closed = true
 	c.srcConn.Close()
 	c.destConn.Close()
+	c.destMu.Unlock()
+	c.queueMem.releaseConn(c)
+}
+
+// connectionOpts holds the settings shared by the proxy connections of
+// an instance, along with the few ones picked per connection
+type connectionOpts struct {
+	// happyEyeballsAddr is the hostname dialed by racing its addresses (if set)
+	happyEyeballsAddr string
+	backendTLS        *tls.Config
+	localBackend      func() io.ReadWriteCloser
+	bufferSize        int
+	pool              *bufferPool
+	queueMem          *queueMemory
+	padBytes          int
+	coalesceWindow    time.Duration
+	serial            bool
+	queueSize         int
+	drainWindow       time.Duration
+	maxQueueAge       time.Duration
+	drainBatchSize    int
+	latencyGen        LatencyGenerator
+	returnLatencyGen  LatencyGenerator
+	classifyDirection func([]byte) Direction
+	stall             *stallSchedule
+	ramp              *DelayRampCfg
+	slowStart         *slowStart
+	idle              *IdleLatencyCfg
+	rtt               *RTTLatencyCfg
+	latencyBudget     time.Duration
+	compressionPerKB  time.Duration
+	responseRules     []ResponseLatencyRule
+	chunks            *chunkSchedule
+	bandwidth         bandwidthLimit
+	reorder           *reorderer
+	freeze            *directionFreeze
+	dialTimeout       time.Duration
+	closeLinger       time.Duration
+	warnOnClientGone  bool
+	reconnect         *reconnectPolicy
+	readRetry         *readRetryPolicy
+	shutdownMessage   []byte
+	stopCtx           context.Context
+	warnLimiter       *logLimiter
+	clock             Clock
 }
 
 func newProxyConnection(
 	ctx context.Context,
 	clientConn io.ReadWriteCloser,
-	srcAddr *net.TCPAddr,
 	destAddr *net.TCPAddr,
-	bufferSize int,
-	queueSize int,
-	latencyGen LatencyGenerator,
+	opts connectionOpts,
 	logger hclog.Logger,
 ) (*connection, error) {
-	destConn, err := net.DialTCP("tcp", nil, destAddr)
+	dial := func() (io.ReadWriteCloser, error) {
+		if opts.localBackend != nil {
+			return opts.localBackend(), nil
+		}
+		// the dial timeout is enforced by the socket and the context's deadline,
+		// both of which are in real time (see Clock)
+		started := time.Now()
+		dialer := net.Dialer{Timeout: opts.dialTimeout}
+		// the dial timeout is only reported if it expires before the context's deadline
+		dialTimeoutFirst := opts.dialTimeout > 0
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Add(opts.dialTimeout).Before(deadline) {
+			dialTimeoutFirst = false
+		}
+		// dialing is aborted as soon as the connection's context is done
+		var destConn net.Conn
+		var err error
+		if opts.happyEyeballsAddr != "" {
+			destConn, err = dialHappyEyeballs(ctx, &dialer, opts.happyEyeballsAddr, happyEyeballsDelay, opts.clock)
+		} else {
+			destConn, err = dialer.DialContext(ctx, "tcp", destAddr.String())
+		}
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && dialTimeoutFirst && ctx.Err() == nil {
+				return nil, &DialTimeoutError{Addr: destAddr.String(), Timeout: opts.dialTimeout}
+			}
+			return nil, fmt.Errorf("Error dialing remote address: %w", err)
+		}
+		if opts.backendTLS != nil {
+			// the handshake is aborted once the connection's context is done
+			// or the dial timeout (which covers the handshake as well) expires
+			handshakeCtx := ctx
+			if opts.dialTimeout > 0 {
+				var cancel context.CancelFunc
+				handshakeCtx, cancel = context.WithDeadline(ctx, started.Add(opts.dialTimeout))
+				defer cancel()
+			}
+			tlsConn := tls.Client(destConn, opts.backendTLS)
+			if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+				destConn.Close()
+				if handshakeCtx.Err() == context.DeadlineExceeded && dialTimeoutFirst && ctx.Err() == nil {
+					return nil, &DialTimeoutError{Addr: destAddr.String(), Timeout: opts.dialTimeout}
+				}
+				return nil, fmt.Errorf("Error establishing TLS with remote address: %s", err)
+			}
+			return tlsConn, nil
+		}
+		return destConn, nil
+	}
+	destConn, err := dial()
 	if err != nil {
-		return nil, fmt.Errorf("Error dialing remote address: %s", err)
+		return nil, err
 	}
 	c := &connection{
-		srcConn:    clientConn,
-		destConn:   destConn,
-		bufferSize: bufferSize,
-		latencyGen: latencyGen,
-		delayQueue: make(chan transitBuffer, queueSize),
-		done:       make(chan error, 3),
-		ctx:        ctx,
-		log:        logger,
+		srcConn:           clientConn,
+		destConn:          destConn,
+		dial:              dial,
+		reconnect:         opts.reconnect,
+		readRetry:         opts.readRetry,
+		shutdownMessage:   opts.shutdownMessage,
+		closeLinger:       opts.closeLinger,
+		warnOnClientGone:  opts.warnOnClientGone,
+		stopCtx:           opts.stopCtx,
+		warnLimiter:       opts.warnLimiter,
+		bufferSize:        opts.bufferSize,
+		pool:              opts.pool,
+		queueMem:          opts.queueMem,
+		padBytes:          opts.padBytes,
+		coalesceWindow:    opts.coalesceWindow,
+		serial:            opts.serial,
+		latencyGen:        opts.latencyGen,
+		returnLatencyGen:  opts.returnLatencyGen,
+		classifyDirection: opts.classifyDirection,
+		stall:             opts.stall,
+		ramp:              newDelayRamp(opts.ramp),
+		slowStart:         opts.slowStart,
+		idle:              newIdleLatency(opts.idle),
+		rtt:               newRTTLatency(opts.rtt),
+		budget:            newLatencyBudget(opts.latencyBudget),
+		compressionPerKB:  opts.compressionPerKB,
+		responseRules:     opts.responseRules,
+		chunks:            opts.chunks,
+		reorder:           opts.reorder,
+		counters:          &connCounters{},
+		freeze:            opts.freeze,
+		delayQueue:        make(chan transitBuffer, opts.queueSize),
+		drainWindow:       opts.drainWindow,
+		maxQueueAge:       opts.maxQueueAge,
+		drainBatchSize:    opts.drainBatchSize,
+		done:              make(chan error, 4),
+		clock:             opts.clock,
+		ctx:               ctx,
+		log:               logger,
+	}
+	if opts.returnLatencyGen != nil || opts.classifyDirection != nil {
+		c.returnQueue = make(chan transitBuffer, opts.queueSize)
+		c.returnFlushed = make(chan struct{})
 	}
+	c.limiters[ClientToServer] = opts.bandwidth.newLimiter()
+	c.limiters[ServerToClient] = opts.bandwidth.newLimiter()
 
 	return c, nil
 }
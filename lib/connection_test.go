@@ -1,9 +1,14 @@
 package lib
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
 	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -28,9 +33,15 @@ type mockConn struct {
 	readRes    []readReturn
 	writeRes   []writeReturn
 	closeRes   []error
+	// closed is optionally set once Close is called, after which Read returns io.EOF,
+	// so that the goroutines reading from it exit instead of cycling through readRes forever
+	closed *int32
 }
 
 func (m mockConn) Read(p []byte) (int, error) {
+	if m.closed != nil && atomic.LoadInt32(m.closed) == 1 {
+		return 0, io.EOF
+	}
 	invocation := *m.readCount
 	*m.readCount++
 	res := m.readRes[invocation%len(m.readRes)]
@@ -46,6 +57,9 @@ func (m mockConn) Write(p []byte) (int, error) {
 }
 
 func (m mockConn) Close() error {
+	if m.closed != nil {
+		atomic.StoreInt32(m.closed, 1)
+	}
 	invocation := *m.closeCount
 	*m.closeCount++
 	res := m.closeRes[invocation%len(m.closeRes)]
@@ -96,6 +110,156 @@ func TestReadFromSrc(t *testing.T) {
 	assert.EqualError(t, err, "Error reading data from client some-error")
 }
 
+func TestReadFromSrcPadding(t *testing.T) {
+	mockSrc := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		readRes: []readReturn{
+			{8, []byte("testdata"), nil},
+			{4, []byte("more"), nil},
+			{0, []byte(""), errors.New("some-error")},
+		},
+	}
+
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+
+	c := &connection{
+		srcConn:    mockSrc,
+		bufferSize: 10,
+		padBytes:   3,
+		latencyGen: &mockLatencyGenerator{time.Millisecond * 2},
+		delayQueue: delayQueue,
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+
+	c.readFromSrc()
+	<-done
+
+	// the padding may exceed the buffer size
+	assert.Equal(t, []byte("testdata\x00\x00\x00"), (<-delayQueue).data)
+	assert.Equal(t, []byte("more\x00\x00\x00"), (<-delayQueue).data)
+}
+
+func TestReadFromSrcCompressionDelay(t *testing.T) {
+	mockSrc := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		readRes: []readReturn{
+			{1024, make([]byte, 1024), nil},
+			{4096, make([]byte, 4096), nil},
+			{0, []byte(""), errors.New("some-error")},
+		},
+	}
+
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+
+	c := &connection{
+		srcConn:          mockSrc,
+		bufferSize:       4096,
+		latencyGen:       &mockLatencyGenerator{time.Millisecond * 20},
+		compressionPerKB: time.Millisecond * 10,
+		delayQueue:       delayQueue,
+		done:             done,
+		log:              hclog.NewNullLogger(),
+		counters:         &connCounters{},
+	}
+
+	start := time.Now()
+	c.readFromSrc()
+	<-done
+
+	// the compression delay is added on top of the latency in proportion to the buffer's size
+	assert.True(t, isDurationCloseTo(time.Millisecond*30, (<-delayQueue).delayUntil.Sub(start), 10))
+	assert.True(t, isDurationCloseTo(time.Millisecond*60, (<-delayQueue).delayUntil.Sub(start), 10))
+	stats := c.counters.snapshot(0)
+	assert.Equal(t, time.Millisecond*50, stats.CompressionDelay)
+	assert.Equal(t, time.Millisecond*40, stats.LatencyDelay)
+	assert.Equal(t, time.Millisecond*90, stats.TotalDelayTime.ClientToServer)
+}
+
+func TestReadFromSrcCoalesce(t *testing.T) {
+	client, server := net.Pipe()
+	delayQueue := make(chan transitBuffer, 200)
+	done := make(chan error, 3)
+
+	c := &connection{
+		srcConn:        server,
+		bufferSize:     64,
+		coalesceWindow: time.Millisecond * 50,
+		latencyGen:     &mockLatencyGenerator{time.Millisecond * 2},
+		delayQueue:     delayQueue,
+		done:           done,
+		log:            hclog.NewNullLogger(),
+	}
+	go c.readFromSrc()
+
+	for i := 0; i < 100; i++ {
+		client.Write([]byte("ab"))
+	}
+	client.Close()
+	assert.EqualError(t, <-done, "Error reading data from client EOF")
+
+	var received []byte
+	entries := len(delayQueue)
+	for len(delayQueue) > 0 {
+		received = append(received, (<-delayQueue).data...)
+	}
+	assert.Equal(t, bytes.Repeat([]byte("ab"), 100), received)
+	// 200 bytes fit in 4 buffers of 64 bytes
+	assert.LessOrEqual(t, entries, 5)
+}
+
+func TestReadFromSrcDelayRamp(t *testing.T) {
+	reads := []readReturn{}
+	for i := 0; i < 20; i++ {
+		reads = append(reads, readReturn{4, []byte("data"), nil})
+	}
+	reads = append(reads, readReturn{0, []byte(""), errors.New("some-error")})
+	mockSrc := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		readRes:    reads,
+	}
+
+	delayQueue := make(chan transitBuffer, 20)
+	done := make(chan error, 3)
+
+	c := &connection{
+		srcConn:    mockSrc,
+		bufferSize: 20,
+		latencyGen: &mockLatencyGenerator{time.Millisecond * 2},
+		ramp:       newDelayRamp(&DelayRampCfg{Step: time.Millisecond * 10, Max: time.Millisecond * 100}),
+		delayQueue: delayQueue,
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+
+	c.readFromSrc()
+	<-done
+
+	first := <-delayQueue
+	prev := first
+	for i := 1; i < 20; i++ {
+		next := <-delayQueue
+		increase := next.delayUntil.Sub(prev.delayUntil)
+		// the delay of each buffer increases by the step until reaching the cap
+		if i < 10 {
+			assert.True(t, isDurationCloseTo(time.Millisecond*10, increase, 20), increase)
+		} else {
+			assert.GreaterOrEqual(t, int64(increase), int64(0))
+			assert.Less(t, int64(increase), int64(time.Millisecond*5))
+		}
+		prev = next
+	}
+	assert.True(t, isDurationCloseTo(time.Millisecond*90, prev.delayUntil.Sub(first.delayUntil), 10))
+}
+
 func TestReadFromDest(t *testing.T) {
 	readCnt := new(int)
 	writeCtn := new(int)
@@ -213,8 +377,8 @@ func TestReadFromDelayQueue(t *testing.T) {
 		log:        hclog.NewNullLogger(),
 	}
 
-	delayQueue <- transitBuffer{[]byte("testdata"), time.Now().Add(time.Millisecond)}
-	delayQueue <- transitBuffer{[]byte("testdata"), time.Now().Add(time.Millisecond * 2)}
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: time.Now().Add(time.Millisecond)}
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: time.Now().Add(time.Millisecond * 2)}
 
 	c.readFromDelayQueue()
 
@@ -242,6 +406,7 @@ func TestStart(t *testing.T) {
 			{0, errors.New("dest-write-err")},
 		},
 		closeRes: []error{nil},
+		closed:   new(int32),
 	}
 
 	readCnt = new(int)
@@ -259,6 +424,7 @@ func TestStart(t *testing.T) {
 			{10, nil},
 		},
 		closeRes: []error{nil},
+		closed:   new(int32),
 	}
 
 	delayQueue := make(chan transitBuffer, 10)
@@ -285,21 +451,502 @@ func TestStart(t *testing.T) {
 }
 
 func TestNewProxyConnectionError(t *testing.T) {
-	localAddr, _ := net.ResolveTCPAddr("tcp", ":8000")
 	destAddr, _ := net.ResolveTCPAddr("tcp", "nope:3000")
 
 	mockClientConn := mockConn{}
 
-	_, err := newProxyConnection(
-		context.TODO(),
-		mockClientConn,
-		localAddr,
-		destAddr,
-		0xffff,
-		100,
-		&mockLatencyGenerator{time.Millisecond * 10},
-		hclog.Default(),
-	)
+	_, err := newProxyConnection(context.TODO(), mockClientConn, destAddr, connectionOpts{}, hclog.Default())
 
 	assert.NotNil(t, err)
 }
+
+func TestStallOneDirection(t *testing.T) {
+	mockDest := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		readRes: []readReturn{
+			{10, []byte("testdata12"), nil},
+			{0, []byte(""), io.EOF},
+		},
+		writeRes: []writeReturn{
+			{10, nil},
+			{0, errors.New("write-error")},
+		},
+	}
+	mockSrc := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		writeRes: []writeReturn{
+			{10, nil},
+		},
+	}
+
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+
+	// server-to-client traffic is stalled for the next 100ms
+	stallDuration := time.Millisecond * 100
+	vc := NewVirtualClock(time.Unix(0, 0))
+	start := vc.Now()
+	c := &connection{
+		srcConn:    mockSrc,
+		destConn:   mockDest,
+		bufferSize: 20,
+		stall: newStallSchedule(start.Add(stallDuration-time.Second), &StallCfg{
+			Direction: ServerToClient,
+			Period:    time.Second,
+			Duration:  stallDuration,
+		}),
+		delayQueue: delayQueue,
+		done:       done,
+		clock:      vc,
+		log:        hclog.NewNullLogger(),
+	}
+
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start}
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start}
+
+	// client-to-server traffic isn't stalled, so it's written without waiting for the clock
+	c.readFromDelayQueue()
+	<-done
+	assert.Equal(t, 2, *mockDest.writeCount)
+	assert.Equal(t, 0, vc.Waiters())
+
+	go c.readFromDest()
+	vc.BlockUntil(1)
+	vc.Advance(stallDuration - time.Millisecond)
+	assert.Equal(t, 0, *mockSrc.writeCount)
+	vc.Advance(time.Millisecond)
+	<-done
+
+	assert.Equal(t, 1, *mockSrc.writeCount)
+}
+
+func TestNewProxyConnectionDialTimeout(t *testing.T) {
+	destAddr, _ := net.ResolveTCPAddr("tcp", "localhost:3000")
+
+	mockClientConn := mockConn{}
+
+	_, err := newProxyConnection(context.TODO(), mockClientConn, destAddr, connectionOpts{dialTimeout: time.Nanosecond}, hclog.Default())
+
+	var timeoutErr *DialTimeoutError
+	assert.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, time.Nanosecond, timeoutErr.Timeout)
+	assert.Equal(t, destAddr.String(), timeoutErr.Addr)
+}
+
+// timedConn records the time and size of each write and fails once a write limit is reached
+type timedConn struct {
+	writes []time.Time
+	sizes  []int
+	limit  int
+}
+
+func (tc *timedConn) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (tc *timedConn) Write(p []byte) (int, error) {
+	if len(tc.writes) >= tc.limit {
+		return 0, errors.New("write-limit")
+	}
+	tc.writes = append(tc.writes, time.Now())
+	tc.sizes = append(tc.sizes, len(p))
+	return len(p), nil
+}
+
+func (tc *timedConn) Close() error {
+	return nil
+}
+
+func TestReadFromDelayQueueBatching(t *testing.T) {
+	window := time.Millisecond * 50
+	offsets := []time.Duration{10, 12, 14, 30, 70, 120}
+	dest := &timedConn{limit: len(offsets)}
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+
+	c := &connection{
+		destConn:    dest,
+		delayQueue:  delayQueue,
+		drainWindow: window,
+		done:        done,
+		log:         hclog.NewNullLogger(),
+	}
+
+	start := time.Now()
+	var due []time.Time
+	for _, offset := range offsets {
+		delayUntil := start.Add(time.Millisecond * offset)
+		due = append(due, delayUntil)
+		delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: delayUntil}
+	}
+	// the last write fails in order for readFromDelayQueue to return
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start}
+
+	c.readFromDelayQueue()
+	<-done
+
+	assert.Len(t, dest.writes, len(offsets))
+	for i, writtenAt := range dest.writes {
+		assert.False(t, writtenAt.Before(due[i]))
+		// the added delay is bounded by the drain window
+		assert.Less(t, int64(writtenAt.Sub(due[i])), int64(window+time.Millisecond*30))
+	}
+	assert.Less(t, c.wakeups, len(offsets))
+}
+
+// stallingConn blocks its first write for a given duration
+type stallingConn struct {
+	*timedConn
+	stall time.Duration
+}
+
+func (sc *stallingConn) Write(p []byte) (int, error) {
+	if len(sc.writes) == 0 {
+		time.Sleep(sc.stall)
+	}
+	return sc.timedConn.Write(p)
+}
+
+func TestReadFromDelayQueueEvictsStaleBuffers(t *testing.T) {
+	dest := &timedConn{limit: 2}
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+
+	c := &connection{
+		destConn:    &stallingConn{dest, time.Millisecond * 100},
+		delayQueue:  delayQueue,
+		maxQueueAge: time.Millisecond * 50,
+		counters:    &connCounters{},
+		done:        done,
+		log:         hclog.NewNullLogger(),
+	}
+
+	start := time.Now()
+	// the buffers due alongside the first one are stuck behind its stalled write
+	delayQueue <- transitBuffer{data: []byte("stalled"), delayUntil: start}
+	delayQueue <- transitBuffer{data: []byte("stale-1"), delayUntil: start}
+	delayQueue <- transitBuffer{data: []byte("stale-2"), delayUntil: start.Add(time.Millisecond * 20)}
+	// while the one due after the stall is written
+	delayQueue <- transitBuffer{data: []byte("fresh"), delayUntil: start.Add(time.Millisecond * 150)}
+	// the last write fails in order for readFromDelayQueue to return
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start.Add(time.Millisecond * 150)}
+
+	c.readFromDelayQueue()
+	<-done
+
+	assert.Equal(t, []int{7, 5}, dest.sizes)
+	assert.Equal(t, 2, c.counters.snapshot(0).EvictedBuffers)
+}
+
+func TestReadFromDelayQueueNoEvictionByDefault(t *testing.T) {
+	dest := &timedConn{limit: 3}
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+
+	c := &connection{
+		destConn:   &stallingConn{dest, time.Millisecond * 100},
+		delayQueue: delayQueue,
+		counters:   &connCounters{},
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start}
+	}
+
+	c.readFromDelayQueue()
+	<-done
+
+	assert.Len(t, dest.writes, 3)
+	assert.Equal(t, 0, c.counters.snapshot(0).EvictedBuffers)
+}
+
+func TestWakeupTime(t *testing.T) {
+	c := &connection{}
+	due := time.Unix(100, int64(time.Millisecond*30))
+	assert.Equal(t, due, c.wakeupTime(due))
+
+	c.drainWindow = time.Millisecond * 50
+	assert.Equal(t, time.Unix(100, int64(time.Millisecond*50)), c.wakeupTime(due))
+	assert.Equal(t, time.Unix(100, 0), c.wakeupTime(time.Unix(100, 0)))
+}
+
+func benchmarkDelayQueue(b *testing.B, drainWindow time.Duration) {
+	dest := &timedConn{limit: b.N}
+	delayQueue := make(chan transitBuffer, b.N+1)
+	c := &connection{
+		destConn:    dest,
+		delayQueue:  delayQueue,
+		drainWindow: drainWindow,
+		done:        make(chan error, 3),
+		log:         hclog.NewNullLogger(),
+	}
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start.Add(time.Microsecond * 10 * time.Duration(i))}
+	}
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start}
+	b.ResetTimer()
+
+	c.readFromDelayQueue()
+
+	b.ReportMetric(float64(c.wakeups)/float64(b.N), "wakeups/op")
+}
+
+func BenchmarkDelayQueue(b *testing.B) {
+	benchmarkDelayQueue(b, 0)
+}
+
+func BenchmarkDelayQueueBatched(b *testing.B) {
+	benchmarkDelayQueue(b, time.Millisecond)
+}
+
+// benchmarkDrainBatchSize drains a backlog of due buffers on 8 connections at once,
+// reporting the spread between the times at which connections finish draining,
+// which shrinks as the connections take turns more often
+func benchmarkDrainBatchSize(b *testing.B, batchSize int) {
+	const conns = 8
+	prevProcs := runtime.GOMAXPROCS(1)
+	defer runtime.GOMAXPROCS(prevProcs)
+	var spread time.Duration
+	for i := 0; i < b.N; i++ {
+		finished := make([]time.Time, conns)
+		var wg sync.WaitGroup
+		for j := 0; j < conns; j++ {
+			delayQueue := make(chan transitBuffer, 1001)
+			for k := 0; k < 1001; k++ {
+				delayQueue <- transitBuffer{data: []byte("testdata")}
+			}
+			c := &connection{
+				destConn:       &timedConn{limit: 1000},
+				delayQueue:     delayQueue,
+				drainBatchSize: batchSize,
+				done:           make(chan error, 3),
+				log:            hclog.NewNullLogger(),
+			}
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				c.readFromDelayQueue()
+				finished[j] = time.Now()
+			}(j)
+		}
+		wg.Wait()
+		first, last := finished[0], finished[0]
+		for _, f := range finished {
+			if f.Before(first) {
+				first = f
+			}
+			if f.After(last) {
+				last = f
+			}
+		}
+		spread += last.Sub(first)
+	}
+	b.ReportMetric(float64(spread.Microseconds())/float64(b.N), "finish-spread-us/op")
+}
+
+func BenchmarkDrainBatchSizeUnlimited(b *testing.B) {
+	benchmarkDrainBatchSize(b, 0)
+}
+
+func BenchmarkDrainBatchSize1(b *testing.B) {
+	benchmarkDrainBatchSize(b, 1)
+}
+
+func BenchmarkDrainBatchSize64(b *testing.B) {
+	benchmarkDrainBatchSize(b, 64)
+}
+
+func TestReadFromDelayQueueDrainBatchSize(t *testing.T) {
+	for _, tc := range []struct{ batchSize, yields int }{{0, 0}, {1, 10}, {3, 3}, {20, 0}} {
+		dest := &timedConn{limit: 10}
+		delayQueue := make(chan transitBuffer, 11)
+		c := &connection{
+			destConn:       dest,
+			delayQueue:     delayQueue,
+			drainWindow:    time.Millisecond,
+			drainBatchSize: tc.batchSize,
+			done:           make(chan error, 3),
+			log:            hclog.NewNullLogger(),
+		}
+		// all buffers are due, so they're released in a row
+		start := time.Now()
+		for i := 0; i < 11; i++ {
+			delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start}
+		}
+
+		c.readFromDelayQueue()
+
+		assert.Len(t, dest.writes, 10)
+		// the goroutine yields after each batch of released buffers
+		assert.Equal(t, tc.yields, c.yields, "batch size %d", tc.batchSize)
+	}
+}
+
+func TestHandleStopShutdownMessage(t *testing.T) {
+	mockSrc := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		writeRes:   []writeReturn{{3, nil}},
+		closeRes:   []error{nil},
+	}
+	mockDest := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		closeRes:   []error{nil},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := &connection{
+		srcConn:         mockSrc,
+		destConn:        mockDest,
+		shutdownMessage: []byte("bye"),
+		stopCtx:         ctx,
+		ctx:             ctx,
+		log:             hclog.NewNullLogger(),
+	}
+
+	c.handleStop()
+
+	assert.Equal(t, 1, *mockSrc.writeCount)
+	assert.Equal(t, 0, *mockDest.writeCount)
+	assert.Equal(t, 1, *mockSrc.closeCount)
+	assert.Equal(t, 1, *mockDest.closeCount)
+}
+
+func TestHandleStopConnContextDone(t *testing.T) {
+	mockSrc := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		writeRes:   []writeReturn{{3, nil}},
+		closeRes:   []error{nil},
+	}
+	mockDest := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		closeRes:   []error{nil},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := &connection{
+		srcConn:         mockSrc,
+		destConn:        mockDest,
+		shutdownMessage: []byte("bye"),
+		stopCtx:         context.Background(),
+		ctx:             ctx,
+		log:             hclog.NewNullLogger(),
+	}
+
+	c.handleStop()
+
+	// the instance wasn't stopped, so no shutdown message is sent
+	assert.Equal(t, 0, *mockSrc.writeCount)
+	assert.Equal(t, 1, *mockSrc.closeCount)
+	assert.Equal(t, 1, *mockDest.closeCount)
+}
+
+func TestChunkedWritesPMTUDrop(t *testing.T) {
+	dest := &timedConn{limit: 7}
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+
+	c := &connection{
+		destConn:   dest,
+		chunks:     newChunkSchedule(time.Now(), 4, time.Millisecond*100, 3),
+		delayQueue: delayQueue,
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+	delayQueue <- transitBuffer{data: []byte("0123456789"), delayUntil: time.Now()}
+	// the second buffer is released after the path MTU drop
+	delayQueue <- transitBuffer{data: []byte("0123456789"), delayUntil: time.Now().Add(time.Millisecond * 150)}
+	// the last write fails in order for readFromDelayQueue to return
+	delayQueue <- transitBuffer{data: []byte("0"), delayUntil: time.Now().Add(time.Millisecond * 150)}
+
+	c.readFromDelayQueue()
+	<-done
+
+	assert.Equal(t, []int{4, 4, 2, 3, 3, 3, 1}, dest.sizes)
+}
+
+// shortWriteConn accepts at most max bytes per write and fails once limit bytes were written
+type shortWriteConn struct {
+	max    int
+	limit  int
+	writes int
+	buf    bytes.Buffer
+}
+
+func (sc *shortWriteConn) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (sc *shortWriteConn) Write(p []byte) (int, error) {
+	if sc.buf.Len() >= sc.limit {
+		return 0, errors.New("write-limit")
+	}
+	sc.writes++
+	if len(p) > sc.max {
+		p = p[:sc.max]
+	}
+	return sc.buf.Write(p)
+}
+
+func (sc *shortWriteConn) Close() error {
+	return nil
+}
+
+func TestReadFromDelayQueueShortWrites(t *testing.T) {
+	dest := &shortWriteConn{max: 3, limit: 20}
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+
+	c := &connection{
+		destConn:   dest,
+		delayQueue: delayQueue,
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+	delayQueue <- transitBuffer{data: []byte("0123456789"), delayUntil: time.Now()}
+	delayQueue <- transitBuffer{data: []byte("abcdefghij"), delayUntil: time.Now()}
+	// the last write fails in order for readFromDelayQueue to return
+	delayQueue <- transitBuffer{data: []byte("!"), delayUntil: time.Now()}
+
+	c.readFromDelayQueue()
+	<-done
+
+	assert.Equal(t, "0123456789abcdefghij", dest.buf.String())
+	assert.Equal(t, 8, dest.writes)
+}
+
+func TestWriteToSrcShortWrites(t *testing.T) {
+	src := &shortWriteConn{max: 4, limit: 100}
+	c := &connection{
+		srcConn: src,
+		done:    make(chan error, 3),
+		log:     hclog.NewNullLogger(),
+	}
+
+	assert.True(t, c.writeToSrc([]byte("0123456789")))
+	assert.Equal(t, "0123456789", src.buf.String())
+	assert.Equal(t, 3, src.writes)
+}
+
+func TestWriteFullNoProgress(t *testing.T) {
+	n, err := writeFull(&shortWriteConn{max: 0, limit: 100}, []byte("data"))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.ErrShortWrite, err)
+}
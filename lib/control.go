@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// controlHelp lists the commands accepted by serveControl
+const controlHelp = "Commands: enable, disable, latency <duration>, destination <host:port>, stats, conns, close <id>, help"
+
+// serveControl reads commands from r line by line, applies them to the instance
+// and writes their results to w until r is exhausted. It's meant as a manual testing
+// front-end built on top of the instance's exported methods.
+func (s *Speedbump) serveControl(r io.Reader, w io.Writer) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch cmd, args := fields[0], fields[1:]; {
+		case cmd == "enable" && len(args) == 0:
+			if !s.Enable() {
+				fmt.Fprintln(w, "Latency is already enabled")
+				continue
+			}
+			fmt.Fprintln(w, "Latency enabled for new connections")
+		case cmd == "disable" && len(args) == 0:
+			s.Disable()
+			fmt.Fprintln(w, "Latency disabled for new connections")
+		case cmd == "latency" && len(args) == 1:
+			base, err := time.ParseDuration(args[0])
+			if err != nil {
+				fmt.Fprintf(w, "Error parsing latency: %s\n", err)
+				continue
+			}
+			s.SetLatency(base)
+			fmt.Fprintf(w, "Latency set to %s for new connections\n", base)
+		case cmd == "destination" && len(args) == 1:
+			if err := s.SetDestination(args[0]); err != nil {
+				fmt.Fprintln(w, err)
+				continue
+			}
+			fmt.Fprintf(w, "Destination set to %s for new connections\n", args[0])
+		case cmd == "stats" && len(args) == 0:
+			json.NewEncoder(w).Encode(s.Stats())
+		case cmd == "conns" && len(args) == 0:
+			json.NewEncoder(w).Encode(s.ConnStats())
+		case cmd == "close" && len(args) == 1:
+			id, err := strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Fprintf(w, "Error parsing connection ID: %s\n", err)
+				continue
+			}
+			if !s.CloseConnection(id) {
+				fmt.Fprintf(w, "No active connection with ID %d\n", id)
+				continue
+			}
+			fmt.Fprintf(w, "Closed connection %d\n", id)
+		default:
+			fmt.Fprintln(w, controlHelp)
+		}
+	}
+}
+
+// latencyCfg returns a copy of the latency config used for new connections
+func (s *Speedbump) latencyCfg() *LatencyCfg {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+	if s.cfg.Latency == nil {
+		return nil
+	}
+	latency := *s.cfg.Latency
+	return &latency
+}
@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeControl(t *testing.T) {
+	go startEchoSrv(9021)
+	waitForListener("localhost:9021")
+
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8019,
+		DestAddr:   "localhost:9021",
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{Base: time.Millisecond * 20},
+		LogLevel:   "ERROR",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	cmdR, cmdW := io.Pipe()
+	outR, outW := io.Pipe()
+	go func() {
+		s.serveControl(cmdR, outW)
+		outW.Close()
+	}()
+	out := bufio.NewScanner(outR)
+	send := func(cmd string) string {
+		io.WriteString(cmdW, cmd+"\n")
+		assert.True(t, out.Scan())
+		return out.Text()
+	}
+
+	assert.Equal(t, "Latency disabled for new connections", send("disable"))
+	assert.Nil(t, s.latencyCfg())
+	assert.Equal(t, "Latency enabled for new connections", send("enable"))
+	assert.Equal(t, time.Millisecond*20, s.latencyCfg().Base)
+	assert.Equal(t, "Latency is already enabled", send("enable"))
+
+	assert.Equal(t, "Latency set to 200ms for new connections", send("latency 200ms"))
+	assert.Equal(t, time.Millisecond*200, s.latencyCfg().Base)
+	assert.Contains(t, send("latency soon"), "Error parsing latency")
+
+	assert.Equal(t, "Destination set to localhost:9021 for new connections", send("destination localhost:9021"))
+	assert.Contains(t, send("destination nope"), "Error resolving destination address")
+
+	var stats Stats
+	assert.Nil(t, json.Unmarshal([]byte(send("stats")), &stats))
+	assert.Equal(t, Stats{}, stats)
+
+	conn, err := net.Dial("tcp", "localhost:8019")
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.Write([]byte("test-string"))
+	res := make([]byte, 1024)
+	n, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:n])
+
+	var conns []ConnStats
+	assert.Nil(t, json.Unmarshal([]byte(send("conns")), &conns))
+	assert.Len(t, conns, 1)
+
+	assert.Equal(t, "No active connection with ID 7", send("close 7"))
+	assert.Equal(t, "Closed connection 0", send("close 0"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(res)
+	assert.Equal(t, io.EOF, err)
+
+	assert.Equal(t, controlHelp, send("bogus"))
+
+	cmdW.Close()
+	assert.False(t, out.Scan())
+}
+
+func TestSpeedbumpConcurrentLatencyControl(t *testing.T) {
+	go startEchoSrv(9081)
+	waitForListener("localhost:9081")
+
+	cfg := SpeedbumpCfg{
+		Port:       8083,
+		DestAddr:   "localhost:9081",
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{Base: time.Millisecond * 5},
+		LogLevel:   "ERROR",
+		// the bandwidth of new connections gets derived from their latency
+		LinkWindow: 1 << 20,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	done := make(chan struct{})
+	var traffic sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		traffic.Add(1)
+		go func() {
+			defer traffic.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				conn, err := net.Dial("tcp", "localhost:8083")
+				if !assert.Nil(t, err) {
+					return
+				}
+				conn.Write([]byte("test-string"))
+				_, err = io.ReadFull(conn, make([]byte, len("test-string")))
+				assert.Nil(t, err)
+				conn.Close()
+			}
+		}()
+	}
+	// the latency and bandwidth of new connections always match each other
+	traffic.Add(1)
+	go func() {
+		defer traffic.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			armed := s.latencyGen.load()
+			base := armed.gen.generateLatency(time.Now())
+			assert.Equal(t, linkBandwidth(cfg.LinkWindow, &LatencyCfg{Base: base}), armed.bandwidth.rate)
+		}
+	}()
+
+	var control sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		control.Add(3)
+		go func() {
+			defer control.Done()
+			for j := 0; j < 200; j++ {
+				s.Enable()
+			}
+		}()
+		go func() {
+			defer control.Done()
+			for j := 0; j < 200; j++ {
+				s.Disable()
+			}
+		}()
+		go func(i int) {
+			defer control.Done()
+			for j := 0; j < 200; j++ {
+				s.SetLatency(time.Millisecond * time.Duration(1+(i+j)%5))
+			}
+		}(i)
+	}
+	control.Wait()
+	close(done)
+	traffic.Wait()
+
+	// the last call wins
+	s.SetLatency(time.Millisecond * 30)
+	assert.False(t, s.Enable())
+	assert.Equal(t, &LatencyCfg{Base: time.Millisecond * 30}, s.latencyCfg())
+	s.Disable()
+	assert.Nil(t, s.latencyCfg())
+	assert.Equal(t, 0, s.latencyGen.load().bandwidth.rate)
+	assert.True(t, s.Enable())
+	assert.Equal(t, &LatencyCfg{Base: time.Millisecond * 30}, s.latencyCfg())
+	assert.Equal(t, linkBandwidth(cfg.LinkWindow, s.latencyCfg()), s.latencyGen.load().bandwidth.rate)
+
+	s.latencyMu.Lock()
+	effective := s.cfg
+	s.latencyMu.Unlock()
+	assert.Equal(t, linkBandwidth(cfg.LinkWindow, effective.Latency), effective.Bandwidth)
+
+	// new connections get the latency that won
+	conn, err := net.Dial("tcp", "localhost:8083")
+	assert.Nil(t, err)
+	defer conn.Close()
+	start := time.Now()
+	conn.Write([]byte("test-string"))
+	_, err = io.ReadFull(conn, make([]byte, len("test-string")))
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Millisecond*30))
+}
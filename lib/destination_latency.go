@@ -0,0 +1,14 @@
+package lib
+
+import "time"
+
+func newDestinationLatencyGenerators(start time.Time, latencies map[string]*LatencyCfg) map[string]LatencyGenerator {
+	if len(latencies) == 0 {
+		return nil
+	}
+	generators := make(map[string]LatencyGenerator, len(latencies))
+	for dest, cfg := range latencies {
+		generators[dest] = newLatencyGenerator(start, cfg)
+	}
+	return generators
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+)
+
+// tlsRecordTypeHandshake is the first byte of a TLS record carrying a ClientHello
+const tlsRecordTypeHandshake = 0x16
+
+// defaultTLSDetectTimeout is the default period of time to wait for
+// the first byte sent by the client before assuming a plaintext protocol
+const defaultTLSDetectTimeout = time.Second
+
+// bufferedConn is a client connection whose initial bytes were consumed
+// while detecting its protocol. These bytes are replayed on subsequent reads.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
+
+// detectTLS waits for the first byte sent by the client and checks whether
+// it begins a TLS handshake. If the client sends nothing within the timeout
+// (as is the case with server-speaks-first protocols), the connection is
+// treated as plaintext. The client connection is closed if the context
+// gets cancelled before the first byte arrives.
+func detectTLS(ctx context.Context, conn net.Conn, timeout time.Duration) (*bufferedConn, bool, error) {
+	detected := make(chan struct{})
+	defer close(detected)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-detected:
+		}
+	}()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	bc := &bufferedConn{conn, bufio.NewReader(conn)}
+	first, err := bc.reader.Peek(1)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		// nothing was buffered, but the reader retains the timeout error
+		return &bufferedConn{conn, bufio.NewReader(conn)}, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return bc, first[0] == tlsRecordTypeHandshake, nil
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectTLS(t *testing.T) {
+	client, server := net.Pipe()
+	go client.Write([]byte{tlsRecordTypeHandshake, 0x03, 0x01})
+
+	bc, isTLS, err := detectTLS(context.TODO(), server, time.Second)
+	assert.Nil(t, err)
+	assert.True(t, isTLS)
+
+	// the peeked byte is replayed
+	res := make([]byte, 10)
+	n, _ := bc.Read(res)
+	assert.Equal(t, []byte{tlsRecordTypeHandshake, 0x03, 0x01}, res[:n])
+}
+
+func TestDetectTLSPlaintext(t *testing.T) {
+	client, server := net.Pipe()
+	go client.Write([]byte("GET / HTTP/1.1\r\n"))
+
+	bc, isTLS, err := detectTLS(context.TODO(), server, time.Second)
+	assert.Nil(t, err)
+	assert.False(t, isTLS)
+
+	res := make([]byte, 32)
+	n, _ := bc.Read(res)
+	assert.Equal(t, []byte("GET / HTTP/1.1\r\n"), res[:n])
+}
+
+func TestDetectTLSCancelled(t *testing.T) {
+	_, server := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := detectTLS(ctx, server, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestDetectTLSTimeout(t *testing.T) {
+	client, server := net.Pipe()
+
+	start := time.Now()
+	bc, isTLS, err := detectTLS(context.TODO(), server, time.Millisecond*50)
+	assert.Nil(t, err)
+	assert.False(t, isTLS)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Millisecond*50))
+
+	// the connection remains usable after the timeout
+	go client.Write([]byte("QUIT\r\n"))
+	res := make([]byte, 32)
+	n, err := bc.Read(res)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("QUIT\r\n"), res[:n])
+}
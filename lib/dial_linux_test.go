@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// startBlackholeSrv returns the address of a listening socket with a full
+// accept backlog, so that dialing it hangs until the dial gets aborted
+func startBlackholeSrv(t *testing.T) string {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}})
+	syscall.Listen(fd, 0)
+	sa, _ := syscall.Getsockname(fd)
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	var fillers []net.Conn
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Millisecond*50)
+		if err != nil {
+			break
+		}
+		fillers = append(fillers, conn)
+	}
+	t.Cleanup(func() {
+		for _, conn := range fillers {
+			conn.Close()
+		}
+		syscall.Close(fd)
+	})
+	return addr
+}
+
+func TestNewProxyConnectionContextDeadlineDuringDial(t *testing.T) {
+	destAddr, _ := net.ResolveTCPAddr("tcp", startBlackholeSrv(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	_, err := newProxyConnection(ctx, mockConn{}, destAddr, connectionOpts{dialTimeout: time.Second * 10}, hclog.NewNullLogger())
+	elapsed := time.Since(start)
+
+	assert.NotNil(t, err)
+	// the connection's deadline is not reported as a dial timeout
+	var timeoutErr *DialTimeoutError
+	assert.False(t, errors.As(err, &timeoutErr))
+	assert.Less(t, int64(elapsed), int64(time.Second))
+}
+
+func TestDialStaggeredFastestWins(t *testing.T) {
+	blackhole := startBlackholeSrv(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	start := time.Now()
+	conn, err := dialStaggered(context.Background(), &net.Dialer{Timeout: time.Second * 10}, []string{blackhole, l.Addr().String()}, time.Millisecond*50, nil)
+	elapsed := time.Since(start)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// the reachable address wins without waiting for the hanging attempt
+	assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+	assert.GreaterOrEqual(t, int64(elapsed), int64(time.Millisecond*50))
+	assert.Less(t, int64(elapsed), int64(time.Second))
+}
+
+func TestSpeedbumpStopDuringDial(t *testing.T) {
+	logger, buf := newBufferLogger()
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8060,
+		DestAddr:   startBlackholeSrv(t),
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+	})
+	assert.Nil(t, err)
+	s.log = logger
+	assert.Nil(t, s.Start())
+
+	conn, err := net.Dial("tcp", "localhost:8060")
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Eventually(t, func() bool {
+		s.activeMu.Lock()
+		defer s.activeMu.Unlock()
+		return s.activeCount == 1
+	}, time.Second, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 50)
+
+	// the pending dial is cancelled instead of blocking Stop()
+	start := time.Now()
+	s.Stop()
+	assert.Less(t, time.Since(start), time.Millisecond*500)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 16))
+	assert.NotNil(t, err)
+	assert.False(t, isTimeout(err))
+	assert.Equal(t, 0, s.Stats().DialTimeouts)
+	// aborted dials aren't reported as failures
+	assert.NotContains(t, strings.Join(buf.lines(), "\n"), "Creating new proxy conn failed")
+}
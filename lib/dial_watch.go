@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// dialWatch keeps reading from a client connection while the proxy destination is being
+// dialed, so that the dial can be aborted as soon as the client disconnects. Data sent by
+// the client in the meantime is retained (up to a limit) and replayed on subsequent reads.
+type dialWatch struct {
+	conn   net.Conn
+	cancel context.CancelFunc
+	done   chan struct{}
+	// err is the error that ended reading before the watch was stopped (set once done is closed)
+	err    error
+	replay io.Reader
+}
+
+// watchClientDuringDial starts watching a client connection. The returned context
+// is cancelled if the client closes the connection before the watch is stopped.
+func watchClientDuringDial(ctx context.Context, conn net.Conn, limit int) (context.Context, *dialWatch) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &dialWatch{conn: conn, cancel: cancel, done: make(chan struct{})}
+	go w.watch(limit)
+	return ctx, w
+}
+
+func (w *dialWatch) watch(limit int) {
+	defer close(w.done)
+	buf := make([]byte, limit)
+	n := 0
+	for n < len(buf) {
+		read, err := w.conn.Read(buf[n:])
+		n += read
+		if err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				w.err = err
+				w.cancel()
+			}
+			break
+		}
+	}
+	w.replay = io.MultiReader(bytes.NewReader(buf[:n]), w.conn)
+}
+
+// stop ends the watch once dialing is finished, returning the error that ended
+// reading if the client disconnected in the meantime
+func (w *dialWatch) stop() error {
+	w.conn.SetReadDeadline(time.Now())
+	<-w.done
+	w.conn.SetReadDeadline(time.Time{})
+	return w.err
+}
+
+// Read replays the data read while watching (only valid once the watch is stopped)
+func (w *dialWatch) Read(p []byte) (int, error) {
+	return w.replay.Read(p)
+}
@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialWatchReplay(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	ctx, w := watchClientDuringDial(context.Background(), server, 1024)
+	defer w.cancel()
+
+	// net.Pipe writes block until the data is read by the watch
+	client.Write([]byte("hello"))
+	assert.Nil(t, w.stop())
+	assert.Nil(t, ctx.Err())
+
+	go client.Write([]byte("world"))
+	res := make([]byte, 10)
+	_, err := io.ReadFull(w, res)
+	assert.Nil(t, err)
+	assert.Equal(t, "helloworld", string(res))
+}
+
+func TestDialWatchClientClosed(t *testing.T) {
+	client, server := net.Pipe()
+	ctx, w := watchClientDuringDial(context.Background(), server, 1024)
+	defer w.cancel()
+
+	client.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the dial context wasn't cancelled once the client disconnected")
+	}
+	assert.Equal(t, io.EOF, w.stop())
+}
+
+func TestSpeedbumpCancelDialOnClientClose(t *testing.T) {
+	backendConns := make(chan net.Conn, 1)
+	assert.Nil(t, startAcceptingSrv(9059, backendConns))
+
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8067,
+		DestAddr:   "localhost:9059",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "ERROR",
+		// the backend never completes the TLS handshake, so the dial hangs until the timeout
+		BackendTLS:              true,
+		DialTimeout:             time.Second * 10,
+		CancelDialOnClientClose: true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	client, err := net.Dial("tcp", "localhost:8067")
+	assert.Nil(t, err)
+	backendConn := <-backendConns
+	defer backendConn.Close()
+	client.Close()
+
+	// the nascent connection to the backend is closed right away
+	start := time.Now()
+	backendConn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = io.Copy(io.Discard, backendConn)
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Eventually(t, func() bool {
+		s.activeMu.Lock()
+		defer s.activeMu.Unlock()
+		return s.activeCount == 0
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, 0, s.Stats().DialTimeouts)
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// classifyMarker classifies buffers starting with RSP as responses
+func classifyMarker(b []byte) Direction {
+	if bytes.HasPrefix(b, []byte("RSP")) {
+		return ServerToClient
+	}
+	return ClientToServer
+}
+
+func TestLatencyGenFor(t *testing.T) {
+	request := &mockLatencyGenerator{time.Millisecond * 10}
+	response := &mockLatencyGenerator{time.Millisecond * 100}
+	c := &connection{latencyGen: request, returnLatencyGen: response}
+	// without a classifier, the physical direction counts
+	assert.Equal(t, request, c.latencyGenFor(ClientToServer, []byte("RSP")))
+	assert.Equal(t, response, c.latencyGenFor(ServerToClient, []byte("REQ")))
+
+	c.classifyDirection = classifyMarker
+	assert.Equal(t, response, c.latencyGenFor(ClientToServer, []byte("RSP")))
+	assert.Equal(t, request, c.latencyGenFor(ServerToClient, []byte("REQ")))
+
+	// responses aren't delayed without a return latency generator
+	c.returnLatencyGen = nil
+	assert.Equal(t, time.Duration(0), c.latencyGenFor(ClientToServer, []byte("RSP")).generateLatency(time.Now()))
+}
+
+func TestSpeedbumpDirectionClassifier(t *testing.T) {
+	go startEchoSrv(9076)
+	waitForListener("localhost:9076")
+
+	cfg := SpeedbumpCfg{
+		Port:                  8078,
+		DestAddr:              "localhost:9076",
+		BufferSize:            0xffff,
+		Latency:               &LatencyCfg{Base: time.Millisecond * 10},
+		ServerToClientLatency: &LatencyCfg{Base: time.Millisecond * 300},
+		LogLevel:              "ERROR",
+		DirectionClassifier:   classifyMarker,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8078")
+	assert.Nil(t, err)
+	defer conn.Close()
+	roundTrip := func(msg string) time.Duration {
+		start := time.Now()
+		conn.Write([]byte(msg))
+		res := make([]byte, 1024)
+		n, _ := conn.Read(res)
+		assert.Equal(t, msg, string(res[:n]))
+		return time.Since(start)
+	}
+	// the echoed request is delayed as a request both ways, and so is the echoed response
+	assert.Less(t, int64(roundTrip("REQ 1")), int64(time.Millisecond*300))
+	assert.GreaterOrEqual(t, int64(roundTrip("RSP 1")), int64(time.Millisecond*600))
+}
@@ -0,0 +1,60 @@
+package lib
+
+import "time"
+
+// drainReportInterval is how often the number of remaining connections is logged during Stop()
+const drainReportInterval = time.Second
+
+// connectionStarted keeps track of a proxy connection about to be started
+func (s *Speedbump) connectionStarted() {
+	s.active.Add(1)
+	s.activeMu.Lock()
+	s.activeCount++
+	s.activeMu.Unlock()
+}
+
+// connectionDone marks a proxy connection started with connectionStarted as closed
+func (s *Speedbump) connectionDone() {
+	s.activeMu.Lock()
+	s.activeCount--
+	s.activeMu.Unlock()
+	s.active.Done()
+}
+
+// DrainingCount returns the number of proxy connections that Stop() is still waiting for
+// to be closed (0 if the instance isn't stopping)
+func (s *Speedbump) DrainingCount() int {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	if !s.draining {
+		return 0
+	}
+	return s.activeCount
+}
+
+// drainConnections waits for active proxy connections to be closed,
+// periodically logging how many of them remain
+func (s *Speedbump) drainConnections() {
+	s.activeMu.Lock()
+	s.draining = true
+	s.activeMu.Unlock()
+	defer func() {
+		s.activeMu.Lock()
+		s.draining = false
+		s.activeMu.Unlock()
+	}()
+
+	drained := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(drained)
+	}()
+	for {
+		select {
+		case <-drained:
+			return
+		case <-s.clock.After(drainReportInterval):
+			s.log.Info("Waiting for active connections to be closed", "remaining", s.DrainingCount())
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpeedbumpDrainingCount(t *testing.T) {
+	go startEchoSrv(9047)
+	waitForListener("localhost:9047")
+
+	conns := 3
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8050,
+		DestAddr:   "localhost:9047",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "ERROR",
+		// each connection takes progressively longer to wrap up once closed
+		ConnTraceFunc: func(ctx context.Context, info ConnInfo) func(ConnSummary) {
+			return func(ConnSummary) {
+				time.Sleep(time.Millisecond * 100 * time.Duration(info.ID+1))
+			}
+		},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	res := make([]byte, 1024)
+	for i := 0; i < conns; i++ {
+		conn, err := net.Dial("tcp", "localhost:8050")
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.Write([]byte("test-string"))
+		_, err = conn.Read(res)
+		assert.Nil(t, err)
+	}
+	// the count is only reported while stopping
+	assert.Equal(t, 0, s.DrainingCount())
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+
+	var counts []int
+	for {
+		select {
+		case <-stopped:
+			assert.Equal(t, []int{3, 2, 1}, counts)
+			assert.Equal(t, 0, s.DrainingCount())
+			return
+		case <-time.After(time.Millisecond * 5):
+			if n := s.DrainingCount(); n > 0 && (len(counts) == 0 || counts[len(counts)-1] != n) {
+				counts = append(counts, n)
+			}
+		}
+	}
+}
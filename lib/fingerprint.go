@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// FingerprintFunc identifies the protocol of a connection based on the greeting
+// sent by its client (see FingerprintFunc in SpeedbumpCfg)
+type FingerprintFunc func(greeting []byte) string
+
+// FingerprintProtocol is a FingerprintFunc telling apart TLS ("tls"), HTTP/1.x ("http")
+// and SSH ("ssh") clients. Other greetings, including empty ones sent by clients of
+// server-speaks-first protocols, are not labeled.
+func FingerprintProtocol(greeting []byte) string {
+	switch {
+	case len(greeting) == 0:
+		return ""
+	case greeting[0] == tlsRecordTypeHandshake:
+		return "tls"
+	case bytes.HasPrefix(greeting, []byte("SSH-")):
+		return "ssh"
+	case looksLikeHTTP(greeting) && bytes.Contains(greeting, []byte(" HTTP/1.")):
+		return "http"
+	}
+	return ""
+}
+
+// readGreeting reads the bytes sent by the client up to the end of the first line,
+// stopping earlier once the preamble limits are reached. The bytes read are replayed
+// on subsequent reads of the returned connection. The client connection is closed
+// if the context gets cancelled.
+func readGreeting(ctx context.Context, conn net.Conn, limits preambleLimits) (*bufferedConn, []byte) {
+	read := make(chan struct{})
+	defer close(read)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-read:
+		}
+	}()
+	conn.SetReadDeadline(time.Now().Add(limits.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, limits.maxBytes)
+	n := 0
+	for n < len(buf) && bytes.IndexByte(buf[:n], '\n') == -1 {
+		read, err := conn.Read(buf[n:])
+		n += read
+		if err != nil {
+			break
+		}
+	}
+	replay := io.MultiReader(bytes.NewReader(buf[:n]), conn)
+	return &bufferedConn{conn, bufio.NewReader(replay)}, buf[:n]
+}
+
+// newFingerprintRoutes resolves the destinations of FingerprintRoutes by label
+func newFingerprintRoutes(routes map[string]string) (map[string]*net.TCPAddr, error) {
+	if len(routes) == 0 {
+		return nil, nil
+	}
+	resolved := make(map[string]*net.TCPAddr, len(routes))
+	for label, dest := range routes {
+		addr, err := net.ResolveTCPAddr("tcp", dest)
+		if err != nil {
+			return nil, fmt.Errorf("Error resolving fingerprint route %s destination address: %s", label, err)
+		}
+		resolved[label] = addr
+	}
+	return resolved, nil
+}
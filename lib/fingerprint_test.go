@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprintProtocol(t *testing.T) {
+	cases := map[string]string{
+		"GET / HTTP/1.1\r\n":       "http",
+		"POST /api HTTP/1.0\r\n":   "http",
+		"SSH-2.0-OpenSSH_9.0\r\n":  "ssh",
+		"\x16\x03\x01\x02\x00\x01": "tls",
+		"EHLO example.com\r\n":     "",
+		"GET the thing done\n":     "",
+		"":                         "",
+	}
+	for greeting, expected := range cases {
+		assert.Equal(t, expected, FingerprintProtocol([]byte(greeting)), greeting)
+	}
+}
+
+func TestSpeedbumpFingerprintRouting(t *testing.T) {
+	web := startNamedEchoSrv(t, "127.0.0.1:0", "web")
+	defer web.Close()
+	shell := startNamedEchoSrv(t, "127.0.0.1:0", "shell")
+	defer shell.Close()
+	def := startNamedEchoSrv(t, "127.0.0.1:0", "default")
+	defer def.Close()
+
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Host:            "127.0.0.1",
+		Port:            0,
+		DestAddr:        def.Addr().String(),
+		BufferSize:      0xffff,
+		Latency:         defaultLatencyCfg,
+		LogLevel:        "ERROR",
+		PreambleTimeout: time.Millisecond * 100,
+		FingerprintFunc: FingerprintProtocol,
+		FingerprintRoutes: map[string]string{
+			"http": web.Addr().String(),
+			"ssh":  shell.Addr().String(),
+		},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	send := func(greeting string) (string, []ConnStats) {
+		conn, err := net.Dial("tcp", s.dialAddr())
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.Write([]byte(greeting))
+		res := make([]byte, 1024)
+		n, err := conn.Read(res)
+		assert.Nil(t, err)
+		return string(res[:n]), s.ConnStats()
+	}
+
+	// greetings are replayed to the destination picked by their fingerprint
+	res, conns := send("GET / HTTP/1.1\r\n")
+	assert.Equal(t, "web:GET / HTTP/1.1\r\n", res)
+	assert.Equal(t, "http", conns[0].Fingerprint)
+	res, conns = send("SSH-2.0-test\r\n")
+	assert.Equal(t, "shell:SSH-2.0-test\r\n", res)
+	assert.Equal(t, "ssh", conns[0].Fingerprint)
+	// unlabeled connections are proxied to DestAddr
+	res, conns = send("HELO example.com\r\n")
+	assert.Equal(t, "default:HELO example.com\r\n", res)
+	assert.Equal(t, "", conns[0].Fingerprint)
+
+	// clients that wait for the server to speak first are proxied after the preamble timeout
+	conn, err := net.Dial("tcp", s.dialAddr())
+	assert.Nil(t, err)
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 200)
+	conn.Write([]byte("late"))
+	res2 := make([]byte, 1024)
+	n, err := conn.Read(res2)
+	assert.Nil(t, err)
+	assert.Equal(t, "default:late", string(res2[:n]))
+}
+
+func TestSpeedbumpFingerprintRouteError(t *testing.T) {
+	_, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:              8000,
+		DestAddr:          "localhost:1234",
+		BufferSize:        0xffff,
+		Latency:           defaultLatencyCfg,
+		LogLevel:          "ERROR",
+		FingerprintFunc:   FingerprintProtocol,
+		FingerprintRoutes: map[string]string{"http": "nope"},
+	})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error resolving fingerprint route http"))
+}
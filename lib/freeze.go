@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"context"
+	"sync"
+)
+
+// directionFreeze keeps track of directions of traffic that are frozen
+// across all proxy connections of a Speedbump instance
+type directionFreeze struct {
+	mu sync.Mutex
+	// thawed contains a channel for each frozen direction that gets closed once it's thawed
+	thawed map[Direction]chan struct{}
+}
+
+func newDirectionFreeze() *directionFreeze {
+	return &directionFreeze{thawed: make(map[Direction]chan struct{})}
+}
+
+func (f *directionFreeze) freeze(direction Direction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, frozen := f.thawed[direction]; !frozen {
+		f.thawed[direction] = make(chan struct{})
+	}
+}
+
+func (f *directionFreeze) thaw(direction Direction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if thawed, frozen := f.thawed[direction]; frozen {
+		close(thawed)
+		delete(f.thawed, direction)
+	}
+}
+
+// isFrozen reports whether the given direction is currently frozen
+func (f *directionFreeze) isFrozen(direction Direction) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, frozen := f.thawed[direction]
+	return frozen
+}
+
+// wait blocks for as long as the given direction is frozen or until the context is done
+func (f *directionFreeze) wait(ctx context.Context, direction Direction) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	thawed, frozen := f.thawed[direction]
+	f.mu.Unlock()
+	if frozen {
+		select {
+		case <-thawed:
+		case <-ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectionFreeze(t *testing.T) {
+	f := newDirectionFreeze()
+	f.freeze(ClientToServer)
+	// freezing an already frozen direction is a no-op
+	f.freeze(ClientToServer)
+
+	// the other direction is not frozen
+	f.wait(context.TODO(), ServerToClient)
+
+	thawed := make(chan time.Time)
+	go func() {
+		f.wait(context.TODO(), ClientToServer)
+		thawed <- time.Now()
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	thawedAt := time.Now()
+	f.thaw(ClientToServer)
+	assert.False(t, (<-thawed).Before(thawedAt))
+
+	// thawing a direction that is not frozen is a no-op
+	f.thaw(ServerToClient)
+	f.wait(context.TODO(), ClientToServer)
+}
+
+func TestDirectionFreezeCancelled(t *testing.T) {
+	f := newDirectionFreeze()
+	f.freeze(ServerToClient)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	f.wait(ctx, ServerToClient)
+	assert.NotNil(t, ctx.Err())
+
+	var nilFreeze *directionFreeze
+	nilFreeze.wait(context.TODO(), ServerToClient)
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// gaussianLatencySummand adds normally distributed jitter with a mean of 0,
+// which makes Base the mean of the resulting latency. With a correlation set,
+// the jitter follows an AR(1) process, in which each value is correlated with
+// the previous one by the given coefficient while the standard deviation of
+// the jitter stays the same. The process is tracked separately for each proxy connection.
+type gaussianLatencySummand struct {
+	stdDev      time.Duration
+	correlation float64
+	// mu guards rng and prev, as the summand may be called by both directions of a connection
+	// (and the rng of the summand shared by all connections seeds their processes)
+	mu  sync.Mutex
+	rng *rand.Rand
+	// prev is the previous jitter in units of stdDev (only used with a correlation)
+	prev float64
+}
+
+func newGaussianLatencySummand(stdDev time.Duration, correlation float64, seed int64) *gaussianLatencySummand {
+	if correlation < 0 || correlation >= 1 {
+		// out of range correlations of configs not validated by NewSpeedbump are ignored
+		correlation = 0
+	}
+	s := &gaussianLatencySummand{
+		stdDev:      stdDev,
+		correlation: correlation,
+		rng:         rand.New(rand.NewSource(seed)),
+	}
+	if correlation > 0 {
+		// the process starts in its stationary distribution
+		s.prev = s.rng.NormFloat64()
+	}
+	return s
+}
+
+func (s *gaussianLatencySummand) getLatency(elapsed time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	z := s.rng.NormFloat64()
+	if s.correlation > 0 {
+		z = s.correlation*s.prev + math.Sqrt(1-s.correlation*s.correlation)*z
+		s.prev = z
+	}
+	return time.Duration(z * float64(s.stdDev))
+}
+
+// forConnection returns a process of correlated jitter for a single proxy connection,
+// seeded by the summand shared by all connections (uncorrelated jitter has no state to keep)
+func (s *gaussianLatencySummand) forConnection() latencySummand {
+	if s.correlation == 0 {
+		return s
+	}
+	s.mu.Lock()
+	seed := s.rng.Int63()
+	s.mu.Unlock()
+	return newGaussianLatencySummand(s.stdDev, s.correlation, seed)
+}
@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGaussianLatencySummand(t *testing.T) {
+	s := newGaussianLatencySummand(time.Millisecond*10, 0, 1)
+	samples := 10000
+	var sum, sumSq float64
+	for i := 0; i < samples; i++ {
+		l := float64(s.getLatency(0))
+		sum += l
+		sumSq += l * l
+	}
+	mean := sum / float64(samples)
+	stdDev := math.Sqrt(sumSq/float64(samples) - mean*mean)
+	assert.InDelta(t, 0, mean, float64(time.Millisecond)*0.5)
+	assert.InDelta(t, float64(time.Millisecond*10), stdDev, float64(time.Millisecond)*0.5)
+}
+
+// lagOneAutocorrelation returns the correlation of consecutive samples
+func lagOneAutocorrelation(samples []float64) float64 {
+	var mean float64
+	for _, x := range samples {
+		mean += x
+	}
+	mean /= float64(len(samples))
+	var cov, variance float64
+	for i, x := range samples {
+		variance += (x - mean) * (x - mean)
+		if i > 0 {
+			cov += (x - mean) * (samples[i-1] - mean)
+		}
+	}
+	return cov / variance
+}
+
+func TestGaussianLatencySummandCorrelation(t *testing.T) {
+	for _, correlation := range []float64{0, 0.5, 0.9} {
+		s := newGaussianLatencySummand(time.Millisecond*10, correlation, 1)
+		samples := make([]float64, 50000)
+		for i := range samples {
+			samples[i] = float64(s.getLatency(0))
+		}
+		assert.InDelta(t, correlation, lagOneAutocorrelation(samples), 0.03, "correlation %v", correlation)
+
+		// the standard deviation is the same regardless of the correlation
+		var sumSq float64
+		for _, l := range samples {
+			sumSq += l * l
+		}
+		stdDev := math.Sqrt(sumSq / float64(len(samples)))
+		assert.InDelta(t, float64(time.Millisecond*10), stdDev, float64(time.Millisecond)*0.5, "correlation %v", correlation)
+	}
+}
+
+func TestGaussianLatencySummandPerConnection(t *testing.T) {
+	shared := newGaussianLatencySummand(time.Millisecond*10, 0.9, 1)
+	a, b := shared.forConnection(), shared.forConnection()
+	alone := newGaussianLatencySummand(time.Millisecond*10, 0.9, 1).forConnection()
+
+	// the jitter of a connection isn't affected by the buffers of the other ones
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, alone.getLatency(0), a.getLatency(0))
+		b.getLatency(0)
+	}
+
+	uncorrelated := newGaussianLatencySummand(time.Millisecond*10, 0, 1)
+	assert.Same(t, uncorrelated, uncorrelated.forConnection())
+}
+
+func TestSimpleLatencyGeneratorWithGaussian(t *testing.T) {
+	start := time.Now()
+	cfg := &LatencyCfg{
+		Base:           time.Millisecond * 5,
+		GaussianStdDev: time.Millisecond * 10,
+		Seed:           1,
+	}
+	g := newSimpleLatencyGenerator(start, cfg)
+	other := newSimpleLatencyGenerator(start, cfg)
+
+	varied := false
+	for i := 0; i < 1000; i++ {
+		l := g.generateLatency(start)
+		// the same seed yields the same sequence
+		assert.Equal(t, l, other.generateLatency(start))
+		// latency is never negative, even though the jitter often exceeds the base
+		assert.GreaterOrEqual(t, l, time.Duration(0))
+		varied = varied || l != time.Millisecond*5
+	}
+	assert.True(t, varied)
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// happyEyeballsDelay is the delay between consecutive connection attempts,
+// following the recommendation of RFC 8305
+const happyEyeballsDelay = time.Millisecond * 250
+
+// dialHappyEyeballs resolves a host:port address and dials all of its IP addresses,
+// starting a new attempt every delay (or as soon as the previous one fails) without
+// aborting the ongoing ones. The first connection established is used.
+func dialHappyEyeballs(ctx context.Context, dialer *net.Dialer, addr string, delay time.Duration, clock Clock) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return dialStaggered(ctx, dialer, interleaveFamilies(ips, port), delay, clock)
+}
+
+// interleaveFamilies orders resolved addresses so that IPv6 and IPv4 ones alternate,
+// starting with the family of the first address
+func interleaveFamilies(ips []net.IPAddr, port string) []string {
+	var first, second []string
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip.String(), port)
+		if (ip.IP.To4() == nil) == (ips[0].IP.To4() == nil) {
+			first = append(first, addr)
+		} else {
+			second = append(second, addr)
+		}
+	}
+	addrs := make([]string, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			addrs = append(addrs, first[i])
+		}
+		if i < len(second) {
+			addrs = append(addrs, second[i])
+		}
+	}
+	return addrs
+}
+
+// dialStaggered dials the given addresses in order, starting a new attempt every delay
+// or as soon as the previous one fails. Once a connection is established, the remaining
+// attempts are aborted and connections established by them in the meantime are closed.
+// The delay is measured by clock, while the attempts themselves time out in real time.
+func dialStaggered(ctx context.Context, dialer *net.Dialer, addrs []string, delay time.Duration, clock Clock) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses to dial")
+	}
+	clock = clockOrReal(clock)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	started, failed := 0, 0
+	nextAt := clock.Now()
+	var lastErr error
+	for {
+		var next <-chan time.Time
+		if started < len(addrs) {
+			next = clock.After(nextAt.Sub(clock.Now()))
+		}
+		select {
+		case <-next:
+			go func(addr string) {
+				conn, err := dialer.DialContext(ctx, "tcp", addr)
+				results <- result{conn, err}
+			}(addrs[started])
+			started++
+			nextAt = clock.Now().Add(delay)
+		case r := <-results:
+			if r.err == nil {
+				go func(pending int) {
+					for i := 0; i < pending; i++ {
+						if late := <-results; late.err == nil {
+							late.conn.Close()
+						}
+					}
+				}(started - failed - 1)
+				return r.conn, nil
+			}
+			failed++
+			lastErr = r.err
+			if failed == len(addrs) {
+				return nil, lastErr
+			}
+			if failed == started {
+				// no attempt is ongoing, so the next one is started right away
+				nextAt = clock.Now()
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	ips := []net.IPAddr{
+		{IP: net.ParseIP("::1")},
+		{IP: net.ParseIP("::2")},
+		{IP: net.ParseIP("::3")},
+		{IP: net.ParseIP("127.0.0.1")},
+	}
+	assert.Equal(t, []string{"[::1]:80", "127.0.0.1:80", "[::2]:80", "[::3]:80"}, interleaveFamilies(ips, "80"))
+}
+
+func TestDialStaggeredFirstRefused(t *testing.T) {
+	refused, _ := net.Listen("tcp", "127.0.0.1:0")
+	refusedAddr := refused.Addr().String()
+	refused.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	start := time.Now()
+	conn, err := dialStaggered(context.Background(), &net.Dialer{}, []string{refusedAddr, l.Addr().String()}, time.Second, nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+	// the next attempt starts as soon as the previous one fails
+	assert.Less(t, int64(time.Since(start)), int64(time.Millisecond*500))
+}
+
+func TestDialStaggeredAllFail(t *testing.T) {
+	refused, _ := net.Listen("tcp", "127.0.0.1:0")
+	refusedAddr := refused.Addr().String()
+	refused.Close()
+
+	_, err := dialStaggered(context.Background(), &net.Dialer{}, []string{refusedAddr, refusedAddr}, time.Millisecond*10, nil)
+	assert.ErrorContains(t, err, "connection refused")
+
+	_, err = dialStaggered(context.Background(), &net.Dialer{}, nil, time.Millisecond*10, nil)
+	assert.EqualError(t, err, "no addresses to dial")
+}
+
+func TestDialHappyEyeballs(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	conn, err := dialHappyEyeballs(context.Background(), &net.Dialer{}, net.JoinHostPort("localhost", port), time.Millisecond*10, nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+}
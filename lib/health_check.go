@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// DestHealthCheckCfg configures health checking of the addresses the proxy destination's
+// host resolves to (see SpeedbumpCfg.DestHealthCheck)
+type DestHealthCheckCfg struct {
+	// Interval is the period of time between health checks, before each of which
+	// the proxy destination's host is re-resolved
+	Interval time.Duration `json:"interval" yaml:"interval"`
+	// Timeout limits the time within which connecting to an address has to succeed
+	// for it to be considered healthy (defaults to Interval)
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+// destHealth maintains the set of healthy addresses of the proxy destination,
+// from which the addresses of new connections are picked in turns
+type destHealth struct {
+	interval, timeout time.Duration
+	// lookup resolves the proxy destination's host
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+	// mu guards dest, healthy and next
+	mu sync.Mutex
+	// dest is the proxy destination (as configured) whose addresses were checked
+	dest    string
+	healthy []*net.TCPAddr
+	next    int
+}
+
+func newDestHealth(cfg *DestHealthCheckCfg) (*destHealth, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("Error configuring destination health checks: interval must be positive")
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = cfg.Interval
+	}
+	return &destHealth{
+		interval: cfg.Interval,
+		timeout:  timeout,
+		lookup:   net.DefaultResolver.LookupIPAddr,
+	}, nil
+}
+
+// check resolves dest and connects to each of its addresses concurrently,
+// replacing the healthy set with the addresses that accepted the connection
+func (h *destHealth) check(ctx context.Context, dest string, l hclog.Logger) {
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		l.Warn("Health checking proxy destination failed", "dest", dest, "err", err)
+		return
+	}
+	ips, err := h.lookup(ctx, host)
+	if err != nil {
+		l.Warn("Resolving proxy destination for health checks failed", "dest", dest, "err", err)
+		return
+	}
+	healthy := make([]bool, len(ips))
+	var wg sync.WaitGroup
+	dialer := &net.Dialer{Timeout: h.timeout}
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				l.Debug("Proxy destination address failed health check", "addr", addr, "err", err)
+				return
+			}
+			conn.Close()
+			healthy[i] = true
+		}(i, net.JoinHostPort(ip.String(), port))
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	var addrs []*net.TCPAddr
+	for i, ip := range ips {
+		if healthy[i] {
+			addr, _ := net.ResolveTCPAddr("tcp", net.JoinHostPort(ip.String(), port))
+			addrs = append(addrs, addr)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !sameAddrs(addrs, h.healthy) || h.dest != dest {
+		l.Info("Healthy proxy destination addresses changed", "dest", dest, "healthy", len(addrs), "resolved", len(ips))
+	}
+	h.dest = dest
+	h.healthy = addrs
+}
+
+// sameAddrs reports whether a and b contain the same addresses, regardless of their order
+func sameAddrs(a, b []*net.TCPAddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]bool, len(a))
+	for _, addr := range a {
+		set[addr.String()] = true
+	}
+	for _, addr := range b {
+		if !set[addr.String()] {
+			return false
+		}
+	}
+	return true
+}
+
+// pick returns the next healthy address of dest,
+// or false if none of its addresses are known to be healthy
+func (h *destHealth) pick(dest string) (*net.TCPAddr, bool) {
+	if h == nil {
+		return nil, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.dest != dest || len(h.healthy) == 0 {
+		return nil, false
+	}
+	addr := h.healthy[h.next%len(h.healthy)]
+	h.next++
+	return addr, true
+}
+
+// addrs returns the healthy addresses of the proxy destination
+func (h *destHealth) addrs() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	addrs := make([]string, len(h.healthy))
+	for i, addr := range h.healthy {
+		addrs[i] = addr.String()
+	}
+	return addrs
+}
+
+// runHealthChecks checks the health of the proxy destination's addresses every interval
+// until the instance is stopped. The current proxy destination is looked up before each
+// check, so that it follows SetDestination.
+func (s *Speedbump) runHealthChecks() {
+	for {
+		select {
+		case <-s.clock.After(s.destHealth.interval):
+		case <-s.ctx.Done():
+			return
+		}
+		_, dest := s.destination()
+		s.destHealth.check(s.ctx, dest, s.log)
+	}
+}
+
+// HealthyDestinations returns the addresses of the proxy destination that passed
+// the last health check (nil if DestHealthCheck is not configured)
+func (s *Speedbump) HealthyDestinations() []string {
+	if s.destHealth == nil {
+		return nil
+	}
+	return s.destHealth.addrs()
+}
@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDestHealthInvalidInterval(t *testing.T) {
+	_, err := newDestHealth(&DestHealthCheckCfg{})
+	assert.EqualError(t, err, "Error configuring destination health checks: interval must be positive")
+}
+
+func TestSpeedbumpDestHealthCheck(t *testing.T) {
+	first := startNamedEchoSrv(t, "127.0.0.1:0", "first")
+	defer first.Close()
+	// both backends listen on the same port, as the destination's addresses share it
+	port := strconv.Itoa(first.Addr().(*net.TCPAddr).Port)
+	second := startNamedEchoSrv(t, net.JoinHostPort("127.0.0.2", port), "second")
+	defer second.Close()
+
+	cfg := SpeedbumpCfg{
+		Host:            "127.0.0.1",
+		Port:            0,
+		DestAddr:        net.JoinHostPort("localhost", port),
+		BufferSize:      0xffff,
+		Latency:         defaultLatencyCfg,
+		LogLevel:        "ERROR",
+		DestHealthCheck: &DestHealthCheckCfg{Interval: time.Millisecond * 50},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	// the destination resolves to both backends
+	s.destHealth.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}, {IP: net.ParseIP("127.0.0.2")}}, nil
+	}
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+	assert.ElementsMatch(t, []string{first.Addr().String(), second.Addr().String()}, s.HealthyDestinations())
+
+	greetings := func(n int) map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < n; i++ {
+			conn, err := net.Dial("tcp", s.dialAddr())
+			assert.Nil(t, err)
+			counts[strings.TrimSuffix(roundTrip(t, conn), ":ping")]++
+			conn.Close()
+		}
+		return counts
+	}
+	// connections are spread across healthy backends
+	assert.Equal(t, map[string]int{"first": 2, "second": 2}, greetings(4))
+
+	second.Close()
+	assert.Eventually(t, func() bool {
+		return len(s.HealthyDestinations()) == 1
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, []string{first.Addr().String()}, s.HealthyDestinations())
+	assert.Equal(t, map[string]int{"first": 4}, greetings(4))
+}
+
+func TestDestHealthCheckReportsChangedSet(t *testing.T) {
+	buf := &syncBuffer{}
+	l := hclog.New(&hclog.LoggerOptions{Output: buf, Level: hclog.Info})
+	first := startNamedEchoSrv(t, "127.0.0.1:0", "first")
+	port := strconv.Itoa(first.Addr().(*net.TCPAddr).Port)
+	dest := net.JoinHostPort("localhost", port)
+
+	h, err := newDestHealth(&DestHealthCheckCfg{Interval: time.Second})
+	assert.Nil(t, err)
+	h.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}, {IP: net.ParseIP("127.0.0.2")}}, nil
+	}
+	h.check(context.Background(), dest, l)
+	assert.Equal(t, []string{first.Addr().String()}, h.addrs())
+	h.check(context.Background(), dest, l)
+	assert.Len(t, buf.lines(), 1)
+
+	// one address goes down while the other one comes back
+	first.Close()
+	second := startNamedEchoSrv(t, net.JoinHostPort("127.0.0.2", port), "second")
+	defer second.Close()
+	h.check(context.Background(), dest, l)
+	assert.Equal(t, []string{second.Addr().String()}, h.addrs())
+	assert.Len(t, buf.lines(), 2)
+	assert.Contains(t, buf.lines()[1], "Healthy proxy destination addresses changed")
+}
+
+func TestSameAddrs(t *testing.T) {
+	a, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:80")
+	b, _ := net.ResolveTCPAddr("tcp", "127.0.0.2:80")
+	assert.True(t, sameAddrs(nil, nil))
+	assert.True(t, sameAddrs([]*net.TCPAddr{a, b}, []*net.TCPAddr{b, a}))
+	assert.False(t, sameAddrs([]*net.TCPAddr{a}, []*net.TCPAddr{b}))
+	assert.False(t, sameAddrs([]*net.TCPAddr{a}, []*net.TCPAddr{a, b}))
+}
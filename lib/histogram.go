@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// histogramSubBuckets is the number of linear sub-buckets each power of two range
+// of microseconds is divided into, which bounds the relative error of percentiles to ~6%
+const histogramSubBuckets = 16
+
+// DurationStats summarizes a distribution of durations
+type DurationStats struct {
+	// Count is the number of recorded durations
+	Count int `json:"count"`
+	// P50, P90 and P99 are approximate percentiles of the recorded durations
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	// Max is the longest recorded duration
+	Max time.Duration `json:"max"`
+}
+
+// durationHistogram aggregates durations into exponentially sized buckets
+type durationHistogram struct {
+	counts map[int]int
+	count  int
+	max    time.Duration
+}
+
+func newDurationHistogram() *durationHistogram {
+	return &durationHistogram{counts: make(map[int]int)}
+}
+
+func histogramBucket(d time.Duration) int {
+	v := uint64(d / time.Microsecond)
+	if v < 2*histogramSubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 5
+	return exp*histogramSubBuckets + int(v>>uint(exp))
+}
+
+// histogramBucketMax returns the upper bound of a bucket's range
+func histogramBucketMax(bucket int) time.Duration {
+	if bucket < 2*histogramSubBuckets {
+		return time.Duration(bucket) * time.Microsecond
+	}
+	exp := bucket/histogramSubBuckets - 1
+	mantissa := uint64(bucket - exp*histogramSubBuckets)
+	return time.Duration(((mantissa+1)<<uint(exp))-1) * time.Microsecond
+}
+
+func (h *durationHistogram) record(d time.Duration) {
+	h.counts[histogramBucket(d)]++
+	h.count++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// percentile returns the approximate duration below which a given fraction of durations fall
+func (h *durationHistogram) percentile(buckets []int, p float64) time.Duration {
+	threshold := int(p*float64(h.count) + 0.5)
+	if threshold < 1 {
+		threshold = 1
+	}
+	seen := 0
+	for _, bucket := range buckets {
+		seen += h.counts[bucket]
+		if seen >= threshold {
+			if d := histogramBucketMax(bucket); d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// merge adds the durations recorded by other (which may be nil)
+func (h *durationHistogram) merge(other *durationHistogram) {
+	if other == nil {
+		return
+	}
+	for bucket, n := range other.counts {
+		h.counts[bucket] += n
+	}
+	h.count += other.count
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+func (h *durationHistogram) stats() DurationStats {
+	if h == nil || h.count == 0 {
+		return DurationStats{}
+	}
+	buckets := make([]int, 0, len(h.counts))
+	for bucket := range h.counts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+	return DurationStats{
+		Count: h.count,
+		P50:   h.percentile(buckets, 0.5),
+		P90:   h.percentile(buckets, 0.9),
+		P99:   h.percentile(buckets, 0.99),
+		Max:   h.max,
+	}
+}
@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	prev := -1
+	for _, d := range []time.Duration{0, time.Microsecond * 31, time.Microsecond * 32, time.Millisecond, time.Second, time.Hour} {
+		bucket := histogramBucket(d)
+		assert.Greater(t, bucket, prev)
+		// each duration falls within its bucket's range with a bounded error
+		max := histogramBucketMax(bucket)
+		assert.GreaterOrEqual(t, int64(max), int64(d/time.Microsecond*time.Microsecond))
+		assert.True(t, float64(max-d) <= float64(d)/histogramSubBuckets+float64(time.Microsecond), d)
+		prev = bucket
+	}
+}
+
+func TestDurationHistogramStats(t *testing.T) {
+	h := newDurationHistogram()
+	assert.Equal(t, DurationStats{}, h.stats())
+
+	for i := 1; i <= 100; i++ {
+		h.record(time.Millisecond * time.Duration(i))
+	}
+	stats := h.stats()
+
+	assert.Equal(t, 100, stats.Count)
+	assert.True(t, isDurationCloseTo(time.Millisecond*50, stats.P50, 7), stats.P50)
+	assert.True(t, isDurationCloseTo(time.Millisecond*90, stats.P90, 7), stats.P90)
+	assert.True(t, isDurationCloseTo(time.Millisecond*99, stats.P99, 7), stats.P99)
+	assert.Equal(t, time.Millisecond*100, stats.Max)
+}
@@ -0,0 +1,59 @@
+package lib
+
+import "time"
+
+// IdleLatencyCfg describes an additional delay added to a buffer read from the client
+// that grows with how long the connection was idle before it, which simulates a cold
+// cache or connection pool adding a penalty to the first request after a pause.
+// Idle time is measured since the previous buffer read from the client within the
+// same proxy connection, so the penalty resets with each buffer.
+type IdleLatencyCfg struct {
+	// Ratio is the delay added per unit of idle time exceeding Threshold (i.e. 0.1 adds
+	// 100ms to a buffer read after the connection was idle for 1s past the threshold)
+	Ratio float64 `json:"ratio" yaml:"ratio"`
+	// Threshold is the idle time below which no delay is added
+	Threshold time.Duration `json:"threshold" yaml:"threshold"`
+	// Max caps the additional delay (no cap if unspecified)
+	Max time.Duration `json:"max" yaml:"max"`
+}
+
+// idleLatency keeps track of the time of the last buffer read from the client
+// within a single proxy connection
+type idleLatency struct {
+	ratio     float64
+	threshold time.Duration
+	max       time.Duration
+	last      time.Time
+}
+
+func newIdleLatency(cfg *IdleLatencyCfg) *idleLatency {
+	if cfg == nil || cfg.Ratio <= 0 {
+		return nil
+	}
+	return &idleLatency{
+		ratio:     cfg.Ratio,
+		threshold: cfg.Threshold,
+		max:       cfg.Max,
+	}
+}
+
+// next returns the additional delay of a buffer read from the client at a given
+// point in time (no delay is added to the first buffer of a connection)
+func (l *idleLatency) next(receivedAt time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+	idle := time.Duration(0)
+	if !l.last.IsZero() {
+		idle = receivedAt.Sub(l.last)
+	}
+	l.last = receivedAt
+	if idle <= l.threshold {
+		return 0
+	}
+	d := time.Duration(float64(idle-l.threshold) * l.ratio)
+	if l.max > 0 && d > l.max {
+		d = l.max
+	}
+	return d
+}
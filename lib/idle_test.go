@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewIdleLatencyDisabled(t *testing.T) {
+	assert.Nil(t, newIdleLatency(nil))
+	assert.Nil(t, newIdleLatency(&IdleLatencyCfg{Max: time.Second}))
+
+	var l *idleLatency
+	assert.Equal(t, time.Duration(0), l.next(time.Now()))
+}
+
+func TestIdleLatencyNext(t *testing.T) {
+	l := newIdleLatency(&IdleLatencyCfg{
+		Ratio:     0.1,
+		Threshold: time.Millisecond * 100,
+		Max:       time.Millisecond * 500,
+	})
+	start := time.Now()
+
+	// a burst of buffers gets no extra delay
+	assert.Equal(t, time.Duration(0), l.next(start))
+	assert.Equal(t, time.Duration(0), l.next(start.Add(time.Millisecond*10)))
+	assert.Equal(t, time.Duration(0), l.next(start.Add(time.Millisecond*20)))
+
+	// the first buffer after an idle second is delayed proportionally to the idle time past the threshold
+	afterIdle := start.Add(time.Millisecond * 1020)
+	assert.Equal(t, time.Millisecond*90, l.next(afterIdle))
+	// while the buffers following it aren't
+	assert.Equal(t, time.Duration(0), l.next(afterIdle.Add(time.Millisecond*10)))
+
+	// the delay is capped
+	assert.Equal(t, time.Millisecond*500, l.next(afterIdle.Add(time.Minute)))
+}
+
+// pausingConn returns a payload on each read, pausing before the reads
+// listed in pauses, and EOF once all payloads were read
+type pausingConn struct {
+	reads  int
+	limit  int
+	pauses map[int]time.Duration
+}
+
+func (p *pausingConn) Read(b []byte) (int, error) {
+	if p.reads == p.limit {
+		return 0, io.EOF
+	}
+	time.Sleep(p.pauses[p.reads])
+	p.reads++
+	return copy(b, "data"), nil
+}
+
+func (p *pausingConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (p *pausingConn) Close() error {
+	return nil
+}
+
+func TestReadFromSrcIdleLatency(t *testing.T) {
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+	c := &connection{
+		// a burst of 3 buffers followed by 300ms of idle time and another burst
+		srcConn:    &pausingConn{limit: 6, pauses: map[int]time.Duration{3: time.Millisecond * 300}},
+		bufferSize: 20,
+		latencyGen: &mockLatencyGenerator{time.Millisecond * 10},
+		idle:       newIdleLatency(&IdleLatencyCfg{Ratio: 0.5, Threshold: time.Millisecond * 100}),
+		delayQueue: delayQueue,
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+
+	c.readFromSrc()
+	<-done
+
+	delays := make([]time.Time, 6)
+	for i := range delays {
+		delays[i] = (<-delayQueue).delayUntil
+	}
+	// buffers within bursts get no extra delay
+	assert.Less(t, int64(delays[2].Sub(delays[0])), int64(time.Millisecond*5))
+	// the first buffer after the idle period gets 100ms on top of the 300ms spent idle
+	assert.True(t, isDurationCloseTo(time.Millisecond*400, delays[3].Sub(delays[2]), 10), delays[3].Sub(delays[2]))
+	// while the next one doesn't, which makes it due before the penalized one
+	assert.InDelta(t, -float64(time.Millisecond*100), float64(delays[4].Sub(delays[3])), float64(time.Millisecond*10))
+	assert.Less(t, int64(delays[5].Sub(delays[4])), int64(time.Millisecond*5))
+}
@@ -0,0 +1,86 @@
+package lib
+
+import "time"
+
+// injectedLatencyWindow is the period over which the latency injected into
+// each connection is averaged by AverageInjectedLatency
+const injectedLatencyWindow = time.Second * 10
+
+// latencyWindow averages latencies over the current window and the one before it,
+// so that the average reflects the last one to two windows without storing samples
+type latencyWindow struct {
+	start            time.Time
+	sum, prevSum     time.Duration
+	count, prevCount int
+}
+
+// roll starts a new window if the current one ended by now
+func (w *latencyWindow) roll(now time.Time) {
+	if now.Before(w.start.Add(injectedLatencyWindow)) {
+		return
+	}
+	if now.Before(w.start.Add(2 * injectedLatencyWindow)) {
+		w.prevSum, w.prevCount = w.sum, w.count
+	} else {
+		w.prevSum, w.prevCount = 0, 0
+	}
+	w.sum, w.count = 0, 0
+	w.start = now
+}
+
+func (w *latencyWindow) record(now time.Time, d time.Duration) {
+	w.roll(now)
+	w.sum += d
+	w.count++
+}
+
+// average returns the average of the latencies recorded recently,
+// or false if there are none
+func (w *latencyWindow) average(now time.Time) (time.Duration, bool) {
+	w.roll(now)
+	if w.count+w.prevCount == 0 {
+		return 0, false
+	}
+	return (w.sum + w.prevSum) / time.Duration(w.count+w.prevCount), true
+}
+
+// addInjectedLatency records the latency generated for a buffer read at a given point in time
+func (cc *connCounters) addInjectedLatency(d time.Duration, receivedAt time.Time) {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.injected.record(receivedAt, d)
+}
+
+// recentInjectedLatency returns the average latency generated for buffers read recently,
+// or false if none were read
+func (cc *connCounters) recentInjectedLatency(now time.Time) (time.Duration, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.injected.average(now)
+}
+
+// AverageInjectedLatency returns the average latency currently being injected across
+// all active proxy connections: the latency generated for buffers flowing in either
+// direction is averaged per connection over the last 10-20 seconds, and the result
+// is the mean of these averages. Connections that haven't transferred any data
+// recently are not taken into account, while 0 is returned if there are none.
+func (s *Speedbump) AverageInjectedLatency() time.Duration {
+	now := s.clock.Now()
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	var sum time.Duration
+	n := 0
+	for _, c := range s.conns {
+		if avg, ok := c.counters.recentInjectedLatency(now); ok {
+			sum += avg
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / time.Duration(n)
+}
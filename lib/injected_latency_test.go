@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyWindow(t *testing.T) {
+	start := time.Now()
+	w := latencyWindow{start: start}
+	_, ok := w.average(start)
+	assert.False(t, ok)
+
+	w.record(start, time.Millisecond*10)
+	w.record(start.Add(time.Second), time.Millisecond*30)
+	avg, ok := w.average(start.Add(time.Second * 2))
+	assert.True(t, ok)
+	assert.Equal(t, time.Millisecond*20, avg)
+
+	// the previous window still counts after a new one starts
+	w.record(start.Add(injectedLatencyWindow), time.Millisecond*50)
+	avg, _ = w.average(start.Add(injectedLatencyWindow + time.Second))
+	assert.Equal(t, time.Millisecond*30, avg)
+
+	// latencies recorded more than two windows ago are dropped
+	_, ok = w.average(start.Add(injectedLatencyWindow * 3))
+	assert.False(t, ok)
+}
+
+func TestSpeedbumpAverageInjectedLatency(t *testing.T) {
+	go startEchoSrv(9071)
+	waitForListener("localhost:9071")
+
+	cfg := SpeedbumpCfg{
+		Port:       8073,
+		DestAddr:   "localhost:9071",
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{},
+		LogLevel:   "ERROR",
+		LatencyProfiles: map[string]LatencyCfg{
+			"fast": {Base: time.Millisecond * 10},
+			"slow": {Base: time.Millisecond * 50},
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	assert.Equal(t, time.Duration(0), s.AverageInjectedLatency())
+
+	for _, profile := range []string{"fast", "slow"} {
+		conn, err := net.Dial("tcp", "localhost:8073")
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.Write([]byte(profile + "\n"))
+		conn.Write([]byte("test-string"))
+		res := make([]byte, 1024)
+		conn.Read(res)
+	}
+
+	assert.Equal(t, time.Millisecond*30, s.AverageInjectedLatency())
+	assert.Equal(t, time.Millisecond*30, s.Stats().AverageInjectedLatency)
+}
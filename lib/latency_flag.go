@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"reflect"
+	"time"
+)
+
+// defaultLatencyFlagInterval is used if LatencyFlagInterval is unspecified
+const defaultLatencyFlagInterval = time.Second
+
+// latencyFlag keeps track of the latency config returned by LatencyFlagProvider
+type latencyFlag struct {
+	provider func() (*LatencyCfg, bool)
+	interval time.Duration
+	// fallback is the latency config applied while the flag is off (Latency as configured)
+	fallback *LatencyCfg
+	// applied is the latency config returned by the provider as of the last poll
+	// (nil while the flag is off)
+	applied *LatencyCfg
+}
+
+func newLatencyFlag(cfg *SpeedbumpCfg) *latencyFlag {
+	if cfg.LatencyFlagProvider == nil {
+		return nil
+	}
+	interval := cfg.LatencyFlagInterval
+	if interval <= 0 {
+		interval = defaultLatencyFlagInterval
+	}
+	var fallback *LatencyCfg
+	if cfg.Latency != nil {
+		copied := *cfg.Latency
+		fallback = &copied
+	}
+	return &latencyFlag{
+		provider: cfg.LatencyFlagProvider,
+		interval: interval,
+		fallback: fallback,
+	}
+}
+
+// poll consults the provider, returning the latency config of new connections
+// and true if the flag changed since the previous poll
+func (f *latencyFlag) poll() (*LatencyCfg, bool) {
+	latency, on := f.provider()
+	if !on {
+		latency = nil
+	}
+	if reflect.DeepEqual(latency, f.applied) {
+		return nil, false
+	}
+	if latency == nil {
+		f.applied = nil
+		return f.fallback, true
+	}
+	copied := *latency
+	f.applied = &copied
+	return f.applied, true
+}
+
+// pollLatencyFlag arms the latency config returned by LatencyFlagProvider
+// if it changed since the previous poll
+func (s *Speedbump) pollLatencyFlag() {
+	latency, changed := s.latencyFlag.poll()
+	if !changed {
+		return
+	}
+	s.log.Info("Latency flag changed", "on", s.latencyFlag.applied != nil)
+	s.ArmLatency(latency)
+}
+
+// runLatencyFlag polls LatencyFlagProvider until the instance is stopped
+func (s *Speedbump) runLatencyFlag() {
+	for {
+		select {
+		case <-s.clock.After(s.latencyFlag.interval):
+		case <-s.ctx.Done():
+			return
+		}
+		s.pollLatencyFlag()
+	}
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockLatencyFlag is a feature flag toggled by a test
+type mockLatencyFlag struct {
+	mu      sync.Mutex
+	latency *LatencyCfg
+	on      bool
+	polls   int
+}
+
+func (f *mockLatencyFlag) set(latency *LatencyCfg, on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency, f.on = latency, on
+}
+
+func (f *mockLatencyFlag) provide() (*LatencyCfg, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.polls++
+	return f.latency, f.on
+}
+
+func TestLatencyFlagPoll(t *testing.T) {
+	flag := &mockLatencyFlag{}
+	f := newLatencyFlag(&SpeedbumpCfg{
+		Latency:             &LatencyCfg{Base: time.Millisecond * 10},
+		LatencyFlagProvider: flag.provide,
+	})
+	assert.Equal(t, defaultLatencyFlagInterval, f.interval)
+
+	// nothing changes while the flag stays off
+	_, changed := f.poll()
+	assert.False(t, changed)
+
+	flag.set(&LatencyCfg{Base: time.Millisecond * 200}, true)
+	latency, changed := f.poll()
+	assert.True(t, changed)
+	assert.Equal(t, &LatencyCfg{Base: time.Millisecond * 200}, latency)
+	_, changed = f.poll()
+	assert.False(t, changed)
+
+	flag.set(&LatencyCfg{Base: time.Millisecond * 300}, true)
+	latency, changed = f.poll()
+	assert.True(t, changed)
+	assert.Equal(t, &LatencyCfg{Base: time.Millisecond * 300}, latency)
+
+	// the configured latency is restored once the flag is off, regardless of the config
+	flag.set(&LatencyCfg{Base: time.Millisecond * 300}, false)
+	latency, changed = f.poll()
+	assert.True(t, changed)
+	assert.Equal(t, &LatencyCfg{Base: time.Millisecond * 10}, latency)
+
+	assert.Nil(t, newLatencyFlag(&SpeedbumpCfg{}))
+}
+
+func TestSpeedbumpLatencyFlag(t *testing.T) {
+	go startEchoSrv(9082)
+	waitForListener("localhost:9082")
+
+	flag := &mockLatencyFlag{latency: &LatencyCfg{Base: time.Millisecond * 300}, on: true}
+	cfg := SpeedbumpCfg{
+		Port:                8085,
+		DestAddr:            "localhost:9082",
+		BufferSize:          0xffff,
+		Latency:             &LatencyCfg{},
+		LogLevel:            "ERROR",
+		LatencyFlagProvider: flag.provide,
+		LatencyFlagInterval: time.Millisecond * 20,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	roundTrip := func() time.Duration {
+		conn, err := net.Dial("tcp", "localhost:8085")
+		assert.Nil(t, err)
+		defer conn.Close()
+		start := time.Now()
+		conn.Write([]byte("test-string"))
+		_, err = io.ReadFull(conn, make([]byte, len("test-string")))
+		assert.Nil(t, err)
+		return time.Since(start)
+	}
+
+	// the flag is applied before the first connection is accepted
+	assert.GreaterOrEqual(t, int64(roundTrip()), int64(time.Millisecond*300))
+
+	flag.set(nil, false)
+	assert.Eventually(t, func() bool {
+		return s.latencyCfg().Base == 0
+	}, time.Second, time.Millisecond*10)
+	assert.Less(t, int64(roundTrip()), int64(time.Millisecond*200))
+
+	flag.set(&LatencyCfg{Base: time.Millisecond * 250}, true)
+	assert.Eventually(t, func() bool {
+		return s.latencyCfg().Base == time.Millisecond*250
+	}, time.Second, time.Millisecond*10)
+	assert.GreaterOrEqual(t, int64(roundTrip()), int64(time.Millisecond*250))
+
+	// the provider isn't polled once the instance is stopped
+	s.Stop()
+	flag.mu.Lock()
+	polls := flag.polls
+	flag.mu.Unlock()
+	time.Sleep(time.Millisecond * 100)
+	flag.mu.Lock()
+	defer flag.mu.Unlock()
+	assert.Equal(t, polls, flag.polls)
+}
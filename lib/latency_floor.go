@@ -0,0 +1,23 @@
+package lib
+
+import "time"
+
+// floorLatencyGenerator raises the latency generated by gen to at least min
+type floorLatencyGenerator struct {
+	gen LatencyGenerator
+	min time.Duration
+}
+
+func newFloorLatencyGenerator(gen LatencyGenerator, min time.Duration) LatencyGenerator {
+	if gen == nil || min <= 0 {
+		return gen
+	}
+	return floorLatencyGenerator{gen: gen, min: min}
+}
+
+func (f floorLatencyGenerator) generateLatency(when time.Time) time.Duration {
+	if l := f.gen.generateLatency(when); l > f.min {
+		return l
+	}
+	return f.min
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFloorLatencyGenerator(t *testing.T) {
+	g := newFloorLatencyGenerator(&mockLatencyGenerator{time.Millisecond}, time.Millisecond*20)
+	assert.Equal(t, time.Millisecond*20, g.generateLatency(time.Now()))
+
+	g = newFloorLatencyGenerator(&mockLatencyGenerator{time.Millisecond * 50}, time.Millisecond*20)
+	assert.Equal(t, time.Millisecond*50, g.generateLatency(time.Now()))
+}
+
+func TestFloorLatencyGeneratorWithGaussian(t *testing.T) {
+	start := time.Now()
+	// the jitter often brings the latency down to 0
+	g := newFloorLatencyGenerator(newLatencyGenerator(start, &LatencyCfg{
+		Base:           time.Millisecond * 5,
+		GaussianStdDev: time.Millisecond * 10,
+		Seed:           1,
+	}), time.Millisecond*3)
+	floored := false
+	for i := 0; i < 1000; i++ {
+		l := g.generateLatency(start)
+		assert.GreaterOrEqual(t, l, time.Millisecond*3)
+		floored = floored || l == time.Millisecond*3
+	}
+	assert.True(t, floored)
+}
+
+func TestFloorLatencyGeneratorDisabled(t *testing.T) {
+	gen := &mockLatencyGenerator{time.Millisecond}
+	assert.Equal(t, gen, newFloorLatencyGenerator(gen, 0))
+	assert.Nil(t, newFloorLatencyGenerator(nil, time.Millisecond))
+}
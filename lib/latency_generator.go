@@ -9,26 +9,66 @@ type LatencyGenerator interface {
 }
 
 type LatencyCfg struct {
-	Base              time.Duration
-	SineAmplitude     time.Duration
-	SinePeriod        time.Duration
-	SawAmplitude      time.Duration
-	SawPeriod         time.Duration
-	SquareAmplitude   time.Duration
-	SquarePeriod      time.Duration
-	TriangleAmplitude time.Duration
-	TrianglePeriod    time.Duration
+	Base              time.Duration `json:"base" yaml:"base"`
+	SineAmplitude     time.Duration `json:"sineAmplitude" yaml:"sineAmplitude"`
+	SinePeriod        time.Duration `json:"sinePeriod" yaml:"sinePeriod"`
+	SawAmplitude      time.Duration `json:"sawAmplitude" yaml:"sawAmplitude"`
+	SawPeriod         time.Duration `json:"sawPeriod" yaml:"sawPeriod"`
+	SquareAmplitude   time.Duration `json:"squareAmplitude" yaml:"squareAmplitude"`
+	SquarePeriod      time.Duration `json:"squarePeriod" yaml:"squarePeriod"`
+	TriangleAmplitude time.Duration `json:"triangleAmplitude" yaml:"triangleAmplitude"`
+	TrianglePeriod    time.Duration `json:"trianglePeriod" yaml:"trianglePeriod"`
+	// GaussianStdDev optionally adds normally distributed jitter with the given
+	// standard deviation, making Base the mean latency (negative totals are treated as 0)
+	GaussianStdDev time.Duration `json:"gaussianStdDev" yaml:"gaussianStdDev"`
+	// JitterCorrelation optionally correlates the GaussianStdDev jitter of consecutive buffers
+	// of a connection (between 0 and 1), modeling network conditions that persist for a while: each buffer's
+	// jitter is the previous one's multiplied by JitterCorrelation plus a random innovation
+	// (an AR(1) process). The standard deviation of the jitter is not affected.
+	JitterCorrelation float64 `json:"jitterCorrelation" yaml:"jitterCorrelation"`
+	// UniformJitter optionally adds uniformly distributed random latency between 0 and
+	// UniformJitter, making Base the minimum latency. In config files, it can also be
+	// specified by setting base to a range such as "100ms-300ms".
+	UniformJitter time.Duration `json:"uniformJitter" yaml:"uniformJitter"`
+	// Markov optionally adds bursty latency following a two-state Markov chain
+	Markov *MarkovLatencyCfg `json:"markov" yaml:"markov"`
+	// Seed is the seed of the random number generator used by randomized summands
+	// (a time-based seed is used if unspecified)
+	Seed int64 `json:"seed" yaml:"seed"`
 }
 
 type latencySummand interface {
 	getLatency(elapsed time.Duration) time.Duration
 }
 
+// statefulLatencySummand is a summand whose latency depends on the previous buffers,
+// which keeps a separate state for each proxy connection
+type statefulLatencySummand interface {
+	latencySummand
+	forConnection() latencySummand
+}
+
 type simpleLatencyGenerator struct {
 	start    time.Time
 	summands []latencySummand
 }
 
+// noLatencyGenerator is used when no latency is configured,
+// which makes the proxy a plain TCP forwarder
+type noLatencyGenerator struct{}
+
+func (noLatencyGenerator) generateLatency(time.Time) time.Duration {
+	return 0
+}
+
+// newLatencyGenerator creates a latency generator based on cfg (no latency is added if cfg is nil)
+func newLatencyGenerator(start time.Time, cfg *LatencyCfg) LatencyGenerator {
+	if cfg == nil {
+		return noLatencyGenerator{}
+	}
+	return newSimpleLatencyGenerator(start, cfg)
+}
+
 func newSimpleLatencyGenerator(start time.Time, cfg *LatencyCfg) simpleLatencyGenerator {
 	summands := []latencySummand{baseLatencySummand{cfg.Base}}
 	if cfg.SineAmplitude > 0 && cfg.SinePeriod > 0 {
@@ -55,17 +95,62 @@ func newSimpleLatencyGenerator(start time.Time, cfg *LatencyCfg) simpleLatencyGe
 			cfg.TrianglePeriod,
 		})
 	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if cfg.GaussianStdDev > 0 {
+		summands = append(summands, newGaussianLatencySummand(cfg.GaussianStdDev, cfg.JitterCorrelation, seed))
+	}
+	if cfg.UniformJitter > 0 {
+		summands = append(summands, newUniformLatencySummand(cfg.UniformJitter, seed))
+	}
+	if cfg.Markov != nil {
+		summands = append(summands, newMarkovLatencySummand(*cfg.Markov, seed))
+	}
 	return simpleLatencyGenerator{
 		start:    start,
 		summands: summands,
 	}
 }
 
+// forConnection returns the generator used by a single proxy connection, whose stateful
+// summands don't share their state with the other connections (g itself if it has none)
+func (g simpleLatencyGenerator) forConnection() simpleLatencyGenerator {
+	var summands []latencySummand
+	for i, s := range g.summands {
+		stateful, ok := s.(statefulLatencySummand)
+		if !ok {
+			continue
+		}
+		if summands == nil {
+			summands = append([]latencySummand(nil), g.summands...)
+		}
+		summands[i] = stateful.forConnection()
+	}
+	if summands == nil {
+		return g
+	}
+	return simpleLatencyGenerator{start: g.start, summands: summands}
+}
+
+// latencyGenForConnection returns the generator used by a single proxy connection
+// for the latency of new connections (see simpleLatencyGenerator.forConnection)
+func latencyGenForConnection(gen LatencyGenerator) LatencyGenerator {
+	if g, ok := gen.(simpleLatencyGenerator); ok {
+		return g.forConnection()
+	}
+	return gen
+}
+
 func (g simpleLatencyGenerator) generateLatency(when time.Time) time.Duration {
 	var latency time.Duration = 0
 	elapsed := when.Sub(g.start)
 	for _, s := range g.summands {
 		latency += s.getLatency(elapsed)
 	}
+	if latency < 0 {
+		return 0
+	}
 	return latency
 }
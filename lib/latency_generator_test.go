@@ -62,3 +62,11 @@ func TestSimpleLatencyGeneratorWithTriangle(t *testing.T) {
 	assert.Equal(t, time.Second*1, after6Sec)
 	assert.Equal(t, time.Second*3, after2Periods)
 }
+
+func TestNewLatencyGeneratorNil(t *testing.T) {
+	start := time.Now()
+	g := newLatencyGenerator(start, nil)
+
+	assert.Equal(t, time.Duration(0), g.generateLatency(start))
+	assert.Equal(t, time.Duration(0), g.generateLatency(start.Add(time.Hour)))
+}
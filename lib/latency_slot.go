@@ -0,0 +1,32 @@
+package lib
+
+import "sync/atomic"
+
+// latencyGenSlot holds the latency generator of new connections. It's read without
+// locking as each connection is accepted, while ArmLatency publishes replacements
+// atomically, so that reconfiguring latency at runtime doesn't contend with accepting.
+type latencyGenSlot struct {
+	v atomic.Value
+}
+
+// armedLatency is the latency generator of new connections along with the bandwidth
+// limit derived from it (see LinkWindow), which are published together so that
+// a connection never gets accepted with one of them stale
+type armedLatency struct {
+	gen       LatencyGenerator
+	bandwidth bandwidthLimit
+}
+
+func newLatencyGenSlot(armed armedLatency) *latencyGenSlot {
+	slot := &latencyGenSlot{}
+	slot.store(armed)
+	return slot
+}
+
+func (s *latencyGenSlot) load() armedLatency {
+	return s.v.Load().(armedLatency)
+}
+
+func (s *latencyGenSlot) store(armed armedLatency) {
+	s.v.Store(armed)
+}
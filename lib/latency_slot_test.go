@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyGenSlot(t *testing.T) {
+	start := time.Now()
+	slot := newLatencyGenSlot(armedLatency{gen: newLatencyGenerator(start, nil)})
+	assert.Equal(t, time.Duration(0), slot.load().gen.generateLatency(start))
+
+	// generators of different types can replace each other
+	slot.store(armedLatency{gen: newLatencyGenerator(start, &LatencyCfg{Base: time.Millisecond * 10}), bandwidth: bandwidthLimit{rate: 1000}})
+	assert.Equal(t, time.Millisecond*10, slot.load().gen.generateLatency(start))
+	assert.Equal(t, 1000, slot.load().bandwidth.rate)
+}
+
+// lockedLatencyGen is the mutex-guarded alternative to latencyGenSlot used as a baseline
+type lockedLatencyGen struct {
+	mu  sync.Mutex
+	gen LatencyGenerator
+}
+
+func (l *lockedLatencyGen) load() LatencyGenerator {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.gen
+}
+
+func (l *lockedLatencyGen) store(gen LatencyGenerator) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gen = gen
+}
+
+// benchmarkLatencyGenSwap generates latency from the current generator on all CPUs,
+// while the generator is replaced every 10 microseconds
+func benchmarkLatencyGenSwap(b *testing.B, load func() LatencyGenerator, store func(LatencyGenerator)) {
+	start := time.Now()
+	gens := []LatencyGenerator{
+		newLatencyGenerator(start, &LatencyCfg{Base: time.Millisecond * 10}),
+		newLatencyGenerator(start, &LatencyCfg{Base: time.Millisecond * 20}),
+	}
+	store(gens[0])
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Microsecond * 10):
+				store(gens[i%2])
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			load().generateLatency(start)
+		}
+	})
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkLatencyGenSwapAtomic(b *testing.B) {
+	slot := newLatencyGenSlot(armedLatency{})
+	benchmarkLatencyGenSwap(b,
+		func() LatencyGenerator { return slot.load().gen },
+		func(gen LatencyGenerator) { slot.store(armedLatency{gen: gen}) })
+}
+
+func BenchmarkLatencyGenSwapLocked(b *testing.B) {
+	locked := &lockedLatencyGen{}
+	benchmarkLatencyGenSwap(b, locked.load, locked.store)
+}
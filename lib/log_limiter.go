@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// logLimiter coalesces identical warnings logged within an interval,
+// so that a flood of repeated events (e.g. dial failures) doesn't overwhelm
+// the logs. The first occurrence is logged right away, while the following
+// ones are only counted and reported in a summary once the interval ends.
+type logLimiter struct {
+	interval time.Duration
+	clock    Clock
+	log      hclog.Logger
+	mu       sync.Mutex
+	windows  map[string]*logWindow
+}
+
+type logWindow struct {
+	suppressed int
+	// stop is closed once the window is flushed before its interval ends
+	stop chan struct{}
+}
+
+func newLogLimiter(interval time.Duration, clock Clock, log hclog.Logger) *logLimiter {
+	if interval <= 0 {
+		return nil
+	}
+	return &logLimiter{
+		interval: interval,
+		clock:    clockOrReal(clock),
+		log:      log,
+		windows:  make(map[string]*logWindow),
+	}
+}
+
+// warn logs a warning via l unless the same message was already logged
+// within the current interval (every warning is logged if ll is nil)
+func (ll *logLimiter) warn(l hclog.Logger, msg string, args ...interface{}) {
+	if ll == nil {
+		l.Warn(msg, args...)
+		return
+	}
+	ll.mu.Lock()
+	if w, ok := ll.windows[msg]; ok {
+		w.suppressed++
+		ll.mu.Unlock()
+		return
+	}
+	w := &logWindow{stop: make(chan struct{})}
+	ll.windows[msg] = w
+	go func() {
+		select {
+		case <-ll.clock.After(ll.interval):
+			ll.flush(msg, w)
+		case <-w.stop:
+		}
+	}()
+	ll.mu.Unlock()
+	l.Warn(msg, args...)
+}
+
+// flush ends the interval of a given message, reporting the number of suppressed warnings
+func (ll *logLimiter) flush(msg string, w *logWindow) {
+	ll.mu.Lock()
+	if ll.windows[msg] != w {
+		ll.mu.Unlock()
+		return
+	}
+	delete(ll.windows, msg)
+	ll.mu.Unlock()
+	ll.report(msg, w)
+}
+
+// report logs the number of warnings suppressed within a window (if any)
+func (ll *logLimiter) report(msg string, w *logWindow) {
+	if w.suppressed > 0 {
+		ll.log.Warn("Suppressed repeated warnings", "msg", msg, "count", w.suppressed, "interval", ll.interval)
+	}
+}
+
+// flushAll ends the intervals of all messages
+func (ll *logLimiter) flushAll() {
+	if ll == nil {
+		return
+	}
+	ll.mu.Lock()
+	windows := ll.windows
+	ll.windows = make(map[string]*logWindow)
+	for _, w := range windows {
+		close(w.stop)
+	}
+	ll.mu.Unlock()
+	for msg, w := range windows {
+		ll.report(msg, w)
+	}
+}
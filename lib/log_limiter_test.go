@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use by a logger and a test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+}
+
+func newBufferLogger() (hclog.Logger, *syncBuffer) {
+	buf := &syncBuffer{}
+	return hclog.New(&hclog.LoggerOptions{Output: buf, Level: hclog.Warn}), buf
+}
+
+func TestLogLimiterCoalesces(t *testing.T) {
+	l, buf := newBufferLogger()
+	ll := newLogLimiter(time.Millisecond*100, nil, l)
+
+	for i := 0; i < 100; i++ {
+		ll.warn(l, "Creating new proxy conn failed", "attempt", i)
+	}
+	ll.warn(l, "Accepting incoming TCP conn failed")
+
+	lines := buf.lines()
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "Creating new proxy conn failed: attempt=0")
+	assert.Contains(t, lines[1], "Accepting incoming TCP conn failed")
+
+	time.Sleep(time.Millisecond * 200)
+
+	lines = buf.lines()
+	// only the repeated warning is summarized
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[2], "Suppressed repeated warnings")
+	assert.Contains(t, lines[2], `msg="Creating new proxy conn failed" count=99 interval=100ms`)
+
+	// the next interval starts with a warning logged right away
+	ll.warn(l, "Creating new proxy conn failed", "attempt", 100)
+	lines = buf.lines()
+	assert.Len(t, lines, 4)
+	assert.Contains(t, lines[3], "Creating new proxy conn failed: attempt=100")
+}
+
+func TestLogLimiterFlushAll(t *testing.T) {
+	l, buf := newBufferLogger()
+	ll := newLogLimiter(time.Hour, nil, l)
+
+	ll.warn(l, "Creating new proxy conn failed")
+	ll.warn(l, "Creating new proxy conn failed")
+	ll.flushAll()
+
+	lines := buf.lines()
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[1], `msg="Creating new proxy conn failed" count=1`)
+}
+
+func TestLogLimiterDisabled(t *testing.T) {
+	l, buf := newBufferLogger()
+	ll := newLogLimiter(0, nil, l)
+	assert.Nil(t, ll)
+
+	for i := 0; i < 10; i++ {
+		ll.warn(l, "Creating new proxy conn failed")
+	}
+	ll.flushAll()
+
+	assert.Len(t, buf.lines(), 10)
+}
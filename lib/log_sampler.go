@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// nullLogger replaces the lifecycle logger of connections that weren't sampled
+var nullLogger = hclog.NewNullLogger()
+
+// logSampler decides whether the opening and closing of a proxy connection gets logged.
+// It's shared by all proxy connections.
+type logSampler struct {
+	rate float64
+	// mu guards rng, which isn't safe for concurrent use
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newLogSampler(rate float64, seed int64) *logSampler {
+	if rate <= 0 || rate >= 1 {
+		return nil
+	}
+	return &logSampler{
+		rate: rate,
+		rng:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// sample reports whether the lifecycle of the next connection should be logged
+// (always if ls is nil)
+func (ls *logSampler) sample() bool {
+	if ls == nil {
+		return true
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.rng.Float64() < ls.rate
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSamplerRate(t *testing.T) {
+	// all connections are logged unless the rate is between 0 and 1
+	assert.Nil(t, newLogSampler(0, 1))
+	assert.Nil(t, newLogSampler(1, 1))
+	assert.True(t, (*logSampler)(nil).sample())
+
+	ls := newLogSampler(0.25, 1)
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if ls.sample() {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 2500, sampled, 200)
+}
+
+func TestSpeedbumpLogSampleRateInvalid(t *testing.T) {
+	_, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:          8081,
+		DestAddr:      "localhost:9079",
+		Latency:       &LatencyCfg{},
+		LogSampleRate: 1.5,
+	})
+	assert.NotNil(t, err)
+}
+
+func TestSpeedbumpLogSampleRate(t *testing.T) {
+	go startEchoSrv(9079)
+	waitForListener("localhost:9079")
+
+	cfg := SpeedbumpCfg{
+		Port:          8081,
+		DestAddr:      "localhost:9079",
+		BufferSize:    0xffff,
+		Latency:       &LatencyCfg{},
+		LogLevel:      "DEBUG",
+		LogSampleRate: 0.1,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	buf := &syncBuffer{}
+	s.log = hclog.New(&hclog.LoggerOptions{Output: buf, Level: hclog.Debug})
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	const conns = 200
+	for i := 0; i < conns; i++ {
+		conn, err := net.Dial("tcp", "localhost:8081")
+		assert.Nil(t, err)
+		conn.Write([]byte("test-string"))
+		_, err = io.ReadFull(conn, make([]byte, len("test-string")))
+		assert.Nil(t, err)
+		conn.Close()
+	}
+
+	// stats include every connection
+	assert.Eventually(t, func() bool {
+		return s.Stats().ConnectionDurations.Count == conns
+	}, time.Second*5, time.Millisecond*10)
+
+	log := strings.Join(buf.lines(), "\n")
+	started := strings.Count(log, "Starting a new proxy connection")
+	closed := strings.Count(log, "Closing proxy connection")
+	assert.InDelta(t, conns/10, started, 15)
+	assert.Equal(t, started, closed)
+}
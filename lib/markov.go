@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// MarkovLatencyCfg describes bursty latency following a two-state Markov chain.
+// The chain is in either the good or the bad state, each with its own latency,
+// and may transition to the other state with each buffer. Each proxy connection
+// has its own chain, which starts in the good state.
+type MarkovLatencyCfg struct {
+	// GoodLatency is added to buffers while the chain is in the good state
+	GoodLatency time.Duration `json:"goodLatency" yaml:"goodLatency"`
+	// BadLatency is added to buffers while the chain is in the bad state
+	BadLatency time.Duration `json:"badLatency" yaml:"badLatency"`
+	// GoodToBad is the probability of transitioning from the good to the bad state with each buffer
+	GoodToBad float64 `json:"goodToBad" yaml:"goodToBad"`
+	// BadToGood is the probability of transitioning from the bad to the good state with each buffer
+	BadToGood float64 `json:"badToGood" yaml:"badToGood"`
+}
+
+type markovLatencySummand struct {
+	cfg MarkovLatencyCfg
+	// mu guards rng and bad, as the summand may be called by both directions of a connection
+	// (and the rng of the summand shared by all connections seeds their chains)
+	mu  sync.Mutex
+	rng *rand.Rand
+	bad bool
+}
+
+func newMarkovLatencySummand(cfg MarkovLatencyCfg, seed int64) *markovLatencySummand {
+	return &markovLatencySummand{
+		cfg: cfg,
+		rng: rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (s *markovLatencySummand) getLatency(elapsed time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bad {
+		s.bad = s.rng.Float64() >= s.cfg.BadToGood
+	} else {
+		s.bad = s.rng.Float64() < s.cfg.GoodToBad
+	}
+	if s.bad {
+		return s.cfg.BadLatency
+	}
+	return s.cfg.GoodLatency
+}
+
+// forConnection returns a chain in the good state for a single proxy connection,
+// seeded by the chain shared by all connections
+func (s *markovLatencySummand) forConnection() latencySummand {
+	s.mu.Lock()
+	seed := s.rng.Int63()
+	s.mu.Unlock()
+	return newMarkovLatencySummand(s.cfg, seed)
+}
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkovLatencySummand(t *testing.T) {
+	s := newMarkovLatencySummand(MarkovLatencyCfg{
+		GoodLatency: time.Millisecond * 10,
+		BadLatency:  time.Millisecond * 500,
+		GoodToBad:   0.1,
+		BadToGood:   0.4,
+	}, 42)
+
+	var inGood, inBad, goodToBad, badToGood int
+	prevBad := false
+	for i := 0; i < 100000; i++ {
+		latency := s.getLatency(time.Duration(0))
+		bad := latency == time.Millisecond*500
+		if !bad {
+			assert.Equal(t, time.Millisecond*10, latency)
+		}
+		if prevBad {
+			inBad++
+			if !bad {
+				badToGood++
+			}
+		} else {
+			inGood++
+			if bad {
+				goodToBad++
+			}
+		}
+		prevBad = bad
+	}
+
+	assert.InDelta(t, 0.1, float64(goodToBad)/float64(inGood), 0.01)
+	assert.InDelta(t, 0.4, float64(badToGood)/float64(inBad), 0.02)
+	// the stationary share of the bad state is GoodToBad / (GoodToBad + BadToGood)
+	assert.InDelta(t, 0.2, float64(inBad)/100000, 0.02)
+}
+
+func TestMarkovLatencySummandSeeded(t *testing.T) {
+	cfg := MarkovLatencyCfg{
+		GoodLatency: time.Millisecond,
+		BadLatency:  time.Second,
+		GoodToBad:   0.3,
+		BadToGood:   0.3,
+	}
+	a := newMarkovLatencySummand(cfg, 7)
+	b := newMarkovLatencySummand(cfg, 7)
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, a.getLatency(0), b.getLatency(0))
+	}
+}
+
+func TestLatencyGeneratorMarkov(t *testing.T) {
+	g := newSimpleLatencyGenerator(time.Now(), &LatencyCfg{
+		Base: time.Millisecond * 5,
+		Markov: &MarkovLatencyCfg{
+			GoodLatency: time.Millisecond * 10,
+			BadLatency:  time.Millisecond * 100,
+			GoodToBad:   1,
+		},
+		Seed: 1,
+	})
+	// the chain moves to the bad state with the first buffer and stays there
+	assert.Equal(t, time.Millisecond*105, g.generateLatency(time.Now()))
+	assert.Equal(t, time.Millisecond*105, g.generateLatency(time.Now()))
+}
+
+func TestLatencyGeneratorMarkovPerConnection(t *testing.T) {
+	g := newSimpleLatencyGenerator(time.Now(), &LatencyCfg{
+		Base: time.Millisecond * 5,
+		Markov: &MarkovLatencyCfg{
+			GoodLatency: time.Millisecond * 10,
+			BadLatency:  time.Millisecond * 100,
+			GoodToBad:   1,
+			BadToGood:   1,
+		},
+		Seed: 1,
+	})
+	a, b := g.forConnection(), g.forConnection()
+	// the chain alternates between the states, each connection starting from the good one
+	assert.Equal(t, time.Millisecond*105, a.generateLatency(time.Now()))
+	assert.Equal(t, time.Millisecond*105, b.generateLatency(time.Now()))
+	assert.Equal(t, time.Millisecond*15, a.generateLatency(time.Now()))
+	assert.Equal(t, time.Millisecond*15, b.generateLatency(time.Now()))
+
+	// generators without stateful summands are shared as is
+	plain := newSimpleLatencyGenerator(time.Now(), &LatencyCfg{Base: time.Millisecond})
+	assert.Equal(t, plain, latencyGenForConnection(plain))
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// SetDestination replaces the proxy destination (DestAddr) of connections accepted from now on.
+// If MigrateOnReload is set, existing connections proxied to the previous destination
+// are reset, so that their clients reconnect to the new destination.
+func (s *Speedbump) SetDestination(addr string) error {
+	destTCPAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Error resolving destination address: %s", err)
+	}
+	s.latencyMu.Lock()
+	prev := s.cfg.DestAddr
+	s.destAddr = *destTCPAddr
+	s.cfg.DestAddr = addr
+	s.latencyMu.Unlock()
+	s.log.Info("Changed proxy destination for new connections", "dest", addr, "prev", prev)
+	if s.cfg.MigrateOnReload && addr != prev {
+		s.migrateConnections(prev)
+	}
+	return nil
+}
+
+// destination returns the current proxy destination alongside its address as configured
+func (s *Speedbump) destination() (net.TCPAddr, string) {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+	return s.destAddr, s.cfg.DestAddr
+}
+
+// migrateConnections resets active proxy connections whose destination is dest
+func (s *Speedbump) migrateConnections(dest string) {
+	s.connsMu.Lock()
+	var migrated []*connection
+	for _, c := range s.conns {
+		if c.destination == dest {
+			migrated = append(migrated, c)
+		}
+	}
+	s.connsMu.Unlock()
+	s.log.Info("Migrating existing connections to the new destination", "count", len(migrated))
+	for _, c := range migrated {
+		c.resetProxyConnections()
+	}
+}
+
+// resetProxyConnections closes both sides of the connection, sending a TCP reset
+// to the client, so that it notices the connection being torn down right away
+func (c *connection) resetProxyConnections() {
+	if tcpConn := underlyingTCPConn(c.srcConn); tcpConn != nil {
+		tcpConn.SetLinger(0)
+	}
+	c.closeProxyConnections()
+}
+
+// underlyingTCPConn returns the TCP connection of a client connection,
+// which may be wrapped by peek-based modes (nil if there is none)
+func underlyingTCPConn(conn io.ReadWriteCloser) *net.TCPConn {
+	switch c := conn.(type) {
+	case *net.TCPConn:
+		return c
+	case *bufferedConn:
+		return underlyingTCPConn(c.Conn)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startNamedEchoSrv starts a server at addr echoing data prefixed with its name
+func startNamedEchoSrv(t *testing.T, addr, name string) net.Listener {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				buf := make([]byte, 1024)
+				for {
+					n, err := c.Read(buf)
+					if err != nil {
+						return
+					}
+					c.Write(append([]byte(name+":"), buf[:n]...))
+				}
+			}(conn)
+		}
+	}()
+	return l
+}
+
+func roundTrip(t *testing.T, conn net.Conn) string {
+	conn.Write([]byte("ping"))
+	res := make([]byte, 1024)
+	n, err := conn.Read(res)
+	assert.Nil(t, err)
+	return string(res[:n])
+}
+
+func newMigrateSpeedbump(t *testing.T, dest string, migrate bool) *Speedbump {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Host:            "127.0.0.1",
+		Port:            0,
+		DestAddr:        dest,
+		BufferSize:      0xffff,
+		Latency:         defaultLatencyCfg,
+		LogLevel:        "ERROR",
+		MigrateOnReload: migrate,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	return s
+}
+
+func TestSpeedbumpSetDestinationMigrate(t *testing.T) {
+	oldSrv := startNamedEchoSrv(t, "127.0.0.1:0", "old")
+	defer oldSrv.Close()
+	newSrv := startNamedEchoSrv(t, "127.0.0.1:0", "new")
+	defer newSrv.Close()
+
+	s := newMigrateSpeedbump(t, oldSrv.Addr().String(), true)
+	defer s.Stop()
+
+	existing, err := net.Dial("tcp", s.dialAddr())
+	assert.Nil(t, err)
+	defer existing.Close()
+	assert.Equal(t, "old:ping", roundTrip(t, existing))
+
+	assert.Nil(t, s.SetDestination(newSrv.Addr().String()))
+	assert.Equal(t, newSrv.Addr().String(), s.cfg.DestAddr)
+	assert.Equal(t, newSrv.Addr().(*net.TCPAddr).Port, s.Destination().(*net.TCPAddr).Port)
+
+	// the existing connection gets reset
+	existing.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = existing.Read(make([]byte, 1024))
+	assert.NotNil(t, err)
+	assert.False(t, isTimeout(err))
+
+	// so that the client reconnects to the new destination
+	reconnected, err := net.Dial("tcp", s.dialAddr())
+	assert.Nil(t, err)
+	defer reconnected.Close()
+	assert.Equal(t, "new:ping", roundTrip(t, reconnected))
+
+	assert.NotNil(t, s.SetDestination("nope"))
+}
+
+func TestSpeedbumpSetDestinationWithoutMigration(t *testing.T) {
+	oldSrv := startNamedEchoSrv(t, "127.0.0.1:0", "old")
+	defer oldSrv.Close()
+	newSrv := startNamedEchoSrv(t, "127.0.0.1:0", "new")
+	defer newSrv.Close()
+
+	s := newMigrateSpeedbump(t, oldSrv.Addr().String(), false)
+	defer s.Stop()
+
+	existing, err := net.Dial("tcp", s.dialAddr())
+	assert.Nil(t, err)
+	defer existing.Close()
+	assert.Equal(t, "old:ping", roundTrip(t, existing))
+
+	assert.Nil(t, s.SetDestination(newSrv.Addr().String()))
+
+	// existing connections keep their destination, while new ones get the new one
+	assert.Equal(t, "old:ping", roundTrip(t, existing))
+	conn, err := net.Dial("tcp", s.dialAddr())
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Equal(t, "new:ping", roundTrip(t, conn))
+}
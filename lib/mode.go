@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// ModeProxy forwards data between clients and the proxy destination
+	ModeProxy = "proxy"
+	// ModeSink discards data sent by clients without connecting to any proxy destination
+	ModeSink = "sink"
+	// ModeSource streams generated data to clients without connecting to any proxy destination
+	ModeSource = "source"
+)
+
+// newLocalBackend returns the function creating the connection used in place of
+// the proxy destination by a given mode (nil if the proxy destination is dialed)
+func newLocalBackend(cfg *SpeedbumpCfg) (func() io.ReadWriteCloser, error) {
+	switch cfg.Mode {
+	case "", ModeProxy:
+		return nil, nil
+	case ModeSink:
+		return func() io.ReadWriteCloser { return newSinkConn() }, nil
+	case ModeSource:
+		if cfg.SourceBytes < 0 {
+			return nil, fmt.Errorf("Error configuring mode: source bytes must not be negative")
+		}
+		return func() io.ReadWriteCloser { return newSourceConn(cfg.SourcePattern, cfg.SourceBytes) }, nil
+	}
+	return nil, fmt.Errorf("Error configuring mode: unknown mode %s", cfg.Mode)
+}
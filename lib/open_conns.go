@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ConnIntent describes a client connection opened through a Speedbump instance by OpenConnections
+type ConnIntent struct {
+	// TLS optionally makes the client perform a TLS handshake using this config once connected
+	TLS *tls.Config
+	// Greeting is optionally written once the connection is set up (i.e. a latency profile token)
+	Greeting []byte
+}
+
+// ConnResult reports the outcome of setting up the connection of a single ConnIntent
+type ConnResult struct {
+	// Index is the position of the intent in the list passed to OpenConnections
+	Index int
+	// Conn is the established client connection (nil if the setup failed), which is to be
+	// closed by the caller
+	Conn net.Conn
+	// SetupTime is the time it took to dial (and perform the handshake of) the connection
+	SetupTime time.Duration
+	// Err is the error that made the setup fail
+	Err error
+}
+
+// OpenConnections establishes a client connection through the instance for each intent,
+// setting up at most concurrency connections at a time (1 if unspecified). The result
+// of each setup is sent on the returned channel as soon as it's known (so results may
+// arrive out of order), which is closed once all intents were handled. The channel is
+// buffered to hold all results, so that the setup doesn't depend on the caller draining it.
+// Intents that haven't been started by the time ctx is done fail with its error.
+// The instance has to be started.
+func (s *Speedbump) OpenConnections(ctx context.Context, intents []ConnIntent, concurrency int) <-chan ConnResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make(chan ConnResult, len(intents))
+	slots := make(chan struct{}, concurrency)
+	addr := s.dialAddr()
+	var wg sync.WaitGroup
+	go func() {
+		defer close(results)
+		for i, intent := range intents {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results <- ConnResult{Index: i, Err: ctx.Err()}
+				continue
+			}
+			wg.Add(1)
+			go func(i int, intent ConnIntent) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				start := s.clock.Now()
+				conn, err := setupConn(ctx, addr, intent)
+				results <- ConnResult{Index: i, Conn: conn, SetupTime: s.clock.Now().Sub(start), Err: err}
+			}(i, intent)
+		}
+		wg.Wait()
+	}()
+	return results
+}
+
+// setupConn dials addr and performs the handshake of a connection intent
+func setupConn(ctx context.Context, addr string, intent ConnIntent) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening connection: %s", err)
+	}
+	if intent.TLS != nil {
+		tlsConn := tls.Client(conn, intent.TLS)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Error performing TLS handshake: %s", err)
+		}
+		conn = tlsConn
+	}
+	if len(intent.Greeting) > 0 {
+		if _, err := conn.Write(intent.Greeting); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Error writing greeting: %s", err)
+		}
+	}
+	return conn, nil
+}
+
+// dialAddr returns the address at which clients can reach the started instance
+func (s *Speedbump) dialAddr() string {
+	host := s.cfg.Host
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(s.Addr().(*net.TCPAddr).Port))
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpeedbumpOpenConnections(t *testing.T) {
+	go startEchoSrv(9072)
+	waitForListener("localhost:9072")
+
+	cfg := SpeedbumpCfg{
+		Port:       8074,
+		DestAddr:   "localhost:9072",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "ERROR",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	intents := make([]ConnIntent, 50)
+	for i := range intents {
+		intents[i].Greeting = []byte("test-string")
+	}
+	// the echo server doesn't speak TLS, so the handshake of the last intent fails
+	intents[49].TLS = &tls.Config{InsecureSkipVerify: true}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	reported := make(map[int]bool)
+	for res := range s.OpenConnections(ctx, intents, 8) {
+		assert.False(t, reported[res.Index])
+		reported[res.Index] = true
+		if res.Index == 49 {
+			assert.NotNil(t, res.Err)
+			assert.Nil(t, res.Conn)
+			continue
+		}
+		assert.Nil(t, res.Err)
+		buf := make([]byte, 1024)
+		n, _ := res.Conn.Read(buf)
+		assert.Equal(t, []byte("test-string"), buf[:n])
+		res.Conn.Close()
+	}
+	assert.Len(t, reported, 50)
+}
+
+func TestSpeedbumpOpenConnectionsCancelled(t *testing.T) {
+	go startEchoSrv(9073)
+	waitForListener("localhost:9073")
+
+	cfg := SpeedbumpCfg{
+		Port:       8075,
+		DestAddr:   "localhost:9073",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "ERROR",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n := 0
+	for res := range s.OpenConnections(ctx, make([]ConnIntent, 10), 2) {
+		assert.NotNil(t, res.Err)
+		n++
+	}
+	assert.Equal(t, 10, n)
+}
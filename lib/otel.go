@@ -0,0 +1,53 @@
+//go:build otel
+// +build otel
+
+package lib
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelSupported tells whether NewOTelConnTraceFunc is compiled in
+const otelSupported = true
+
+// NewOTelConnTraceFunc returns a ConnTraceFunc producing an OpenTelemetry span per proxy
+// connection using tracer. Each span is a child of the span carried by the connection's
+// context (see ConnContextFunc) and ends once the connection is closed, with attributes
+// describing the proxied bytes, delays, destination and close reason.
+func NewOTelConnTraceFunc(tracer trace.Tracer) ConnTraceFunc {
+	return func(ctx context.Context, info ConnInfo) func(ConnSummary) {
+		_, span := tracer.Start(ctx, "speedbump.connection",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.Int("speedbump.connection.id", info.ID),
+				attribute.String("speedbump.client.addr", info.RemoteAddr.String()),
+				attribute.String("speedbump.destination", info.Destination),
+				attribute.String("speedbump.virtual_host", info.VirtualHost),
+			),
+		)
+		return func(summary ConnSummary) {
+			reason := ""
+			if summary.CloseReason != nil {
+				reason = summary.CloseReason.Error()
+			}
+			span.SetAttributes(
+				attribute.Int64("speedbump.bytes.client_to_server", summary.Bytes.ClientToServer),
+				attribute.Int64("speedbump.bytes.server_to_client", summary.Bytes.ServerToClient),
+				attribute.Int64("speedbump.delay_ms.client_to_server", summary.TotalDelayTime.ClientToServer.Milliseconds()),
+				attribute.Int64("speedbump.delay_ms.server_to_client", summary.TotalDelayTime.ServerToClient.Milliseconds()),
+				attribute.Int("speedbump.evicted_buffers", summary.EvictedBuffers),
+				attribute.String("speedbump.close_reason", reason),
+			)
+			// connections closed cleanly by either peer or by stopping the instance are not errors
+			if reason != "" && !strings.HasSuffix(reason, "EOF") && summary.CloseReason != context.Canceled {
+				span.SetStatus(codes.Error, reason)
+			}
+			span.End()
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !otel
+// +build !otel
+
+package lib
+
+// otelSupported tells whether NewOTelConnTraceFunc is compiled in
+const otelSupported = false
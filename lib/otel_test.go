@@ -0,0 +1,73 @@
+//go:build otel
+// +build otel
+
+package lib
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestOTelConnTraceFunc(t *testing.T) {
+	go startEchoSrv(9035)
+	waitForListener("localhost:9035")
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+	tracer := provider.Tracer("speedbump-test")
+	parentCtx, parent := tracer.Start(context.Background(), "test")
+	defer parent.End()
+
+	cfg := SpeedbumpCfg{
+		Port:       8032,
+		DestAddr:   "localhost:9035",
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{Base: time.Millisecond * 20},
+		LogLevel:   "ERROR",
+		ConnContextFunc: func(ctx context.Context, remote net.Addr) context.Context {
+			return trace.ContextWithSpan(ctx, trace.SpanFromContext(parentCtx))
+		},
+		ConnTraceFunc: NewOTelConnTraceFunc(tracer),
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	conn, err := net.Dial("tcp", "localhost:8032")
+	assert.Nil(t, err)
+	conn.Write([]byte("test-string"))
+	res := make([]byte, 1024)
+	n, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:n])
+	conn.Close()
+	// Stop waits for the connection to be closed, ending its span
+	time.Sleep(time.Millisecond * 50)
+	s.Stop()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "speedbump.connection", span.Name)
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+	assert.Equal(t, codes.Unset, span.Status.Code)
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	assert.Equal(t, "127.0.0.1:9035", attrs["speedbump.destination"].AsString())
+	assert.Equal(t, int64(11), attrs["speedbump.bytes.client_to_server"].AsInt64())
+	assert.Equal(t, int64(11), attrs["speedbump.bytes.server_to_client"].AsInt64())
+	assert.Equal(t, int64(20), attrs["speedbump.delay_ms.client_to_server"].AsInt64())
+	assert.Contains(t, attrs["speedbump.close_reason"].AsString(), "EOF")
+}
@@ -0,0 +1,38 @@
+package lib
+
+import "sync"
+
+// bufferPool allows for reusing read buffers of a given size across proxy
+// connections, which reduces allocations at high connection churn
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() interface{} {
+		buffer := make([]byte, size)
+		return &buffer
+	}
+	return p
+}
+
+// get returns a buffer taken from the pool, or a newly allocated one
+// of a given size if p is nil (the pool's buffers are always of its own size)
+func (p *bufferPool) get(size int) []byte {
+	if p == nil {
+		return make([]byte, size)
+	}
+	return *p.pool.Get().(*[]byte)
+}
+
+// put returns a buffer to the pool once it's no longer referenced anywhere else.
+// Buffers which weren't taken from the pool (i.e. of a different capacity) are ignored.
+func (p *bufferPool) put(buffer []byte) {
+	if p == nil || cap(buffer) != p.size {
+		return
+	}
+	buffer = buffer[:p.size]
+	p.pool.Put(&buffer)
+}
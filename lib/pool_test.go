@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// seqConn returns distinct, numbered payloads on each read until the limit
+// is reached and records everything written to it
+type seqConn struct {
+	reads   int
+	limit   int
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (s *seqConn) Read(p []byte) (int, error) {
+	if s.reads == s.limit {
+		return 0, io.EOF
+	}
+	s.reads++
+	return copy(p, fmt.Sprintf("payload-%06d;", s.reads)), nil
+}
+
+func (s *seqConn) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written.Write(p)
+}
+
+func (s *seqConn) Close() error {
+	return nil
+}
+
+func proxyThroughDelayQueue(pool *bufferPool, reads int) *seqConn {
+	conn := &seqConn{limit: reads}
+	c := &connection{
+		srcConn:    conn,
+		destConn:   conn,
+		bufferSize: 64,
+		pool:       pool,
+		latencyGen: noLatencyGenerator{},
+		delayQueue: make(chan transitBuffer, 16),
+		done:       make(chan error, 3),
+		log:        hclog.NewNullLogger(),
+	}
+	go c.readFromSrc()
+	go c.readFromDelayQueue()
+	// readFromSrc reports an EOF once all payloads were read
+	<-c.done
+	return conn
+}
+
+func waitForWritten(conn *seqConn, size int) []byte {
+	for {
+		conn.mu.Lock()
+		if conn.written.Len() >= size {
+			defer conn.mu.Unlock()
+			return conn.written.Bytes()
+		}
+		conn.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBufferPoolNoCorruption(t *testing.T) {
+	reads := 10000
+	var expected bytes.Buffer
+	for i := 1; i <= reads; i++ {
+		fmt.Fprintf(&expected, "payload-%06d;", i)
+	}
+
+	conn := proxyThroughDelayQueue(newBufferPool(64), reads)
+
+	assert.Equal(t, expected.Bytes(), waitForWritten(conn, expected.Len()))
+}
+
+func TestBufferPool(t *testing.T) {
+	p := newBufferPool(16)
+	buffer := p.get(16)
+	assert.Len(t, buffer, 16)
+	p.put(buffer[:4])
+	assert.Len(t, p.get(16), 16)
+
+	// buffers of a different capacity are not pooled
+	p.put(make([]byte, 8))
+
+	var nilPool *bufferPool
+	assert.Len(t, nilPool.get(32), 32)
+	nilPool.put(buffer)
+}
+
+func benchmarkReadFromSrc(b *testing.B, pool *bufferPool) {
+	b.ReportAllocs()
+	conn := &seqConn{limit: b.N}
+	c := &connection{
+		srcConn:    conn,
+		bufferSize: 0xffff,
+		pool:       pool,
+		latencyGen: noLatencyGenerator{},
+		delayQueue: make(chan transitBuffer),
+		done:       make(chan error, 3),
+		log:        hclog.NewNullLogger(),
+	}
+	go func() {
+		for t := range c.delayQueue {
+			c.pool.put(t.data)
+		}
+	}()
+	b.ResetTimer()
+
+	c.readFromSrc()
+	close(c.delayQueue)
+}
+
+func BenchmarkReadFromSrc(b *testing.B) {
+	benchmarkReadFromSrc(b, nil)
+}
+
+func BenchmarkReadFromSrcPooled(b *testing.B) {
+	benchmarkReadFromSrc(b, newBufferPool(0xffff))
+}
@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// portRange is an inclusive range of local ports tried in order when starting the listener
+type portRange struct {
+	start, end int
+}
+
+// parsePortRange parses a port range in start-end format (nil if it's empty)
+func parsePortRange(r string) (*portRange, error) {
+	if r == "" {
+		return nil, nil
+	}
+	bounds := strings.SplitN(r, "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("Error parsing preferred port range %s: expected start-end", r)
+	}
+	start, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing preferred port range %s: %s", r, err)
+	}
+	end, err := strconv.Atoi(bounds[1])
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing preferred port range %s: %s", r, err)
+	}
+	if start < 1 || end > 65535 || start > end {
+		return nil, fmt.Errorf("Error parsing preferred port range %s: ports must be ascending within 1-65535", r)
+	}
+	return &portRange{start: start, end: end}, nil
+}
+
+// listen binds to the first port of the range that is available on the host of addr
+func (pr *portRange) listen(addr net.TCPAddr) (*net.TCPListener, error) {
+	var err error
+	for port := pr.start; port <= pr.end; port++ {
+		addr.Port = port
+		var listener *net.TCPListener
+		listener, err = net.ListenTCP("tcp", &addr)
+		if err == nil {
+			return listener, nil
+		}
+	}
+	return nil, fmt.Errorf("no port available within %d-%d (last error: %s)", pr.start, pr.end, err)
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortRange(t *testing.T) {
+	pr, err := parsePortRange("9000-9099")
+	assert.Nil(t, err)
+	assert.Equal(t, &portRange{start: 9000, end: 9099}, pr)
+
+	pr, err = parsePortRange("")
+	assert.Nil(t, err)
+	assert.Nil(t, pr)
+
+	for _, invalid := range []string{"9000", "a-9099", "9000-b", "9099-9000", "0-10", "65000-65536"} {
+		_, err := parsePortRange(invalid)
+		assert.True(t, strings.HasPrefix(err.Error(), "Error parsing preferred port range"), invalid)
+	}
+}
+
+func TestSpeedbumpPortRangePreferred(t *testing.T) {
+	// the first two ports of the range are occupied
+	for _, addr := range []string{"localhost:9063", "localhost:9064"} {
+		l, err := net.Listen("tcp", addr)
+		assert.Nil(t, err)
+		defer l.Close()
+	}
+
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Host:               "localhost",
+		PortRangePreferred: "9063-9066",
+		DestAddr:           "localhost:1234",
+		BufferSize:         0xffff,
+		Latency:            defaultLatencyCfg,
+		LogLevel:           "ERROR",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Addr())
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	assert.Equal(t, 9065, s.Addr().(*net.TCPAddr).Port)
+	assert.Equal(t, 9065, s.cfg.Port)
+	conn, err := net.Dial("tcp", "localhost:9065")
+	assert.Nil(t, err)
+	conn.Close()
+}
+
+func TestSpeedbumpPortRangeExhausted(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:9067")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Host:               "localhost",
+		PortRangePreferred: "9067-9067",
+		DestAddr:           "localhost:1234",
+		BufferSize:         0xffff,
+		Latency:            defaultLatencyCfg,
+		LogLevel:           "ERROR",
+	})
+	assert.Nil(t, err)
+	err = s.Start()
+	assert.True(t, strings.HasPrefix(err.Error(), "Error starting TCP listener: no port available within 9067-9067"), err)
+}
@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// defaultPreambleTimeout is the default period of time within which a client
+// has to send the preamble read by peek-based modes
+const defaultPreambleTimeout = time.Second * 5
+
+// defaultMaxPreambleBytes is the default maximum size of a preamble
+const defaultMaxPreambleBytes = 4096
+
+// preambleLimits bound the initial bytes read from clients by peek-based modes
+// (such as latency profile tokens), protecting them from slowloris-style clients
+type preambleLimits struct {
+	timeout  time.Duration
+	maxBytes int
+}
+
+func newPreambleLimits(timeout time.Duration, maxBytes int) preambleLimits {
+	if timeout <= 0 {
+		timeout = defaultPreambleTimeout
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxPreambleBytes
+	}
+	return preambleLimits{timeout: timeout, maxBytes: maxBytes}
+}
+
+// readPreambleLine reads the first line sent by the client within the limits.
+// The line is consumed, while the bytes buffered after it are replayed on subsequent
+// reads of the returned connection. The client connection is closed if the context
+// gets cancelled before the line arrives.
+func readPreambleLine(ctx context.Context, conn net.Conn, limits preambleLimits) (*bufferedConn, []byte, error) {
+	read := make(chan struct{})
+	defer close(read)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-read:
+		}
+	}()
+	conn.SetReadDeadline(time.Now().Add(limits.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	bc := &bufferedConn{conn, bufio.NewReaderSize(conn, limits.maxBytes)}
+	line, err := bc.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, nil, fmt.Errorf("Error reading preamble: exceeds %d bytes", limits.maxBytes)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading preamble: %s", err)
+	}
+	return bc, line, nil
+}
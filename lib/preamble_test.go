@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPreambleLimitsDefaults(t *testing.T) {
+	assert.Equal(t, preambleLimits{defaultPreambleTimeout, defaultMaxPreambleBytes}, newPreambleLimits(0, 0))
+	assert.Equal(t, preambleLimits{time.Second, 100}, newPreambleLimits(time.Second, 100))
+}
+
+func TestReadPreambleLine(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("token\nrest"))
+
+	bc, line, err := readPreambleLine(context.Background(), server, newPreambleLimits(time.Second, 64))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("token\n"), line)
+	rest := make([]byte, 4)
+	n, _ := io.ReadFull(bc, rest)
+	assert.Equal(t, []byte("rest"), rest[:n])
+}
+
+func TestReadPreambleLineTooLong(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write(bytes.Repeat([]byte("a"), 100))
+
+	_, _, err := readPreambleLine(context.Background(), server, newPreambleLimits(time.Second, 64))
+	assert.EqualError(t, err, "Error reading preamble: exceeds 64 bytes")
+}
+
+func TestSpeedbumpPreambleLimits(t *testing.T) {
+	go startEchoSrv(9025)
+	waitForListener("localhost:9025")
+
+	cfg := SpeedbumpCfg{
+		Port:             8023,
+		DestAddr:         "localhost:9025",
+		BufferSize:       0xffff,
+		LogLevel:         "ERROR",
+		LatencyProfiles:  map[string]LatencyCfg{"fast": {}},
+		PreambleTimeout:  time.Millisecond * 300,
+		MaxPreambleBytes: 1024,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	// a huge preamble gets the client disconnected right away
+	huge, err := net.Dial("tcp", "localhost:8023")
+	assert.Nil(t, err)
+	defer huge.Close()
+	huge.Write(bytes.Repeat([]byte("a"), 1<<20))
+	huge.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = huge.Read(make([]byte, 1024))
+	assert.NotNil(t, err)
+	assert.False(t, isTimeout(err))
+
+	// a slow client gets disconnected once the preamble timeout expires
+	slow, err := net.Dial("tcp", "localhost:8023")
+	assert.Nil(t, err)
+	defer slow.Close()
+	start := time.Now()
+	go func() {
+		for i := 0; i < 10; i++ {
+			if _, err := slow.Write([]byte("f")); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+	}()
+	slow.SetReadDeadline(time.Now().Add(time.Second * 2))
+	_, err = slow.Read(make([]byte, 1024))
+	// either EOF or a reset, depending on whether a write raced with the disconnect
+	assert.NotNil(t, err)
+	assert.False(t, isTimeout(err))
+	assert.True(t, isDurationCloseTo(time.Millisecond*300, time.Since(start), 30))
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
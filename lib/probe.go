@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"context"
+	"time"
+)
+
+// probeBackend dials the proxy destination once, recording the time it took
+// to establish the connection as the baseline RTT
+func (s *Speedbump) probeBackend() {
+	ctx := context.Background()
+	if s.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.dialTimeout)
+		defer cancel()
+	}
+	start := s.clock.Now()
+	dest := s.Destination().String()
+	conn, err := s.probeDial(ctx, "tcp", dest)
+	if err != nil {
+		s.log.Warn("Probing proxy destination failed", "err", err)
+		return
+	}
+	rtt := s.clock.Now().Sub(start)
+	conn.Close()
+	s.statsMu.Lock()
+	s.backendRTT = rtt
+	s.statsMu.Unlock()
+	s.log.Info("Probed proxy destination", "dest", dest, "rtt", rtt)
+}
+
+// BackendRTT returns the time it took to connect to the proxy destination when
+// it was probed by Start() (0 if ProbeBackendOnStart is disabled or probing failed)
+func (s *Speedbump) BackendRTT() time.Duration {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.backendRTT
+}
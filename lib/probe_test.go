@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpeedbumpProbeBackendOnStart(t *testing.T) {
+	go startEchoSrv(9036)
+	waitForListener("localhost:9036")
+
+	cfg := SpeedbumpCfg{
+		Port:                8033,
+		DestAddr:            "localhost:9036",
+		BufferSize:          0xffff,
+		Latency:             defaultLatencyCfg,
+		LogLevel:            "ERROR",
+		ProbeBackendOnStart: true,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	// connecting to a local backend is near-instant, so the connect delay is simulated
+	s.probeDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		time.Sleep(time.Millisecond * 50)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	assert.Equal(t, time.Duration(0), s.BackendRTT())
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	assert.GreaterOrEqual(t, s.BackendRTT(), time.Millisecond*50)
+	assert.Less(t, s.BackendRTT(), time.Second)
+}
+
+func TestSpeedbumpProbeBackendOnStartFailure(t *testing.T) {
+	cfg := SpeedbumpCfg{
+		Port:                8034,
+		DestAddr:            "localhost:9037",
+		BufferSize:          0xffff,
+		Latency:             defaultLatencyCfg,
+		LogLevel:            "ERROR",
+		ProbeBackendOnStart: true,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	// the instance starts regardless of the probe failing
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	assert.Equal(t, time.Duration(0), s.BackendRTT())
+}
@@ -0,0 +1,31 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// readProfileToken reads the first line sent by the client, which names the latency
+// profile to be applied to its connection. The line is consumed, so that it's not
+// forwarded to the proxy destination.
+func readProfileToken(ctx context.Context, conn net.Conn, limits preambleLimits) (*bufferedConn, string, error) {
+	bc, line, err := readPreambleLine(ctx, conn, limits)
+	if err != nil {
+		return nil, "", err
+	}
+	return bc, strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func newProfileLatencyGenerators(start time.Time, profiles map[string]LatencyCfg) map[string]LatencyGenerator {
+	if len(profiles) == 0 {
+		return nil
+	}
+	generators := make(map[string]LatencyGenerator, len(profiles))
+	for name, cfg := range profiles {
+		cfg := cfg
+		generators[name] = newLatencyGenerator(start, &cfg)
+	}
+	return generators
+}
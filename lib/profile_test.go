@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpeedbumpLatencyProfiles(t *testing.T) {
+	go startEchoSrv(9023)
+	waitForListener("localhost:9023")
+
+	cfg := SpeedbumpCfg{
+		Port:       8021,
+		DestAddr:   "localhost:9023",
+		BufferSize: 0xffff,
+		LogLevel:   "ERROR",
+		LatencyProfiles: map[string]LatencyCfg{
+			"fast": {},
+			"slow": {Base: time.Millisecond * 300},
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	roundTrip := func(token string) time.Duration {
+		conn, err := net.Dial("tcp", "localhost:8021")
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.Write([]byte(token + "\n"))
+		start := time.Now()
+		conn.Write([]byte("test-string"))
+		res := make([]byte, 1024)
+		n, _ := conn.Read(res)
+		// the token is not forwarded to the proxy destination
+		assert.Equal(t, []byte("test-string"), res[:n])
+		return time.Since(start)
+	}
+
+	assert.Less(t, int64(roundTrip("fast")), int64(time.Millisecond*150))
+	assert.GreaterOrEqual(t, int64(roundTrip("slow")), int64(time.Millisecond*300))
+
+	// clients naming an unknown profile get disconnected
+	conn, err := net.Dial("tcp", "localhost:8021")
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.Write([]byte("nope\r\ntest-string"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1024))
+	assert.Equal(t, io.EOF, err)
+}
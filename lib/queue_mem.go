@@ -0,0 +1,186 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+)
+
+// QueueMemPolicy specifies what happens once the memory held by all delay queues
+// reaches GlobalQueueMemLimit
+type QueueMemPolicy int
+
+const (
+	// QueueMemBlock makes connections wait for memory to be released before queueing more data
+	QueueMemBlock QueueMemPolicy = iota
+	// QueueMemDropOldest drops the oldest buffers queued by a connection to make room for
+	// a new one (or the new buffer if the connection has none queued), corrupting its stream
+	QueueMemDropOldest
+	// QueueMemCloseHeaviest closes the connection holding the most queued memory
+	QueueMemCloseHeaviest
+)
+
+func (p QueueMemPolicy) String() string {
+	switch p {
+	case QueueMemDropOldest:
+		return "drop-oldest"
+	case QueueMemCloseHeaviest:
+		return "close-heaviest"
+	}
+	return "block"
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (p QueueMemPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (p *QueueMemPolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "block":
+		*p = QueueMemBlock
+	case "drop-oldest":
+		*p = QueueMemDropOldest
+	case "close-heaviest":
+		*p = QueueMemCloseHeaviest
+	default:
+		return fmt.Errorf("Unknown queue memory policy: %s", text)
+	}
+	return nil
+}
+
+// queueMemory accounts for the memory held by the delay queues of all proxy connections
+type queueMemory struct {
+	limit  int
+	policy QueueMemPolicy
+	// mu guards the fields below along with the queuedBytes and queueMemReleased
+	// fields of the connections
+	mu    sync.Mutex
+	used  int
+	conns map[*connection]struct{}
+	// wake is closed (and replaced) whenever memory is released
+	wake chan struct{}
+}
+
+func newQueueMemory(limit int, policy QueueMemPolicy) *queueMemory {
+	if limit <= 0 {
+		return nil
+	}
+	return &queueMemory{
+		limit:  limit,
+		policy: policy,
+		conns:  make(map[*connection]struct{}),
+		wake:   make(chan struct{}),
+	}
+}
+
+// acquire accounts for n bytes about to be queued by a connection, applying the policy
+// if the limit was reached. It returns false if the data must not be queued, either
+// because it was dropped or because the connection is done.
+func (q *queueMemory) acquire(c *connection, n int) bool {
+	if q == nil {
+		return true
+	}
+	for {
+		q.mu.Lock()
+		if c.queueMemReleased {
+			q.mu.Unlock()
+			return false
+		}
+		// a buffer exceeding the limit on its own is let through if nothing else is queued
+		if q.used+n <= q.limit || q.used == 0 {
+			q.used += n
+			c.queuedBytes += n
+			q.conns[c] = struct{}{}
+			q.mu.Unlock()
+			return true
+		}
+		switch q.policy {
+		case QueueMemDropOldest:
+			q.mu.Unlock()
+			select {
+			case t := <-c.delayQueue:
+				c.log.Debug("Dropping oldest queued buffer due to the queue memory limit", "bytes", len(t.data))
+				q.release(c, len(t.data))
+				c.pool.put(t.data)
+			default:
+				c.log.Debug("Dropping buffer due to the queue memory limit", "bytes", n)
+				return false
+			}
+		case QueueMemCloseHeaviest:
+			heaviest := q.heaviest()
+			bytes := heaviest.queuedBytes
+			q.mu.Unlock()
+			heaviest.log.Warn("Closing proxy connection holding the most queued memory", "bytes", bytes)
+			// closing the connection releases all of its queued memory
+			heaviest.closeProxyConnections()
+		default:
+			wake := q.wake
+			q.mu.Unlock()
+			select {
+			case <-wake:
+			case <-c.ctx.Done():
+				return false
+			}
+		}
+	}
+}
+
+// heaviest returns the connection holding the most queued memory (q.mu must be held).
+// As q.used is greater than zero, at least one connection holds memory.
+func (q *queueMemory) heaviest() *connection {
+	var heaviest *connection
+	for c := range q.conns {
+		if heaviest == nil || c.queuedBytes > heaviest.queuedBytes {
+			heaviest = c
+		}
+	}
+	return heaviest
+}
+
+// release accounts for n bytes queued by a connection that are no longer held
+func (q *queueMemory) release(c *connection, n int) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// data that wasn't accounted for (i.e. queued before the memory got released
+	// on close) is ignored
+	if n > c.queuedBytes {
+		n = c.queuedBytes
+	}
+	c.queuedBytes -= n
+	q.used -= n
+	q.notify()
+}
+
+// releaseConn releases all memory held by a closed connection
+func (q *queueMemory) releaseConn(c *connection) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used -= c.queuedBytes
+	c.queuedBytes = 0
+	c.queueMemReleased = true
+	delete(q.conns, c)
+	q.notify()
+}
+
+// notify wakes up connections waiting for memory to be released (q.mu must be held)
+func (q *queueMemory) notify() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// usage returns the number of bytes currently held by all delay queues
+func (q *queueMemory) usage() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.used
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newQueueMemTestConn(ctx context.Context) *connection {
+	return &connection{
+		srcConn:    mockConn{closeCount: new(int), closeRes: []error{nil}},
+		destConn:   mockConn{closeCount: new(int), closeRes: []error{nil}},
+		delayQueue: make(chan transitBuffer, 10),
+		ctx:        ctx,
+		log:        hclog.NewNullLogger(),
+	}
+}
+
+func TestQueueMemoryBlock(t *testing.T) {
+	q := newQueueMemory(100, QueueMemBlock)
+	a := newQueueMemTestConn(context.Background())
+	b := newQueueMemTestConn(context.Background())
+	a.queueMem, b.queueMem = q, q
+
+	assert.True(t, q.acquire(a, 60))
+	acquired := make(chan bool)
+	go func() { acquired <- q.acquire(b, 60) }()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire returned before memory was released")
+	case <-time.After(time.Millisecond * 50):
+	}
+	assert.Equal(t, 60, q.usage())
+
+	q.release(a, 60)
+	assert.True(t, <-acquired)
+	assert.Equal(t, 60, q.usage())
+
+	// waiting stops once the connection's context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newQueueMemTestConn(ctx)
+	go func() { acquired <- q.acquire(c, 60) }()
+	cancel()
+	assert.False(t, <-acquired)
+}
+
+func TestQueueMemoryDropOldest(t *testing.T) {
+	q := newQueueMemory(100, QueueMemDropOldest)
+	a := newQueueMemTestConn(context.Background())
+	b := newQueueMemTestConn(context.Background())
+
+	assert.True(t, q.acquire(a, 60))
+	a.delayQueue <- transitBuffer{data: make([]byte, 60)}
+
+	// the connection's oldest queued buffer is dropped to make room
+	assert.True(t, q.acquire(a, 60))
+	assert.Len(t, a.delayQueue, 0)
+	assert.Equal(t, 60, q.usage())
+
+	// the new buffer is dropped if the connection has none queued
+	assert.False(t, q.acquire(b, 60))
+	assert.Equal(t, 60, q.usage())
+}
+
+func TestQueueMemoryCloseHeaviest(t *testing.T) {
+	q := newQueueMemory(100, QueueMemCloseHeaviest)
+	heavy := newQueueMemTestConn(context.Background())
+	light := newQueueMemTestConn(context.Background())
+	heavy.queueMem, light.queueMem = q, q
+
+	assert.True(t, q.acquire(heavy, 80))
+	assert.True(t, q.acquire(light, 10))
+	assert.True(t, q.acquire(light, 30))
+
+	assert.Equal(t, 1, *heavy.srcConn.(mockConn).closeCount)
+	assert.Equal(t, 0, *light.srcConn.(mockConn).closeCount)
+	assert.Equal(t, 40, q.usage())
+
+	// a closed connection can't queue more data
+	assert.False(t, q.acquire(heavy, 10))
+	q.release(heavy, 80)
+	assert.Equal(t, 40, q.usage())
+}
+
+func TestSpeedbumpQueueMemory(t *testing.T) {
+	go startEchoSrv(9031)
+	waitForListener("localhost:9031")
+
+	cfg := SpeedbumpCfg{
+		Port:                8028,
+		DestAddr:            "localhost:9031",
+		BufferSize:          0xffff,
+		Latency:             &LatencyCfg{Base: time.Millisecond * 300},
+		LogLevel:            "ERROR",
+		GlobalQueueMemLimit: 1 << 20,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8028")
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.Write([]byte("test-string"))
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, len("test-string"), s.Stats().QueueMemory)
+
+	res := make([]byte, 1024)
+	n, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:n])
+	// memory is released once the write to the destination returns, which may be after the echo arrives
+	assert.Eventually(t, func() bool { return s.Stats().QueueMemory == 0 }, time.Second, time.Millisecond)
+}
+
+func TestQueueMemPolicyText(t *testing.T) {
+	for _, p := range []QueueMemPolicy{QueueMemBlock, QueueMemDropOldest, QueueMemCloseHeaviest} {
+		text, err := p.MarshalText()
+		assert.Nil(t, err)
+		var parsed QueueMemPolicy
+		assert.Nil(t, parsed.UnmarshalText(text))
+		assert.Equal(t, p, parsed)
+	}
+	var parsed QueueMemPolicy
+	assert.EqualError(t, parsed.UnmarshalText([]byte("nope")), "Unknown queue memory policy: nope")
+}
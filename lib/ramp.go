@@ -0,0 +1,155 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// DelayRampCfg describes an additional delay that grows with each buffer read
+// from the client within a single proxy connection, which simulates progressively
+// degrading service within a session. The ramp starts over for each new connection.
+type DelayRampCfg struct {
+	// Step is the amount of delay added to each subsequent buffer
+	Step time.Duration `json:"step" yaml:"step"`
+	// Max caps the additional delay (no cap if unspecified)
+	Max time.Duration `json:"max" yaml:"max"`
+}
+
+// delayRamp keeps track of the ramp's progress within a single proxy connection
+type delayRamp struct {
+	step    time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newDelayRamp(cfg *DelayRampCfg) *delayRamp {
+	if cfg == nil || cfg.Step <= 0 {
+		return nil
+	}
+	return &delayRamp{
+		step: cfg.Step,
+		max:  cfg.Max,
+	}
+}
+
+// next returns the additional delay of the next buffer read from the client
+func (r *delayRamp) next() time.Duration {
+	if r == nil {
+		return 0
+	}
+	r.current += r.step
+	if r.max > 0 && r.current > r.max {
+		r.current = r.max
+	}
+	return r.current
+}
+
+// RampCurve selects how the penalty of SlowStartCfg decays over RampDuration
+type RampCurve int
+
+const (
+	// RampLinear decays the penalty at a constant rate
+	RampLinear RampCurve = iota
+	// RampExponential decays most of the penalty early on, leaving a long tail
+	// of a small penalty
+	RampExponential
+	// RampLogarithmic decays the penalty faster at first, though more gradually than
+	// RampExponential, with the rate slowing down towards the end of the ramp
+	RampLogarithmic
+)
+
+// rampExponentialRate is the decay rate of RampExponential over the ramp,
+// after which about 0.7% of the penalty would be left without normalization
+const rampExponentialRate = 5
+
+func (rc RampCurve) String() string {
+	switch rc {
+	case RampExponential:
+		return "exponential"
+	case RampLogarithmic:
+		return "logarithmic"
+	}
+	return "linear"
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (rc RampCurve) MarshalText() ([]byte, error) {
+	return []byte(rc.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (rc *RampCurve) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "linear":
+		*rc = RampLinear
+	case "exponential":
+		*rc = RampExponential
+	case "logarithmic":
+		*rc = RampLogarithmic
+	default:
+		return fmt.Errorf("Unknown ramp curve: %s", text)
+	}
+	return nil
+}
+
+// remaining returns the fraction of the penalty left after a given fraction
+// of the ramp elapsed, going from 1 at the start to 0 at the end
+func (rc RampCurve) remaining(progress float64) float64 {
+	switch rc {
+	case RampExponential:
+		end := math.Exp(-rampExponentialRate)
+		return (math.Exp(-rampExponentialRate*progress) - end) / (1 - end)
+	case RampLogarithmic:
+		return 1 - math.Log(1+(math.E-1)*progress)
+	}
+	return 1 - progress
+}
+
+// SlowStartCfg describes an additional delay added to buffers read from the client early
+// in the life of a proxy connection, which decays to nothing over RampDuration since the
+// connection was opened. It simulates warmup costs, such as cold caches or connection pools.
+type SlowStartCfg struct {
+	// Penalty is the delay added to buffers read right after the connection was opened
+	Penalty time.Duration `json:"penalty" yaml:"penalty"`
+	// RampDuration is the time since the connection was opened after which no delay is added
+	RampDuration time.Duration `json:"rampDuration" yaml:"rampDuration"`
+	// RampCurve specifies how the penalty decays (defaults to RampLinear)
+	RampCurve RampCurve `json:"rampCurve" yaml:"rampCurve"`
+}
+
+// slowStart keeps track of the time a single proxy connection was opened at
+type slowStart struct {
+	penalty  time.Duration
+	duration time.Duration
+	curve    RampCurve
+	start    time.Time
+}
+
+// newSlowStart creates the ramp of a connection opened at a given point in time
+func newSlowStart(cfg *SlowStartCfg, openedAt time.Time) *slowStart {
+	if cfg == nil || cfg.Penalty <= 0 || cfg.RampDuration <= 0 {
+		return nil
+	}
+	return &slowStart{
+		penalty:  cfg.Penalty,
+		duration: cfg.RampDuration,
+		curve:    cfg.RampCurve,
+		start:    openedAt,
+	}
+}
+
+// next returns the additional delay of a buffer read from the client at a given point in time
+func (s *slowStart) next(receivedAt time.Time) time.Duration {
+	if s == nil {
+		return 0
+	}
+	elapsed := receivedAt.Sub(s.start)
+	if elapsed >= s.duration {
+		return 0
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return time.Duration(float64(s.penalty) * s.curve.remaining(float64(elapsed)/float64(s.duration)))
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDelayRampDisabled(t *testing.T) {
+	assert.Nil(t, newDelayRamp(nil))
+	assert.Nil(t, newDelayRamp(&DelayRampCfg{Max: time.Second}))
+
+	var r *delayRamp
+	assert.Equal(t, time.Duration(0), r.next())
+}
+
+func TestDelayRampNext(t *testing.T) {
+	r := newDelayRamp(&DelayRampCfg{Step: time.Millisecond * 10, Max: time.Millisecond * 35})
+
+	assert.Equal(t, time.Millisecond*10, r.next())
+	assert.Equal(t, time.Millisecond*20, r.next())
+	assert.Equal(t, time.Millisecond*30, r.next())
+	assert.Equal(t, time.Millisecond*35, r.next())
+	assert.Equal(t, time.Millisecond*35, r.next())
+}
+
+func TestDelayRampUncapped(t *testing.T) {
+	r := newDelayRamp(&DelayRampCfg{Step: time.Second})
+	for i := 0; i < 99; i++ {
+		r.next()
+	}
+	assert.Equal(t, time.Second*100, r.next())
+}
+
+func TestNewSlowStartDisabled(t *testing.T) {
+	assert.Nil(t, newSlowStart(nil, time.Now()))
+	assert.Nil(t, newSlowStart(&SlowStartCfg{Penalty: time.Second}, time.Now()))
+	assert.Nil(t, newSlowStart(&SlowStartCfg{RampDuration: time.Second}, time.Now()))
+
+	var s *slowStart
+	assert.Equal(t, time.Duration(0), s.next(time.Now()))
+}
+
+func TestSlowStartCurves(t *testing.T) {
+	tests := []struct {
+		curve RampCurve
+		// expected holds the penalty in ms left at each quarter of the ramp
+		expected []float64
+	}{
+		{RampLinear, []float64{1000, 750, 500, 250, 0}},
+		{RampExponential, []float64{1000, 281.7, 75.9, 16.9, 0}},
+		{RampLogarithmic, []float64{1000, 642.6, 379.8, 172.0, 0}},
+	}
+	start := time.Now()
+	for _, tt := range tests {
+		s := newSlowStart(&SlowStartCfg{Penalty: time.Second, RampDuration: time.Second, RampCurve: tt.curve}, start)
+		for i, expected := range tt.expected {
+			d := s.next(start.Add(time.Millisecond * 250 * time.Duration(i)))
+			assert.InDelta(t, expected*float64(time.Millisecond), float64(d), float64(time.Millisecond), "%s at %d/4", tt.curve, i)
+		}
+		// the penalty only decays
+		prev := s.next(start)
+		for elapsed := time.Millisecond; elapsed <= time.Second; elapsed += time.Millisecond {
+			d := s.next(start.Add(elapsed))
+			assert.LessOrEqual(t, int64(d), int64(prev), "%s at %s", tt.curve, elapsed)
+			prev = d
+		}
+		assert.Equal(t, time.Duration(0), s.next(start.Add(time.Hour)))
+	}
+}
+
+func TestRampCurveText(t *testing.T) {
+	for _, curve := range []RampCurve{RampLinear, RampExponential, RampLogarithmic} {
+		text, err := curve.MarshalText()
+		assert.Nil(t, err)
+		var parsed RampCurve
+		assert.Nil(t, parsed.UnmarshalText(text))
+		assert.Equal(t, curve, parsed)
+	}
+	var curve RampCurve
+	assert.NotNil(t, curve.UnmarshalText([]byte("quadratic")))
+}
+
+func TestSpeedbumpSlowStart(t *testing.T) {
+	go startEchoSrv(9080)
+	waitForListener("localhost:9080")
+
+	cfg := SpeedbumpCfg{
+		Port:       8082,
+		DestAddr:   "localhost:9080",
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{},
+		LogLevel:   "ERROR",
+		SlowStart: &SlowStartCfg{
+			Penalty:      time.Millisecond * 500,
+			RampDuration: time.Millisecond * 600,
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8082")
+	assert.Nil(t, err)
+	defer conn.Close()
+	roundTrip := func() time.Duration {
+		start := time.Now()
+		conn.Write([]byte("test-string"))
+		_, err := io.ReadFull(conn, make([]byte, len("test-string")))
+		assert.Nil(t, err)
+		return time.Since(start)
+	}
+
+	// the first request gets most of the penalty
+	assert.Greater(t, int64(roundTrip()), int64(time.Millisecond*400))
+	// requests sent once the ramp is over aren't delayed
+	time.Sleep(time.Millisecond * 200)
+	assert.Less(t, int64(roundTrip()), int64(time.Millisecond*200))
+}
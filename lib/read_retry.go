@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+const defaultReadRetryBackoff = time.Millisecond * 10
+
+// readRetryPolicy specifies how reads failing with a temporary network error are retried
+type readRetryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+func newReadRetryPolicy(cfg *SpeedbumpCfg) *readRetryPolicy {
+	if cfg.ReadRetries <= 0 {
+		return nil
+	}
+	p := &readRetryPolicy{
+		attempts: cfg.ReadRetries,
+		backoff:  cfg.ReadRetryBackoff,
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultReadRetryBackoff
+	}
+	return p
+}
+
+// isTemporary reports whether err is a net.Error marked as temporary
+// (timeouts are not retried, as they are caused by deadlines set by speedbump itself)
+func isTemporary(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Temporary() && !ne.Timeout()
+}
+
+// read reads from one side of the connection, retrying reads that fail with
+// a temporary network error according to the read retry policy
+func (c *connection) read(r io.Reader, b []byte) (int, error) {
+	n, err := r.Read(b)
+	if c.readRetry == nil {
+		return n, err
+	}
+	for attempt := 1; n == 0 && err != nil && isTemporary(err) && attempt <= c.readRetry.attempts; attempt++ {
+		c.log.Debug("Retrying read after a temporary error", "attempt", attempt, "err", err)
+		select {
+		case <-c.after(c.readRetry.backoff):
+		case <-c.ctx.Done():
+			return n, err
+		}
+		n, err = r.Read(b)
+	}
+	return n, err
+}
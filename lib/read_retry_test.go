@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary-error" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// blippingConn wraps a connection, failing a given number of reads
+// with a temporary error before the wrapped connection's read with index at
+type blippingConn struct {
+	io.ReadWriteCloser
+	at       int
+	failures int
+	reads    int
+}
+
+func (b *blippingConn) Read(p []byte) (int, error) {
+	if b.reads == b.at && b.failures > 0 {
+		b.failures--
+		return 0, temporaryError{}
+	}
+	b.reads++
+	return b.ReadWriteCloser.Read(p)
+}
+
+func TestNewReadRetryPolicy(t *testing.T) {
+	assert.Nil(t, newReadRetryPolicy(&SpeedbumpCfg{}))
+
+	p := newReadRetryPolicy(&SpeedbumpCfg{ReadRetries: 2})
+	assert.Equal(t, 2, p.attempts)
+	assert.Equal(t, defaultReadRetryBackoff, p.backoff)
+
+	p = newReadRetryPolicy(&SpeedbumpCfg{ReadRetries: 5, ReadRetryBackoff: time.Second})
+	assert.Equal(t, time.Second, p.backoff)
+}
+
+func TestIsTemporary(t *testing.T) {
+	assert.True(t, isTemporary(temporaryError{}))
+	assert.False(t, isTemporary(errors.New("some-error")))
+	assert.False(t, isTemporary(timeoutError{}))
+}
+
+type timeoutError struct{ temporaryError }
+
+func (timeoutError) Timeout() bool { return true }
+
+func newBlippingDestConnection(failures int, readRetry *readRetryPolicy) (*connection, mockConn, chan error) {
+	mockDest := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		readRes: []readReturn{
+			{10, []byte("testdata12"), nil},
+			{10, []byte("testdata34"), nil},
+			{0, []byte(""), io.EOF},
+		},
+	}
+	mockSrc := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		writeRes:   []writeReturn{{10, nil}},
+	}
+	done := make(chan error, 3)
+	c := &connection{
+		srcConn:    mockSrc,
+		destConn:   &blippingConn{ReadWriteCloser: mockDest, at: 1, failures: failures},
+		bufferSize: 20,
+		readRetry:  readRetry,
+		done:       done,
+		ctx:        context.TODO(),
+		log:        hclog.NewNullLogger(),
+	}
+	return c, mockSrc, done
+}
+
+func TestReadFromDestSurvivesTemporaryError(t *testing.T) {
+	c, mockSrc, done := newBlippingDestConnection(2, &readRetryPolicy{attempts: 3, backoff: time.Millisecond * 10})
+
+	start := time.Now()
+	c.readFromDest()
+
+	// both buffers made it through the blip and the connection was closed by EOF
+	assert.EqualError(t, <-done, "Error reading data from proxy destination: EOF")
+	assert.Equal(t, 2, *mockSrc.writeCount)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Millisecond*20))
+}
+
+func TestReadFromDestTemporaryErrorRetriesExhausted(t *testing.T) {
+	c, mockSrc, done := newBlippingDestConnection(3, &readRetryPolicy{attempts: 2, backoff: time.Millisecond})
+
+	c.readFromDest()
+
+	assert.EqualError(t, <-done, "Error reading data from proxy destination: temporary-error")
+	assert.Equal(t, 1, *mockSrc.writeCount)
+}
+
+func TestReadFromDestTemporaryErrorWithoutRetries(t *testing.T) {
+	c, mockSrc, done := newBlippingDestConnection(1, nil)
+
+	c.readFromDest()
+
+	assert.EqualError(t, <-done, "Error reading data from proxy destination: temporary-error")
+	assert.Equal(t, 1, *mockSrc.writeCount)
+}
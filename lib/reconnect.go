@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	defaultReconnectAttempts = 3
+	defaultReconnectBackoff  = time.Millisecond * 100
+)
+
+// reconnectPolicy specifies how re-dialing the proxy destination is retried
+type reconnectPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+func newReconnectPolicy(cfg *SpeedbumpCfg) *reconnectPolicy {
+	if !cfg.ReconnectBackend {
+		return nil
+	}
+	p := &reconnectPolicy{
+		attempts: cfg.ReconnectAttempts,
+		backoff:  cfg.ReconnectBackoff,
+	}
+	if p.attempts <= 0 {
+		p.attempts = defaultReconnectAttempts
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultReconnectBackoff
+	}
+	return p
+}
+
+// dest returns the current connection to the proxy destination
+// alongside its generation, which is incremented on each reconnect
+func (c *connection) dest() (io.ReadWriteCloser, int) {
+	c.destMu.Lock()
+	defer c.destMu.Unlock()
+	return c.destConn, c.destGen
+}
+
+// reconnectDest attempts to replace a failed connection to the proxy destination
+// with a new one according to the reconnect policy. It returns nil if the connection
+// was replaced (possibly by another goroutine) or the original cause otherwise.
+// The destination closing the connection cleanly (EOF) is not treated as a failure.
+func (c *connection) reconnectDest(failedGen int, cause error) error {
+	if c.reconnect == nil || errors.Is(cause, io.EOF) {
+		return cause
+	}
+	c.destMu.Lock()
+	if reconnecting := c.reconnecting; reconnecting != nil {
+		// another goroutine is already reconnecting, wait for its result
+		c.destMu.Unlock()
+		<-reconnecting
+		c.destMu.Lock()
+	}
+	if c.closed || c.reconnectFailed {
+		c.destMu.Unlock()
+		return cause
+	}
+	if c.destGen != failedGen {
+		// the connection was already replaced
+		c.destMu.Unlock()
+		return nil
+	}
+	reconnecting := make(chan struct{})
+	c.reconnecting = reconnecting
+	c.destConn.Close()
+	c.destMu.Unlock()
+
+	// the lock is not held while sleeping and dialing,
+	// so that the connection can be closed in the meantime
+	err := c.redial(cause)
+
+	c.destMu.Lock()
+	c.reconnecting = nil
+	c.reconnectFailed = err != nil
+	c.destMu.Unlock()
+	close(reconnecting)
+	return err
+}
+
+func (c *connection) redial(cause error) error {
+	c.warnLimiter.warn(c.log, "Connection to proxy destination failed, reconnecting", "err", cause)
+	for attempt := 1; attempt <= c.reconnect.attempts; attempt++ {
+		select {
+		case <-c.after(c.reconnect.backoff):
+		case <-c.ctx.Done():
+			return cause
+		}
+		destConn, err := c.dial()
+		if err != nil {
+			c.warnLimiter.warn(c.log, "Reconnecting to proxy destination failed", "attempt", attempt, "err", err)
+			continue
+		}
+		c.destMu.Lock()
+		defer c.destMu.Unlock()
+		if c.closed {
+			destConn.Close()
+			return cause
+		}
+		c.log.Info("Reconnected to proxy destination", "attempt", attempt)
+		c.destConn = destConn
+		c.destGen++
+		return nil
+	}
+	return cause
+}
@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewReconnectPolicy(t *testing.T) {
+	assert.Nil(t, newReconnectPolicy(&SpeedbumpCfg{}))
+
+	p := newReconnectPolicy(&SpeedbumpCfg{ReconnectBackend: true})
+	assert.Equal(t, defaultReconnectAttempts, p.attempts)
+	assert.Equal(t, defaultReconnectBackoff, p.backoff)
+
+	p = newReconnectPolicy(&SpeedbumpCfg{
+		ReconnectBackend:  true,
+		ReconnectAttempts: 5,
+		ReconnectBackoff:  time.Second,
+	})
+	assert.Equal(t, 5, p.attempts)
+	assert.Equal(t, time.Second, p.backoff)
+}
+
+func TestReadFromDestReconnectFails(t *testing.T) {
+	mockDest := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		readRes: []readReturn{
+			{0, []byte(""), errors.New("some-error")},
+		},
+		closeRes: []error{nil},
+	}
+
+	dialCount := 0
+	done := make(chan error, 3)
+	l, buf := newBufferLogger()
+
+	c := &connection{
+		destConn:   mockDest,
+		bufferSize: 20,
+		dial: func() (io.ReadWriteCloser, error) {
+			dialCount++
+			return nil, errors.New("dial-error")
+		},
+		reconnect:   &reconnectPolicy{attempts: 2, backoff: time.Millisecond},
+		done:        done,
+		ctx:         context.TODO(),
+		warnLimiter: newLogLimiter(time.Hour, nil, l),
+		log:         l,
+	}
+
+	c.readFromDest()
+
+	err := <-done
+
+	assert.Equal(t, 2, dialCount)
+	assert.Equal(t, 1, *mockDest.closeCount)
+	assert.EqualError(t, err, "Error reading data from proxy destination: some-error")
+	// repeated failures of the attempts are rate limited
+	lines := buf.lines()
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "Connection to proxy destination failed, reconnecting")
+	assert.Contains(t, lines[1], "Reconnecting to proxy destination failed: attempt=1")
+}
+
+func TestReadFromDelayQueueReconnect(t *testing.T) {
+	failingDest := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		writeRes: []writeReturn{
+			{0, errors.New("write-error")},
+		},
+		closeRes: []error{nil},
+	}
+	newDest := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		writeRes: []writeReturn{
+			{8, nil},
+			{0, errors.New("write-error")},
+		},
+		closeRes: []error{nil},
+	}
+
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+
+	c := &connection{
+		destConn:   failingDest,
+		reconnect:  &reconnectPolicy{attempts: 1, backoff: time.Millisecond},
+		delayQueue: delayQueue,
+		done:       done,
+		ctx:        context.TODO(),
+		log:        hclog.NewNullLogger(),
+	}
+	// the second destination connection fails as well, but there are no more dials to make
+	c.dial = func() (io.ReadWriteCloser, error) {
+		c.dial = func() (io.ReadWriteCloser, error) {
+			return nil, errors.New("dial-error")
+		}
+		return newDest, nil
+	}
+
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: time.Now()}
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: time.Now()}
+
+	c.readFromDelayQueue()
+
+	err := <-done
+
+	// the first buffer was retried on the new destination connection
+	assert.Equal(t, 1, *failingDest.writeCount)
+	assert.Equal(t, 2, *newDest.writeCount)
+	assert.Equal(t, 1, c.destGen)
+	assert.EqualError(t, err, "Error writing from delay queue to proxy destination: write-error")
+}
+
+func TestReconnectDestAfterClose(t *testing.T) {
+	c := &connection{
+		reconnect: &reconnectPolicy{attempts: 1, backoff: time.Millisecond},
+		closed:    true,
+	}
+	cause := errors.New("some-error")
+	assert.Equal(t, cause, c.reconnectDest(0, cause))
+}
+
+func TestReadFromDestNoReconnectOnEOF(t *testing.T) {
+	mockDest := mockConn{
+		readCount:  new(int),
+		writeCount: new(int),
+		closeCount: new(int),
+		readRes: []readReturn{
+			{0, []byte(""), io.EOF},
+		},
+		closeRes: []error{nil},
+	}
+
+	dialCount := 0
+	done := make(chan error, 3)
+
+	c := &connection{
+		destConn:   mockDest,
+		bufferSize: 20,
+		dial: func() (io.ReadWriteCloser, error) {
+			dialCount++
+			return nil, errors.New("dial-error")
+		},
+		reconnect: &reconnectPolicy{attempts: 2, backoff: time.Millisecond},
+		done:      done,
+		ctx:       context.TODO(),
+		log:       hclog.NewNullLogger(),
+	}
+
+	c.readFromDest()
+
+	err := <-done
+
+	assert.Equal(t, 0, dialCount)
+	assert.EqualError(t, err, "Error reading data from proxy destination: EOF")
+}
+
+func TestReconnectDestDoesNotBlockClose(t *testing.T) {
+	newMock := func() mockConn {
+		return mockConn{
+			readCount:  new(int),
+			writeCount: new(int),
+			closeCount: new(int),
+			closeRes:   []error{nil},
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &connection{
+		srcConn:   newMock(),
+		destConn:  newMock(),
+		reconnect: &reconnectPolicy{attempts: 1, backoff: time.Minute},
+		dial: func() (io.ReadWriteCloser, error) {
+			return nil, errors.New("dial-error")
+		},
+		ctx: ctx,
+		log: hclog.NewNullLogger(),
+	}
+
+	cause := errors.New("some-error")
+	result := make(chan error, 2)
+	go func() { result <- c.reconnectDest(0, cause) }()
+	// a second caller waits for the ongoing reconnect instead of starting another one
+	go func() { result <- c.reconnectDest(0, cause) }()
+
+	time.Sleep(time.Millisecond * 50)
+
+	closed := make(chan struct{})
+	go func() {
+		c.closeProxyConnections()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("closing the connection was blocked by the ongoing reconnect")
+	}
+
+	cancel()
+	assert.Equal(t, cause, <-result)
+	assert.Equal(t, cause, <-result)
+}
@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// defaultRefusedRetryAfter is the default retry-after suggested to clients
+// after the first refused dial
+const defaultRefusedRetryAfter = time.Second
+
+// maxRefusedDoublings caps the growth of the retry-after suggested to clients
+// while the proxy destination keeps refusing connections
+const maxRefusedDoublings = 6
+
+// RefusedMessageData is passed to the RefusedMessage template
+type RefusedMessageData struct {
+	// RetryAfter is the delay after which the client is suggested to retry
+	RetryAfter time.Duration
+	// RetryAfterSeconds is RetryAfter rounded up to whole seconds
+	RetryAfterSeconds int
+}
+
+// refusedHint writes RefusedMessage to clients whose proxy destination refused the
+// connection, suggesting a retry-after that doubles with each consecutive refused dial
+type refusedHint struct {
+	tmpl *template.Template
+	base time.Duration
+	// mu guards consecutive, the number of dials refused since the last successful one
+	mu          sync.Mutex
+	consecutive int
+}
+
+func newRefusedHint(message string, retryAfter time.Duration) (*refusedHint, error) {
+	if message == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("refused").Parse(message)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing refused message: %s", err)
+	}
+	if retryAfter <= 0 {
+		retryAfter = defaultRefusedRetryAfter
+	}
+	return &refusedHint{tmpl: tmpl, base: retryAfter}, nil
+}
+
+// refused records a refused dial, returning the retry-after to suggest
+func (h *refusedHint) refused() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	doublings := h.consecutive
+	if doublings > maxRefusedDoublings {
+		doublings = maxRefusedDoublings
+	}
+	h.consecutive++
+	return h.base << doublings
+}
+
+// dialed records a successful dial, which resets the retry-after
+func (h *refusedHint) dialed() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.consecutive = 0
+}
+
+func (h *refusedHint) render(retryAfter time.Duration) ([]byte, error) {
+	var buf bytes.Buffer
+	err := h.tmpl.Execute(&buf, RefusedMessageData{
+		RetryAfter:        retryAfter,
+		RetryAfterSeconds: int(math.Ceil(retryAfter.Seconds())),
+	})
+	return buf.Bytes(), err
+}
+
+// writeRefusedMessage writes RefusedMessage to a client if dialing its proxy destination
+// failed because the connection was refused
+func (s *Speedbump) writeRefusedMessage(conn net.Conn, dialErr error, l hclog.Logger) {
+	if s.refusedHint == nil || !errors.Is(dialErr, syscall.ECONNREFUSED) {
+		return
+	}
+	retryAfter := s.refusedHint.refused()
+	msg, err := s.refusedHint.render(retryAfter)
+	if err != nil {
+		s.warnLimiter.warn(l, "Rendering refused message failed", "err", err)
+		return
+	}
+	l.Debug("Suggesting proxy client to retry later", "retryAfter", retryAfter)
+	conn.SetWriteDeadline(time.Now().Add(shutdownMessageTimeout))
+	if _, err := writeFull(conn, msg); err != nil {
+		l.Debug("Writing refused message to proxy client failed", "err", err)
+	}
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefusedHint(t *testing.T) {
+	h, err := newRefusedHint("retry in {{.RetryAfter}} ({{.RetryAfterSeconds}}s)", time.Millisecond*1500)
+	assert.Nil(t, err)
+	msg, err := h.render(h.refused())
+	assert.Nil(t, err)
+	assert.Equal(t, "retry in 1.5s (2s)", string(msg))
+	assert.Equal(t, time.Second*3, h.refused())
+
+	// the retry-after stops growing at 64 times the initial one
+	for i := 0; i < 10; i++ {
+		h.refused()
+	}
+	assert.Equal(t, time.Millisecond*1500*64, h.refused())
+
+	h.dialed()
+	assert.Equal(t, time.Millisecond*1500, h.refused())
+}
+
+func TestNewRefusedHint(t *testing.T) {
+	h, err := newRefusedHint("", time.Second)
+	assert.Nil(t, h)
+	assert.Nil(t, err)
+
+	h, err = newRefusedHint("retry", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, defaultRefusedRetryAfter, h.base)
+
+	_, err = newRefusedHint("{{.RetryAfter", 0)
+	assert.Contains(t, err.Error(), "Error parsing refused message")
+}
+
+func TestSpeedbumpRefusedMessage(t *testing.T) {
+	cfg := SpeedbumpCfg{
+		Port:              8077,
+		DestAddr:          "localhost:9075",
+		BufferSize:        0xffff,
+		Latency:           defaultLatencyCfg,
+		LogLevel:          "ERROR",
+		RefusedMessage:    "-ERR backend unavailable, retry in {{.RetryAfterSeconds}}s\r\n",
+		RefusedRetryAfter: time.Second * 2,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	// received returns all data sent to a client before its connection got closed
+	received := func() string {
+		conn, err := net.Dial("tcp", "localhost:8077")
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		data, _ := io.ReadAll(conn)
+		return string(data)
+	}
+	// nothing listens on the destination's port, so dialing it is refused
+	assert.Equal(t, "-ERR backend unavailable, retry in 2s\r\n", received())
+	assert.Equal(t, "-ERR backend unavailable, retry in 4s\r\n", received())
+
+	// the retry-after is reset once the destination accepts a connection again
+	l, err := net.Listen("tcp", "localhost:9075")
+	assert.Nil(t, err)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	assert.Equal(t, "", received())
+	l.Close()
+	assert.Equal(t, "-ERR backend unavailable, retry in 2s\r\n", received())
+}
@@ -0,0 +1,35 @@
+package lib
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// reorderer decides whether a buffer released from the delay queue should swap
+// places with the one queued behind it. It's shared by all proxy connections.
+type reorderer struct {
+	rate float64
+	// mu guards rng, which isn't safe for concurrent use
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newReorderer(rate float64, seed int64) *reorderer {
+	if rate <= 0 {
+		return nil
+	}
+	return &reorderer{
+		rate: rate,
+		rng:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// swap reports whether the next buffer should be reordered (never if r is nil)
+func (r *reorderer) swap() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Float64() < r.rate
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReorderer(t *testing.T) {
+	assert.Nil(t, newReorderer(0, 1))
+	var r *reorderer
+	assert.False(t, r.swap())
+
+	r = newReorderer(1, 1)
+	assert.True(t, r.swap())
+}
+
+func TestReadFromDelayQueueReorder(t *testing.T) {
+	buffers := 2000
+	dest := &timedConn{limit: buffers}
+	delayQueue := make(chan transitBuffer, buffers+1)
+	done := make(chan error, 3)
+
+	c := &connection{
+		destConn:   dest,
+		delayQueue: delayQueue,
+		reorder:    newReorderer(0.2, 42),
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+
+	start := time.Now()
+	// buffers are identified by their size
+	for i := 1; i <= buffers; i++ {
+		delayQueue <- transitBuffer{data: make([]byte, i), delayUntil: start}
+	}
+	// the last write fails in order for readFromDelayQueue to return
+	delayQueue <- transitBuffer{data: []byte("testdata"), delayUntil: start}
+
+	c.readFromDelayQueue()
+	<-done
+
+	assert.Len(t, dest.sizes, buffers)
+	swaps := 0
+	i := 0
+	for ; i+1 < buffers; i++ {
+		if dest.sizes[i] == i+2 && dest.sizes[i+1] == i+1 {
+			// only adjacent buffers swap places
+			swaps++
+			i++
+			continue
+		}
+		if dest.sizes[i] != i+1 {
+			// the last buffer swapped places with the failing one
+			break
+		}
+	}
+	assert.GreaterOrEqual(t, i, buffers-2)
+	// each swap releases two buffers at once
+	rate := float64(swaps) / float64(i-swaps)
+	assert.InDelta(t, 0.2, rate, 0.03)
+}
+
+func TestNewSpeedbumpInvalidReorderRate(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:        8080,
+		DestAddr:    "localhost:1234",
+		BufferSize:  0xffff,
+		LogLevel:    "WARN",
+		ReorderRate: 1.5,
+	})
+	assert.Nil(t, s)
+	assert.EqualError(t, err, "Error configuring reordering: rate must be between 0 and 1")
+}
+
+func TestNewSpeedbumpInvalidJitterCorrelation(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8080,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		LogLevel:   "WARN",
+		Latency:    &LatencyCfg{GaussianStdDev: time.Millisecond, JitterCorrelation: 1},
+	})
+	assert.Nil(t, s)
+	assert.EqualError(t, err, "Error configuring latency: jitter correlation must be at least 0 and less than 1")
+}
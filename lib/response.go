@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+// ResponseLatencyRule adds latency to HTTP responses sent by the proxy destination
+// with a status code within a given range (i.e. 500-599 for a struggling backend)
+type ResponseLatencyRule struct {
+	// StatusMin is the lowest status code matched by the rule
+	StatusMin int `json:"statusMin" yaml:"statusMin"`
+	// StatusMax is the highest status code matched by the rule
+	StatusMax int `json:"statusMax" yaml:"statusMax"`
+	// Latency is the additional latency applied to matching responses
+	Latency time.Duration `json:"latency" yaml:"latency"`
+}
+
+var httpVersionPrefix = []byte("HTTP/1.")
+
+// parseResponseStatus returns the status code of an HTTP/1.x response
+// starting at the beginning of data (or 0 if data doesn't start with a status line)
+func parseResponseStatus(data []byte) int {
+	// i.e. "HTTP/1.1 200 "
+	if len(data) < 13 || !bytes.HasPrefix(data, httpVersionPrefix) || data[8] != ' ' || data[12] != ' ' {
+		return 0
+	}
+	status, err := strconv.Atoi(string(data[9:12]))
+	if err != nil {
+		return 0
+	}
+	return status
+}
+
+// responseLatency returns the additional latency of a response starting at the
+// beginning of data according to the first matching rule
+func responseLatency(rules []ResponseLatencyRule, data []byte) time.Duration {
+	if len(rules) == 0 {
+		return 0
+	}
+	status := parseResponseStatus(data)
+	if status == 0 {
+		return 0
+	}
+	for _, rule := range rules {
+		if status >= rule.StatusMin && status <= rule.StatusMax {
+			return rule.Latency
+		}
+	}
+	return 0
+}
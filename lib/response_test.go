@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResponseStatus(t *testing.T) {
+	assert.Equal(t, 200, parseResponseStatus([]byte("HTTP/1.1 200 OK\r\n\r\n")))
+	assert.Equal(t, 503, parseResponseStatus([]byte("HTTP/1.0 503 Service Unavailable\r\n")))
+	assert.Equal(t, 0, parseResponseStatus([]byte("HTTP/1.1 20")))
+	assert.Equal(t, 0, parseResponseStatus([]byte("HTTP/1.1 abc OK\r\n")))
+	assert.Equal(t, 0, parseResponseStatus([]byte("GET / HTTP/1.1\r\n")))
+	assert.Equal(t, 0, parseResponseStatus([]byte("some body data")))
+}
+
+func TestResponseLatency(t *testing.T) {
+	rules := []ResponseLatencyRule{
+		{StatusMin: 500, StatusMax: 599, Latency: time.Second},
+		{StatusMin: 400, StatusMax: 599, Latency: time.Millisecond},
+	}
+	assert.Equal(t, time.Second, responseLatency(rules, []byte("HTTP/1.1 500 Internal Server Error\r\n")))
+	assert.Equal(t, time.Millisecond, responseLatency(rules, []byte("HTTP/1.1 404 Not Found\r\n")))
+	assert.Equal(t, time.Duration(0), responseLatency(rules, []byte("HTTP/1.1 200 OK\r\n")))
+	assert.Equal(t, time.Duration(0), responseLatency(rules, []byte("body")))
+	assert.Equal(t, time.Duration(0), responseLatency(nil, []byte("HTTP/1.1 500 Internal Server Error\r\n")))
+}
+
+func TestSpeedbumpResponseLatency(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	backend := &http.Server{Handler: mux}
+	go backend.Serve(l)
+	defer backend.Close()
+
+	ruleLatency := time.Millisecond * 300
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Host:       "127.0.0.1",
+		Port:       0,
+		DestAddr:   l.Addr().String(),
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+		ResponseLatency: []ResponseLatencyRule{
+			{StatusMin: 500, StatusMax: 599, Latency: ruleLatency},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func(path string) (int, time.Duration) {
+		start := time.Now()
+		res, err := client.Get("http://" + s.dialAddr() + path)
+		assert.Nil(t, err)
+		res.Body.Close()
+		return res.StatusCode, time.Since(start)
+	}
+
+	status, okDuration := get("/ok")
+	assert.Equal(t, http.StatusOK, status)
+	status, failDuration := get("/fail")
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	// only the failed response is delayed by the rule, on top of the latency both of them get
+	assert.GreaterOrEqual(t, int64(failDuration), int64(ruleLatency))
+	assert.Greater(t, int64(failDuration-okDuration), int64(ruleLatency/2))
+}
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultRTTSmoothing is used if RTTLatencyCfg.Smoothing is unspecified (matching TCP's SRTT)
+const defaultRTTSmoothing = 0.125
+
+// RTTLatencyCfg describes an additional delay added to buffers read from the client that
+// is proportional to a smoothed estimate of the proxy destination's round-trip time, which
+// makes the emulated latency track the backend's real latency as it drifts. The estimate
+// is maintained per proxy connection from observable round trips: the time between writing
+// data to the proxy destination and the first data it sends back afterwards.
+type RTTLatencyCfg struct {
+	// Multiplier is the delay added per unit of the estimated round-trip time
+	// (i.e. 2 adds 100ms with an estimate of 50ms)
+	Multiplier float64 `json:"multiplier" yaml:"multiplier"`
+	// Smoothing is the weight of each new round-trip sample in the estimate
+	// within (0, 1] (defaults to 0.125)
+	Smoothing float64 `json:"smoothing" yaml:"smoothing"`
+	// Max caps the additional delay (no cap if unspecified)
+	Max time.Duration `json:"max" yaml:"max"`
+}
+
+// rttLatency maintains the smoothed round-trip time estimate of a single proxy connection,
+// which is updated by the goroutines writing to and reading from the proxy destination
+type rttLatency struct {
+	multiplier float64
+	smoothing  float64
+	max        time.Duration
+	// mu guards sentAt, which is the time of the first write not followed by a response
+	// yet, and srtt, which is the estimate (0 until the first round trip is observed)
+	mu     sync.Mutex
+	sentAt time.Time
+	srtt   time.Duration
+}
+
+func newRTTLatency(cfg *RTTLatencyCfg) *rttLatency {
+	if cfg == nil || cfg.Multiplier <= 0 {
+		return nil
+	}
+	smoothing := cfg.Smoothing
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = defaultRTTSmoothing
+	}
+	return &rttLatency{multiplier: cfg.Multiplier, smoothing: smoothing, max: cfg.Max}
+}
+
+// sent starts timing a round trip with data written to the proxy destination at a given
+// point in time, unless an earlier write is still waiting for a response
+func (r *rttLatency) sent(when time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sentAt.IsZero() {
+		r.sentAt = when
+	}
+}
+
+// received completes the round trip being timed (if any) with data read
+// from the proxy destination at a given point in time
+func (r *rttLatency) received(when time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sentAt.IsZero() {
+		return
+	}
+	sample := when.Sub(r.sentAt)
+	r.sentAt = time.Time{}
+	if r.srtt == 0 {
+		r.srtt = sample
+		return
+	}
+	r.srtt += time.Duration(r.smoothing * float64(sample-r.srtt))
+}
+
+// estimate returns the smoothed round-trip time estimate
+func (r *rttLatency) estimate() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.srtt
+}
+
+// next returns the additional delay of a buffer read from the client
+func (r *rttLatency) next() time.Duration {
+	if r == nil {
+		return 0
+	}
+	d := time.Duration(float64(r.estimate()) * r.multiplier)
+	if r.max > 0 && d > r.max {
+		d = r.max
+	}
+	return d
+}
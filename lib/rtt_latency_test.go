@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRTTLatencyEstimate(t *testing.T) {
+	r := newRTTLatency(&RTTLatencyCfg{Multiplier: 2, Smoothing: 0.5})
+	start := time.Unix(1000, 0)
+	assert.Equal(t, time.Duration(0), r.next())
+
+	// the first sample is taken as is
+	r.sent(start)
+	// writes made while waiting for a response don't restart the round trip
+	r.sent(start.Add(time.Millisecond * 10))
+	r.received(start.Add(time.Millisecond * 100))
+	assert.Equal(t, time.Millisecond*100, r.estimate())
+	assert.Equal(t, time.Millisecond*200, r.next())
+
+	// data sent by the destination on its own doesn't complete a round trip
+	r.received(start.Add(time.Second))
+	assert.Equal(t, time.Millisecond*100, r.estimate())
+
+	// later samples are smoothed
+	r.sent(start.Add(time.Second * 2))
+	r.received(start.Add(time.Second*2 + time.Millisecond*300))
+	assert.Equal(t, time.Millisecond*200, r.estimate())
+
+	r.max = time.Millisecond * 250
+	assert.Equal(t, time.Millisecond*250, r.next())
+}
+
+func TestRTTLatencyDisabled(t *testing.T) {
+	assert.Nil(t, newRTTLatency(nil))
+	assert.Nil(t, newRTTLatency(&RTTLatencyCfg{}))
+	assert.Equal(t, defaultRTTSmoothing, newRTTLatency(&RTTLatencyCfg{Multiplier: 1}).smoothing)
+	var r *rttLatency
+	r.sent(time.Now())
+	r.received(time.Now())
+	assert.Equal(t, time.Duration(0), r.next())
+}
+
+// startDelayedEchoSrv echoes data back after the number of milliseconds stored in delayMs
+func startDelayedEchoSrv(t *testing.T, addr string, delayMs *int64) {
+	srv, err := net.Listen("tcp", addr)
+	assert.Nil(t, err)
+	t.Cleanup(func() { srv.Close() })
+	go func() {
+		for {
+			conn, err := srv.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				buf := make([]byte, 1024)
+				for {
+					n, err := c.Read(buf)
+					if err != nil {
+						return
+					}
+					time.Sleep(time.Millisecond * time.Duration(atomic.LoadInt64(delayMs)))
+					c.Write(buf[:n])
+				}
+			}(conn)
+		}
+	}()
+}
+
+func TestSpeedbumpRTTLatency(t *testing.T) {
+	delayMs := int64(20)
+	startDelayedEchoSrv(t, "localhost:9068", &delayMs)
+
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8070,
+		DestAddr:   "localhost:9068",
+		BufferSize: 0xffff,
+		LogLevel:   "ERROR",
+		RTTLatency: &RTTLatencyCfg{Multiplier: 1, Smoothing: 0.5},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8070")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// roundTrip returns the delay injected into a request sent by the client
+	roundTrip := func() time.Duration {
+		before := s.ConnStats()
+		conn.Write([]byte("ping"))
+		_, err := io.ReadFull(conn, make([]byte, 4))
+		assert.Nil(t, err)
+		after := s.ConnStats()
+		if len(before) == 0 {
+			return after[0].LatencyDelay
+		}
+		return after[0].LatencyDelay - before[0].LatencyDelay
+	}
+
+	for i := 0; i < 5; i++ {
+		roundTrip()
+	}
+	// samples include the time spent by the proxy itself, so they may exceed the backend's delay
+	fast := roundTrip()
+	assert.GreaterOrEqual(t, fast, time.Millisecond*20)
+	assert.Less(t, fast, time.Millisecond*100)
+
+	// the injected latency follows the backend as it slows down mid-connection
+	atomic.StoreInt64(&delayMs, 100)
+	for i := 0; i < 6; i++ {
+		roundTrip()
+	}
+	slow := roundTrip()
+	assert.GreaterOrEqual(t, slow, time.Millisecond*95)
+	assert.Greater(t, slow, fast)
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"context"
+	"time"
+)
+
+// RunDegradeScenario scripts a common test run on top of ArmLatency: new connections
+// are proxied with the current latency for the warmup period, then with the base latency
+// raised to degradeLatency for degradeDuration, after which the original latency is
+// restored for the recover period. It blocks until the scenario ends or ctx is canceled,
+// in which case the original latency is restored right away and ctx's error is returned.
+func (s *Speedbump) RunDegradeScenario(ctx context.Context, warmup, degradeLatency, degradeDuration, recover time.Duration) error {
+	original := s.latencyCfg()
+	degraded := &LatencyCfg{}
+	if original != nil {
+		*degraded = *original
+	}
+	degraded.Base = degradeLatency
+
+	s.log.Info("Running degrade scenario", "warmup", warmup, "latency", degradeLatency, "duration", degradeDuration, "recover", recover)
+	if err := s.waitScenario(ctx, warmup); err != nil {
+		return err
+	}
+	s.ArmLatency(degraded)
+	if err := s.waitScenario(ctx, degradeDuration); err != nil {
+		s.ArmLatency(original)
+		return err
+	}
+	s.ArmLatency(original)
+	return s.waitScenario(ctx, recover)
+}
+
+// waitScenario waits for a given duration according to the instance's clock
+// unless ctx is done first
+func (s *Speedbump) waitScenario(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-s.clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
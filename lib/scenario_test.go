@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scenarioClock records the latency armed for new connections at each wait and,
+// once the wait with index blockAt is reached, cancels the scenario instead of advancing
+type scenarioClock struct {
+	fakeClock
+	s       *Speedbump
+	waits   []time.Duration
+	armed   []*LatencyCfg
+	blockAt int
+	cancel  context.CancelFunc
+}
+
+func (c *scenarioClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	c.armed = append(c.armed, c.s.latencyCfg())
+	if len(c.waits)-1 == c.blockAt {
+		c.cancel()
+		return nil
+	}
+	return c.fakeClock.After(d)
+}
+
+func newScenarioSpeedbump(t *testing.T, latency *LatencyCfg) *Speedbump {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8046,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		Latency:    latency,
+		LogLevel:   "WARN",
+	})
+	assert.Nil(t, err)
+	return s
+}
+
+func TestRunDegradeScenario(t *testing.T) {
+	s := newScenarioSpeedbump(t, nil)
+	clock := &scenarioClock{s: s, blockAt: -1, fakeClock: fakeClock{now: time.Unix(0, 0)}}
+	s.clock = clock
+
+	err := s.RunDegradeScenario(context.Background(), time.Minute, time.Millisecond*300, time.Minute*2, time.Minute*3)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []time.Duration{time.Minute, time.Minute * 2, time.Minute * 3}, clock.waits)
+	// no latency during warmup, elevated during degrade and none after recovery
+	assert.Nil(t, clock.armed[0])
+	assert.Equal(t, &LatencyCfg{Base: time.Millisecond * 300}, clock.armed[1])
+	assert.Nil(t, clock.armed[2])
+	assert.Nil(t, s.latencyCfg())
+	assert.Equal(t, time.Unix(0, 0).Add(time.Minute*6), clock.now)
+}
+
+func TestRunDegradeScenarioKeepsLatencyParams(t *testing.T) {
+	s := newScenarioSpeedbump(t, &LatencyCfg{Base: time.Millisecond * 10, SineAmplitude: time.Millisecond * 5})
+	clock := &scenarioClock{s: s, blockAt: -1}
+	s.clock = clock
+
+	assert.Nil(t, s.RunDegradeScenario(context.Background(), time.Second, time.Second, time.Second, time.Second))
+	assert.Equal(t, &LatencyCfg{Base: time.Second, SineAmplitude: time.Millisecond * 5}, clock.armed[1])
+	assert.Equal(t, &LatencyCfg{Base: time.Millisecond * 10, SineAmplitude: time.Millisecond * 5}, s.latencyCfg())
+}
+
+func TestRunDegradeScenarioCanceled(t *testing.T) {
+	s := newScenarioSpeedbump(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	// canceled while degraded
+	clock := &scenarioClock{s: s, blockAt: 1, cancel: cancel}
+	s.clock = clock
+
+	err := s.RunDegradeScenario(ctx, time.Second, time.Second, time.Minute, time.Second)
+	assert.Equal(t, context.Canceled, err)
+	assert.Len(t, clock.waits, 2)
+	assert.NotNil(t, clock.armed[1])
+	// the original latency is restored right away
+	assert.Nil(t, s.latencyCfg())
+
+	// a canceled context aborts the scenario before it starts
+	assert.Equal(t, context.Canceled, s.RunDegradeScenario(ctx, time.Second, time.Second, time.Second, time.Second))
+	assert.Len(t, clock.waits, 2)
+}
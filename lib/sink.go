@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"io"
+	"sync"
+)
+
+// sinkConn is used in place of a proxy destination connection in sink mode.
+// Writes are discarded, while reads block until the connection is closed.
+type sinkConn struct {
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newSinkConn() *sinkConn {
+	return &sinkConn{closed: make(chan struct{})}
+}
+
+func (s *sinkConn) Read(b []byte) (int, error) {
+	<-s.closed
+	return 0, io.EOF
+}
+
+func (s *sinkConn) Write(b []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, io.ErrClosedPipe
+	default:
+		return len(b), nil
+	}
+}
+
+func (s *sinkConn) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
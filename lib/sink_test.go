@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSinkConn(t *testing.T) {
+	s := newSinkConn()
+	n, err := s.Write([]byte("discarded"))
+	assert.Nil(t, err)
+	assert.Equal(t, 9, n)
+
+	read := make(chan error)
+	go func() {
+		_, err := s.Read(make([]byte, 16))
+		read <- err
+	}()
+	select {
+	case <-read:
+		t.Fatal("reading from a sink returned before it was closed")
+	case <-time.After(time.Millisecond * 50):
+	}
+	s.Close()
+	assert.Equal(t, "EOF", (<-read).Error())
+	_, err = s.Write([]byte("late"))
+	assert.NotNil(t, err)
+}
+
+func TestSpeedbumpSink(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:               8054,
+		BufferSize:         0xffff,
+		Latency:            &LatencyCfg{Base: time.Millisecond * 50},
+		LogLevel:           "ERROR",
+		Mode:               ModeSink,
+		Bandwidth:          10000,
+		BandwidthAlgorithm: BandwidthLeakyBucket,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8054")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	msg := make([]byte, 1000)
+	for i := 0; i < 3; i++ {
+		_, err := conn.Write(msg)
+		assert.Nil(t, err)
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	// all the data gets read without a proxy destination
+	assert.Eventually(t, func() bool {
+		conns := s.ConnStats()
+		return len(conns) == 1 && conns[0].Bytes.ClientToServer == 3000
+	}, time.Second, time.Millisecond*10)
+	// releasing 1000 bytes at 10KB/s takes 100ms, so the second (released 20ms after
+	// the first one) and the third buffer wait for the previous ones to be discarded
+	assert.Eventually(t, func() bool {
+		return s.ConnStats()[0].BandwidthDelay >= time.Millisecond*150
+	}, time.Second, time.Millisecond*10)
+	stats := s.ConnStats()[0]
+	assert.GreaterOrEqual(t, stats.LatencyDelay, time.Millisecond*50)
+	assert.Equal(t, int64(0), stats.Bytes.ServerToClient)
+}
+
+func TestSpeedbumpSinkWithDestAddr(t *testing.T) {
+	// the destination is never dialed, even if specified
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8055,
+		DestAddr:   "localhost:1",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "ERROR",
+		Mode:       ModeSink,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8055")
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.Write([]byte("test"))
+	assert.Eventually(t, func() bool {
+		conns := s.ConnStats()
+		return len(conns) == 1 && conns[0].Bytes.ClientToServer == 4
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestNewSpeedbumpUnknownMode(t *testing.T) {
+	_, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:       8000,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "ERROR",
+		Mode:       "mirror",
+	})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error configuring mode"))
+
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// sniAllowlist contains the server names accepted from clients (see AllowedSNIs)
+type sniAllowlist map[string]struct{}
+
+func newSNIAllowlist(names []string) sniAllowlist {
+	if len(names) == 0 {
+		return nil
+	}
+	allowed := make(sniAllowlist, len(names))
+	for _, name := range names {
+		allowed[normalizeServerName(name)] = struct{}{}
+	}
+	return allowed
+}
+
+// allows reports whether a server name sent by a client is on the allowlist
+func (a sniAllowlist) allows(name string) bool {
+	if name == "" {
+		return false
+	}
+	_, ok := a[normalizeServerName(name)]
+	return ok
+}
+
+// normalizeServerName makes server names comparable, as DNS names are case-insensitive
+// and may be fully qualified
+func normalizeServerName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// readSNI peeks at the ClientHello sent by the client in order to extract the server name
+// sent via SNI. No name is returned for clients that don't start a TLS handshake, send
+// no SNI or exceed the preamble limits. The bytes read are replayed on subsequent reads
+// of the returned connection.
+func readSNI(ctx context.Context, conn net.Conn, limits preambleLimits) (*bufferedConn, string) {
+	return readServerName(ctx, conn, limits, parseSNI)
+}
+
+// parseSNI extracts the server name from a ClientHello. It returns false if more bytes are needed.
+func parseSNI(data []byte) (string, bool) {
+	if len(data) == 0 {
+		return "", false
+	}
+	if data[0] != tlsRecordTypeHandshake {
+		return "", true
+	}
+	return parseVirtualHost(data)
+}
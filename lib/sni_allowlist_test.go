@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSNI(t *testing.T) {
+	name, done := parseSNI(clientHello("example.test"))
+	assert.True(t, done)
+	assert.Equal(t, "example.test", name)
+
+	// the Host header of plaintext requests doesn't count as a server name
+	name, done = parseSNI([]byte("GET / HTTP/1.1\r\nHost: example.test\r\n\r\n"))
+	assert.True(t, done)
+	assert.Equal(t, "", name)
+}
+
+func TestSNIAllowlist(t *testing.T) {
+	allowed := newSNIAllowlist([]string{"Example.test", "api.example.test."})
+	assert.True(t, allowed.allows("example.test"))
+	assert.True(t, allowed.allows("API.example.test"))
+	assert.False(t, allowed.allows("other.test"))
+	assert.False(t, allowed.allows(""))
+	assert.Nil(t, newSNIAllowlist(nil))
+}
+
+func TestSpeedbumpAllowedSNIs(t *testing.T) {
+	startTLSEchoSrv(t, 9070)
+
+	cfg := SpeedbumpCfg{
+		Port:            8072,
+		DestAddr:        "localhost:9070",
+		BufferSize:      0xffff,
+		Latency:         defaultLatencyCfg,
+		LogLevel:        "ERROR",
+		AllowedSNIs:     []string{"allowed.test"},
+		PreambleTimeout: time.Millisecond * 100,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := tls.Dial("tcp", "localhost:8072", &tls.Config{ServerName: "allowed.test", InsecureSkipVerify: true})
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.Write([]byte("test-string"))
+	res := make([]byte, 1024)
+	n, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:n])
+
+	// the handshake of a client requesting another server name fails, as its connection is closed
+	_, err = tls.Dial("tcp", "localhost:8072", &tls.Config{ServerName: "denied.test", InsecureSkipVerify: true})
+	assert.NotNil(t, err)
+
+	// plaintext clients send no server name
+	plain, err := net.Dial("tcp", "localhost:8072")
+	assert.Nil(t, err)
+	defer plain.Close()
+	plain.Write([]byte("test-string"))
+	_, err = plain.Read(res)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 2, s.Stats().SNIRejections)
+}
@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// sourceConn is used in place of a proxy destination connection in source mode.
+// Reads return a repeated pattern (or random bytes if it's empty) until the limit
+// of bytes is reached, while writes are discarded.
+type sourceConn struct {
+	pattern []byte
+	// offset is the position in pattern at which the next read starts
+	offset int
+	// remaining is the number of bytes left to be read (negative if unlimited)
+	remaining int64
+	rng       *rand.Rand
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newSourceConn(pattern []byte, limit int64) *sourceConn {
+	remaining := limit
+	if limit == 0 {
+		remaining = -1
+	}
+	return &sourceConn{
+		pattern:   pattern,
+		remaining: remaining,
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (s *sourceConn) Read(b []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, io.EOF
+	default:
+	}
+	if s.remaining == 0 {
+		return 0, io.EOF
+	}
+	n := len(b)
+	if s.remaining > 0 && int64(n) > s.remaining {
+		n = int(s.remaining)
+	}
+	if len(s.pattern) == 0 {
+		s.rng.Read(b[:n])
+	} else {
+		for i := 0; i < n; {
+			copied := copy(b[i:n], s.pattern[s.offset:])
+			s.offset = (s.offset + copied) % len(s.pattern)
+			i += copied
+		}
+	}
+	if s.remaining > 0 {
+		s.remaining -= int64(n)
+	}
+	return n, nil
+}
+
+func (s *sourceConn) Write(b []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, io.ErrClosedPipe
+	default:
+		return len(b), nil
+	}
+}
+
+func (s *sourceConn) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceConnPattern(t *testing.T) {
+	s := newSourceConn([]byte("abc"), 7)
+	buf := make([]byte, 5)
+	n, err := s.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "abcab", string(buf[:n]))
+	n, err = s.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ca", string(buf[:n]))
+	_, err = s.Read(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSourceConnRandom(t *testing.T) {
+	s := newSourceConn(nil, 0)
+	buf := make([]byte, 1024)
+	for i := 0; i < 10; i++ {
+		n, err := s.Read(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, 1024, n)
+	}
+	assert.NotEqual(t, make([]byte, 1024), buf)
+
+	// writes are discarded until the connection is closed
+	_, err := s.Write([]byte("discarded"))
+	assert.Nil(t, err)
+	s.Close()
+	_, err = s.Read(buf)
+	assert.Equal(t, io.EOF, err)
+	_, err = s.Write([]byte("late"))
+	assert.NotNil(t, err)
+}
+
+func TestSpeedbumpSource(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:               8056,
+		BufferSize:         1000,
+		Latency:            &LatencyCfg{Base: time.Millisecond * 50},
+		LogLevel:           "ERROR",
+		Mode:               ModeSource,
+		SourcePattern:      []byte("0123456789"),
+		SourceBytes:        3000,
+		Bandwidth:          10000,
+		BandwidthAlgorithm: BandwidthLeakyBucket,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	start := time.Now()
+	conn, err := net.Dial("tcp", "localhost:8056")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// the connection is closed once all the generated data is delivered
+	res, err := io.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("0123456789"), 300), res)
+	// each 1000 byte buffer is delayed by 50ms and takes 100ms to release at 10KB/s
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*250)
+	assert.Less(t, time.Since(start), time.Millisecond*800)
+}
+
+func TestSpeedbumpSourceRandom(t *testing.T) {
+	s, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:        8057,
+		BufferSize:  0xffff,
+		Latency:     defaultLatencyCfg,
+		LogLevel:    "ERROR",
+		Mode:        ModeSource,
+		SourceBytes: 100000,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8057")
+	assert.Nil(t, err)
+	defer conn.Close()
+	res, err := io.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Len(t, res, 100000)
+}
+
+func TestNewSpeedbumpSourceBytesError(t *testing.T) {
+	_, err := NewSpeedbump(&SpeedbumpCfg{
+		Port:        8000,
+		BufferSize:  0xffff,
+		Latency:     defaultLatencyCfg,
+		LogLevel:    "ERROR",
+		Mode:        ModeSource,
+		SourceBytes: -1,
+	})
+	assert.True(t, strings.HasPrefix(err.Error(), "Error configuring mode"))
+}
@@ -2,10 +2,15 @@
 package lib
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"strings"
+	"net/http"
+	"os"
 	"sync"
 	"time"
 
@@ -14,14 +19,130 @@ import (
 
 // Speedbump is a proxy instance returned by NewSpeedbump
 type Speedbump struct {
+	// cfg is the effective configuration of the instance
+	cfg               SpeedbumpCfg
 	bufferSize        int
+	pool              *bufferPool
+	queueMem          *queueMemory
+	padBytes          int
+	coalesceWindow    time.Duration
 	queueSize         int
+	drainWindow       time.Duration
+	maxQueueAge       time.Duration
+	drainBatchSize    int
 	srcAddr, destAddr net.TCPAddr
-	listener          *net.TCPListener
-	latencyGen        LatencyGenerator
-	nextConnId        int
-	// active keeps track of proxy connections that are running
-	active sync.WaitGroup
+	// connOpts holds the settings passed to every proxy connection
+	connOpts connectionOpts
+	// portRange optionally contains the ports tried by Start() in place of srcAddr's
+	portRange   *portRange
+	tlsDestAddr *net.TCPAddr
+	// fingerprintRoutes contains the resolved FingerprintRoutes by label
+	fingerprintRoutes map[string]*net.TCPAddr
+	tlsDetectTimeout  time.Duration
+	backendTLS        *tls.Config
+	// localBackend optionally creates the connections used in place of the proxy destination
+	// (see ModeSink and ModeSource)
+	localBackend func() io.ReadWriteCloser
+	listener     *net.TCPListener
+	// clock is used for timing connections, accepts and scripted scenarios
+	clock Clock
+	// latencyMu serializes replacing cfg.Latency (along with latencyGen, and cfg.Bandwidth
+	// if LinkWindow is set) and guards disabledLatency, as well as destAddr
+	// and cfg.DestAddr, which get replaced by SetDestination
+	latencyMu sync.Mutex
+	// latencyGen holds the latency generator and bandwidth limit of new connections,
+	// which are swapped by ArmLatency without blocking accepts
+	latencyGen *latencyGenSlot
+	// disabledLatency holds the latency config replaced by Disable (nil if there's none)
+	disabledLatency *LatencyCfg
+	// returnLatencyGen delays data sent back by the proxy destination (nil if disabled)
+	returnLatencyGen LatencyGenerator
+	// generatorDeadline optionally bounds the latency generation of each buffer
+	generatorDeadline time.Duration
+	// profiles contains latency generators by the names of latency profiles,
+	// while destLatencies contains the ones by proxy destination
+	profiles map[string]LatencyGenerator
+	// allowedSNIs contains the normalized AllowedSNIs (nil if unrestricted)
+	allowedSNIs       sniAllowlist
+	destLatencies     map[string]LatencyGenerator
+	preamble          preambleLimits
+	stall             *stallSchedule
+	ramp              *DelayRampCfg
+	slowStart         *SlowStartCfg
+	idleLatency       *IdleLatencyCfg
+	rttLatency        *RTTLatencyCfg
+	latencyBudget     time.Duration
+	minLatency        time.Duration
+	compressionDelay  time.Duration
+	responseRules     []ResponseLatencyRule
+	maxChunkSize      int
+	pmtuDropAfter     time.Duration
+	pmtuDropChunkSize int
+	reorder           *reorderer
+	freeze            *directionFreeze
+	dialTimeout       time.Duration
+	closeLinger       time.Duration
+	acceptIdleTimeout time.Duration
+	acceptTimeline    []time.Duration
+	statsWarmup       time.Duration
+	// startedAt is set by Start()
+	startedAt time.Time
+	// quota caps the number of accepted connections (nil if unlimited)
+	quota *connQuota
+	// refusedHint optionally renders RefusedMessage (nil if disabled)
+	refusedHint *refusedHint
+	// latencyFlag polls LatencyFlagProvider (nil if unset)
+	latencyFlag *latencyFlag
+	// destHealth contains the healthy addresses of the proxy destination (nil if not health checked)
+	destHealth *destHealth
+	// probeDial is used for probing the proxy destination on startup
+	probeDial  func(ctx context.Context, network, addr string) (net.Conn, error)
+	backendRTT time.Duration
+	// backendSlots is used as a semaphore limiting connections to the proxy destination
+	backendSlots        chan struct{}
+	backendQueueTimeout time.Duration
+	reconnect           *reconnectPolicy
+	readRetry           *readRetryPolicy
+	shutdownMessage     []byte
+	onTimeout           func(err error)
+	connContext         func(ctx context.Context, remote net.Addr) context.Context
+	destinationFunc     func(remote net.Addr) (string, error)
+	connTrace           ConnTraceFunc
+	connBatcher         *connBatcher
+	warnLimiter         *logLimiter
+	logSampler          *logSampler
+	adminAddr           string
+	adminServer         *http.Server
+	adminListener       net.Listener
+	acceptWorkers       int
+	acceptJitter        *acceptJitter
+	// acceptMu guards nextConnId, lastAccepted and acceptDeadline,
+	// which are shared by accept workers and the accept idle watcher
+	acceptMu       sync.Mutex
+	nextConnId     int
+	lastAccepted   time.Time
+	acceptDeadline time.Time
+	// conns contains active proxy connections by ID and is guarded by connsMu
+	conns   map[int]*connection
+	connsMu sync.Mutex
+	// timelines contains the timelines of active and recently closed connections by ID
+	// (in the order of closing in closedTimelines) and is guarded by timelinesMu
+	timelines       map[int]*connTimeline
+	closedTimelines []int
+	timelinesMu     sync.Mutex
+	// stats and the histograms are guarded by statsMu
+	stats            Stats
+	connDurations    *durationHistogram
+	acceptIntervals  *durationHistogram
+	acceptProcessing *durationHistogram
+	queueWait        *durationHistogram
+	statsMu          sync.Mutex
+	// active keeps track of proxy connections that are running, while activeCount
+	// counts them and draining is set once Stop() waits for them (both guarded by activeMu)
+	active      sync.WaitGroup
+	activeCount int
+	draining    bool
+	activeMu    sync.Mutex
 	// ctx is used for notifying proxy connections once Stop() is invoked
 	ctx       context.Context
 	ctxCancel context.CancelFunc
@@ -31,19 +152,425 @@ type Speedbump struct {
 // SpeedbumpCfg contains Spedbump instance configuration
 type SpeedbumpCfg struct {
 	// IP or a hostname to listen on (binds to all network interfaces if unspecified)
-	Host string
+	Host string `json:"host" yaml:"host"`
 	// Port specifies the local port number to listen on
-	Port int
+	Port int `json:"port" yaml:"port"`
+	// PortRangePreferred optionally specifies a range of ports in start-end format (i.e. 9000-9099),
+	// which Start() tries to listen on in order, using the first available one in place of Port.
+	// The chosen port is reported by Addr().
+	PortRangePreferred string `json:"portRangePreferred" yaml:"portRangePreferred"`
 	// DestAddr specifies the proxy desination address in host:port format
-	DestAddr string
+	DestAddr string `json:"destAddr" yaml:"destAddr"`
+	// Mode can be one of: proxy (default), sink, source. In sink mode, data sent by clients
+	// is delayed and throttled as usual, but then discarded without connecting to
+	// the proxy destination (DestAddr is optional), which measures how fast clients
+	// can push data under the configured conditions. In source mode, generated data
+	// (see SourcePattern) is streamed to clients in place of the proxy destination's,
+	// delayed by Latency unless ServerToClientLatency is specified.
+	Mode string `json:"mode" yaml:"mode"`
+	// SourcePattern is repeated in the data streamed to clients in source mode
+	// (random bytes are streamed if unspecified)
+	SourcePattern []byte `json:"sourcePattern" yaml:"sourcePattern"`
+	// SourceBytes optionally limits the number of bytes streamed to each client in
+	// source mode, after which the connection is closed (unlimited if unspecified)
+	SourceBytes int64 `json:"sourceBytes" yaml:"sourceBytes"`
+	// TLSDestAddr optionally specifies a separate destination address (in host:port format)
+	// for TLS connections. If set, the first byte sent by each client is inspected
+	// in order to detect a TLS handshake and route the connection accordingly.
+	TLSDestAddr string `json:"tlsDestAddr" yaml:"tlsDestAddr"`
+	// TLSDetectTimeout specifies how long to wait for the first byte sent by the client
+	// when TLSDestAddr is set. Clients that send nothing within it (i.e. ones using
+	// server-speaks-first protocols) are proxied to DestAddr (defaults to 1s).
+	TLSDetectTimeout time.Duration `json:"tlsDetectTimeout" yaml:"tlsDetectTimeout"`
 	// BufferSize specifies the number of bytes in a buffer used for TCP reads
-	BufferSize int
+	BufferSize int `json:"bufferSize" yaml:"bufferSize"`
 	// The size of the delay queue containing read buffers (defaults to 1024)
-	QueueSize int
+	QueueSize int `json:"queueSize" yaml:"queueSize"`
+	// QueueDrainWindow optionally enables batching of the delay queue's timer wakeups,
+	// so that all buffers due within the same window are released in one wakeup.
+	// This reduces timer churn at high throughput at the cost of up to QueueDrainWindow
+	// of additional delay.
+	QueueDrainWindow time.Duration `json:"queueDrainWindow" yaml:"queueDrainWindow"`
+	// MaxQueueAge optionally enables evicting buffers that waited in the delay queue for longer
+	// than MaxQueueAge past their release time because writing to the proxy destination
+	// was blocked, simulating a link that gives up on stale data (ignored with DebugSerial)
+	MaxQueueAge time.Duration `json:"maxQueueAge" yaml:"maxQueueAge"`
+	// DrainBatchSize optionally limits the number of due buffers a connection releases from
+	// its delay queue in a row before yielding to other goroutines (unlimited if unspecified).
+	// Small values favor fairness across many connections with a backlog of due buffers
+	// (i.e. released at once with QueueDrainWindow), while large ones favor the throughput
+	// of a single connection (ignored with DebugSerial).
+	DrainBatchSize int `json:"drainBatchSize" yaml:"drainBatchSize"`
 	// LatencyCfg specifies parameters of the desired latency summands
-	Latency *LatencyCfg
+	// (if nil, no latency is added and the proxy acts as a plain TCP forwarder)
+	Latency *LatencyCfg `json:"latency" yaml:"latency"`
+	// ServerToClientLatency optionally specifies the latency summands added to data sent back
+	// by the proxy destination, configured independently of Latency (which only affects data
+	// sent by the client). It's not affected by ArmLatency or LatencyProfiles.
+	ServerToClientLatency *LatencyCfg `json:"serverToClientLatency" yaml:"serverToClientLatency"`
+	// LatencyProfiles optionally contains named latency configs selectable by clients.
+	// If specified, the first line sent by each client must name one of the profiles,
+	// which is then used in place of Latency for its connection. The line is stripped
+	// from the data forwarded to the proxy destination, while clients naming an unknown
+	// profile get disconnected. Profiles are not affected by ArmLatency.
+	LatencyProfiles map[string]LatencyCfg `json:"latencyProfiles" yaml:"latencyProfiles"`
+	// DestinationLatency optionally contains latency configs by proxy destination (in host:port
+	// format, as specified in DestAddr, TLSDestAddr or returned by DestinationFunc), which are
+	// used in place of Latency for connections proxied to a given destination (a nil config
+	// disables latency). Latency profiles requested by clients take precedence. It's not
+	// affected by ArmLatency.
+	DestinationLatency map[string]*LatencyCfg `json:"destinationLatency" yaml:"destinationLatency"`
+	// LabelVirtualHosts makes the initial bytes sent by each client get inspected in order
+	// to extract the server name sent via SNI (TLS) or the Host header (HTTP/1.x), which labels
+	// the connection's stats and logs. Clients that send neither within the preamble limits
+	// are proxied without a label.
+	LabelVirtualHosts bool `json:"labelVirtualHosts" yaml:"labelVirtualHosts"`
+	// AllowedSNIs optionally restricts the server names clients may request via SNI. The ClientHello
+	// of each client is inspected right after accepting its connection, which gets closed (and counted
+	// in Stats.SNIRejections) unless it names one of the allowed servers. Names are matched
+	// case-insensitively, while clients that don't start a TLS handshake, send no SNI or exceed
+	// the preamble limits are rejected.
+	AllowedSNIs []string `json:"allowedSNIs" yaml:"allowedSNIs"`
+	// DirectionClassifier optionally tells whether each buffer (read from either side of
+	// a connection) is a request or a response based on its content, i.e. markers of
+	// a multiplexed or tunneled protocol. The latency of buffers classified as ClientToServer
+	// is generated by Latency (or the connection's profile or destination latency), while
+	// that of ServerToClient ones is generated by ServerToClientLatency (none if unspecified),
+	// regardless of the side of the connection they were read from. Buffers are still
+	// forwarded in their physical direction. It's invoked concurrently by all connections.
+	DirectionClassifier func(b []byte) Direction `json:"-" yaml:"-"`
+	// FingerprintFunc optionally identifies the protocol of each connection based on the
+	// greeting sent by its client: the bytes up to the end of the first line, or whatever
+	// was sent once the preamble limits are reached (clients of server-speaks-first protocols
+	// such as SMTP send nothing, so they're only fingerprinted after PreambleTimeout passes).
+	// The greeting is replayed to the proxy destination. The returned label is included in the
+	// connection's stats and logs and selects its destination from FingerprintRoutes.
+	// FingerprintProtocol can be used as a built-in fingerprinter.
+	FingerprintFunc FingerprintFunc `json:"-" yaml:"-"`
+	// FingerprintRoutes optionally contains proxy destinations (in host:port format) by the labels
+	// returned by FingerprintFunc, overriding DestAddr and DestinationFunc. Connections whose
+	// label has no route are proxied to their destination as usual.
+	FingerprintRoutes map[string]string `json:"fingerprintRoutes" yaml:"fingerprintRoutes"`
+	// PreambleTimeout limits the time within which clients have to send the preamble
+	// read by peek-based modes such as LatencyProfiles, LabelVirtualHosts, AllowedSNIs or FingerprintFunc
+	// (defaults to 5s)
+	PreambleTimeout time.Duration `json:"preambleTimeout" yaml:"preambleTimeout"`
+	// MaxPreambleBytes limits the size of the preamble read by peek-based modes
+	// (defaults to 4096). Clients exceeding either limit get disconnected.
+	MaxPreambleBytes int `json:"maxPreambleBytes" yaml:"maxPreambleBytes"`
 	// LogLevel can be one of: DEBUG, TRACE, INFO, WARN, ERROR
-	LogLevel string
+	LogLevel string `json:"logLevel" yaml:"logLevel"`
+	// Stall optionally specifies a schedule of periodic stalls of one direction of traffic
+	Stall *StallCfg `json:"stall" yaml:"stall"`
+	// DelayRamp optionally adds a delay that grows with each buffer read from the client
+	// within a proxy connection (on top of Latency)
+	DelayRamp *DelayRampCfg `json:"delayRamp" yaml:"delayRamp"`
+	// SlowStart optionally adds a delay to buffers read from the client early in the life
+	// of a proxy connection, which decays over time (on top of Latency)
+	SlowStart *SlowStartCfg `json:"slowStart" yaml:"slowStart"`
+	// IdleLatency optionally adds a delay to buffers read from the client that grows
+	// with how long the connection was idle before them (on top of Latency)
+	IdleLatency *IdleLatencyCfg `json:"idleLatency" yaml:"idleLatency"`
+	// RTTLatency optionally adds a delay to buffers read from the client that is proportional
+	// to each connection's smoothed estimate of the proxy destination's round-trip time
+	// (on top of Latency), adapting as the backend's real latency drifts
+	RTTLatency *RTTLatencyCfg `json:"rttLatency" yaml:"rttLatency"`
+	// LatencyBudget optionally caps the total latency injected into a single proxy connection
+	// (Latency, DelayRamp, IdleLatency and ServerToClientLatency combined), after which
+	// its buffers pass through without delay. Each connection gets its own budget.
+	LatencyBudget time.Duration `json:"latencyBudget" yaml:"latencyBudget"`
+	// CompressionDelayPerKB optionally delays each buffer in proportion to its size
+	// (per KiB read from either side) on top of latency, modeling the CPU cost of
+	// a middlebox compressing and decompressing the data. It doesn't count towards LatencyBudget.
+	CompressionDelayPerKB time.Duration `json:"compressionDelayPerKB" yaml:"compressionDelayPerKB"`
+	// MinLatency optionally sets a floor under the latency generated for each buffer (by Latency,
+	// a latency profile, a destination latency or ServerToClientLatency), guaranteeing a baseline
+	// delay even if the configured distribution produces near-zero values
+	MinLatency time.Duration `json:"minLatency" yaml:"minLatency"`
+	// LatencyGeneratorDeadline optionally enables a watchdog bounding the time it takes to
+	// generate the latency of each buffer. Buffers whose latency isn't generated in time
+	// pass through without delay and a warning is logged, which protects connections from
+	// a slow or hanging generator at the cost of an extra goroutine per buffer.
+	LatencyGeneratorDeadline time.Duration `json:"latencyGeneratorDeadline" yaml:"latencyGeneratorDeadline"`
+	// ResponseLatency optionally specifies rules adding latency to HTTP/1.x responses
+	// sent back by the proxy destination based on their status code
+	ResponseLatency []ResponseLatencyRule `json:"responseLatency" yaml:"responseLatency"`
+	// MaxChunkSize optionally limits the size of individual writes made by the proxy
+	// in both directions, splitting larger buffers into multiple writes (unlimited if unspecified)
+	MaxChunkSize int `json:"maxChunkSize" yaml:"maxChunkSize"`
+	// PMTUDropAfter specifies the time since each connection was opened after which
+	// the write size limit is reduced to PMTUDropChunkSize, simulating a path MTU drop
+	PMTUDropAfter time.Duration `json:"pmtuDropAfter" yaml:"pmtuDropAfter"`
+	// PMTUDropChunkSize is the write size limit in effect after PMTUDropAfter (disabled if unspecified)
+	PMTUDropChunkSize int `json:"pmtuDropChunkSize" yaml:"pmtuDropChunkSize"`
+	// Bandwidth optionally limits the throughput of each direction of each proxy connection
+	// in bytes per second (unlimited if unspecified)
+	Bandwidth int `json:"bandwidth" yaml:"bandwidth"`
+	// BandwidthAlgorithm specifies how Bandwidth is enforced (defaults to BandwidthTokenBucket)
+	BandwidthAlgorithm BandwidthAlgorithm `json:"bandwidthAlgorithm" yaml:"bandwidthAlgorithm"`
+	// BandwidthWindow is the period of time worth of traffic that BandwidthTokenBucket lets
+	// through in a burst and the length of BandwidthFixedWindow's windows (defaults to 100ms)
+	BandwidthWindow time.Duration `json:"bandwidthWindow" yaml:"bandwidthWindow"`
+	// LinkWindow optionally links Bandwidth to the latency, modeling a link that keeps up to
+	// LinkWindow bytes in flight (i.e. a TCP window). Bandwidth is derived by dividing it by
+	// the base latency and recomputed whenever the latency is changed via ArmLatency, so that
+	// the throughput drops as the latency rises. It overrides Bandwidth (throughput is not
+	// limited while the base latency is 0).
+	LinkWindow int `json:"linkWindow" yaml:"linkWindow"`
+	// BandwidthSchedule optionally varies the throughput limit over time (i.e. modeling
+	// a mobile handoff), overriding Bandwidth and LinkWindow. Each proxy connection
+	// advances through the steps from the time it was opened, while the limit of
+	// the last step stays in effect once the schedule ends.
+	BandwidthSchedule []BandwidthStep `json:"bandwidthSchedule" yaml:"bandwidthSchedule"`
+	// BandwidthScheduleGlobal makes all proxy connections follow BandwidthSchedule from
+	// the time the instance was created, rather than from the time each one was opened
+	BandwidthScheduleGlobal bool `json:"bandwidthScheduleGlobal" yaml:"bandwidthScheduleGlobal"`
+	// MaxLifetimeConnections optionally caps the number of client connections accepted over
+	// the lifetime of the instance, with connections accepted past the cap closed right away
+	// (and counted in Stats.QuotaRejections)
+	MaxLifetimeConnections int `json:"maxLifetimeConnections" yaml:"maxLifetimeConnections"`
+	// ConnQuotaInterval optionally turns MaxLifetimeConnections into a rolling quota that's
+	// reset every interval (starting with the first accepted connection), i.e. 100 connections
+	// per minute
+	ConnQuotaInterval time.Duration `json:"connQuotaInterval" yaml:"connQuotaInterval"`
+	// BackendMaxConns optionally limits the number of concurrent connections to the proxy
+	// destination. Once the limit is reached, new client connections are held in a queue
+	// (without dialing the destination) until a connection slot frees up (unlimited if unspecified).
+	BackendMaxConns int `json:"backendMaxConns" yaml:"backendMaxConns"`
+	// BackendQueueTimeout limits the time a client connection waits for a connection slot,
+	// after which it's rejected (no limit if unspecified)
+	BackendQueueTimeout time.Duration `json:"backendQueueTimeout" yaml:"backendQueueTimeout"`
+	// DialTimeout limits the time spent dialing the proxy destination, including the TLS
+	// handshake if BackendTLS is set (no limit if unspecified)
+	DialTimeout time.Duration `json:"dialTimeout" yaml:"dialTimeout"`
+	// CancelDialOnClientClose makes the proxy keep reading from each client while dialing
+	// the proxy destination, aborting the dial if the client disconnects in the meantime
+	// (data sent by the client is forwarded once the dial completes). Clients half-closing
+	// the connection right after sending a request are treated as disconnected as well.
+	CancelDialOnClientClose bool `json:"cancelDialOnClientClose" yaml:"cancelDialOnClientClose"`
+	// AcceptIdleTimeout specifies the period of time after which a warning
+	// is reported if no incoming connections were accepted (disabled if unspecified)
+	AcceptIdleTimeout time.Duration `json:"acceptIdleTimeout" yaml:"acceptIdleTimeout"`
+	// AcceptTimeline optionally paces accepting incoming connections so that they match
+	// recorded inter-arrival times (see ParseAcceptTimeline), which allows for replaying
+	// a captured connection-open pattern with a client opening connections eagerly.
+	// The n-th entry is the minimum time between accepting connections n and n+1, while
+	// connections past the end of the timeline are accepted without delay.
+	AcceptTimeline []time.Duration `json:"acceptTimeline" yaml:"acceptTimeline"`
+	// AcceptWorkers is the number of goroutines concurrently accepting connections on the
+	// listener, which may improve accept throughput at very high connection churn
+	// (defaults to 1, which is also used if AcceptTimeline is set)
+	AcceptWorkers int `json:"acceptWorkers" yaml:"acceptWorkers"`
+	// AcceptDelayJitter optionally delays handing off each accepted connection by a random
+	// duration within [0, AcceptDelayJitter], which spreads out the setup of connections
+	// opened at once. The delay holds up the accept worker, and with it the following accepts.
+	AcceptDelayJitter time.Duration `json:"acceptDelayJitter" yaml:"acceptDelayJitter"`
+	// OnTimeout is an optional callback invoked with a *DialTimeoutError, an *AcceptIdleError
+	// or a *BackendQueueTimeoutError whenever one of the configured timeouts is exceeded
+	OnTimeout func(err error) `json:"-" yaml:"-"`
+	// ConnContextFunc optionally derives the context of each proxy connection
+	// from the Speedbump instance's context (similarly to http.Server's ConnContext).
+	// The connection is closed as soon as the returned context is done, which allows
+	// for setting per-connection deadlines and values.
+	ConnContextFunc func(ctx context.Context, remote net.Addr) context.Context `json:"-" yaml:"-"`
+	// DestinationFunc optionally picks the proxy destination of each connection in host:port
+	// format based on the client's address, overriding DestAddr (the destination of TLS
+	// connections routed to TLSDestAddr is not affected). The client connection is closed
+	// if it returns an error.
+	DestinationFunc func(remote net.Addr) (string, error) `json:"-" yaml:"-"`
+	// LatencyFlagProvider optionally ties the latency of new connections to an external
+	// feature flag. It's polled every LatencyFlagInterval (starting with Start()), and whenever
+	// its result changes, the latency config it returns gets armed if it returns true,
+	// or Latency (as configured) otherwise. In between changes, the latency can still be
+	// replaced via ArmLatency and the likes. It's never invoked concurrently.
+	LatencyFlagProvider func() (*LatencyCfg, bool) `json:"-" yaml:"-"`
+	// LatencyFlagInterval is the interval at which LatencyFlagProvider is polled (defaults to 1s)
+	LatencyFlagInterval time.Duration `json:"latencyFlagInterval" yaml:"latencyFlagInterval"`
+	// ConnTraceFunc is optionally invoked as each proxy connection is opened (prior to dialing
+	// the proxy destination) with its context, which may carry a parent span. The function it
+	// returns is invoked with a summary of the connection once it's closed. Build with the otel
+	// tag in order to use NewOTelConnTraceFunc, which produces an OpenTelemetry span per connection.
+	ConnTraceFunc ConnTraceFunc `json:"-" yaml:"-"`
+	// Clock optionally replaces real time with a controllable one (see NewVirtualClock),
+	// making latency, schedules, bandwidth limiting and queueing timeouts deterministic in tests
+	Clock Clock `json:"-" yaml:"-"`
+	// CaptureDir optionally makes the byte stream of each connection get recorded to
+	// conn-<id>.jsonl within the given directory (see CaptureRecord), which can be replayed
+	// against a backend with ReplayCapture for regression testing
+	CaptureDir string `json:"captureDir" yaml:"captureDir"`
+	// RecordTimelines makes each connection record a timeline of its events (see TimelineEvent),
+	// which can be retrieved via ConnectionTimeline for debugging a single connection
+	RecordTimelines bool `json:"recordTimelines" yaml:"recordTimelines"`
+	// TimelineMaxEvents limits the number of events kept in each connection's timeline,
+	// after which the oldest ones are dropped (defaults to 1000)
+	TimelineMaxEvents int `json:"timelineMaxEvents" yaml:"timelineMaxEvents"`
+	// TimelineByteMilestone is the number of bytes read in a direction of a connection
+	// after which each bytes event is recorded in its timeline (defaults to 1MiB)
+	TimelineByteMilestone int64 `json:"timelineByteMilestone" yaml:"timelineByteMilestone"`
+	// OnConnectionsClosed is an optional callback receiving the final stats of closed
+	// connections in batches, which reduces the overhead of handling them at high churn.
+	// Connections still buffered when the instance is stopped are delivered by Stop().
+	OnConnectionsClosed func(stats []ConnStats) `json:"-" yaml:"-"`
+	// ConnBatchSize is the number of connections delivered to OnConnectionsClosed at once (defaults to 100)
+	ConnBatchSize int `json:"connBatchSize" yaml:"connBatchSize"`
+	// ConnBatchInterval limits how long after the first connection of a batch was closed
+	// a partial batch gets delivered to OnConnectionsClosed (defaults to 1s)
+	ConnBatchInterval time.Duration `json:"connBatchInterval" yaml:"connBatchInterval"`
+	// ReconnectBackend enables re-dialing the proxy destination when the connection to it
+	// fails mid-stream (i.e. it gets reset) instead of closing the client connection.
+	// The destination closing the connection cleanly (EOF) is propagated to the client.
+	// This is only suitable for protocols that tolerate transparently switching to a new
+	// upstream connection, as data that was in flight when the failure occurred may be lost.
+	ReconnectBackend bool `json:"reconnectBackend" yaml:"reconnectBackend"`
+	// ReconnectAttempts is the number of re-dial attempts made before giving up (defaults to 3)
+	ReconnectAttempts int `json:"reconnectAttempts" yaml:"reconnectAttempts"`
+	// ReconnectBackoff is the delay before each re-dial attempt (defaults to 100ms)
+	ReconnectBackoff time.Duration `json:"reconnectBackoff" yaml:"reconnectBackoff"`
+	// ReadRetries is the number of times a read from either side of a proxy connection
+	// failing with a temporary network error is retried before the connection is closed
+	ReadRetries int `json:"readRetries" yaml:"readRetries"`
+	// ReadRetryBackoff is the delay before each read retry (defaults to 10ms)
+	ReadRetryBackoff time.Duration `json:"readRetryBackoff" yaml:"readRetryBackoff"`
+	// MigrateOnReload makes SetDestination reset existing connections proxied to the previous
+	// destination, so that their clients reconnect to the new one. Data in flight (including
+	// buffers held in delay queues) is lost, so it's only suitable for clients that retry
+	// requests on a reset. Connections aren't re-dialed transparently, as replaying a request
+	// to a different backend is only safe for idempotent protocols, which speedbump can't tell apart.
+	MigrateOnReload bool `json:"migrateOnReload" yaml:"migrateOnReload"`
+	// ShutdownMessage is optionally written to each proxy client right before its
+	// connection is closed by Stop(), which allows for distinguishing a planned shutdown
+	// from a crash. It's not sent to connections closed because their ConnContextFunc
+	// context is done. It's only suitable for protocols that tolerate such an epilogue.
+	ShutdownMessage []byte `json:"shutdownMessage" yaml:"shutdownMessage"`
+	// RefusedMessage is optionally written to proxy clients whose proxy destination refused
+	// the connection right before closing them, allowing test clients to implement backoff
+	// driven by the proxy. It's a text/template executed with RefusedMessageData, whose
+	// retry-after starts at RefusedRetryAfter and doubles with each consecutive refused
+	// dial (up to 64 times as much) until dialing succeeds again, i.e.
+	// "-ERR backend unavailable, retry in {{.RetryAfterSeconds}}s\r\n". It's only suitable
+	// for protocols that tolerate such a message.
+	RefusedMessage string `json:"refusedMessage" yaml:"refusedMessage"`
+	// RefusedRetryAfter is the retry-after suggested by RefusedMessage after the first
+	// refused dial (defaults to 1s)
+	RefusedRetryAfter time.Duration `json:"refusedRetryAfter" yaml:"refusedRetryAfter"`
+	// LogRateLimit optionally coalesces identical warnings (e.g. accept errors, dial failures
+	// or write errors) logged within the given interval into a single line followed by
+	// a summary of the number of suppressed ones (disabled if unspecified)
+	LogRateLimit time.Duration `json:"logRateLimit" yaml:"logRateLimit"`
+	// LogSampleRate optionally limits logging the opening and closing of proxy connections
+	// to a random fraction of them (all of them are logged if unspecified), which keeps logs
+	// readable at high connection churn. Warnings are logged for all connections and stats
+	// aren't affected by sampling.
+	LogSampleRate float64 `json:"logSampleRate" yaml:"logSampleRate"`
+	// AdminAddr optionally specifies the address of an HTTP admin API exposing the instance's
+	// stats and effective config, either in host:port format or as a Unix socket path
+	// (unix:/path), which keeps the control plane off the network
+	AdminAddr string `json:"adminAddr" yaml:"adminAddr"`
+	// EnableStdinControl starts reading commands (enable, disable, latency <duration>,
+	// stats, conns, close <id>) from stdin, which allows for driving the instance live
+	// when running it manually. Results are printed to stdout.
+	EnableStdinControl bool `json:"enableStdinControl" yaml:"enableStdinControl"`
+	// PoolBuffers enables reusing read buffers across proxy connections, which reduces
+	// allocations and GC pressure at high connection churn
+	PoolBuffers bool `json:"poolBuffers" yaml:"poolBuffers"`
+	// ReorderRate is the probability of a buffer released from the delay queue swapping
+	// places with the one queued behind it (disabled if unspecified). As TCP guarantees
+	// in-order delivery, reordering corrupts the proxied stream. It's meant for testing
+	// how applications relying on their own datagram-like framing handle it.
+	ReorderRate float64 `json:"reorderRate" yaml:"reorderRate"`
+	// CloseLinger optionally defers closing one side of a proxy connection after the other
+	// side was closed by its peer, simulating a peer that is slow to complete the close
+	// handshake (i.e. lingering in FIN_WAIT), which exercises half-closed state handling
+	CloseLinger time.Duration `json:"closeLinger" yaml:"closeLinger"`
+	// WarnOnClientGone makes proxy connections closed because their client went away without
+	// closing them cleanly (see ClientGoneError) get logged as warnings rather than at the debug
+	// level. Either way, the connection to the proxy destination is closed right away.
+	WarnOnClientGone bool `json:"warnOnClientGone" yaml:"warnOnClientGone"`
+	// DestHealthCheck optionally makes the proxy destination's host get resolved and each
+	// of its addresses (i.e. multiple A records) get connected to periodically, with new
+	// connections proxied to the addresses that accepted the last health check in turns.
+	// While none of them is healthy, connections are proxied to the destination as usual.
+	// The first check is performed by Start(). Destinations returned by DestinationFunc
+	// or selected by TLSDestAddr or FingerprintRoutes are not health checked.
+	DestHealthCheck *DestHealthCheckCfg `json:"destHealthCheck" yaml:"destHealthCheck"`
+	// HappyEyeballs makes the proxy destination's host get resolved each time it's dialed,
+	// with all of its addresses (i.e. both IPv4 and IPv6 ones) dialed in parallel with
+	// a small stagger and the first connection established used, which reduces connect
+	// latency to dual-stack destinations (by default, the address resolved by NewSpeedbump
+	// is dialed)
+	HappyEyeballs bool `json:"happyEyeballs" yaml:"happyEyeballs"`
+	// BackendTLS makes speedbump originate TLS connections to the proxy destination,
+	// while accepting plaintext from clients (connections detected as TLS and routed
+	// to TLSDestAddr are proxied as is)
+	BackendTLS bool `json:"backendTLS" yaml:"backendTLS"`
+	// BackendTLSServerName is the server name verified against the proxy destination's
+	// certificate and sent via SNI (defaults to the host of DestAddr)
+	BackendTLSServerName string `json:"backendTLSServerName" yaml:"backendTLSServerName"`
+	// BackendInsecureSkipVerify disables verification of the proxy destination's certificate
+	BackendInsecureSkipVerify bool `json:"backendInsecureSkipVerify" yaml:"backendInsecureSkipVerify"`
+	// StatsWarmup optionally excludes the given initial period after Start() from the
+	// aggregate stats returned by Stats(), so that measurements reflect steady state.
+	// Traffic is proxied normally during the warmup, while connections accepted within it
+	// are not counted (OnTimeout is still notified about timeouts).
+	StatsWarmup time.Duration `json:"statsWarmup" yaml:"statsWarmup"`
+	// PadBytes optionally appends the given number of zero filler bytes to each buffer
+	// read from the client before it's forwarded to the proxy destination, modeling
+	// overhead injection. As it alters the proxied stream, it's only suitable
+	// for framed (i.e. length-prefixed) protocols tolerating such padding.
+	PadBytes int `json:"padBytes" yaml:"padBytes"`
+	// CoalesceWindow optionally makes consecutive small reads from the client get coalesced
+	// into a single buffer (up to BufferSize) for the given period of time after the first one,
+	// so that delay queue slots (which count buffers rather than bytes) reflect data volume
+	// better for chatty streams. Coalesced data is delayed based on the time of the first read,
+	// so it should be short compared to the latency.
+	CoalesceWindow time.Duration `json:"coalesceWindow" yaml:"coalesceWindow"`
+	// DebugSerial makes the data sent by each client get read, delayed and written to the proxy
+	// destination by a single goroutine without the delay queue, so that the order of events
+	// is deterministic when debugging. As each buffer is read only after the previous one
+	// was written, it throttles throughput and isn't meant for production use. QueueSize,
+	// QueueDrainWindow, ReorderRate, CoalesceWindow and PadBytes have no effect in this mode.
+	DebugSerial bool `json:"debugSerial" yaml:"debugSerial"`
+	// GlobalQueueMemLimit optionally caps the number of bytes held by the delay queues
+	// of all connections combined, which prevents a runaway connection from exhausting
+	// the host's memory (disabled if unspecified)
+	GlobalQueueMemLimit int `json:"globalQueueMemLimit" yaml:"globalQueueMemLimit"`
+	// QueueMemPolicy specifies what happens once GlobalQueueMemLimit is reached
+	// (defaults to QueueMemBlock)
+	QueueMemPolicy QueueMemPolicy `json:"queueMemPolicy" yaml:"queueMemPolicy"`
+	// ProbeBackendOnStart makes Start() dial the proxy destination once and log the time
+	// it took to connect as the baseline RTT (available via BackendRTT), which helps with
+	// choosing sensible latency values. Failing to connect is only logged as a warning.
+	ProbeBackendOnStart bool `json:"probeBackendOnStart" yaml:"probeBackendOnStart"`
+}
+
+// Stats contains counters describing the activity of a Speedbump instance
+type Stats struct {
+	// DialTimeouts is the number of proxy destination dials that exceeded DialTimeout
+	DialTimeouts int `json:"dialTimeouts"`
+	// AcceptIdleTimeouts is the number of times no connection was accepted within AcceptIdleTimeout
+	AcceptIdleTimeouts int `json:"acceptIdleTimeouts"`
+	// BackendQueueTimeouts is the number of client connections rejected after BackendQueueTimeout
+	BackendQueueTimeouts int `json:"backendQueueTimeouts"`
+	// SNIRejections is the number of client connections rejected due to AllowedSNIs
+	SNIRejections int `json:"sniRejections"`
+	// QuotaRejections is the number of client connections refused due to MaxLifetimeConnections
+	QuotaRejections int `json:"quotaRejections"`
+	// ConnectionDurations summarizes the lifetimes of closed proxy connections
+	ConnectionDurations DurationStats `json:"connectionDurations"`
+	// AcceptIntervals summarizes the time between successive accepted connections,
+	// which grows under load if the accept loop becomes a bottleneck
+	AcceptIntervals DurationStats `json:"acceptIntervals"`
+	// AcceptProcessing summarizes the time the accept loop spends on each accepted connection
+	AcceptProcessing DurationStats `json:"acceptProcessing"`
+	// QueueWait summarizes the time buffers of closed proxy connections actually waited
+	// in their queues (see ConnStats.QueueWait)
+	QueueWait DurationStats `json:"queueWait"`
+	// QueueMemory is the number of bytes currently held by the delay queues of all
+	// connections (only tracked if GlobalQueueMemLimit is set)
+	QueueMemory int `json:"queueMemory"`
+	// AverageInjectedLatency is the average latency currently being injected across all
+	// active connections (see Speedbump.AverageInjectedLatency)
+	AverageInjectedLatency time.Duration `json:"averageInjectedLatency"`
 }
 
 // NewSpeedbump creates a Speedbump instance based on a provided config
@@ -52,9 +579,61 @@ func NewSpeedbump(cfg *SpeedbumpCfg) (*Speedbump, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error resolving local address: %s", err)
 	}
-	destTCPAddr, err := net.ResolveTCPAddr("tcp", cfg.DestAddr)
+	portRange, err := parsePortRange(cfg.PortRangePreferred)
+	if err != nil {
+		return nil, err
+	}
+	localBackend, err := newLocalBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// the destination address is not used by local backends
+	destTCPAddr := &net.TCPAddr{}
+	if localBackend == nil || cfg.DestAddr != "" {
+		destTCPAddr, err = net.ResolveTCPAddr("tcp", cfg.DestAddr)
+		if err != nil {
+			return nil, fmt.Errorf("Error resolving destination address: %s", err)
+		}
+	}
+	fingerprintRoutes, err := newFingerprintRoutes(cfg.FingerprintRoutes)
+	if err != nil {
+		return nil, err
+	}
+	var tlsDestTCPAddr *net.TCPAddr
+	if cfg.TLSDestAddr != "" {
+		tlsDestTCPAddr, err = net.ResolveTCPAddr("tcp", cfg.TLSDestAddr)
+		if err != nil {
+			return nil, fmt.Errorf("Error resolving TLS destination address: %s", err)
+		}
+	}
+	tlsDetectTimeout := cfg.TLSDetectTimeout
+	if tlsDetectTimeout <= 0 {
+		tlsDetectTimeout = defaultTLSDetectTimeout
+	}
+	if cfg.Stall != nil && cfg.Stall.Period > 0 && cfg.Stall.Duration >= cfg.Stall.Period {
+		return nil, fmt.Errorf("Error configuring stall: duration must be shorter than period")
+	}
+	for _, latency := range []*LatencyCfg{cfg.Latency, cfg.ServerToClientLatency} {
+		if latency != nil && (latency.JitterCorrelation < 0 || latency.JitterCorrelation >= 1) {
+			return nil, fmt.Errorf("Error configuring latency: jitter correlation must be at least 0 and less than 1")
+		}
+	}
+	if cfg.ReorderRate < 0 || cfg.ReorderRate > 1 {
+		return nil, fmt.Errorf("Error configuring reordering: rate must be between 0 and 1")
+	}
+	if cfg.LogSampleRate < 0 || cfg.LogSampleRate > 1 {
+		return nil, fmt.Errorf("Error configuring log sampling: rate must be between 0 and 1")
+	}
+	if err := validateBandwidthSchedule(cfg.BandwidthSchedule); err != nil {
+		return nil, err
+	}
+	destHealth, err := newDestHealth(cfg.DestHealthCheck)
+	if err != nil {
+		return nil, err
+	}
+	refusedHint, err := newRefusedHint(cfg.RefusedMessage, cfg.RefusedRetryAfter)
 	if err != nil {
-		return nil, fmt.Errorf("Error resolving destination address: %s", err)
+		return nil, err
 	}
 	l := hclog.New(&hclog.LoggerOptions{
 		Level: hclog.LevelFromString(cfg.LogLevel),
@@ -65,73 +644,655 @@ func NewSpeedbump(cfg *SpeedbumpCfg) (*Speedbump, error) {
 	if queueSize == 0 {
 		queueSize = 1024
 	}
+	effectiveCfg := *cfg
+	effectiveCfg.QueueSize = queueSize
+	bandwidth := newBandwidthLimit(cfg)
+	effectiveCfg.Bandwidth = bandwidth.rate
+	if cfg.TLSDestAddr != "" {
+		effectiveCfg.TLSDetectTimeout = tlsDetectTimeout
+	}
+	if cfg.Latency != nil {
+		latency := *cfg.Latency
+		effectiveCfg.Latency = &latency
+	}
+	clock := clockOrReal(cfg.Clock)
+	start := clock.Now()
+	bandwidth.scheduleStart = start
+	var returnLatencyGen LatencyGenerator
+	if cfg.ServerToClientLatency != nil {
+		latency := *cfg.ServerToClientLatency
+		effectiveCfg.ServerToClientLatency = &latency
+		returnLatencyGen = newLatencyGenerator(start, &latency)
+	} else if cfg.Mode == ModeSource && cfg.Latency != nil {
+		// the data streamed to clients is delayed by Latency in source mode
+		returnLatencyGen = newLatencyGenerator(start, cfg.Latency)
+	}
+	if len(cfg.LatencyProfiles) > 0 || cfg.LabelVirtualHosts || len(cfg.AllowedSNIs) > 0 || cfg.FingerprintFunc != nil {
+		limits := newPreambleLimits(cfg.PreambleTimeout, cfg.MaxPreambleBytes)
+		effectiveCfg.PreambleTimeout = limits.timeout
+		effectiveCfg.MaxPreambleBytes = limits.maxBytes
+	}
+	if cfg.LatencyProfiles != nil {
+		effectiveCfg.LatencyProfiles = make(map[string]LatencyCfg, len(cfg.LatencyProfiles))
+		for name, profile := range cfg.LatencyProfiles {
+			effectiveCfg.LatencyProfiles[name] = profile
+		}
+	}
+	if cfg.DestinationLatency != nil {
+		effectiveCfg.DestinationLatency = make(map[string]*LatencyCfg, len(cfg.DestinationLatency))
+		for dest, latency := range cfg.DestinationLatency {
+			if latency != nil {
+				copied := *latency
+				latency = &copied
+			}
+			effectiveCfg.DestinationLatency[dest] = latency
+		}
+	}
+	if cfg.Stall != nil {
+		stall := *cfg.Stall
+		effectiveCfg.Stall = &stall
+	}
+	if refusedHint != nil {
+		effectiveCfg.RefusedRetryAfter = refusedHint.base
+	}
+	latencyFlag := newLatencyFlag(cfg)
+	if latencyFlag != nil {
+		effectiveCfg.LatencyFlagInterval = latencyFlag.interval
+	}
+	if destHealth != nil {
+		effectiveCfg.DestHealthCheck = &DestHealthCheckCfg{Interval: destHealth.interval, Timeout: destHealth.timeout}
+	}
+	if cfg.AllowedSNIs != nil {
+		effectiveCfg.AllowedSNIs = append([]string(nil), cfg.AllowedSNIs...)
+	}
+	if cfg.ResponseLatency != nil {
+		effectiveCfg.ResponseLatency = append([]ResponseLatencyRule(nil), cfg.ResponseLatency...)
+	}
+	if cfg.BandwidthSchedule != nil {
+		effectiveCfg.BandwidthSchedule = append([]BandwidthStep(nil), cfg.BandwidthSchedule...)
+		bandwidth.schedule = effectiveCfg.BandwidthSchedule
+	}
+	if cfg.AcceptTimeline != nil {
+		effectiveCfg.AcceptTimeline = append([]time.Duration(nil), cfg.AcceptTimeline...)
+	}
+	if cfg.DelayRamp != nil {
+		ramp := *cfg.DelayRamp
+		effectiveCfg.DelayRamp = &ramp
+	}
+	if cfg.SlowStart != nil {
+		slowStart := *cfg.SlowStart
+		effectiveCfg.SlowStart = &slowStart
+	}
+	if cfg.IdleLatency != nil {
+		idle := *cfg.IdleLatency
+		effectiveCfg.IdleLatency = &idle
+	}
+	if cfg.RTTLatency != nil {
+		rtt := *cfg.RTTLatency
+		effectiveCfg.RTTLatency = &rtt
+	}
 	s := &Speedbump{
-		bufferSize: int(cfg.BufferSize),
-		queueSize:  queueSize,
-		srcAddr:    *localTCPAddr,
-		destAddr:   *destTCPAddr,
-		latencyGen: newSimpleLatencyGenerator(time.Now(), cfg.Latency),
-		log:        l,
+		cfg:                 effectiveCfg,
+		clock:               clock,
+		bufferSize:          int(cfg.BufferSize),
+		padBytes:            cfg.PadBytes,
+		queueMem:            newQueueMemory(cfg.GlobalQueueMemLimit, cfg.QueueMemPolicy),
+		coalesceWindow:      cfg.CoalesceWindow,
+		queueSize:           queueSize,
+		drainWindow:         cfg.QueueDrainWindow,
+		maxQueueAge:         cfg.MaxQueueAge,
+		drainBatchSize:      cfg.DrainBatchSize,
+		srcAddr:             *localTCPAddr,
+		portRange:           portRange,
+		destAddr:            *destTCPAddr,
+		tlsDestAddr:         tlsDestTCPAddr,
+		fingerprintRoutes:   fingerprintRoutes,
+		localBackend:        localBackend,
+		tlsDetectTimeout:    tlsDetectTimeout,
+		latencyGen:          newLatencyGenSlot(armedLatency{newLatencyGenerator(start, cfg.Latency), bandwidth}),
+		returnLatencyGen:    returnLatencyGen,
+		profiles:            newProfileLatencyGenerators(start, cfg.LatencyProfiles),
+		allowedSNIs:         newSNIAllowlist(cfg.AllowedSNIs),
+		destLatencies:       newDestinationLatencyGenerators(start, cfg.DestinationLatency),
+		preamble:            newPreambleLimits(cfg.PreambleTimeout, cfg.MaxPreambleBytes),
+		stall:               newStallSchedule(start, cfg.Stall),
+		ramp:                effectiveCfg.DelayRamp,
+		slowStart:           effectiveCfg.SlowStart,
+		idleLatency:         effectiveCfg.IdleLatency,
+		rttLatency:          effectiveCfg.RTTLatency,
+		latencyBudget:       cfg.LatencyBudget,
+		compressionDelay:    cfg.CompressionDelayPerKB,
+		minLatency:          cfg.MinLatency,
+		generatorDeadline:   cfg.LatencyGeneratorDeadline,
+		responseRules:       effectiveCfg.ResponseLatency,
+		maxChunkSize:        cfg.MaxChunkSize,
+		pmtuDropAfter:       cfg.PMTUDropAfter,
+		pmtuDropChunkSize:   cfg.PMTUDropChunkSize,
+		reorder:             newReorderer(cfg.ReorderRate, time.Now().UnixNano()),
+		acceptJitter:        newAcceptJitter(cfg.AcceptDelayJitter, time.Now().UnixNano()),
+		freeze:              newDirectionFreeze(),
+		dialTimeout:         cfg.DialTimeout,
+		closeLinger:         cfg.CloseLinger,
+		backendQueueTimeout: cfg.BackendQueueTimeout,
+		acceptIdleTimeout:   cfg.AcceptIdleTimeout,
+		acceptTimeline:      effectiveCfg.AcceptTimeline,
+		acceptWorkers:       cfg.AcceptWorkers,
+		statsWarmup:         cfg.StatsWarmup,
+		reconnect:           newReconnectPolicy(cfg),
+		readRetry:           newReadRetryPolicy(cfg),
+		shutdownMessage:     cfg.ShutdownMessage,
+		onTimeout:           cfg.OnTimeout,
+		connContext:         cfg.ConnContextFunc,
+		destinationFunc:     cfg.DestinationFunc,
+		connTrace:           cfg.ConnTraceFunc,
+		connBatcher:         newConnBatcher(cfg),
+		probeDial:           (&net.Dialer{}).DialContext,
+		destHealth:          destHealth,
+		latencyFlag:         latencyFlag,
+		refusedHint:         refusedHint,
+		quota:               newConnQuota(cfg.MaxLifetimeConnections, cfg.ConnQuotaInterval),
+		warnLimiter:         newLogLimiter(cfg.LogRateLimit, clock, l),
+		logSampler:          newLogSampler(cfg.LogSampleRate, time.Now().UnixNano()),
+		adminAddr:           cfg.AdminAddr,
+		connDurations:       newDurationHistogram(),
+		acceptIntervals:     newDurationHistogram(),
+		acceptProcessing:    newDurationHistogram(),
+		queueWait:           newDurationHistogram(),
+		conns:               make(map[int]*connection),
+		timelines:           make(map[int]*connTimeline),
+		log:                 l,
+	}
+	if cfg.PoolBuffers {
+		s.pool = newBufferPool(s.bufferSize)
+	}
+	if cfg.BackendTLS {
+		s.backendTLS = newBackendTLSConfig(cfg)
+		s.cfg.BackendTLSServerName = s.backendTLS.ServerName
+	}
+	if cfg.BackendMaxConns > 0 {
+		s.backendSlots = make(chan struct{}, cfg.BackendMaxConns)
+	}
+	if s.reconnect != nil {
+		s.cfg.ReconnectAttempts = s.reconnect.attempts
+		s.cfg.ReconnectBackoff = s.reconnect.backoff
+	}
+	if s.readRetry != nil {
+		s.cfg.ReadRetryBackoff = s.readRetry.backoff
+	}
+	s.connOpts = connectionOpts{
+		localBackend:      s.localBackend,
+		bufferSize:        s.bufferSize,
+		pool:              s.pool,
+		queueMem:          s.queueMem,
+		padBytes:          s.padBytes,
+		coalesceWindow:    s.coalesceWindow,
+		serial:            s.cfg.DebugSerial,
+		queueSize:         s.queueSize,
+		drainWindow:       s.drainWindow,
+		maxQueueAge:       s.maxQueueAge,
+		drainBatchSize:    s.drainBatchSize,
+		classifyDirection: s.cfg.DirectionClassifier,
+		stall:             s.stall,
+		ramp:              s.ramp,
+		idle:              s.idleLatency,
+		rtt:               s.rttLatency,
+		latencyBudget:     s.latencyBudget,
+		compressionPerKB:  s.compressionDelay,
+		responseRules:     s.responseRules,
+		reorder:           s.reorder,
+		freeze:            s.freeze,
+		dialTimeout:       s.dialTimeout,
+		closeLinger:       s.closeLinger,
+		warnOnClientGone:  s.cfg.WarnOnClientGone,
+		reconnect:         s.reconnect,
+		readRetry:         s.readRetry,
+		shutdownMessage:   s.shutdownMessage,
+		warnLimiter:       s.warnLimiter,
 	}
 	return s, nil
 }
 
+// startAcceptLoop runs the configured number of accept workers
+// (a single one is used for pacing accepts according to AcceptTimeline)
 func (s *Speedbump) startAcceptLoop() {
+	workers := s.acceptWorkers
+	if workers < 1 || len(s.acceptTimeline) > 0 {
+		workers = 1
+	}
+	if s.acceptIdleTimeout > 0 {
+		s.acceptMu.Lock()
+		s.acceptDeadline = s.clock.Now().Add(s.acceptIdleTimeout)
+		s.acceptMu.Unlock()
+		go s.watchAcceptIdle()
+	}
+	for i := 1; i < workers; i++ {
+		go s.acceptConnections()
+	}
+	s.acceptConnections()
+}
+
+// acceptConnections accepts incoming connections until the listener is closed
+func (s *Speedbump) acceptConnections() {
 	for {
+		s.acceptMu.Lock()
+		ok := s.waitForTimeline(s.nextConnId, s.lastAccepted)
+		s.acceptMu.Unlock()
+		if !ok {
+			return
+		}
 		conn, err := s.listener.AcceptTCP()
 		if err != nil {
-			if strings.Contains(err.Error(), "use of closed") {
+			if errors.Is(err, net.ErrClosed) {
 				// the listener was closed, which means that Stop() was called
 				return
 			} else {
-				s.log.Warn("Accepting incoming TCP conn failed", "err", err)
+				s.warnLimiter.warn(s.log, "Accepting incoming TCP conn failed", "err", err)
 				continue
 			}
 		}
-		l := s.log.With("connection", s.nextConnId)
-		p, err := newProxyConnection(
-			s.ctx,
-			conn,
-			&s.srcAddr,
-			&s.destAddr,
-			s.bufferSize,
-			s.queueSize,
-			s.latencyGen,
-			l,
-		)
-		if err != nil {
-			s.log.Warn("Creating new proxy conn failed", "err", err)
+		acceptedAt := s.clock.Now()
+		if !s.quota.take(acceptedAt) {
+			s.warnLimiter.warn(s.log, "Refusing incoming conn, connection quota exhausted", "remote", conn.RemoteAddr())
+			s.recordQuotaRejection(acceptedAt)
 			conn.Close()
 			continue
 		}
+		if d := s.acceptJitter.next(); d > 0 {
+			select {
+			case <-s.clock.After(d):
+			case <-s.ctx.Done():
+				conn.Close()
+				return
+			}
+		}
+		s.acceptMu.Lock()
+		id := s.nextConnId
 		s.nextConnId++
-		s.active.Add(1)
-		go s.startProxyConnection(p)
+		lastAccepted := s.lastAccepted
+		s.lastAccepted = acceptedAt
+		s.acceptDeadline = acceptedAt.Add(s.acceptIdleTimeout)
+		s.acceptMu.Unlock()
+		l := s.log.With("connection", id)
+		s.connectionStarted()
+		go s.startProxyConnection(conn, id, l)
+		s.recordAccept(lastAccepted, acceptedAt)
+	}
+}
+
+// watchAcceptIdle reports an accept idle timeout whenever no connection is accepted
+// within AcceptIdleTimeout (measured by the instance's clock) until Stop() is called
+func (s *Speedbump) watchAcceptIdle() {
+	for {
+		s.acceptMu.Lock()
+		now := s.clock.Now()
+		idle := !now.Before(s.acceptDeadline)
+		if idle {
+			s.acceptDeadline = now.Add(s.acceptIdleTimeout)
+		}
+		wait := s.acceptDeadline.Sub(now)
+		s.acceptMu.Unlock()
+		if idle {
+			s.handleTimeout(&AcceptIdleError{Idle: s.acceptIdleTimeout})
+		}
+		select {
+		case <-s.clock.After(wait):
+		case <-s.ctx.Done():
+			return
+		}
 	}
 }
 
-func (s *Speedbump) startProxyConnection(p *connection) {
-	defer s.active.Done()
+// recordAccept records the accept timing metrics of a connection accepted
+// at a given point in time (the previous one was accepted at last)
+func (s *Speedbump) recordAccept(last, accepted time.Time) {
+	if s.warmingUp(accepted) {
+		return
+	}
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if !last.IsZero() && !s.warmingUp(last) {
+		s.acceptIntervals.record(accepted.Sub(last))
+	}
+	s.acceptProcessing.record(s.clock.Now().Sub(accepted))
+}
+
+// handleTimeout records an exceeded timeout in stats and notifies the OnTimeout callback
+func (s *Speedbump) handleTimeout(err error) {
+	if _, ok := err.(*AcceptIdleError); ok {
+		s.log.Warn("Accept idle timeout exceeded", "err", err)
+	}
+	if !s.warmingUp(s.clock.Now()) {
+		s.statsMu.Lock()
+		switch err.(type) {
+		case *DialTimeoutError:
+			s.stats.DialTimeouts++
+		case *AcceptIdleError:
+			s.stats.AcceptIdleTimeouts++
+		case *BackendQueueTimeoutError:
+			s.stats.BackendQueueTimeouts++
+		}
+		s.statsMu.Unlock()
+	}
+	if s.onTimeout != nil {
+		s.onTimeout(err)
+	}
+}
+
+// recordSNIRejection records a client connection rejected due to AllowedSNIs in stats
+func (s *Speedbump) recordSNIRejection(acceptedAt time.Time) {
+	if s.warmingUp(acceptedAt) {
+		return
+	}
+	s.statsMu.Lock()
+	s.stats.SNIRejections++
+	s.statsMu.Unlock()
+}
+
+func (s *Speedbump) startProxyConnection(conn *net.TCPConn, id int, l hclog.Logger) {
+	defer s.connectionDone()
+	acceptedAt := s.clock.Now()
+	ctx := s.ctx
+	if s.connContext != nil {
+		ctx = s.connContext(ctx, conn.RemoteAddr())
+	}
+	armed := s.latencyGen.load()
+	latencyGen := armed.gen
+	bandwidth := armed.bandwidth.forConnection(acceptedAt)
+	var clientConn io.ReadWriteCloser = conn
+	// peekConn is the client connection from which initial bytes are consumed
+	var peekConn net.Conn = conn
+	if s.allowedSNIs != nil {
+		// rejected clients are closed before they hold a proxy destination connection slot
+		bc, name := readSNI(ctx, peekConn, s.preamble)
+		if !s.allowedSNIs.allows(name) {
+			s.warnLimiter.warn(l, "Rejecting incoming conn with a disallowed server name", "serverName", name)
+			s.recordSNIRejection(acceptedAt)
+			conn.Close()
+			return
+		}
+		clientConn, peekConn = bc, bc
+	}
+	if !s.acquireBackendSlot(ctx, l) {
+		conn.Close()
+		return
+	}
+	defer s.releaseBackendSlot()
+	// profiled is set if the client requested a latency profile
+	profiled := false
+	if s.profiles != nil {
+		bc, token, err := readProfileToken(ctx, peekConn, s.preamble)
+		if err != nil {
+			s.warnLimiter.warn(l, "Reading latency profile token of incoming conn failed", "err", err)
+			conn.Close()
+			return
+		}
+		profile, ok := s.profiles[token]
+		if !ok {
+			s.warnLimiter.warn(l, "Unknown latency profile requested by incoming conn", "profile", token)
+			conn.Close()
+			return
+		}
+		l.Debug("Applying latency profile", "profile", token)
+		latencyGen = profile
+		profiled = true
+		clientConn, peekConn = bc, bc
+	}
+	// destName is the destination as configured, by which destination latencies are looked up
+	defaultDest, destName := s.destination()
+	destAddr := &defaultDest
+	backendTLS := s.backendTLS
+	happyEyeballsAddr := ""
+	if addr, ok := s.destHealth.pick(destName); ok {
+		destAddr = addr
+	} else if s.cfg.HappyEyeballs {
+		happyEyeballsAddr = destName
+	}
+	if s.destinationFunc != nil {
+		dest, addr, err := s.selectDestination(conn.RemoteAddr())
+		if err != nil {
+			s.warnLimiter.warn(l, "Selecting proxy destination of incoming conn failed", "err", err)
+			conn.Close()
+			return
+		}
+		l.Debug("Selected proxy destination", "dest", dest)
+		destAddr = addr
+		destName = dest
+		if s.cfg.HappyEyeballs {
+			happyEyeballsAddr = dest
+		}
+	}
+	fingerprint := ""
+	if s.cfg.FingerprintFunc != nil {
+		bc, greeting := readGreeting(ctx, peekConn, s.preamble)
+		clientConn, peekConn = bc, bc
+		fingerprint = s.cfg.FingerprintFunc(greeting)
+		if fingerprint != "" {
+			l = l.With("fingerprint", fingerprint)
+		}
+		if addr, ok := s.fingerprintRoutes[fingerprint]; ok {
+			l.Debug("Routing by fingerprint", "dest", s.cfg.FingerprintRoutes[fingerprint])
+			destAddr = addr
+			destName = s.cfg.FingerprintRoutes[fingerprint]
+			if s.cfg.HappyEyeballs {
+				happyEyeballsAddr = destName
+			}
+		}
+	}
+	if s.tlsDestAddr != nil {
+		bc, isTLS, err := detectTLS(ctx, peekConn, s.tlsDetectTimeout)
+		if err != nil {
+			s.warnLimiter.warn(l, "Detecting protocol of incoming conn failed", "err", err)
+			conn.Close()
+			return
+		}
+		if isTLS {
+			l.Debug("Detected TLS handshake")
+			destAddr = s.tlsDestAddr
+			destName = s.cfg.TLSDestAddr
+			// TLS clients are proxied to the TLS destination as is
+			backendTLS = nil
+			if s.cfg.HappyEyeballs {
+				happyEyeballsAddr = s.cfg.TLSDestAddr
+			}
+		}
+		clientConn, peekConn = bc, bc
+	}
+	if gen, ok := s.destLatencies[destName]; ok && !profiled {
+		l.Debug("Applying destination latency", "dest", destName)
+		latencyGen = gen
+	}
+	virtualHost := ""
+	if s.cfg.LabelVirtualHosts {
+		bc, host := readVirtualHost(ctx, peekConn, s.preamble)
+		clientConn, peekConn = bc, bc
+		if host != "" {
+			virtualHost = host
+			l = l.With("virtualHost", host)
+		}
+	}
+	endTrace := s.traceConn(ctx, ConnInfo{ID: id, RemoteAddr: conn.RemoteAddr(), Destination: destAddr.String(), VirtualHost: virtualHost})
+	timeline := s.startTimeline(id)
+	timeline.record(TimelineEvent{Time: acceptedAt, Kind: TimelineOpen, Detail: destName})
+	dialCtx := ctx
+	var watch *dialWatch
+	if s.cfg.CancelDialOnClientClose && s.localBackend == nil {
+		dialCtx, watch = watchClientDuringDial(ctx, peekConn, s.bufferSize)
+		defer watch.cancel()
+		clientConn = &bufferedConn{peekConn, bufio.NewReader(watch)}
+	}
+	opts := s.connOpts
+	opts.happyEyeballsAddr = happyEyeballsAddr
+	opts.backendTLS = backendTLS
+	opts.latencyGen = newFloorLatencyGenerator(newWatchdogLatencyGenerator(latencyGenForConnection(latencyGen), s.generatorDeadline, s.clock, s.warnLimiter, l), s.minLatency)
+	opts.returnLatencyGen = newFloorLatencyGenerator(newWatchdogLatencyGenerator(latencyGenForConnection(s.returnLatencyGen), s.generatorDeadline, s.clock, s.warnLimiter, l), s.minLatency)
+	opts.slowStart = newSlowStart(s.slowStart, s.clock.Now())
+	opts.chunks = newChunkSchedule(s.clock.Now(), s.maxChunkSize, s.pmtuDropAfter, s.pmtuDropChunkSize)
+	opts.bandwidth = bandwidth
+	opts.stopCtx = s.ctx
+	opts.clock = s.clock
+	p, err := newProxyConnection(dialCtx, clientConn, destAddr, opts, l)
+	var clientErr error
+	if watch != nil {
+		clientErr = watch.stop()
+	}
+	if err != nil {
+		if clientErr != nil {
+			l.Debug("Client disconnected while dialing proxy destination", "err", clientErr)
+		} else if ctx.Err() != nil {
+			// dialing was aborted due to Stop() (or the connection's context being done)
+			l.Debug("Creating new proxy conn aborted", "err", err)
+		} else {
+			s.warnLimiter.warn(l, "Creating new proxy conn failed", "err", err)
+			s.writeRefusedMessage(conn, err, l)
+		}
+		var timeoutErr *DialTimeoutError
+		if errors.As(err, &timeoutErr) {
+			s.handleTimeout(timeoutErr)
+		}
+		conn.Close()
+		endTrace(ConnSummary{CloseReason: err})
+		s.endTimeline(id, timeline, err)
+		return
+	}
+	s.refusedHint.dialed()
+	p.counters.virtualHost = virtualHost
+	p.counters.fingerprint = fingerprint
+	p.destination = destName
+	p.timeline = timeline
+	p.capture = s.startCapture(id, acceptedAt, l)
+	if !s.logSampler.sample() {
+		p.lifecycleLog = nullLogger
+	}
+	s.connsMu.Lock()
+	s.conns[id] = p
+	s.connsMu.Unlock()
 	// start will block until a proxy connection is closed
-	p.start()
+	closeReason := p.start()
+	s.endCapture(p.capture, l)
+	s.connsMu.Lock()
+	delete(s.conns, id)
+	s.connsMu.Unlock()
+	stats := p.counters.snapshot(id)
+	endTrace(ConnSummary{Bytes: stats.Bytes, TotalDelayTime: stats.TotalDelayTime, EvictedBuffers: stats.EvictedBuffers, CloseReason: closeReason})
+	s.endTimeline(id, timeline, closeReason)
+	s.connBatcher.add(stats)
+	if s.warmingUp(acceptedAt) {
+		return
+	}
+	s.statsMu.Lock()
+	s.connDurations.record(s.clock.Now().Sub(acceptedAt))
+	p.counters.mergeQueueWait(s.queueWait)
+	s.statsMu.Unlock()
+}
+
+// selectDestination returns the proxy destination picked by DestinationFunc
+// along with its resolved address
+func (s *Speedbump) selectDestination(remote net.Addr) (string, *net.TCPAddr, error) {
+	dest, err := s.destinationFunc(remote)
+	if err != nil {
+		return "", nil, err
+	}
+	addr, err := net.ResolveTCPAddr("tcp", dest)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error resolving destination address: %s", err)
+	}
+	return dest, addr, nil
+}
+
+// warmingUp reports whether a given point in time falls within the stats warmup period,
+// during which events are not recorded in the aggregate stats
+func (s *Speedbump) warmingUp(when time.Time) bool {
+	return s.statsWarmup > 0 && when.Before(s.startedAt.Add(s.statsWarmup))
+}
+
+// acquireBackendSlot waits for a free proxy destination connection slot if BackendMaxConns
+// is set. It returns false if the client connection should be rejected.
+func (s *Speedbump) acquireBackendSlot(ctx context.Context, l hclog.Logger) bool {
+	if s.backendSlots == nil {
+		return true
+	}
+	select {
+	case s.backendSlots <- struct{}{}:
+		return true
+	default:
+	}
+	l.Debug("Proxy destination connection limit reached, queueing client connection")
+	var timeout <-chan time.Time
+	if s.backendQueueTimeout > 0 {
+		timeout = s.clock.After(s.backendQueueTimeout)
+	}
+	select {
+	case s.backendSlots <- struct{}{}:
+		return true
+	case <-timeout:
+		err := &BackendQueueTimeoutError{Timeout: s.backendQueueTimeout}
+		s.warnLimiter.warn(l, "Rejecting queued client connection", "err", err)
+		s.handleTimeout(err)
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Speedbump) releaseBackendSlot() {
+	if s.backendSlots != nil {
+		<-s.backendSlots
+	}
 }
 
 // Start launches a Speedbump instance. This operation will unblock either
 // as soon as the proxy starts listening or when a startup error occurrs.
 func (s *Speedbump) Start() error {
-	listener, err := net.ListenTCP("tcp", &s.srcAddr)
+	var listener *net.TCPListener
+	var err error
+	if s.portRange != nil {
+		listener, err = s.portRange.listen(s.srcAddr)
+	} else {
+		listener, err = net.ListenTCP("tcp", &s.srcAddr)
+	}
 	if err != nil {
 		return fmt.Errorf("Error starting TCP listener: %s", err)
 	}
 	s.listener = listener
+	// the port may have been picked from the preferred range (or by the OS if it's 0)
+	s.srcAddr.Port = listener.Addr().(*net.TCPAddr).Port
+	s.cfg.Port = s.srcAddr.Port
 
+	// ctx is created before the admin API is started, so that its
+	// streaming handlers can observe Stop()
 	ctx, cancel := context.WithCancel(context.Background())
 	s.ctx = ctx
 	s.ctxCancel = cancel
 
-	s.log.Info("Started speedbump", "port", s.srcAddr.Port, "dest", s.destAddr.String())
+	if err := s.startAdmin(); err != nil {
+		cancel()
+		listener.Close()
+		return err
+	}
+	s.startedAt = s.clock.Now()
+
+	if s.localBackend != nil {
+		s.log.Info("Started speedbump", "port", s.srcAddr.Port, "mode", s.cfg.Mode)
+	} else {
+		s.log.Info("Started speedbump", "port", s.srcAddr.Port, "dest", s.Destination().String())
+	}
+	if s.cfg.ProbeBackendOnStart && s.localBackend == nil {
+		s.probeBackend()
+	}
+	if s.destHealth != nil && s.localBackend == nil {
+		_, dest := s.destination()
+		s.destHealth.check(ctx, dest, s.log)
+		go s.runHealthChecks()
+	}
+	if s.latencyFlag != nil {
+		s.pollLatencyFlag()
+		go s.runLatencyFlag()
+	}
 
 	go s.startAcceptLoop()
+	if s.cfg.EnableStdinControl {
+		go s.serveControl(os.Stdin, os.Stdout)
+	}
 	return nil
 }
 
@@ -142,9 +1303,165 @@ func (s *Speedbump) Stop() {
 	s.log.Info("Stopping speedbump")
 	// close TCP listener so that startAcceptLoop returns
 	s.listener.Close()
+	if s.adminServer != nil {
+		s.adminServer.Close()
+	}
 	// notify all proxy connections
 	s.ctxCancel()
 	s.log.Debug("Waiting for active connections to be closed")
-	s.active.Wait()
+	s.drainConnections()
+	s.connBatcher.flush()
+	s.warnLimiter.flushAll()
 	s.log.Info("Speedbump stopped")
 }
+
+// Addr returns the address the instance listens on (nil before Start is called),
+// which contains the port chosen from PortRangePreferred (or by the OS if Port is 0)
+func (s *Speedbump) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Destination returns the proxy destination address resolved when the instance was created
+// (or by the last SetDestination call). If TLSDestAddr is configured, it's the destination
+// of plaintext connections only, while with HappyEyeballs enabled, it's the first of
+// the host's addresses resolved at that point.
+func (s *Speedbump) Destination() net.Addr {
+	addr, _ := s.destination()
+	return &addr
+}
+
+// Stats returns a snapshot of the Speedbump instance's counters
+func (s *Speedbump) Stats() Stats {
+	injected := s.AverageInjectedLatency()
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	stats := s.stats
+	stats.AverageInjectedLatency = injected
+	stats.ConnectionDurations = s.connDurations.stats()
+	stats.AcceptIntervals = s.acceptIntervals.stats()
+	stats.AcceptProcessing = s.acceptProcessing.stats()
+	stats.QueueWait = s.queueWait.stats()
+	stats.QueueMemory = s.queueMem.usage()
+	return stats
+}
+
+// FreezeDirection stops forwarding data flowing in a given direction across all
+// proxy connections (including the ones accepted afterwards) until ThawDirection
+// is called. Data flowing in the opposite direction is not affected.
+func (s *Speedbump) FreezeDirection(direction Direction) {
+	s.log.Info("Freezing direction", "direction", direction)
+	s.freeze.freeze(direction)
+}
+
+// ArmLatency replaces the latency configuration used for proxy connections accepted
+// from now on, while existing connections keep the latency they were accepted with
+// (a nil cfg disables latency for new connections). The latency config replaced by
+// Disable (if any) is discarded.
+//
+// ArmLatency, Enable, Disable and SetLatency may be called concurrently, in which case
+// they take effect one at a time in some order, with the last one winning. Each connection
+// is accepted with the latency (and bandwidth, if LinkWindow is set) of one of them.
+func (s *Speedbump) ArmLatency(cfg *LatencyCfg) {
+	var latency *LatencyCfg
+	if cfg != nil {
+		copied := *cfg
+		latency = &copied
+	}
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+	s.disabledLatency = nil
+	s.armLatency(latency)
+}
+
+// Disable disables latency for new connections, keeping the latency config
+// in order for Enable to restore it
+func (s *Speedbump) Disable() {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+	if s.cfg.Latency != nil {
+		s.disabledLatency = s.cfg.Latency
+	}
+	s.armLatency(nil)
+}
+
+// Enable restores the latency config of new connections replaced by Disable.
+// It returns false if latency wasn't disabled.
+func (s *Speedbump) Enable() bool {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+	if s.disabledLatency == nil {
+		return false
+	}
+	latency := s.disabledLatency
+	s.disabledLatency = nil
+	s.armLatency(latency)
+	return true
+}
+
+// SetLatency changes the base latency of new connections, keeping the rest of the latency
+// config. If latency was disabled, it gets enabled again with the base latency changed.
+func (s *Speedbump) SetLatency(base time.Duration) {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+	latency := &LatencyCfg{}
+	if s.cfg.Latency != nil {
+		*latency = *s.cfg.Latency
+	} else if s.disabledLatency != nil {
+		*latency = *s.disabledLatency
+	}
+	latency.Base = base
+	s.disabledLatency = nil
+	s.armLatency(latency)
+}
+
+// armLatency publishes the latency config of new connections (latencyMu must be held).
+// The config must not be modified afterwards.
+func (s *Speedbump) armLatency(latency *LatencyCfg) {
+	if latency != nil {
+		s.log.Info("Arming latency for new connections", "base", latency.Base)
+	} else {
+		s.log.Info("Disabling latency for new connections")
+	}
+	bandwidth := s.latencyGen.load().bandwidth
+	if s.cfg.LinkWindow > 0 {
+		bandwidth.rate = linkBandwidth(s.cfg.LinkWindow, latency)
+		s.cfg.Bandwidth = bandwidth.rate
+		s.log.Info("Adjusting bandwidth to the link window", "bandwidth", bandwidth.rate)
+	}
+	s.latencyGen.store(armedLatency{newLatencyGenerator(s.clock.Now(), latency), bandwidth})
+	s.cfg.Latency = latency
+}
+
+// CloseConnection closes an active proxy connection with a given ID,
+// returning false if there is no such connection
+func (s *Speedbump) CloseConnection(id int) bool {
+	s.connsMu.Lock()
+	c, ok := s.conns[id]
+	s.connsMu.Unlock()
+	if !ok {
+		return false
+	}
+	s.log.Info("Closing proxy connection", "connection", id)
+	c.closeProxyConnections()
+	return true
+}
+
+// ThawDirection resumes forwarding data flowing in a direction previously frozen with FreezeDirection
+func (s *Speedbump) ThawDirection(direction Direction) {
+	s.log.Info("Thawing direction", "direction", direction)
+	s.freeze.thaw(direction)
+}
+
+func newBackendTLSConfig(cfg *SpeedbumpCfg) *tls.Config {
+	serverName := cfg.BackendTLSServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(cfg.DestAddr)
+	}
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: cfg.BackendInsecureSkipVerify,
+	}
+}
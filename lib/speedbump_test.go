@@ -1,10 +1,16 @@
 package lib
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -37,28 +43,44 @@ func startEchoSrv(port int) error {
 
 func TestNewSpeedbump(t *testing.T) {
 	cfg := SpeedbumpCfg{
-		"localhost",
-		8000,
-		"localhost:1234",
-		0xffff,
-		100,
-		defaultLatencyCfg,
-		"WARN",
+		Host:       "localhost",
+		Port:       8000,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		QueueSize:  100,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
 	}
 	s, err := NewSpeedbump(&cfg)
 	assert.Nil(t, err)
 	assert.Equal(t, 0xffff, s.bufferSize)
 }
 
+func TestSpeedbumpDestination(t *testing.T) {
+	cfg := SpeedbumpCfg{
+		Port:       8000,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		LogLevel:   "WARN",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	dest, ok := s.Destination().(*net.TCPAddr)
+	assert.True(t, ok)
+	assert.True(t, dest.IP.IsLoopback())
+	assert.Equal(t, 1234, dest.Port)
+	assert.Equal(t, "tcp", dest.Network())
+}
+
 func TestNewSpeedbumpInvalidHost(t *testing.T) {
 	cfg := SpeedbumpCfg{
-		"nope",
-		8080,
-		"localhost:1234",
-		0xffff,
-		100,
-		defaultLatencyCfg,
-		"WARN",
+		Host:       "nope",
+		Port:       8080,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		QueueSize:  100,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
 	}
 	s, err := NewSpeedbump(&cfg)
 	assert.Nil(t, s)
@@ -67,13 +89,13 @@ func TestNewSpeedbumpInvalidHost(t *testing.T) {
 
 func TestNewSpeedbumpErrorResolvingLocal(t *testing.T) {
 	cfg := SpeedbumpCfg{
-		"localhost",
-		-1,
-		"localhost:1234",
-		0xffff,
-		100,
-		defaultLatencyCfg,
-		"WARN",
+		Host:       "localhost",
+		Port:       -1,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		QueueSize:  100,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
 	}
 	s, err := NewSpeedbump(&cfg)
 	assert.Nil(t, s)
@@ -82,13 +104,13 @@ func TestNewSpeedbumpErrorResolvingLocal(t *testing.T) {
 
 func TestNewSpeedbumpErrorResolvingDest(t *testing.T) {
 	cfg := SpeedbumpCfg{
-		"localhost",
-		8000,
-		"nope:1234",
-		0xffff,
-		100,
-		defaultLatencyCfg,
-		"WARN",
+		Host:       "localhost",
+		Port:       8000,
+		DestAddr:   "nope:1234",
+		BufferSize: 0xffff,
+		QueueSize:  100,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
 	}
 	s, err := NewSpeedbump(&cfg)
 	assert.Nil(t, s)
@@ -111,13 +133,13 @@ func TestNewSpeedbumpDefaultQueueSize(t *testing.T) {
 
 func TestStartListenError(t *testing.T) {
 	cfg := SpeedbumpCfg{
-		"localhost",
-		1, // a privileged port
-		"localhost:1234",
-		0xffff,
-		100,
-		defaultLatencyCfg,
-		"WARN",
+		Host:       "localhost",
+		Port:       1, // a privileged port
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		QueueSize:  100,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
 	}
 	s, _ := NewSpeedbump(&cfg)
 
@@ -142,17 +164,17 @@ func TestSpeedbumpWithEchoServer(t *testing.T) {
 	go startEchoSrv(port)
 
 	cfg := SpeedbumpCfg{
-		"localhost",
-		8000,
-		testSrvAddr,
-		0xffff,
-		100,
-		&LatencyCfg{
+		Host:       "localhost",
+		Port:       8000,
+		DestAddr:   testSrvAddr,
+		BufferSize: 0xffff,
+		QueueSize:  100,
+		Latency: &LatencyCfg{
 			Base:          time.Millisecond * 100,
 			SineAmplitude: time.Millisecond * 100,
 			SinePeriod:    time.Millisecond * 400,
 		},
-		"WARN",
+		LogLevel: "WARN",
 	}
 	s, err := NewSpeedbump(&cfg)
 	s.Start()
@@ -195,3 +217,897 @@ func TestSpeedbumpWithEchoServer(t *testing.T) {
 	assert.Equal(t, []byte("another-test"), trimmedRes)
 	assert.True(t, isDurationCloseTo(time.Millisecond*200, secondOpElapsed, 20))
 }
+
+func TestSpeedbumpAcceptIdleTimeout(t *testing.T) {
+	timeouts := make(chan error, 10)
+	clock := NewVirtualClock(time.Unix(0, 0))
+	cfg := SpeedbumpCfg{
+		Host:              "127.0.0.1",
+		Port:              0,
+		DestAddr:          "localhost:1234",
+		BufferSize:        0xffff,
+		Latency:           defaultLatencyCfg,
+		LogLevel:          "ERROR",
+		AcceptIdleTimeout: time.Millisecond * 50,
+		Clock:             clock,
+		OnTimeout: func(err error) {
+			timeouts <- err
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Millisecond * 49)
+	assert.Empty(t, timeouts)
+	clock.Advance(time.Millisecond)
+	err = <-timeouts
+	var idleErr *AcceptIdleError
+	assert.ErrorAs(t, err, &idleErr)
+	assert.Equal(t, time.Millisecond*50, idleErr.Idle)
+
+	// the timeout is reported again after each idle period
+	clock.BlockUntil(1)
+	clock.Advance(time.Millisecond * 50)
+	<-timeouts
+	assert.Equal(t, 2, s.Stats().AcceptIdleTimeouts)
+	assert.Equal(t, 0, s.Stats().DialTimeouts)
+}
+
+func TestSpeedbumpDialTimeout(t *testing.T) {
+	timeouts := make(chan error, 10)
+	cfg := SpeedbumpCfg{
+		Port:        8002,
+		DestAddr:    "localhost:1234",
+		BufferSize:  0xffff,
+		Latency:     defaultLatencyCfg,
+		LogLevel:    "ERROR",
+		DialTimeout: time.Nanosecond,
+		OnTimeout: func(err error) {
+			timeouts <- err
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	conn, err := net.Dial("tcp", "localhost:8002")
+	assert.Nil(t, err)
+	// the proxy closes the client connection once dialing the destination times out
+	_, err = conn.Read(make([]byte, 10))
+	assert.Equal(t, io.EOF, err)
+
+	s.Stop()
+
+	assert.Equal(t, 1, s.Stats().DialTimeouts)
+	assert.Equal(t, 0, s.Stats().AcceptIdleTimeouts)
+	err = <-timeouts
+	var dialErr *DialTimeoutError
+	assert.ErrorAs(t, err, &dialErr)
+	assert.Equal(t, "127.0.0.1:1234", dialErr.Addr)
+}
+
+// startRecordingSrv listens on a given port and accepts TCP connections in the
+// background, sending the first chunk of data received on each of them
+// via the received channel before closing it
+func startRecordingSrv(port int, received chan []byte) error {
+	srv, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer srv.Close()
+		for {
+			conn, err := srv.Accept()
+			if err != nil {
+				continue
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				res := make([]byte, 1024)
+				bytes, _ := c.Read(res)
+				received <- res[:bytes]
+			}(conn)
+		}
+	}()
+	return nil
+}
+
+func TestSpeedbumpProtocolDetection(t *testing.T) {
+	plainReceived := make(chan []byte, 1)
+	tlsReceived := make(chan []byte, 1)
+	assert.Nil(t, startRecordingSrv(9007, plainReceived))
+	assert.Nil(t, startRecordingSrv(9008, tlsReceived))
+
+	cfg := SpeedbumpCfg{
+		Port:        8003,
+		DestAddr:    "localhost:9007",
+		TLSDestAddr: "localhost:9008",
+		BufferSize:  0xffff,
+		Latency:     defaultLatencyCfg,
+		LogLevel:    "WARN",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	tlsConn, err := net.Dial("tcp", "localhost:8003")
+	assert.Nil(t, err)
+	// the handshake fails once the recording server closes the connection
+	go tls.Client(tlsConn, &tls.Config{ServerName: "example.com"}).Handshake()
+
+	plainConn, err := net.Dial("tcp", "localhost:8003")
+	assert.Nil(t, err)
+	plainConn.Write([]byte("plaintext-payload"))
+
+	clientHello := <-tlsReceived
+	assert.Equal(t, byte(tlsRecordTypeHandshake), clientHello[0])
+	assert.Contains(t, string(clientHello), "example.com")
+	assert.Equal(t, []byte("plaintext-payload"), <-plainReceived)
+
+	tlsConn.Close()
+	plainConn.Close()
+}
+
+func TestNewSpeedbumpErrorResolvingTLSDest(t *testing.T) {
+	cfg := SpeedbumpCfg{
+		Port:        8000,
+		DestAddr:    "localhost:1234",
+		TLSDestAddr: "nope:1234",
+		BufferSize:  0xffff,
+		Latency:     defaultLatencyCfg,
+		LogLevel:    "WARN",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, s)
+	assert.True(t, strings.HasPrefix(err.Error(), "Error resolving TLS destination"))
+}
+
+// waitForListener blocks until a TCP listener accepts connections on a given address
+func waitForListener(addr string) {
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSpeedbumpConnContextDeadline(t *testing.T) {
+	go startEchoSrv(9009)
+	waitForListener("localhost:9009")
+
+	var cancels []context.CancelFunc
+	remotes := make(chan net.Addr, 1)
+	cfg := SpeedbumpCfg{
+		Port:       8004,
+		DestAddr:   "localhost:9009",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+		ConnContextFunc: func(ctx context.Context, remote net.Addr) context.Context {
+			remotes <- remote
+			ctx, cancel := context.WithTimeout(ctx, time.Millisecond*300)
+			cancels = append(cancels, cancel)
+			return ctx
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	start := time.Now()
+	conn, err := net.Dial("tcp", "localhost:8004")
+	assert.Nil(t, err)
+
+	conn.Write([]byte("test-string"))
+	res := make([]byte, 1024)
+	bytes, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:bytes])
+
+	// the connection is closed by the proxy once its deadline is exceeded
+	_, err = conn.Read(res)
+	elapsed := time.Since(start)
+	assert.Equal(t, io.EOF, err)
+	assert.GreaterOrEqual(t, int64(elapsed), int64(time.Millisecond*300))
+	assert.Less(t, int64(elapsed), int64(time.Second*2))
+	assert.Equal(t, conn.LocalAddr().String(), (<-remotes).String())
+
+	s.Stop()
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+// startAcceptingSrv listens on a given port and accepts TCP connections
+// in the background, passing them via the conns channel
+func startAcceptingSrv(port int, conns chan net.Conn) error {
+	srv, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer srv.Close()
+		for {
+			conn, err := srv.Accept()
+			if err != nil {
+				continue
+			}
+			conns <- conn
+		}
+	}()
+	return nil
+}
+
+func TestSpeedbumpFreezeDirection(t *testing.T) {
+	backendConns := make(chan net.Conn, 1)
+	assert.Nil(t, startAcceptingSrv(9010, backendConns))
+
+	cfg := SpeedbumpCfg{
+		Port:       8005,
+		DestAddr:   "localhost:9010",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	conn, err := net.Dial("tcp", "localhost:8005")
+	assert.Nil(t, err)
+	backendConn := <-backendConns
+
+	s.FreezeDirection(ClientToServer)
+
+	conn.Write([]byte("request"))
+	backendConn.Write([]byte("response"))
+
+	// responses keep flowing...
+	res := make([]byte, 1024)
+	bytes, _ := conn.Read(res)
+	assert.Equal(t, []byte("response"), res[:bytes])
+
+	// ...while requests are stalled
+	backendConn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	_, err = backendConn.Read(res)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	s.ThawDirection(ClientToServer)
+
+	backendConn.SetReadDeadline(time.Time{})
+	bytes, _ = backendConn.Read(res)
+	assert.Equal(t, []byte("request"), res[:bytes])
+
+	conn.Close()
+	backendConn.Close()
+	s.Stop()
+}
+
+func TestSpeedbumpReconnectBackend(t *testing.T) {
+	backend, err := net.Listen("tcp", "localhost:9011")
+	assert.Nil(t, err)
+
+	cfg := SpeedbumpCfg{
+		Port:              8006,
+		DestAddr:          "localhost:9011",
+		BufferSize:        0xffff,
+		Latency:           defaultLatencyCfg,
+		LogLevel:          "ERROR",
+		ReconnectBackend:  true,
+		ReconnectAttempts: 50,
+		ReconnectBackoff:  time.Millisecond * 10,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	conn, err := net.Dial("tcp", "localhost:8006")
+	assert.Nil(t, err)
+
+	res := make([]byte, 1024)
+	conn.Write([]byte("first"))
+	firstBackendConn, err := backend.Accept()
+	assert.Nil(t, err)
+	bytes, _ := firstBackendConn.Read(res)
+	assert.Equal(t, []byte("first"), res[:bytes])
+
+	// the backend goes down, resetting the connection mid-stream
+	backend.Close()
+	firstBackendConn.(*net.TCPConn).SetLinger(0)
+	firstBackendConn.Close()
+
+	// reconnect attempts fail until the backend comes back up
+	time.Sleep(time.Millisecond * 100)
+	backend, err = net.Listen("tcp", "localhost:9011")
+	assert.Nil(t, err)
+	defer backend.Close()
+	backend.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second * 5))
+	secondBackendConn, err := backend.Accept()
+	assert.Nil(t, err)
+
+	conn.Write([]byte("second"))
+	bytes, _ = secondBackendConn.Read(res)
+	assert.Equal(t, []byte("second"), res[:bytes])
+
+	// the client connection survived
+	secondBackendConn.Write([]byte("response"))
+	bytes, _ = conn.Read(res)
+	assert.Equal(t, []byte("response"), res[:bytes])
+
+	conn.Close()
+	secondBackendConn.Close()
+	s.Stop()
+}
+
+func TestSpeedbumpShutdownMessage(t *testing.T) {
+	go startEchoSrv(9012)
+	waitForListener("localhost:9012")
+
+	cfg := SpeedbumpCfg{
+		Port:            8007,
+		DestAddr:        "localhost:9012",
+		BufferSize:      0xffff,
+		Latency:         defaultLatencyCfg,
+		LogLevel:        "WARN",
+		ShutdownMessage: []byte("421 shutting down\r\n"),
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	conn, err := net.Dial("tcp", "localhost:8007")
+	assert.Nil(t, err)
+	conn.Write([]byte("test-string"))
+	res := make([]byte, 1024)
+	bytes, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:bytes])
+
+	s.Stop()
+
+	received, err := io.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("421 shutting down\r\n"), received)
+}
+
+func TestSpeedbumpShutdownMessageConnContextDeadline(t *testing.T) {
+	go startEchoSrv(9014)
+	waitForListener("localhost:9014")
+	var cancels []context.CancelFunc
+
+	cfg := SpeedbumpCfg{
+		Port:            8009,
+		DestAddr:        "localhost:9014",
+		BufferSize:      0xffff,
+		Latency:         defaultLatencyCfg,
+		LogLevel:        "WARN",
+		ShutdownMessage: []byte("421 shutting down\r\n"),
+		ConnContextFunc: func(ctx context.Context, remote net.Addr) context.Context {
+			ctx, cancel := context.WithTimeout(ctx, time.Millisecond*300)
+			cancels = append(cancels, cancel)
+			return ctx
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8009")
+	assert.Nil(t, err)
+	conn.Write([]byte("test-string"))
+	res := make([]byte, 1024)
+	bytes, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:bytes])
+
+	// the connection is closed once its deadline passes, without the shutdown message
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	received, err := io.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Empty(t, received)
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+func TestSpeedbumpProtocolDetectionServerSpeaksFirst(t *testing.T) {
+	backendConns := make(chan net.Conn, 1)
+	assert.Nil(t, startAcceptingSrv(9013, backendConns))
+
+	cfg := SpeedbumpCfg{
+		Port:             8008,
+		DestAddr:         "localhost:9013",
+		TLSDestAddr:      "localhost:9008",
+		TLSDetectTimeout: time.Millisecond * 50,
+		BufferSize:       0xffff,
+		Latency:          defaultLatencyCfg,
+		LogLevel:         "WARN",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	// the client waits for a greeting without sending anything
+	conn, err := net.Dial("tcp", "localhost:8008")
+	assert.Nil(t, err)
+
+	backendConn := <-backendConns
+	backendConn.Write([]byte("220 ready\r\n"))
+
+	res := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	bytes, err := conn.Read(res)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("220 ready\r\n"), res[:bytes])
+
+	conn.Close()
+	backendConn.Close()
+	s.Stop()
+}
+
+func TestSpeedbumpArmLatency(t *testing.T) {
+	go startEchoSrv(9015)
+	waitForListener("localhost:9015")
+
+	cfg := SpeedbumpCfg{
+		Port:       8010,
+		DestAddr:   "localhost:9015",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	roundTrip := func(conn net.Conn) time.Duration {
+		start := time.Now()
+		conn.Write([]byte("test-string"))
+		res := make([]byte, 1024)
+		bytes, _ := conn.Read(res)
+		assert.Equal(t, []byte("test-string"), res[:bytes])
+		return time.Since(start)
+	}
+
+	before, err := net.Dial("tcp", "localhost:8010")
+	assert.Nil(t, err)
+	defer before.Close()
+	roundTrip(before)
+
+	s.ArmLatency(&LatencyCfg{Base: time.Millisecond * 300})
+
+	after, err := net.Dial("tcp", "localhost:8010")
+	assert.Nil(t, err)
+	defer after.Close()
+
+	assert.GreaterOrEqual(t, int64(roundTrip(after)), int64(time.Millisecond*300))
+	// the connection accepted before arming is not affected
+	assert.Less(t, int64(roundTrip(before)), int64(time.Millisecond*150))
+
+	var buf bytes.Buffer
+	assert.Nil(t, s.SaveConfig(&buf, "json"))
+	loaded, err := LoadConfig(&buf, "json")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Millisecond*300, loaded.Latency.Base)
+}
+
+func TestSpeedbumpConnectionDurations(t *testing.T) {
+	go startEchoSrv(9017)
+	waitForListener("localhost:9017")
+
+	cfg := SpeedbumpCfg{
+		Port:       8015,
+		DestAddr:   "localhost:9017",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "WARN",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	lifetimes := []time.Duration{100, 200, 300, 400}
+	for _, lifetime := range lifetimes {
+		go func(lifetime time.Duration) {
+			conn, err := net.Dial("tcp", "localhost:8015")
+			assert.Nil(t, err)
+			time.Sleep(lifetime * time.Millisecond)
+			conn.Close()
+		}(lifetime)
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for s.Stats().ConnectionDurations.Count < len(lifetimes) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	stats := s.Stats().ConnectionDurations
+
+	assert.Equal(t, 4, stats.Count)
+	assert.True(t, isDurationCloseTo(time.Millisecond*200, stats.P50, 15), stats.P50)
+	assert.True(t, isDurationCloseTo(time.Millisecond*400, stats.P90, 15), stats.P90)
+	assert.True(t, isDurationCloseTo(time.Millisecond*400, stats.Max, 15), stats.Max)
+}
+
+func TestSpeedbumpNilLatency(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer backend.Close()
+	go serveEcho(backend)
+
+	cfg := SpeedbumpCfg{
+		Host:       "127.0.0.1",
+		Port:       0,
+		DestAddr:   backend.Addr().String(),
+		BufferSize: 0xffff,
+		LogLevel:   "WARN",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+	assert.Equal(t, noLatencyGenerator{}, s.latencyGen.load().gen)
+
+	conn, err := net.Dial("tcp", s.dialAddr())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	conn.Write([]byte("test-string"))
+	res := make([]byte, 1024)
+	n, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:n])
+
+	// the data is passed through with no latency armed in either direction
+	s.connsMu.Lock()
+	assert.Len(t, s.conns, 1)
+	for _, p := range s.conns {
+		assert.Equal(t, noLatencyGenerator{}, p.latencyGen)
+		assert.Nil(t, p.returnLatencyGen)
+	}
+	s.connsMu.Unlock()
+
+	// latency can be disabled for new connections as well
+	s.ArmLatency(nil)
+	var buf bytes.Buffer
+	assert.Nil(t, s.SaveConfig(&buf, "json"))
+	loaded, err := LoadConfig(&buf, "json")
+	assert.Nil(t, err)
+	assert.Nil(t, loaded.Latency)
+}
+
+func TestSpeedbumpBackendMaxConns(t *testing.T) {
+	backendConns := make(chan net.Conn, 3)
+	assert.Nil(t, startAcceptingSrv(9019, backendConns))
+
+	timeouts := make(chan error, 1)
+	cfg := SpeedbumpCfg{
+		Port:                8017,
+		DestAddr:            "localhost:9019",
+		BufferSize:          0xffff,
+		Latency:             defaultLatencyCfg,
+		LogLevel:            "ERROR",
+		BackendMaxConns:     1,
+		BackendQueueTimeout: time.Millisecond * 500,
+		OnTimeout: func(err error) {
+			timeouts <- err
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	first, err := net.Dial("tcp", "localhost:8017")
+	assert.Nil(t, err)
+	firstBackendConn := <-backendConns
+	defer firstBackendConn.Close()
+
+	// the second client is queued without dialing the backend
+	second, err := net.Dial("tcp", "localhost:8017")
+	assert.Nil(t, err)
+	defer second.Close()
+	second.Write([]byte("queued"))
+	select {
+	case <-backendConns:
+		t.Fatal("the backend was dialed despite reaching the connection limit")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// the second client is served once the first one disconnects
+	first.Close()
+	secondBackendConn := <-backendConns
+	defer secondBackendConn.Close()
+	res := make([]byte, 1024)
+	n, _ := secondBackendConn.Read(res)
+	assert.Equal(t, []byte("queued"), res[:n])
+
+	// the third client times out waiting in the queue
+	third, err := net.Dial("tcp", "localhost:8017")
+	assert.Nil(t, err)
+	defer third.Close()
+	third.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = third.Read(res)
+	assert.Equal(t, io.EOF, err)
+
+	var queueErr *BackendQueueTimeoutError
+	assert.ErrorAs(t, <-timeouts, &queueErr)
+	assert.Equal(t, time.Millisecond*500, queueErr.Timeout)
+	assert.Equal(t, 1, s.Stats().BackendQueueTimeouts)
+}
+
+func TestSpeedbumpCloseLinger(t *testing.T) {
+	backendConns := make(chan net.Conn, 1)
+	assert.Nil(t, startAcceptingSrv(9022, backendConns))
+
+	cfg := SpeedbumpCfg{
+		Port:        8020,
+		DestAddr:    "localhost:9022",
+		BufferSize:  0xffff,
+		LogLevel:    "WARN",
+		CloseLinger: time.Millisecond * 300,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	// the client closes first, so the proxy destination's close is deferred
+	client, err := net.Dial("tcp", "localhost:8020")
+	assert.Nil(t, err)
+	backend := <-backendConns
+	start := time.Now()
+	client.Close()
+	_, err = io.ReadAll(backend)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Millisecond*300))
+	backend.Close()
+
+	// the proxy destination closes first, so the client's close is deferred
+	client, err = net.Dial("tcp", "localhost:8020")
+	assert.Nil(t, err)
+	defer client.Close()
+	backend = <-backendConns
+	start = time.Now()
+	backend.Close()
+	_, err = io.ReadAll(client)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Millisecond*300))
+	assert.Less(t, int64(time.Since(start)), int64(time.Millisecond*600))
+}
+
+func TestSpeedbumpAcceptTiming(t *testing.T) {
+	go startEchoSrv(9024)
+	waitForListener("localhost:9024")
+
+	cfg := SpeedbumpCfg{
+		Port:       8022,
+		DestAddr:   "localhost:9024",
+		BufferSize: 0xffff,
+		LogLevel:   "ERROR",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	clients := 50
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", "localhost:8022")
+			assert.Nil(t, err)
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for s.Stats().AcceptProcessing.Count < clients && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	stats := s.Stats()
+	assert.Equal(t, clients, stats.AcceptProcessing.Count)
+	assert.Equal(t, clients-1, stats.AcceptIntervals.Count)
+	assert.Greater(t, int64(stats.AcceptIntervals.Max), int64(0))
+	assert.GreaterOrEqual(t, int64(stats.AcceptIntervals.Max), int64(stats.AcceptIntervals.P50))
+}
+
+func TestSpeedbumpStatsWarmup(t *testing.T) {
+	go startEchoSrv(9028)
+	waitForListener("localhost:9028")
+
+	cfg := SpeedbumpCfg{
+		Port:        8026,
+		DestAddr:    "localhost:9028",
+		BufferSize:  0xffff,
+		LogLevel:    "WARN",
+		StatsWarmup: time.Millisecond * 300,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	transfer := func() {
+		conn, err := net.Dial("tcp", "localhost:8026")
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.Write([]byte("test-string"))
+		res := make([]byte, 1024)
+		n, _ := conn.Read(res)
+		// traffic is proxied normally during the warmup
+		assert.Equal(t, []byte("test-string"), res[:n])
+	}
+
+	transfer()
+	transfer()
+	time.Sleep(time.Millisecond * 300)
+	transfer()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for s.Stats().ConnectionDurations.Count < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	// allow for connections accepted during the warmup to be closed
+	time.Sleep(time.Millisecond * 50)
+	stats := s.Stats()
+	assert.Equal(t, 1, stats.ConnectionDurations.Count)
+	assert.Equal(t, 1, stats.AcceptProcessing.Count)
+	assert.Equal(t, 0, stats.AcceptIntervals.Count)
+}
+
+func TestSpeedbumpDestinationFunc(t *testing.T) {
+	receivedA := make(chan []byte, 1)
+	receivedB := make(chan []byte, 1)
+	assert.Nil(t, startRecordingSrv(9029, receivedA))
+	assert.Nil(t, startRecordingSrv(9030, receivedB))
+
+	destinations := make(chan string, 3)
+	destinations <- "localhost:9029"
+	destinations <- "localhost:9030"
+	cfg := SpeedbumpCfg{
+		Port:       8027,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		LogLevel:   "ERROR",
+		DestinationFunc: func(remote net.Addr) (string, error) {
+			assert.True(t, remote.(*net.TCPAddr).IP.IsLoopback())
+			select {
+			case dest := <-destinations:
+				return dest, nil
+			default:
+				return "", errors.New("no destination")
+			}
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	send := func(data string) net.Conn {
+		conn, err := net.Dial("tcp", "localhost:8027")
+		assert.Nil(t, err)
+		conn.Write([]byte(data))
+		return conn
+	}
+
+	a := send("client-a")
+	defer a.Close()
+	assert.Equal(t, []byte("client-a"), <-receivedA)
+	b := send("client-b")
+	defer b.Close()
+	assert.Equal(t, []byte("client-b"), <-receivedB)
+
+	// the connection is closed if the func returns an error
+	c := send("client-c")
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c.Read(make([]byte, 10))
+	assert.NotNil(t, err)
+	assert.False(t, isTimeout(err))
+}
+
+func TestSpeedbumpDestinationLatency(t *testing.T) {
+	for _, port := range []int{9044, 9045, 9046} {
+		go startEchoSrv(port)
+		waitForListener(fmt.Sprintf("localhost:%d", port))
+	}
+
+	destinations := make(chan string, 3)
+	destinations <- "localhost:9044"
+	destinations <- "localhost:9045"
+	destinations <- "localhost:9046"
+	cfg := SpeedbumpCfg{
+		Port:       8049,
+		DestAddr:   "localhost:1234",
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{Base: time.Millisecond * 20},
+		DestinationLatency: map[string]*LatencyCfg{
+			"localhost:9044": {Base: time.Millisecond * 60},
+			"localhost:9045": nil,
+		},
+		LogLevel: "ERROR",
+		DestinationFunc: func(remote net.Addr) (string, error) {
+			return <-destinations, nil
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	res := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", "localhost:8049")
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.Write([]byte("test-string"))
+		n, err := conn.Read(res)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("test-string"), res[:n])
+	}
+
+	stats := s.ConnStats()
+	assert.Len(t, stats, 3)
+	// each destination gets its own latency
+	assert.Equal(t, time.Millisecond*60, stats[0].TotalDelayTime.ClientToServer)
+	// a nil config disables latency
+	assert.Equal(t, time.Duration(0), stats[1].TotalDelayTime.ClientToServer)
+	// while destinations without a config get the default latency
+	assert.Equal(t, time.Millisecond*20, stats[2].TotalDelayTime.ClientToServer)
+}
+
+func TestSpeedbumpServerToClientLatency(t *testing.T) {
+	go startEchoSrv(9032)
+	waitForListener("localhost:9032")
+
+	cfg := SpeedbumpCfg{
+		Port:       8029,
+		DestAddr:   "localhost:9032",
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{Base: time.Millisecond * 5},
+		ServerToClientLatency: &LatencyCfg{
+			Base:           time.Millisecond * 10,
+			GaussianStdDev: time.Millisecond * 3,
+			Seed:           1,
+		},
+		LogLevel: "ERROR",
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8029")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	roundTrips := 30
+	res := make([]byte, 1024)
+	for i := 0; i < roundTrips; i++ {
+		start := time.Now()
+		conn.Write([]byte("test-string"))
+		n, err := conn.Read(res)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("test-string"), res[:n])
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*5)
+	}
+
+	stats := s.ConnStats()
+	assert.Len(t, stats, 1)
+	// the fixed latency is added to each buffer sent by the client
+	assert.Equal(t, time.Millisecond*5*time.Duration(roundTrips), stats[0].TotalDelayTime.ClientToServer)
+	// while responses get normally distributed latency with the configured mean
+	downstream := stats[0].TotalDelayTime.ServerToClient
+	assert.NotEqual(t, time.Millisecond*10*time.Duration(roundTrips), downstream)
+	assert.InDelta(t, float64(time.Millisecond*10), float64(downstream)/float64(roundTrips), float64(time.Millisecond*2))
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+)
+
+// Direction identifies one of the two directions in which data flows
+// through a proxy connection
+type Direction int
+
+const (
+	// ClientToServer is the direction of data sent by the proxy client to the destination
+	ClientToServer Direction = iota
+	// ServerToClient is the direction of data sent by the destination back to the proxy client
+	ServerToClient
+)
+
+func (d Direction) String() string {
+	if d == ServerToClient {
+		return "server-to-client"
+	}
+	return "client-to-server"
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Direction) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "client-to-server":
+		*d = ClientToServer
+	case "server-to-client":
+		*d = ServerToClient
+	default:
+		return fmt.Errorf("Unknown direction: %s", text)
+	}
+	return nil
+}
+
+// StallCfg describes a periodic stall of a single direction of proxied traffic.
+// Within each Period, traffic in the stalled direction flows normally at first
+// and is then held back for the last Duration of the period, while the other
+// direction is not affected.
+type StallCfg struct {
+	// Direction specifies which direction of the connection gets stalled
+	Direction Direction `json:"direction" yaml:"direction"`
+	// Period specifies how often the stall occurs
+	Period time.Duration `json:"period" yaml:"period"`
+	// Duration specifies how long each stall lasts (must be shorter than Period)
+	Duration time.Duration `json:"duration" yaml:"duration"`
+}
+
+type stallSchedule struct {
+	start     time.Time
+	direction Direction
+	period    time.Duration
+	duration  time.Duration
+}
+
+func newStallSchedule(start time.Time, cfg *StallCfg) *stallSchedule {
+	if cfg == nil || cfg.Period <= 0 || cfg.Duration <= 0 {
+		return nil
+	}
+	return &stallSchedule{
+		start:     start,
+		direction: cfg.Direction,
+		period:    cfg.Period,
+		duration:  cfg.Duration,
+	}
+}
+
+// remaining returns how much longer traffic flowing in a given direction
+// remains stalled at a given point in time (0 if it isn't stalled)
+func (s *stallSchedule) remaining(direction Direction, when time.Time) time.Duration {
+	if s == nil || s.direction != direction {
+		return 0
+	}
+	elapsed := when.Sub(s.start) % s.period
+	if elapsed < s.period-s.duration {
+		return 0
+	}
+	return s.period - elapsed
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStallScheduleDisabled(t *testing.T) {
+	assert.Nil(t, newStallSchedule(time.Now(), nil))
+	assert.Nil(t, newStallSchedule(time.Now(), &StallCfg{Period: time.Second}))
+	assert.Nil(t, newStallSchedule(time.Now(), &StallCfg{Duration: time.Second}))
+
+	var s *stallSchedule
+	assert.Equal(t, time.Duration(0), s.remaining(ServerToClient, time.Now()))
+}
+
+func TestStallScheduleRemaining(t *testing.T) {
+	start := time.Now()
+	s := newStallSchedule(start, &StallCfg{
+		Direction: ServerToClient,
+		Period:    time.Second * 10,
+		Duration:  time.Second * 2,
+	})
+
+	assert.Equal(t, time.Duration(0), s.remaining(ServerToClient, start))
+	assert.Equal(t, time.Duration(0), s.remaining(ServerToClient, start.Add(time.Second*7)))
+	assert.Equal(t, time.Second*2, s.remaining(ServerToClient, start.Add(time.Second*8)))
+	assert.Equal(t, time.Second, s.remaining(ServerToClient, start.Add(time.Second*9)))
+	assert.Equal(t, time.Duration(0), s.remaining(ServerToClient, start.Add(time.Second*10)))
+	assert.Equal(t, time.Millisecond*500, s.remaining(ServerToClient, start.Add(time.Millisecond*29500)))
+	// the other direction is never stalled
+	assert.Equal(t, time.Duration(0), s.remaining(ClientToServer, start.Add(time.Second*9)))
+}
+
+func TestDirectionText(t *testing.T) {
+	for _, d := range []Direction{ClientToServer, ServerToClient} {
+		text, err := d.MarshalText()
+		assert.Nil(t, err)
+		var parsed Direction
+		assert.Nil(t, parsed.UnmarshalText(text))
+		assert.Equal(t, d, parsed)
+	}
+	var d Direction
+	assert.EqualError(t, d.UnmarshalText([]byte("sideways")), "Unknown direction: sideways")
+}
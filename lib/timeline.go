@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ParseAcceptTimeline reads a connection-open timeline, i.e. extracted from logs,
+// containing one RFC 3339 timestamp per line (anything following the timestamp
+// on a line is ignored, as are blank lines). It returns the inter-arrival times
+// between consecutive timestamps, which can be used as AcceptTimeline.
+func ParseAcceptTimeline(r io.Reader) ([]time.Duration, error) {
+	var timeline []time.Duration
+	var last time.Time
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing accept timeline line %d: %s", line, err)
+		}
+		if !last.IsZero() {
+			if ts.Before(last) {
+				return nil, fmt.Errorf("Error parsing accept timeline line %d: timestamps are out of order", line)
+			}
+			timeline = append(timeline, ts.Sub(last))
+		}
+		last = ts
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading accept timeline: %s", err)
+	}
+	return timeline, nil
+}
+
+// waitForTimeline delays accepting the connection following the n accepted so far until
+// the recorded inter-arrival time has passed since the previous one was accepted at last.
+// It returns false if the instance was stopped while waiting.
+func (s *Speedbump) waitForTimeline(n int, last time.Time) bool {
+	if n == 0 || n > len(s.acceptTimeline) {
+		return true
+	}
+	d := last.Add(s.acceptTimeline[n-1]).Sub(s.clock.Now())
+	if d <= 0 {
+		return true
+	}
+	s.log.Trace("Pacing accept according to the timeline", "wait", d)
+	select {
+	case <-s.clock.After(d):
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
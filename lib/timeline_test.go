@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAcceptTimeline(t *testing.T) {
+	timeline, err := ParseAcceptTimeline(strings.NewReader(`2022-08-01T10:00:00Z conn opened
+2022-08-01T10:00:00.1Z conn opened
+
+2022-08-01T10:00:00.15Z conn opened
+2022-08-01T10:00:01.15Z`))
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{time.Millisecond * 100, time.Millisecond * 50, time.Second}, timeline)
+
+	_, err = ParseAcceptTimeline(strings.NewReader("2022-08-01T10:00:00Z\nnot-a-timestamp"))
+	assert.True(t, strings.HasPrefix(err.Error(), "Error parsing accept timeline line 2"))
+
+	_, err = ParseAcceptTimeline(strings.NewReader("2022-08-01T10:00:01Z\n2022-08-01T10:00:00Z"))
+	assert.EqualError(t, err, "Error parsing accept timeline line 2: timestamps are out of order")
+}
+
+func TestSpeedbumpAcceptTimeline(t *testing.T) {
+	go startEchoSrv(9039)
+	waitForListener("localhost:9039")
+
+	timeline := []time.Duration{time.Millisecond * 100, time.Millisecond * 50, time.Millisecond * 200}
+	var mu sync.Mutex
+	var accepts []time.Time
+	cfg := SpeedbumpCfg{
+		Port:           8036,
+		DestAddr:       "localhost:9039",
+		BufferSize:     0xffff,
+		Latency:        defaultLatencyCfg,
+		LogLevel:       "ERROR",
+		AcceptTimeline: timeline,
+		ConnContextFunc: func(ctx context.Context, remote net.Addr) context.Context {
+			mu.Lock()
+			accepts = append(accepts, time.Now())
+			mu.Unlock()
+			return ctx
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	// all connections are opened at once, waiting in the listen backlog
+	for i := 0; i < 5; i++ {
+		conn, err := net.Dial("tcp", "localhost:8036")
+		assert.Nil(t, err)
+		defer conn.Close()
+	}
+	time.Sleep(time.Millisecond * 450)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, accepts, 5)
+	for i, gap := range timeline {
+		interval := accepts[i+1].Sub(accepts[i])
+		assert.GreaterOrEqual(t, int64(interval), int64(gap))
+		assert.Less(t, int64(interval), int64(gap+time.Millisecond*30))
+	}
+	// connections past the end of the timeline are accepted right away
+	assert.Less(t, int64(accepts[4].Sub(accepts[3])), int64(time.Millisecond*30))
+}
+
+func TestSpeedbumpAcceptTimelineStop(t *testing.T) {
+	cfg := SpeedbumpCfg{
+		Port:           8037,
+		DestAddr:       "localhost:9039",
+		BufferSize:     0xffff,
+		Latency:        defaultLatencyCfg,
+		LogLevel:       "ERROR",
+		AcceptTimeline: []time.Duration{time.Hour},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+
+	conn, err := net.Dial("tcp", "localhost:8037")
+	assert.Nil(t, err)
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 50)
+
+	// Stop doesn't wait for the next accept on the timeline
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked on the accept timeline")
+	}
+}
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+)
+
+// DialTimeoutError is reported when dialing the proxy destination
+// does not complete within the configured DialTimeout
+type DialTimeoutError struct {
+	// Addr is the destination address that was being dialed
+	Addr string
+	// Timeout is the dial timeout that was exceeded
+	Timeout time.Duration
+}
+
+func (e *DialTimeoutError) Error() string {
+	return fmt.Sprintf("Error dialing remote address %s: timed out after %s", e.Addr, e.Timeout)
+}
+
+// AcceptIdleError is reported when no incoming connection
+// is accepted within the configured AcceptIdleTimeout
+type AcceptIdleError struct {
+	// Idle is the period of time during which no connection was accepted
+	Idle time.Duration
+}
+
+func (e *AcceptIdleError) Error() string {
+	return fmt.Sprintf("No incoming connections accepted for %s", e.Idle)
+}
+
+// BackendQueueTimeoutError is reported when a client connection waits longer than
+// the configured BackendQueueTimeout for a free proxy destination connection slot
+type BackendQueueTimeoutError struct {
+	// Timeout is the queue timeout that was exceeded
+	Timeout time.Duration
+}
+
+func (e *BackendQueueTimeoutError) Error() string {
+	return fmt.Sprintf("No proxy destination connection slot freed up within %s", e.Timeout)
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"context"
+	"net"
+)
+
+// ConnInfo describes a proxy connection being opened
+type ConnInfo struct {
+	// ID identifies the connection (matching the connection field in logs)
+	ID int
+	// RemoteAddr is the address of the proxy client
+	RemoteAddr net.Addr
+	// Destination is the address of the proxy destination the connection is dialing
+	Destination string
+	// VirtualHost is the server name requested by the client (see LabelVirtualHosts)
+	VirtualHost string
+}
+
+// ConnSummary describes a proxy connection once it was closed
+type ConnSummary struct {
+	// Bytes counts the bytes read from each side of the connection
+	Bytes ByteTotals
+	// TotalDelayTime sums the delays applied to buffers flowing in each direction
+	TotalDelayTime DelayTotals
+	// EvictedBuffers is the number of buffers dropped from the delay queue after exceeding MaxQueueAge
+	EvictedBuffers int
+	// CloseReason is the error that closed the connection (i.e. a read error,
+	// a dial failure, a *ClientGoneError or the context being done)
+	CloseReason error
+}
+
+// ConnTraceFunc is invoked as each proxy connection is opened with the connection's
+// context, returning a function invoked once the connection is closed. See SpeedbumpCfg.
+type ConnTraceFunc func(ctx context.Context, info ConnInfo) func(ConnSummary)
+
+// traceConn notifies connTrace about a proxy connection being opened, returning
+// a function to be invoked once it's closed (a no-op if tracing is disabled)
+func (s *Speedbump) traceConn(ctx context.Context, info ConnInfo) func(ConnSummary) {
+	if s.connTrace == nil {
+		return func(ConnSummary) {}
+	}
+	if end := s.connTrace(ctx, info); end != nil {
+		return end
+	}
+	return func(ConnSummary) {}
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type traceCtxKey struct{}
+
+func TestSpeedbumpConnTraceFunc(t *testing.T) {
+	go startEchoSrv(9033)
+	waitForListener("localhost:9033")
+
+	infos := make(chan ConnInfo, 1)
+	summaries := make(chan ConnSummary, 1)
+	cfg := SpeedbumpCfg{
+		Port:       8030,
+		DestAddr:   "localhost:9033",
+		BufferSize: 0xffff,
+		Latency:    &LatencyCfg{Base: time.Millisecond * 20},
+		LogLevel:   "ERROR",
+		ConnContextFunc: func(ctx context.Context, remote net.Addr) context.Context {
+			return context.WithValue(ctx, traceCtxKey{}, "parent")
+		},
+		ConnTraceFunc: func(ctx context.Context, info ConnInfo) func(ConnSummary) {
+			// the connection's context is passed to the trace func
+			assert.Equal(t, "parent", ctx.Value(traceCtxKey{}))
+			infos <- info
+			return func(summary ConnSummary) { summaries <- summary }
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8030")
+	assert.Nil(t, err)
+	info := <-infos
+	assert.Equal(t, 0, info.ID)
+	assert.Equal(t, conn.LocalAddr().String(), info.RemoteAddr.String())
+	assert.Equal(t, "127.0.0.1:9033", info.Destination)
+
+	conn.Write([]byte("test-string"))
+	res := make([]byte, 1024)
+	n, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:n])
+	conn.Close()
+
+	summary := <-summaries
+	assert.Equal(t, ByteTotals{ClientToServer: 11, ServerToClient: 11}, summary.Bytes)
+	assert.Equal(t, time.Millisecond*20, summary.TotalDelayTime.ClientToServer)
+	assert.True(t, strings.HasSuffix(summary.CloseReason.Error(), "EOF"))
+}
+
+func TestSpeedbumpConnTraceFuncDialError(t *testing.T) {
+	summaries := make(chan ConnSummary, 1)
+	cfg := SpeedbumpCfg{
+		Port:       8031,
+		DestAddr:   "localhost:9034",
+		BufferSize: 0xffff,
+		Latency:    defaultLatencyCfg,
+		LogLevel:   "ERROR",
+		ConnTraceFunc: func(ctx context.Context, info ConnInfo) func(ConnSummary) {
+			return func(summary ConnSummary) { summaries <- summary }
+		},
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8031")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	summary := <-summaries
+	assert.Equal(t, ConnSummary{CloseReason: summary.CloseReason}, summary)
+	assert.True(t, strings.HasPrefix(summary.CloseReason.Error(), "Error dialing remote address"))
+}
@@ -0,0 +1,28 @@
+package lib
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// uniformLatencySummand adds uniformly distributed random latency between 0 and max
+type uniformLatencySummand struct {
+	max time.Duration
+	// mu guards rng, as the summand is shared by all proxy connections
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newUniformLatencySummand(max time.Duration, seed int64) *uniformLatencySummand {
+	return &uniformLatencySummand{
+		max: max,
+		rng: rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (s *uniformLatencySummand) getLatency(elapsed time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.rng.Int63n(int64(s.max) + 1))
+}
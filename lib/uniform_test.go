@@ -0,0 +1,21 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUniformLatencySummand(t *testing.T) {
+	s := newUniformLatencySummand(time.Millisecond*10, 1)
+	samples := 10000
+	var sum time.Duration
+	for i := 0; i < samples; i++ {
+		l := s.getLatency(0)
+		assert.GreaterOrEqual(t, l, time.Duration(0))
+		assert.LessOrEqual(t, l, time.Millisecond*10)
+		sum += l
+	}
+	assert.InDelta(t, float64(time.Millisecond*5), float64(sum/time.Duration(samples)), float64(time.Millisecond)*0.5)
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// errClientHelloRead aborts the TLS handshake used for parsing a ClientHello
+var errClientHelloRead = errors.New("ClientHello read")
+
+// readVirtualHost peeks at the initial bytes sent by the client in order to extract
+// the server name from a TLS ClientHello (SNI) or the Host header of an HTTP/1.x request.
+// Reading stops once the name is found or the preamble limits are reached, in which
+// case no name is returned. The bytes read are replayed on subsequent reads of the
+// returned connection. The client connection is closed if the context gets cancelled.
+func readVirtualHost(ctx context.Context, conn net.Conn, limits preambleLimits) (*bufferedConn, string) {
+	return readServerName(ctx, conn, limits, parseVirtualHost)
+}
+
+// readServerName peeks at the initial bytes sent by the client until parse extracts a server
+// name from them (or tells that there's none) or the preamble limits are reached
+func readServerName(ctx context.Context, conn net.Conn, limits preambleLimits, parse func([]byte) (string, bool)) (*bufferedConn, string) {
+	read := make(chan struct{})
+	defer close(read)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-read:
+		}
+	}()
+	conn.SetReadDeadline(time.Now().Add(limits.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, limits.maxBytes)
+	n := 0
+	host := ""
+	for n < len(buf) {
+		read, err := conn.Read(buf[n:])
+		n += read
+		name, done := parse(buf[:n])
+		if done || err != nil {
+			host = name
+			break
+		}
+	}
+	replay := io.MultiReader(bytes.NewReader(buf[:n]), conn)
+	return &bufferedConn{conn, bufio.NewReader(replay)}, host
+}
+
+// parseVirtualHost extracts the server name from the initial bytes sent by the client.
+// It returns false if more bytes are needed in order to tell.
+func parseVirtualHost(data []byte) (string, bool) {
+	if len(data) == 0 {
+		return "", false
+	}
+	if data[0] == tlsRecordTypeHandshake {
+		// the record header ends with the length of the record carrying the ClientHello
+		if len(data) < 5 || len(data) < 5+(int(data[3])<<8|int(data[4])) {
+			return "", false
+		}
+		return clientHelloServerName(data), true
+	}
+	if !looksLikeHTTP(data) {
+		return "", true
+	}
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end == -1 {
+		return "", false
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data[:end+4])))
+	if err != nil {
+		return "", true
+	}
+	return req.Host, true
+}
+
+// looksLikeHTTP reports whether data may start with an HTTP/1.x request line,
+// which begins with an uppercase method name followed by a space
+func looksLikeHTTP(data []byte) bool {
+	for i, b := range data {
+		if b == ' ' {
+			return i > 0
+		}
+		if b < 'A' || b > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientHelloServerName returns the server name sent via SNI in a ClientHello,
+// which is parsed by starting a TLS handshake that's aborted right after reading it
+func clientHelloServerName(record []byte) string {
+	var name string
+	tls.Server(&helloConn{r: bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = hello.ServerName
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	return name
+}
+
+// helloConn feeds a ClientHello to a TLS handshake, discarding anything it writes
+type helloConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *helloConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *helloConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (c *helloConn) Close() error {
+	return nil
+}
+
+func (c *helloConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (c *helloConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (c *helloConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
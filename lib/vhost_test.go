@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// clientHello returns the first bytes sent by a TLS client requesting a given server name
+func clientHello(serverName string) []byte {
+	client, server := net.Pipe()
+	go tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+	defer client.Close()
+	defer server.Close()
+	buf := make([]byte, 4096)
+	n, _ := server.Read(buf)
+	return buf[:n]
+}
+
+func TestParseVirtualHost(t *testing.T) {
+	hello := clientHello("example.test")
+	host, done := parseVirtualHost(hello)
+	assert.True(t, done)
+	assert.Equal(t, "example.test", host)
+
+	// more bytes are needed until the whole ClientHello record arrives
+	_, done = parseVirtualHost(hello[:20])
+	assert.False(t, done)
+
+	req := []byte("GET / HTTP/1.1\r\nHost: api.example.test:8080\r\nAccept: */*\r\n\r\nbody")
+	host, done = parseVirtualHost(req)
+	assert.True(t, done)
+	assert.Equal(t, "api.example.test:8080", host)
+
+	_, done = parseVirtualHost(req[:20])
+	assert.False(t, done)
+
+	// other protocols are not labeled
+	host, done = parseVirtualHost([]byte{0x00, 0x01, 0x02})
+	assert.True(t, done)
+	assert.Equal(t, "", host)
+	host, done = parseVirtualHost([]byte("PING\r\n"))
+	assert.True(t, done)
+	assert.Equal(t, "", host)
+}
+
+func TestSpeedbumpLabelVirtualHostsSNI(t *testing.T) {
+	go startEchoSrv(9040)
+	waitForListener("localhost:9040")
+	startTLSEchoSrv(t, 9041)
+
+	cfg := SpeedbumpCfg{
+		Port:              8038,
+		DestAddr:          "localhost:9040",
+		TLSDestAddr:       "localhost:9041",
+		BufferSize:        0xffff,
+		Latency:           defaultLatencyCfg,
+		LogLevel:          "ERROR",
+		LabelVirtualHosts: true,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	buf := &syncBuffer{}
+	s.log = hclog.New(&hclog.LoggerOptions{Output: buf, Level: hclog.Debug})
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := tls.Dial("tcp", "localhost:8038", &tls.Config{ServerName: "example.test", InsecureSkipVerify: true})
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.Write([]byte("test-string"))
+	res := make([]byte, 1024)
+	n, _ := conn.Read(res)
+	assert.Equal(t, []byte("test-string"), res[:n])
+
+	stats := s.ConnStats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, "example.test", stats[0].VirtualHost)
+	assert.Contains(t, strings.Join(buf.lines(), "\n"), "virtualHost=example.test")
+}
+
+func TestSpeedbumpLabelVirtualHostsHTTP(t *testing.T) {
+	go startEchoSrv(9042)
+	waitForListener("localhost:9042")
+
+	cfg := SpeedbumpCfg{
+		Port:              8039,
+		DestAddr:          "localhost:9042",
+		BufferSize:        0xffff,
+		Latency:           defaultLatencyCfg,
+		LogLevel:          "ERROR",
+		LabelVirtualHosts: true,
+		PreambleTimeout:   time.Millisecond * 100,
+	}
+	s, err := NewSpeedbump(&cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Start())
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:8039")
+	assert.Nil(t, err)
+	defer conn.Close()
+	// the request is forwarded as is, even though it arrives in pieces
+	conn.Write([]byte("GET / HTTP/1.1\r\nHo"))
+	time.Sleep(time.Millisecond * 20)
+	conn.Write([]byte("st: example.test\r\n\r\n"))
+	res := make([]byte, 1024)
+	n, err := io.ReadAtLeast(conn, res, 36)
+	assert.Nil(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\nHost: example.test\r\n\r\n", string(res[:n]))
+	assert.Equal(t, "example.test", s.ConnStats()[0].VirtualHost)
+
+	// clients that don't send a request within the preamble timeout are proxied without a label
+	other, err := net.Dial("tcp", "localhost:8039")
+	assert.Nil(t, err)
+	defer other.Close()
+	time.Sleep(time.Millisecond * 150)
+	other.Write([]byte("late"))
+	n, err = other.Read(res)
+	assert.Nil(t, err)
+	assert.Equal(t, "late", string(res[:n]))
+	assert.Equal(t, "", s.ConnStats()[1].VirtualHost)
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// watchdogLatencyGenerator enforces a deadline on the latency generation of each buffer,
+// so that a slow or hanging generator doesn't stall the connection. A buffer whose latency
+// isn't generated in time gets no delay. While a call that exceeded the deadline is still
+// running, no further calls are made and buffers pass through without delay as well.
+type watchdogLatencyGenerator struct {
+	gen      LatencyGenerator
+	deadline time.Duration
+	clock    Clock
+	limiter  *logLimiter
+	log      hclog.Logger
+	// mu guards pending, which is closed once a call that exceeded the deadline returns
+	mu      sync.Mutex
+	pending chan struct{}
+}
+
+func newWatchdogLatencyGenerator(gen LatencyGenerator, deadline time.Duration, clock Clock, limiter *logLimiter, log hclog.Logger) LatencyGenerator {
+	if gen == nil || deadline <= 0 {
+		return gen
+	}
+	return &watchdogLatencyGenerator{gen: gen, deadline: deadline, clock: clockOrReal(clock), limiter: limiter, log: log}
+}
+
+func (w *watchdogLatencyGenerator) generateLatency(when time.Time) time.Duration {
+	w.mu.Lock()
+	if w.pending != nil {
+		select {
+		case <-w.pending:
+			w.pending = nil
+		default:
+			w.mu.Unlock()
+			return 0
+		}
+	}
+	w.mu.Unlock()
+
+	res := make(chan time.Duration, 1)
+	finished := make(chan struct{})
+	go func() {
+		res <- w.gen.generateLatency(when)
+		close(finished)
+	}()
+	select {
+	case d := <-res:
+		return d
+	case <-w.clock.After(w.deadline):
+		w.limiter.warn(w.log, "Latency generation exceeded the deadline, skipping delay", "deadline", w.deadline)
+		w.mu.Lock()
+		w.pending = finished
+		w.mu.Unlock()
+		return 0
+	}
+}
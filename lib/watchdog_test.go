@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowLatencyGenerator takes the given times to generate subsequent latencies
+// (the last one is repeated)
+type slowLatencyGenerator struct {
+	delay time.Duration
+	mu    sync.Mutex
+	took  []time.Duration
+	calls int
+}
+
+func (g *slowLatencyGenerator) generateLatency(when time.Time) time.Duration {
+	g.mu.Lock()
+	took := g.took[len(g.took)-1]
+	if g.calls < len(g.took) {
+		took = g.took[g.calls]
+	}
+	g.calls++
+	g.mu.Unlock()
+	time.Sleep(took)
+	return g.delay
+}
+
+func TestNewWatchdogLatencyGeneratorDisabled(t *testing.T) {
+	gen := &mockLatencyGenerator{time.Millisecond}
+	assert.Equal(t, gen, newWatchdogLatencyGenerator(gen, 0, nil, nil, hclog.NewNullLogger()))
+	assert.Nil(t, newWatchdogLatencyGenerator(nil, time.Second, nil, nil, hclog.NewNullLogger()))
+}
+
+// gatedLatencyGenerator blocks each call until it's released
+type gatedLatencyGenerator struct {
+	delay   time.Duration
+	release chan struct{}
+}
+
+func (g *gatedLatencyGenerator) generateLatency(when time.Time) time.Duration {
+	<-g.release
+	return g.delay
+}
+
+func TestWatchdogLatencyGenerator(t *testing.T) {
+	l, buf := newBufferLogger()
+	vc := NewVirtualClock(time.Unix(0, 0))
+	gen := &gatedLatencyGenerator{delay: time.Millisecond * 50, release: make(chan struct{}, 1)}
+	w := newWatchdogLatencyGenerator(gen, time.Millisecond*20, vc, nil, l).(*watchdogLatencyGenerator)
+
+	// latency generated in time is used as is
+	gen.release <- struct{}{}
+	assert.Equal(t, time.Millisecond*50, w.generateLatency(vc.Now()))
+
+	// the deadline of the first call remains pending in the clock
+	res := make(chan time.Duration)
+	go func() { res <- w.generateLatency(vc.Now()) }()
+	vc.BlockUntil(2)
+	vc.Advance(time.Millisecond * 20)
+	assert.Equal(t, time.Duration(0), <-res)
+	assert.Contains(t, buf.lines()[0], "Latency generation exceeded the deadline")
+
+	// the generator isn't called again until the call that exceeded the deadline returns
+	assert.Equal(t, time.Duration(0), w.generateLatency(vc.Now()))
+	assert.Len(t, buf.lines(), 1)
+
+	w.mu.Lock()
+	pending := w.pending
+	w.mu.Unlock()
+	gen.release <- struct{}{}
+	<-pending
+	gen.release <- struct{}{}
+	assert.Equal(t, time.Millisecond*50, w.generateLatency(vc.Now()))
+}
+
+func TestReadFromSrcSlowLatencyGenerator(t *testing.T) {
+	l, buf := newBufferLogger()
+	delayQueue := make(chan transitBuffer, 10)
+	done := make(chan error, 3)
+	c := &connection{
+		srcConn:    &pausingConn{limit: 5},
+		bufferSize: 20,
+		latencyGen: newWatchdogLatencyGenerator(&slowLatencyGenerator{delay: time.Second, took: []time.Duration{time.Hour}}, time.Millisecond*10, nil, nil, l),
+		delayQueue: delayQueue,
+		done:       done,
+		log:        hclog.NewNullLogger(),
+	}
+
+	start := time.Now()
+	c.readFromSrc()
+	<-done
+
+	// traffic keeps flowing without delay despite the generator hanging
+	assert.Less(t, int64(time.Since(start)), int64(time.Millisecond*100))
+	for i := 0; i < 5; i++ {
+		assert.Less(t, int64((<-delayQueue).delayUntil.Sub(start)), int64(time.Millisecond*100))
+	}
+	assert.Len(t, buf.lines(), 1)
+}